@@ -0,0 +1,694 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/bundle"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/daemon"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/ssl"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/steam"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/users"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	MASKED = "********"
+)
+
+var (
+	// secrets are the settings masked by 'config show' and kept out of
+	// the systemd unit
+	secretSettings = [][2]string{{"webAdmin", "password"}, {"metrics", "password"}, {"metrics", "token"}}
+)
+
+func usage() {
+
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [flags] <command> [arguments]\n\n", os.Args[0])
+	fmt.Fprintln(out, "commands:")
+	fmt.Fprintln(out, "  serve                       run the web admin (default)")
+	fmt.Fprintln(out, "  install-steamcmd            download and install steamcmd")
+	fmt.Fprintln(out, "  install-game                install the sandstorm dedicated server")
+	fmt.Fprintln(out, "  update                      update and validate the sandstorm dedicated server")
+	fmt.Fprintln(out, "  instance list               list the server instances")
+	fmt.Fprintln(out, "  instance start <id>         start a server instance")
+	fmt.Fprintln(out, "  instance stop <id>          stop a server instance")
+	fmt.Fprintln(out, "  instance export <id> [-output f]")
+	fmt.Fprintln(out, "                              write the signed bundle of an instance")
+	fmt.Fprintln(out, "  instance import <file> [-id i] [-dir d] [-preview] [-insecure]")
+	fmt.Fprintln(out, "                              create an instance from a bundle, the web admin must be stopped")
+	fmt.Fprintln(out, "  config show [-format f]     print the configuration as json or yaml")
+	fmt.Fprintln(out, "  config validate             check the configuration")
+	fmt.Fprintln(out, "  config schema               print the json schema of the configuration file")
+	fmt.Fprintln(out, "  cert generate               generate a new web admin certificate")
+	fmt.Fprintln(out, "  user add <name>             add a web admin user")
+	fmt.Fprintln(out, "  user passwd <name>          change the password of a web admin user")
+	fmt.Fprintln(out, "  store export [-output f]    write the whole store as json")
+	fmt.Fprintln(out, "  store import <file>         replace the store with an export, the web admin must be stopped")
+	fmt.Fprintln(out, "  systemd-unit                print a systemd unit running the web admin")
+	fmt.Fprintln(out, "\nflags:")
+	flag.PrintDefaults()
+}
+
+// run dispatches the command line to its command and returns the process
+// exit code: 0 on success, 1 when the command failed and 2 on bad usage.
+func run(conf *config.Configuration, log *admin_log.Log, args []string) int {
+
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "help":
+		usage()
+		return 0
+	case "config":
+		return configCommand(conf, log, args)
+	}
+
+	if err := conf.Read(); err != nil {
+		return 1
+	}
+	setLogLevel(conf, log)
+
+	switch command {
+	case "serve":
+		return serve(conf, log)
+	case "install-steamcmd":
+		return installSteamCommand(conf, log)
+	case "install-game":
+		return installGameCommand(conf, log, false)
+	case "update":
+		return installGameCommand(conf, log, true)
+	case "instance":
+		return instanceCommand(conf, log, args)
+	case "cert":
+		return certCommand(conf, log, args)
+	case "user":
+		return userCommand(conf, log, args)
+	case "store":
+		return storeCommand(conf, log, args)
+	case "systemd-unit":
+		return unitCommand(conf, log, args)
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command '%s'\n", command)
+	usage()
+	return 2
+}
+
+func installSteamCommand(conf *config.Configuration, log *admin_log.Log) int {
+
+	store := store.New(conf, log)
+	if err := store.Open(); err != nil {
+		return 1
+	}
+
+	steam := steam.New(conf, log, store)
+	if !steam.HasInstaller() {
+		if err := steam.Download(); err != nil {
+			return 1
+		}
+	}
+
+	if err := steam.Install(); err != nil {
+		return 1
+	}
+
+	fmt.Printf("steamcmd installed in '%s'\n", conf.Steam.Dir)
+	return 0
+}
+
+// installGameCommand installs or updates the dedicated server with
+// steamcmd, which must have been installed before.
+func installGameCommand(conf *config.Configuration, log *admin_log.Log, update bool) int {
+
+	store := store.New(conf, log)
+	if err := store.Open(); err != nil {
+		return 1
+	}
+
+	steam := steam.New(conf, log, store)
+	if !steam.IsInstalled() {
+		fmt.Fprintln(os.Stderr, "steamcmd is not installed, run 'install-steamcmd' first")
+		return 1
+	}
+
+	game := insurgency.New(conf, log, steam.Executable())
+	if update {
+		if !game.Update() {
+			record(conf, log, store, audit.Entry{Action: "game.update", Target: game.Dir}, fmt.Errorf("update failed"))
+			return 1
+		}
+		steam.Updated()
+		record(conf, log, store, audit.Entry{Action: "game.update", Target: game.Dir}, nil)
+		fmt.Printf("sandstorm server in '%s' updated\n", game.Dir)
+		return 0
+	}
+
+	if !game.Install() {
+		record(conf, log, store, audit.Entry{Action: "game.install", Target: game.Dir}, fmt.Errorf("installation failed"))
+		return 1
+	}
+	steam.Updated()
+	record(conf, log, store, audit.Entry{Action: "game.install", Target: game.Dir}, nil)
+	fmt.Printf("sandstorm server installed in '%s'\n", game.Dir)
+
+	return 0
+}
+
+// instanceCommand manages the instances from the command line. Servers
+// started here keep running after the command exits and are found again by
+// their command line to be stopped.
+func instanceCommand(conf *config.Configuration, log *admin_log.Log, args []string) int {
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: webadmin instance list|start <id>|stop <id>|export <id>|import <file>")
+		return 2
+	}
+
+	if args[0] == "import" {
+		// the running web admin would write its instances back over the
+		// imported one
+		daemon := daemon.New(conf, log)
+		if err := daemon.Lock(); err != nil {
+			return 1
+		}
+		defer daemon.Unlock()
+	}
+
+	store := store.New(conf, log)
+	if err := store.Open(); err != nil {
+		return 1
+	}
+
+	instances := insurgency.NewInstances(conf, log, store)
+	if err := instances.Load(); err != nil {
+		return 1
+	}
+
+	if args[0] == "list" {
+		out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(out, "ID\tNAME\tPORT\tQUERY\tRCON\tSCENARIO\tPID")
+		for _, instance := range instances.List() {
+			pid := "-"
+			if process, err := instance.FindProcess(); err == nil {
+				pid = fmt.Sprintf("%d", process.Pid)
+			}
+			fmt.Fprintf(out, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n", instance.ID, instance.Name, instance.Port, instance.QueryPort, instance.RconPort, instance.Scenario, pid)
+		}
+		out.Flush()
+		return 0
+	}
+
+	if args[0] == "import" {
+		return importBundle(conf, log, store, instances, args[1:])
+	}
+
+	if len(args) < 2 || (len(args) != 2 && args[0] != "export") {
+		fmt.Fprintf(os.Stderr, "usage: webadmin instance %s <id>\n", args[0])
+		return 2
+	}
+
+	instance, err := instances.Get(args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	switch args[0] {
+	case "start":
+		if process, err := instance.FindProcess(); err == nil {
+			fmt.Fprintf(os.Stderr, "instance '%s' is already running with pid %d\n", instance.ID, process.Pid)
+			return 1
+		}
+		err := instance.Start()
+		record(conf, log, store, audit.Entry{Action: "instance.start", Instance: instance.ID}, err)
+		if err != nil {
+			return 1
+		}
+		fmt.Printf("instance '%s' started\n", instance.ID)
+		return 0
+	case "export":
+		return exportBundle(conf, log, store, instances, instance, args[2:])
+	case "stop":
+		process, err := instance.FindProcess()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		err = instance.Terminate(process)
+		record(conf, log, store, audit.Entry{Action: "instance.stop", Instance: instance.ID}, err)
+		if err != nil {
+			return 1
+		}
+		fmt.Printf("instance '%s' stopped\n", instance.ID)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown instance command '%s'\n", args[0])
+	return 2
+}
+
+// exportBundle writes the bundle of an instance to a file or the standard
+// output.
+func exportBundle(conf *config.Configuration, log *admin_log.Log, store *store.Store, instances *insurgency.Instances, instance *insurgency.Instance, args []string) int {
+
+	set := flag.NewFlagSet("instance export", flag.ContinueOnError)
+	output := set.String("output", "", "the file to write, the standard output when not given")
+	if err := set.Parse(args); err != nil {
+		return 2
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	manifest, err := bundle.New(conf, log, instances).Export(instance, out)
+	record(conf, log, store, audit.Entry{Action: "instance.export", Instance: instance.ID, Target: *output}, err)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "instance '%s' exported with %d files\n", instance.ID, len(manifest.Files))
+	return 0
+}
+
+// importBundle creates an instance from a bundle file and prints what was
+// remapped.
+func importBundle(conf *config.Configuration, log *admin_log.Log, store *store.Store, instances *insurgency.Instances, args []string) int {
+
+	set := flag.NewFlagSet("instance import", flag.ContinueOnError)
+	id := set.String("id", "", "the id of the instance, the one of the bundle when not given")
+	dir := set.String("dir", "", "the game directory, sandstorm.dir when not given")
+	preview := set.Bool("preview", false, "only print what would be imported")
+	insecure := set.Bool("insecure", false, "import a bundle of any signer when bundles.trusted is empty")
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: webadmin instance import <file> [-id i] [-dir d] [-preview] [-insecure]")
+		return 2
+	}
+	if err := set.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer f.Close()
+
+	result, err := bundle.New(conf, log, instances).Import(f, bundle.Options{ID: *id, Dir: *dir, Preview: *preview, Insecure: *insecure}, systemUser())
+	if !*preview {
+		record(conf, log, store, audit.Entry{Action: "instance.import-bundle", Instance: *id, Target: args[0]}, err)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	for _, change := range result.Remapped {
+		fmt.Printf("%s: %s -> %s\n", change.Item, change.From, change.To)
+	}
+	for _, warning := range result.Warnings {
+		fmt.Printf("warning: %s\n", warning)
+	}
+	if *preview {
+		fmt.Printf("instance '%s' can be imported with %s\n", result.Instance.ID, strings.Join(result.Files, ", "))
+		return 0
+	}
+	fmt.Printf("instance '%s' imported with %s\n", result.Instance.ID, strings.Join(result.Files, ", "))
+	return 0
+}
+
+// configCommand runs 'config show', 'config validate' and 'config schema'.
+func configCommand(conf *config.Configuration, log *admin_log.Log, args []string) int {
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: webadmin config show|validate|schema")
+		return 2
+	}
+
+	switch args[0] {
+	case "show":
+		set := flag.NewFlagSet("config show", flag.ContinueOnError)
+		format := set.String("format", "yaml", "output format: yaml or json")
+		secrets := set.Bool("secrets", false, "show passwords instead of masking them")
+		if err := set.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if err := conf.Read(); err != nil {
+			return 1
+		}
+		return showConfig(conf, *format, *secrets)
+	case "validate":
+		log.SetLogLevel(admin_log.LOG_CRITICAL)
+		if err := conf.Read(); err != nil {
+			for _, problem := range conf.Problems() {
+				fmt.Println(problem)
+			}
+			fmt.Println(err.Error())
+			return 1
+		}
+		if conf.File != "" {
+			fmt.Printf("configuration '%s' is valid\n", conf.File)
+		} else {
+			fmt.Println("configuration is valid")
+		}
+		return 0
+	case "schema":
+		schema, err := conf.Schema()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		fmt.Println(string(schema))
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown config command '%s'\n", args[0])
+	return 2
+}
+
+// showConfig prints the effective configuration with the same keys as the
+// configuration file.
+func showConfig(conf *config.Configuration, format string, secrets bool) int {
+
+	data, err := json.Marshal(conf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	document := make(map[string]any)
+	if err := json.Unmarshal(data, &document); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	for _, secret := range secretSettings {
+		if section, ok := document[secret[0]].(map[string]any); ok && !secrets && section[secret[1]] != "" {
+			section[secret[1]] = MASKED
+		}
+	}
+
+	switch format {
+	case "json":
+		data, err = json.MarshalIndent(document, "", "  ")
+	case "yaml":
+		data, err = yaml.Marshal(document)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format '%s', use yaml or json\n", format)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	fmt.Println(strings.TrimRight(string(data), "\n"))
+	return 0
+}
+
+func certCommand(conf *config.Configuration, log *admin_log.Log, args []string) int {
+
+	if len(args) == 0 || args[0] != "generate" {
+		fmt.Fprintln(os.Stderr, "usage: webadmin cert generate")
+		return 2
+	}
+
+	ssl := ssl.New(conf, log)
+	if err := ssl.Generate(); err != nil {
+		return 1
+	}
+
+	details, err := ssl.Details()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Printf("certificate '%s' and key '%s' generated for %s, valid until %s\n", details.CertPath, details.KeyPath, strings.Join(append(details.DNSNames, details.IPAddresses...), ", "), details.NotAfter.Format("2006-01-02"))
+
+	return 0
+}
+
+// userCommand adds users and changes passwords. The password is taken from
+// -password or read as one line from the standard input.
+func userCommand(conf *config.Configuration, log *admin_log.Log, args []string) int {
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: webadmin user add|passwd [-password password] <name>")
+		return 2
+	}
+
+	set := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	password := set.String("password", "", "the password, read from the standard input when not given")
+	if err := set.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if set.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: webadmin user %s [-password password] <name>\n", args[0])
+		return 2
+	}
+	name := set.Arg(0)
+
+	store := store.New(conf, log)
+	if err := store.Open(); err != nil {
+		return 1
+	}
+
+	users := users.New(conf, log, store)
+	if err := users.Load(""); err != nil {
+		return 1
+	}
+
+	if *password == "" {
+		fmt.Fprintf(os.Stderr, "password for '%s': ", name)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(os.Stderr, "\nfailed to read the password")
+			return 1
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+
+	var err error
+	switch args[0] {
+	case "add":
+		err = users.Add(name, *password)
+	case "passwd":
+		err = users.SetPassword(name, *password)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown user command '%s'\n", args[0])
+		return 2
+	}
+	record(conf, log, store, audit.Entry{Action: "user." + args[0], Target: name}, err)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	fmt.Printf("user '%s' saved\n", name)
+	return 0
+}
+
+// storeCommand exports the store or replaces it with an export, for
+// example to move the web admin to another host.
+func storeCommand(conf *config.Configuration, log *admin_log.Log, args []string) int {
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: webadmin store export [-output file] | import <file>")
+		return 2
+	}
+
+	store := store.New(conf, log)
+
+	switch args[0] {
+	case "export":
+		set := flag.NewFlagSet("store export", flag.ContinueOnError)
+		output := set.String("output", "", "the file to write, the standard output when not given")
+		if err := set.Parse(args[1:]); err != nil {
+			return 2
+		}
+		out := os.Stdout
+		if *output == "" {
+			// keep the log lines out of the exported data
+			log.SetOutput(os.Stderr)
+		}
+		if err := store.Open(); err != nil {
+			return 1
+		}
+		if *output != "" {
+			f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				return 1
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := store.Export(out); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		record(conf, log, store, audit.Entry{Action: "store.export", Target: *output}, nil)
+		return 0
+	case "import":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: webadmin store import <file>")
+			return 2
+		}
+		// the running web admin keeps instances and schedules in memory
+		// and would write them back over the imported ones
+		daemon := daemon.New(conf, log)
+		if err := daemon.Lock(); err != nil {
+			return 1
+		}
+		defer daemon.Unlock()
+
+		f, err := os.Open(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		defer f.Close()
+		if err := store.Open(); err != nil {
+			return 1
+		}
+		err = store.Import(f)
+		record(conf, log, store, audit.Entry{Action: "store.import", Target: args[1]}, err)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		fmt.Printf("store imported from '%s'\n", args[1])
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown store command '%s'\n", args[0])
+	return 2
+}
+
+// record adds an action made from the command line to the audit log under
+// the name of the system user running the command.
+func record(conf *config.Configuration, log *admin_log.Log, store *store.Store, entry audit.Entry, err error) {
+
+	entry.User = systemUser()
+	entry.Address = audit.ADDRESS_LOCAL
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	audit.New(conf, log, store).Record(entry)
+}
+
+// systemUser is the name of the user running the command.
+func systemUser() string {
+
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+// unitCommand prints a systemd unit starting the web admin from the current
+// directory with the same global flags as this command.
+func unitCommand(conf *config.Configuration, log *admin_log.Log, args []string) int {
+
+	set := flag.NewFlagSet("systemd-unit", flag.ContinueOnError)
+	user := set.String("user", "", "user running the service")
+	group := set.String("group", "", "group running the service")
+	watchdog := set.Int("watchdog", daemon.UNIT_WATCHDOG, "watchdog timeout in seconds, 0 disables it")
+	output := set.String("output", "", "write the unit to this file instead of the standard output")
+	if err := set.Parse(args); err != nil {
+		return 2
+	}
+
+	global := os.Args[1 : len(os.Args)-flag.NArg()]
+	if key := secretSetting(global); key != "" {
+		fmt.Fprintf(os.Stderr, "-set %s would be readable by every user in the unit file, put it in the configuration file or an -env file instead\n", key)
+		return 1
+	}
+	unit, err := daemon.New(conf, log).NewUnit(global)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	unit.User = *user
+	unit.Group = *group
+	unit.WatchdogSec = *watchdog
+
+	data, err := unit.Render()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Printf("unit written to '%s', enable it with 'systemctl enable --now %s'\n", *output, strings.TrimSuffix(filepath.Base(*output), ".service"))
+
+	return 0
+}
+
+// secretSetting returns the first secret set with -set in the global flags.
+func secretSetting(global []string) string {
+
+	for n, arg := range global {
+		var setting string
+		switch {
+		case arg == "-set" || arg == "--set":
+			if n+1 < len(global) {
+				setting = global[n+1]
+			}
+		case strings.HasPrefix(arg, "-set=") || strings.HasPrefix(arg, "--set="):
+			_, setting, _ = strings.Cut(arg, "=")
+		default:
+			continue
+		}
+		key, _, _ := strings.Cut(setting, "=")
+		for _, secret := range secretSettings {
+			if strings.EqualFold(key, secret[0]+"."+secret[1]) {
+				return key
+			}
+		}
+	}
+
+	return ""
+}
+
+// setLogLevel applies webAdmin.logLevel, which Read has validated.
+func setLogLevel(conf *config.Configuration, log *admin_log.Log) {
+
+	if severity, err := admin_log.ParseSeverity(conf.WebAdmin.LogLevel); err == nil {
+		log.SetLogLevel(severity)
+	}
+}
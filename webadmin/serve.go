@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/announcer"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/backup"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/bundle"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/chat"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/daemon"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/importer"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/monitor"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/players"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/scheduler"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/server"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/ssl"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/steam"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/templates"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/users"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/webhooks"
+)
+
+const (
+	SHUTDOWN_TIMEOUT = 10 * time.Second
+)
+
+// serve runs the web admin: it installs steamcmd when needed, loads the
+// instances, starts the scheduler and serves the API until it fails or is
+// asked to stop with SIGTERM or SIGINT. SIGHUP reloads the configuration.
+func serve(conf *config.Configuration, log *admin_log.Log) int {
+
+	log.Write("Starting Web Admin", "main", admin_log.LOG_INFO)
+
+	log.Open()
+
+	daemon := daemon.New(conf, log)
+	if err := daemon.Lock(); err != nil {
+		return 1
+	}
+	defer daemon.Unlock()
+
+	store := store.New(conf, log)
+	if err := store.Open(); err != nil {
+		return 1
+	}
+	defer store.Close()
+
+	events := events.New()
+	webhooks := webhooks.New(conf, log, store)
+	if err := webhooks.Load(); err != nil {
+		return 1
+	}
+	webhooks.Subscribe(events)
+
+	ssl := ssl.New(conf, log)
+	ssl.Events = events
+	if !ssl.Load() {
+		return 1
+	}
+	ssl.Watch()
+
+	var hasInstaller = false
+	var isInstalled = false
+
+	steam := steam.New(conf, log, store)
+	if hasInstaller = steam.HasInstaller(); !hasInstaller {
+		if err := steam.Download(); err == nil {
+			hasInstaller = true
+		}
+	}
+
+	if isInstalled = steam.IsInstalled(); !isInstalled && hasInstaller {
+		if err := steam.Install(); err == nil {
+			isInstalled = true
+		}
+	}
+
+	instances := insurgency.NewInstances(conf, log, store)
+	instances.Events = events
+	if err := instances.Load(); err != nil {
+		return 1
+	}
+	if count := instances.Reattach(); count > 0 {
+		log.Write(fmt.Sprintf("reattached to %d running instance(s)", count), "main", admin_log.LOG_INFO)
+	}
+
+	backups := backup.New(conf, log, instances, store)
+
+	audit := audit.New(conf, log, store)
+
+	schedules := scheduler.New(conf, log, instances, store)
+	schedules.Audit = audit
+	game := insurgency.New(conf, log, steam.Executable())
+	schedules.Register(scheduler.TASK_UPDATE, scheduler.UpdateTask(game, events))
+	schedules.Register(scheduler.TASK_CHECK, scheduler.CheckUpdateTask(game, events))
+	schedules.Register(scheduler.TASK_BACKUP, scheduler.BackupTask(backups))
+	if err := schedules.Load(); err != nil {
+		return 1
+	}
+	schedules.Start()
+
+	monitor := monitor.New(conf, log, instances)
+	monitor.Events = events
+	monitor.Start()
+
+	players := players.New(conf, log, instances, store)
+	if err := players.Load(); err != nil {
+		return 1
+	}
+	players.Start()
+
+	chat := chat.New(conf, log, instances, store)
+	chat.Audit = audit
+	if err := chat.Load(); err != nil {
+		return 1
+	}
+	chat.Start()
+
+	announcer := announcer.New(conf, log, instances, store)
+	announcer.Scheduler = schedules
+	if err := announcer.Load(); err != nil {
+		return 1
+	}
+	announcer.Start()
+
+	templates := templates.New(conf, log, instances, store)
+	importer := importer.New(conf, log, instances)
+	bundles := bundle.New(conf, log, instances)
+
+	users := users.New(conf, log, store)
+	if err := users.Load(conf.WebAdmin.Password); err != nil {
+		return 1
+	}
+
+	server := server.New(conf, log)
+	server.SslCert = ssl.SslCert
+	server.SslKey = ssl.SslKey
+	server.Instances = instances
+	server.Users = users
+	server.Audit = audit
+	server.Scheduler = schedules
+	server.Backups = backups
+	server.Ssl = ssl
+	server.Monitor = monitor
+	server.Steam = steam
+	server.Webhooks = webhooks
+	server.Players = players
+	server.Chat = chat
+	server.Announcer = announcer
+	server.Templates = templates
+	server.Importer = importer
+	server.Bundles = bundles
+	server.Store = store
+	server.Ready = func() {
+		daemon.Ready()
+		address, port := conf.WebAdmin.Address, conf.WebAdmin.Port
+		daemon.Watchdog(func() bool { return listening(address, port) })
+	}
+
+	live := &live{log: log, ssl: ssl, server: server, users: users, bundles: bundles, backups: backups, players: players, started: conf}
+	current := conf
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Run()
+	}()
+
+	for {
+		select {
+		case err := <-errs:
+			daemon.Stop()
+			if err != nil {
+				return 1
+			}
+			return 0
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				daemon.Reloading()
+				current = live.reload(current)
+				daemon.Ready()
+				continue
+			}
+
+			log.Write(fmt.Sprintf("received %s, stopping", sig), "main", admin_log.LOG_INFO)
+			daemon.Stopping()
+			daemon.Stop()
+			schedules.Stop()
+			webhooks.Stop()
+			monitor.Stop()
+			players.Stop()
+			chat.Stop()
+			announcer.Stop()
+			ssl.Stop()
+			if conf.WebAdmin.KeepInstances {
+				for _, instance := range instances.List() {
+					instance.Detach()
+				}
+				log.Write("leaving the instances running", "main", admin_log.LOG_INFO)
+			} else {
+				stopInstances(instances, log)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+			server.Shutdown(ctx)
+			cancel()
+			<-errs
+
+			log.Write("Web Admin stopped", "main", admin_log.LOG_INFO)
+			return 0
+		}
+	}
+}
+
+// live are the services whose settings change without a restart.
+type live struct {
+	log     *admin_log.Log
+	ssl     *ssl.Ssl
+	server  *server.Server
+	users   *users.Users
+	bundles *bundle.Bundles
+	backups *backup.Backups
+	players *players.Players
+	started *config.Configuration
+}
+
+// runtime are the settings applied while running, the others need a
+// restart.
+var runtime = map[string]bool{
+	"webAdmin.logLevel":       true,
+	"webAdmin.password":       true,
+	"metrics.username":        true,
+	"metrics.password":        true,
+	"metrics.token":           true,
+	"bundles.trusted":         true,
+	"backup.keep":             true,
+	"backup.maxAge":           true,
+	"players.recordAddresses": true,
+	"players.retention":       true,
+}
+
+// reload reads the configuration again, reopens the log file and loads the
+// certificate files again. The settings the services can change while
+// running are applied through them, the others are reported until the
+// next start. It returns the configuration now in use.
+func (l *live) reload(current *config.Configuration) *config.Configuration {
+
+	l.log.Reopen()
+	l.log.Write("reloading configuration", "main", admin_log.LOG_INFO)
+
+	fresh, err := current.Reload()
+	if err != nil {
+		l.log.Write(fmt.Sprintf("keeping the current configuration. ERR: %s", err.Error()), "main", admin_log.LOG_ERROR)
+		return current
+	}
+
+	if fresh.WebAdmin.SslUse {
+		l.ssl.Reload()
+	}
+
+	applied := make([]string, 0)
+	for _, setting := range config.Changed(current, fresh) {
+		if runtime[setting] {
+			l.apply(fresh, setting)
+			applied = append(applied, setting)
+		}
+	}
+	if len(applied) > 0 {
+		l.log.Write(fmt.Sprintf("applied %s", strings.Join(applied, ", ")), "main", admin_log.LOG_INFO)
+	}
+
+	// compared with the start, a setting changed back needs no restart
+	restart := make([]string, 0)
+	for _, setting := range config.Changed(l.started, fresh) {
+		if !runtime[setting] {
+			restart = append(restart, setting)
+		}
+	}
+	if len(restart) > 0 {
+		l.log.Write(fmt.Sprintf("%s changed, restart the web admin to apply them", strings.Join(restart, ", ")), "main", admin_log.LOG_WARNING)
+	}
+
+	return fresh
+}
+
+// apply gives a changed runtime setting to the service using it.
+func (l *live) apply(conf *config.Configuration, setting string) {
+
+	switch setting {
+	case "webAdmin.logLevel":
+		setLogLevel(conf, l.log)
+	case "webAdmin.password":
+		// the password only creates the first user when there is none
+		l.users.Load(conf.WebAdmin.Password)
+	case "metrics.username", "metrics.password", "metrics.token":
+		l.server.SetMetrics(conf.Metrics)
+	case "bundles.trusted":
+		l.bundles.SetTrusted(conf.Bundles.Trusted)
+	case "backup.keep", "backup.maxAge":
+		l.backups.SetRetention(conf.Backup.Keep, conf.Backup.MaxAge)
+	case "players.recordAddresses", "players.retention":
+		l.players.SetOptions(conf.Players.RecordAddresses, conf.Players.Retention)
+	}
+}
+
+// stopInstances stops every running instance at the same time, each one
+// gets the whole stop timeout to exit before it is killed.
+func stopInstances(instances *insurgency.Instances, log *admin_log.Log) {
+
+	var wait sync.WaitGroup
+	for _, instance := range instances.List() {
+		if !instance.IsRunning() {
+			continue
+		}
+		wait.Add(1)
+		go func(instance *insurgency.Instance) {
+			defer wait.Done()
+			instance.Stop()
+		}(instance)
+	}
+	wait.Wait()
+}
+
+// listening checks that the web admin still accepts connections, it is
+// the health check of the systemd watchdog.
+func listening(address string, port int) bool {
+
+	if ip := net.ParseIP(address); address == "" || (ip != nil && ip.IsUnspecified()) {
+		address = "127.0.0.1"
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(address, fmt.Sprintf("%d", port)), 5*time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
@@ -0,0 +1,196 @@
+package a2s
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+type Info struct {
+	Protocol   uint8  `json:"protocol"`
+	Name       string `json:"name"`
+	Map        string `json:"map"`
+	Folder     string `json:"folder"`
+	Game       string `json:"game"`
+	AppID      uint16 `json:"appId"`
+	Players    uint8  `json:"players"`
+	MaxPlayers uint8  `json:"maxPlayers"`
+	Bots       uint8  `json:"bots"`
+	Version    string `json:"version"`
+}
+
+type Player struct {
+	Index    uint8         `json:"index"`
+	Name     string        `json:"name"`
+	Score    int32         `json:"score"`
+	Duration time.Duration `json:"duration"`
+}
+
+type Client struct {
+	Address string        `json:"address"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+const (
+	MODULE = "a2s"
+
+	DEFAULT_TIMEOUT = 3 * time.Second
+	MAX_PACKET_SIZE = 1400
+
+	A2S_INFO             = 0x54
+	A2S_INFO_RESPONSE    = 0x49
+	A2S_PLAYER           = 0x55
+	A2S_PLAYER_RESPONSE  = 0x44
+	A2S_CHALLENGE        = 0x41
+	A2S_SINGLE_PACKET    = -1
+	A2S_CHALLENGE_EMPTY  = -1
+	A2S_INFO_PAYLOAD_STR = "Source Engine Query"
+)
+
+func New(address string) *Client {
+
+	c := new(Client)
+	c.Address = address
+	c.Timeout = DEFAULT_TIMEOUT
+
+	return c
+}
+
+func (c *Client) Info() (*Info, error) {
+
+	request := new(bytes.Buffer)
+	binary.Write(request, binary.LittleEndian, int32(A2S_SINGLE_PACKET))
+	request.WriteByte(A2S_INFO)
+	request.WriteString(A2S_INFO_PAYLOAD_STR)
+	request.WriteByte(0)
+
+	data, err := c.query(request.Bytes(), A2S_INFO_RESPONSE, true)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(data)
+	info := new(Info)
+	info.Protocol, _ = r.ReadByte()
+	info.Name = readString(r)
+	info.Map = readString(r)
+	info.Folder = readString(r)
+	info.Game = readString(r)
+	binary.Read(r, binary.LittleEndian, &info.AppID)
+	info.Players, _ = r.ReadByte()
+	info.MaxPlayers, _ = r.ReadByte()
+	info.Bots, _ = r.ReadByte()
+	// server type, environment, visibility and vac
+	r.Seek(4, 1)
+	info.Version = readString(r)
+
+	return info, nil
+}
+
+func (c *Client) Players() ([]Player, error) {
+
+	request := new(bytes.Buffer)
+	binary.Write(request, binary.LittleEndian, int32(A2S_SINGLE_PACKET))
+	request.WriteByte(A2S_PLAYER)
+	binary.Write(request, binary.LittleEndian, int32(A2S_CHALLENGE_EMPTY))
+
+	data, err := c.query(request.Bytes(), A2S_PLAYER_RESPONSE, false)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(data)
+	count, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("invalid A2S_PLAYER response from '%s'", c.Address)
+	}
+
+	players := make([]Player, 0, count)
+	for i := 0; i < int(count) && r.Len() > 0; i++ {
+		var p Player
+		var duration float32
+		p.Index, _ = r.ReadByte()
+		p.Name = readString(r)
+		binary.Read(r, binary.LittleEndian, &p.Score)
+		binary.Read(r, binary.LittleEndian, &duration)
+		if !math.IsNaN(float64(duration)) {
+			p.Duration = time.Duration(float64(duration) * float64(time.Second))
+		}
+		players = append(players, p)
+	}
+
+	return players, nil
+}
+
+// query sends the request and answers a server challenge if one is
+// returned. A2S_INFO appends the challenge to the original payload while
+// A2S_PLAYER replaces the trailing challenge number.
+func (c *Client) query(request []byte, expected byte, appendChallenge bool) ([]byte, error) {
+
+	conn, err := net.DialTimeout("udp", c.Address, c.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to '%s'. ERR: %s", c.Address, err.Error())
+	}
+	defer conn.Close()
+
+	base := request
+	for attempt := 0; attempt < 3; attempt++ {
+
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+		if _, err := conn.Write(request); err != nil {
+			return nil, fmt.Errorf("failed to send query to '%s'. ERR: %s", c.Address, err.Error())
+		}
+
+		buf := make([]byte, MAX_PACKET_SIZE)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read query response from '%s'. ERR: %s", c.Address, err.Error())
+		}
+
+		if n < 5 {
+			return nil, fmt.Errorf("invalid query response from '%s'", c.Address)
+		}
+
+		var header int32
+		binary.Read(bytes.NewReader(buf[0:4]), binary.LittleEndian, &header)
+		if header != A2S_SINGLE_PACKET {
+			return nil, fmt.Errorf("split query responses from '%s' are not supported", c.Address)
+		}
+
+		switch buf[4] {
+		case expected:
+			return buf[5:n], nil
+		case A2S_CHALLENGE:
+			if n < 9 {
+				return nil, fmt.Errorf("invalid challenge from '%s'", c.Address)
+			}
+			challenge := buf[5:9]
+			if appendChallenge {
+				request = append(base[:len(base):len(base)], challenge...)
+			} else {
+				request = append(base[:len(base)-4:len(base)-4], challenge...)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected query response type 0x%02x from '%s'", buf[4], c.Address)
+		}
+	}
+
+	return nil, fmt.Errorf("too many challenges from '%s'", c.Address)
+}
+
+func readString(r *bytes.Reader) string {
+
+	var buf []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil || b == 0 {
+			break
+		}
+		buf = append(buf, b)
+	}
+
+	return string(buf)
+}
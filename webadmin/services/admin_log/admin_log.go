@@ -6,6 +6,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,6 +28,7 @@ type Log struct {
 	level  Severity
 	multi  io.Writer
 	isOpen bool
+	mutex  sync.Mutex
 }
 
 var severities = map[Severity]string{
@@ -40,8 +43,7 @@ func New() *Log {
 
 	var err error
 	l := new(Log)
-	now := time.Now()
-	fName := fmt.Sprintf("./logs/%04d_%02d_%02dT%02d_%02d_%02d.log", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second())
+	fName := fileName("./logs", time.Now())
 	l.path, err = filepath.Abs(fName)
 	if err != nil {
 		log.Fatalf("failed to calculate absolute file for path '%s'. ERR:", fName)
@@ -52,21 +54,65 @@ func New() *Log {
 	return l
 }
 
+func fileName(dir string, now time.Time) string {
+
+	return filepath.Join(dir, fmt.Sprintf("%04d_%02d_%02dT%02d_%02d_%02d.log", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second()))
+}
+
 func (l *Log) Open() {
 	l.isOpen = true
 }
 
+// Reopen starts writing to a new log file in the same directory, so the
+// previous one can be rotated or compressed.
+func (l *Log) Reopen() {
+
+	l.mutex.Lock()
+	l.path = fileName(filepath.Dir(l.path), time.Now())
+	l.mutex.Unlock()
+}
+
+// SetOutput sets where log lines are printed besides the log file, for
+// commands that write their own output to the standard output.
+func (l *Log) SetOutput(out *os.File) {
+
+	l.mutex.Lock()
+	l.out = out
+	l.mutex.Unlock()
+}
+
 func (l *Log) SetLogLevel(severity Severity) {
 
+	l.mutex.Lock()
 	l.level = severity
+	l.mutex.Unlock()
+}
+
+// ParseSeverity returns the severity for a level name like "info".
+func ParseSeverity(name string) (Severity, error) {
+
+	for severity, text := range severities {
+		if strings.EqualFold(text, name) {
+			return severity, nil
+		}
+	}
+
+	return LOG_INFO, fmt.Errorf("unknown log level '%s'", name)
 }
 
 func (l *Log) Write(msg string, module string, severity Severity) error {
 
-	if l.level >= severity {
+	l.mutex.Lock()
+	level := l.level
+	l.mutex.Unlock()
+
+	if level >= severity {
 
 		var err error
 
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
 		if l.isOpen {
 			l.file, err = os.OpenFile(l.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 			if err != nil {
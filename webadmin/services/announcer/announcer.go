@@ -0,0 +1,377 @@
+package announcer
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/scheduler"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+)
+
+// Announcements are the messages said on an instance in turn, every
+// Interval seconds while at least MinPlayers players are connected, and
+// the message that welcomes the players joining it.
+type Announcements struct {
+	Instance   string    `json:"instance"`
+	Enabled    bool      `json:"enabled"`
+	Interval   int       `json:"interval"`
+	MinPlayers int       `json:"minPlayers"`
+	Messages   []string  `json:"messages"`
+	Welcome    string    `json:"welcome"`
+	Updated    time.Time `json:"updated"`
+	next       int
+	last       time.Time
+}
+
+// Announcer says the announcements of every instance through RCON.
+type Announcer struct {
+	Scheduler     *scheduler.Scheduler
+	Announcements map[string]*Announcements
+	instances     *insurgency.Instances
+	followed      map[string]chan struct{}
+	db            *store.Store
+	log           *admin_log.Log
+	done          chan struct{}
+	mutex         sync.Mutex
+}
+
+const (
+	MODULE = "announcer"
+
+	TICK_INTERVAL    = 5 * time.Second
+	MIN_INTERVAL     = 30
+	DEFAULT_INTERVAL = 300
+	WELCOME_DELAY    = 15 * time.Second
+)
+
+var (
+	// Variables are replaced in the messages, {name} only in the welcome
+	Variables = []string{"{server}", "{players}", "{maxPlayers}", "{map}", "{nextMap}", "{restart}", "{time}", "{name}"}
+
+	variable = regexp.MustCompile(`\{[A-Za-z]+\}`)
+)
+
+func New(conf *config.Configuration, log *admin_log.Log, instances *insurgency.Instances, db *store.Store) *Announcer {
+
+	a := new(Announcer)
+	a.Announcements = make(map[string]*Announcements)
+	a.instances = instances
+	a.followed = make(map[string]chan struct{})
+	a.db = db
+	a.log = log
+
+	return a
+}
+
+func (a *Announcer) Load() error {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	err := a.db.View(func(tx *store.Tx) error {
+		return tx.ForEach(store.BUCKET_ANNOUNCEMENTS, func(id string, value []byte) error {
+			announcements := new(Announcements)
+			if err := json.Unmarshal(value, announcements); err != nil {
+				return fmt.Errorf("failed to parse the announcements of instance '%s'. ERR: %s", id, err.Error())
+			}
+			a.Announcements[id] = announcements
+			return nil
+		})
+	})
+	if err != nil {
+		return a.log.Write(fmt.Sprintf("failed to load announcements. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	return nil
+}
+
+// Get returns the announcements of an instance, disabled ones when it has
+// none.
+func (a *Announcer) Get(instance string) Announcements {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if announcements, ok := a.Announcements[instance]; ok {
+		return *announcements
+	}
+
+	return Announcements{Instance: instance, Interval: DEFAULT_INTERVAL, MinPlayers: 1, Messages: make([]string, 0)}
+}
+
+// Set validates and saves the announcements of an instance, the rotation
+// starts again from the first message.
+func (a *Announcer) Set(instance string, announcements Announcements) (*Announcements, error) {
+
+	announcements.Instance = instance
+	if announcements.Interval == 0 {
+		announcements.Interval = DEFAULT_INTERVAL
+	}
+	if announcements.MinPlayers <= 0 {
+		announcements.MinPlayers = 1
+	}
+	if announcements.Messages == nil {
+		announcements.Messages = make([]string, 0)
+	}
+	if err := announcements.validate(); err != nil {
+		return nil, err
+	}
+	announcements.Updated = time.Now()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	err := a.db.Update(func(tx *store.Tx) error {
+		return tx.Put(store.BUCKET_ANNOUNCEMENTS, instance, announcements)
+	})
+	if err != nil {
+		return nil, a.log.Write(fmt.Sprintf("failed to save the announcements of instance '%s'. ERR: %s", instance, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	a.Announcements[instance] = &announcements
+
+	return &announcements, nil
+}
+
+func (a *Announcer) Remove(instance string) error {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, ok := a.Announcements[instance]; !ok {
+		return fmt.Errorf("instance '%s' has no announcements", instance)
+	}
+
+	err := a.db.Update(func(tx *store.Tx) error {
+		return tx.Delete(store.BUCKET_ANNOUNCEMENTS, instance)
+	})
+	if err != nil {
+		return a.log.Write(fmt.Sprintf("failed to remove the announcements of instance '%s'. ERR: %s", instance, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	delete(a.Announcements, instance)
+
+	return nil
+}
+
+func (n *Announcements) validate() error {
+
+	if n.Interval < MIN_INTERVAL {
+		return fmt.Errorf("interval %d must be at least %d seconds", n.Interval, MIN_INTERVAL)
+	}
+
+	for _, message := range n.Messages {
+		if strings.TrimSpace(message) == "" {
+			return fmt.Errorf("announcements can not be empty")
+		}
+		if err := checkVariables(message, false); err != nil {
+			return err
+		}
+	}
+
+	return checkVariables(n.Welcome, true)
+}
+
+func checkVariables(message string, welcome bool) error {
+
+	for _, name := range variable.FindAllString(message, -1) {
+		if !contains(Variables, name) {
+			return fmt.Errorf("unknown variable %s in '%s', the variables are %s", name, message, strings.Join(Variables, ", "))
+		}
+		if name == "{name}" && !welcome {
+			return fmt.Errorf("variable {name} can only be used in the welcome message")
+		}
+	}
+
+	return nil
+}
+
+func (a *Announcer) Start() {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.done != nil {
+		return
+	}
+
+	a.done = make(chan struct{})
+	go a.loop(a.done)
+}
+
+func (a *Announcer) Stop() {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.done == nil {
+		return
+	}
+	close(a.done)
+	a.done = nil
+
+	for id, stop := range a.followed {
+		close(stop)
+		delete(a.followed, id)
+	}
+}
+
+func (a *Announcer) loop(done chan struct{}) {
+
+	ticker := time.NewTicker(TICK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			a.follow()
+			a.announce(now)
+		}
+	}
+}
+
+// announce says the next message of every instance that is due.
+func (a *Announcer) announce(now time.Time) {
+
+	for _, instance := range a.instances.List() {
+
+		a.mutex.Lock()
+		announcements, ok := a.Announcements[instance.ID]
+		if !ok || !announcements.Enabled || len(announcements.Messages) == 0 || now.Sub(announcements.last) < time.Duration(announcements.Interval)*time.Second {
+			a.mutex.Unlock()
+			continue
+		}
+		// an empty server waits for another interval as well
+		announcements.last = now
+		minPlayers := announcements.MinPlayers
+		a.mutex.Unlock()
+
+		if !instance.IsRunning() {
+			continue
+		}
+
+		values := a.values(instance)
+		if values.players < minPlayers {
+			continue
+		}
+
+		a.mutex.Lock()
+		announcements.next %= len(announcements.Messages)
+		message := announcements.Messages[announcements.next]
+		announcements.next++
+		a.mutex.Unlock()
+
+		if _, err := instance.Say(values.render(message)); err != nil {
+			a.log.Write(fmt.Sprintf("failed to announce on instance '%s'. ERR: %s", instance.ID, err.Error()), MODULE, admin_log.LOG_WARNING)
+		}
+	}
+}
+
+// follow reads the log of the instances not followed yet, for the players
+// to welcome, and stops following the removed ones.
+func (a *Announcer) follow() {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.done == nil {
+		return
+	}
+
+	ids := make(map[string]bool)
+	for _, instance := range a.instances.List() {
+		ids[instance.ID] = true
+		if _, ok := a.followed[instance.ID]; ok {
+			continue
+		}
+		stop := make(chan struct{})
+		a.followed[instance.ID] = stop
+		go a.read(instance, stop)
+	}
+
+	for id, stop := range a.followed {
+		if !ids[id] {
+			close(stop)
+			delete(a.followed, id)
+		}
+	}
+}
+
+func (a *Announcer) read(instance *insurgency.Instance, stop chan struct{}) {
+
+	lines, cancel := instance.Subscribe()
+	defer cancel()
+
+	parser := insurgency.NewLogParser()
+	for {
+		select {
+		case <-stop:
+			return
+		case line := <-lines:
+			if event, ok := parser.Parse(line); ok && event.Type == insurgency.LOG_PLAYER_JOINED {
+				a.welcome(instance, event.Name)
+			}
+		}
+	}
+}
+
+// welcome greets a player once they had time to load the map.
+func (a *Announcer) welcome(instance *insurgency.Instance, name string) {
+
+	a.mutex.Lock()
+	announcements, ok := a.Announcements[instance.ID]
+	if !ok || !announcements.Enabled || announcements.Welcome == "" {
+		a.mutex.Unlock()
+		return
+	}
+	message := announcements.Welcome
+	a.mutex.Unlock()
+
+	time.AfterFunc(WELCOME_DELAY, func() {
+		if !instance.IsRunning() {
+			return
+		}
+		values := a.values(instance)
+		values.name = name
+		if _, err := instance.Say(values.render(message)); err != nil {
+			a.log.Write(fmt.Sprintf("failed to welcome '%s' on instance '%s'. ERR: %s", name, instance.ID, err.Error()), MODULE, admin_log.LOG_WARNING)
+		}
+	})
+}
+
+// Preview renders the announcements of an instance with its current
+// values, the welcome for a player named "Player".
+func (a *Announcer) Preview(instance *insurgency.Instance) ([]string, string) {
+
+	announcements := a.Get(instance.ID)
+	values := a.values(instance)
+
+	messages := make([]string, 0, len(announcements.Messages))
+	for _, message := range announcements.Messages {
+		messages = append(messages, values.render(message))
+	}
+
+	values.name = "Player"
+
+	return messages, values.render(announcements.Welcome)
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,89 @@
+package announcer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+)
+
+// values are what the variables of a message are replaced with.
+type values struct {
+	server     string
+	players    int
+	maxPlayers int
+	mapName    string
+	nextMap    string
+	restart    string
+	name       string
+}
+
+const (
+	UNKNOWN      = "unknown"
+	RESTART_NONE = "not scheduled"
+	RESTART_SOON = "less than a minute"
+)
+
+// values reads the current values of an instance: the players and map
+// from a server query, the next map from the map cycle and the next
+// restart from the schedules.
+func (a *Announcer) values(instance *insurgency.Instance) values {
+
+	v := values{server: instance.Name, mapName: UNKNOWN, nextMap: UNKNOWN, restart: RESTART_NONE}
+
+	if info, err := instance.Query().Info(); err == nil {
+		v.players = int(info.Players)
+		v.maxPlayers = int(info.MaxPlayers)
+		v.mapName = info.Map
+	} else {
+		a.log.Write(fmt.Sprintf("failed to query instance '%s'. ERR: %s", instance.ID, err.Error()), MODULE, admin_log.LOG_DEBUG)
+	}
+
+	if cycle, err := instance.MapCycle(); err == nil {
+		if next, ok := insurgency.NextScenario(cycle, v.mapName); ok {
+			v.nextMap = insurgency.ScenarioMap(next.Scenario)
+		}
+	}
+
+	if a.Scheduler != nil {
+		// stops and updates restart the server as well for the players
+		if next := a.Scheduler.NextDisruption(instance.ID); !next.IsZero() {
+			v.restart = remaining(time.Until(next))
+		}
+	}
+
+	return v
+}
+
+func (v values) render(message string) string {
+
+	return strings.NewReplacer(
+		"{server}", v.server,
+		"{players}", strconv.Itoa(v.players),
+		"{maxPlayers}", strconv.Itoa(v.maxPlayers),
+		"{map}", v.mapName,
+		"{nextMap}", v.nextMap,
+		"{restart}", v.restart,
+		"{time}", time.Now().Format("15:04"),
+		"{name}", v.name,
+	).Replace(message)
+}
+
+// remaining writes the time left before a restart in hours and minutes.
+func remaining(d time.Duration) string {
+
+	minutes := int(d / time.Minute)
+	switch {
+	case minutes < 1:
+		return RESTART_SOON
+	case minutes < 60:
+		return fmt.Sprintf("%d minute(s)", minutes)
+	case minutes%60 == 0:
+		return fmt.Sprintf("%d hour(s)", minutes/60)
+	}
+
+	return fmt.Sprintf("%d hour(s) %d minute(s)", minutes/60, minutes%60)
+}
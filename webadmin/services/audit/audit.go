@@ -0,0 +1,200 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+)
+
+// Entry is one administrative action. Every entry carries the hash of the
+// previous one and its own hash, computed over the entry with an empty
+// Hash and Mac, so editing or removing an entry breaks the chain after it.
+// Mac authenticates the hash with the audit key, kept outside the store,
+// so the chain can not be rebuilt by someone who can only write the store.
+type Entry struct {
+	Sequence   uint64            `json:"seq"`
+	Time       time.Time         `json:"time"`
+	User       string            `json:"user"`
+	Address    string            `json:"address"`
+	Action     string            `json:"action"`
+	Instance   string            `json:"instance,omitempty"`
+	Target     string            `json:"target,omitempty"`
+	Reason     string            `json:"reason,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Result     string            `json:"result,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Previous   string            `json:"prev"`
+	Hash       string            `json:"hash"`
+	Mac        string            `json:"mac,omitempty"`
+}
+
+type Audit struct {
+	KeyFile string
+	key     []byte
+	db      *store.Store
+	log     *admin_log.Log
+	mutex   sync.Mutex
+}
+
+const (
+	MODULE = "audit"
+
+	// ADDRESS_LOCAL is the address of the actions not made through the API
+	ADDRESS_LOCAL = "local"
+
+	KEY_NAME = "audit.key"
+	KEY_SIZE = 32
+)
+
+func New(conf *config.Configuration, log *admin_log.Log, db *store.Store) *Audit {
+
+	a := new(Audit)
+	a.KeyFile = conf.Audit.KeyFile
+	if a.KeyFile == "" {
+		a.KeyFile = filepath.Join(conf.WebAdmin.ConfigDir, KEY_NAME)
+	}
+	a.db = db
+	a.log = log
+
+	return a
+}
+
+// Record appends the entry to the audit bucket, chained to the last entry.
+// Entries are never changed or removed once written. The sequence and hash
+// of the new head of the chain go to the admin log, which the store can
+// not rewrite, so removed entries at the end are noticed too.
+func (a *Audit) Record(entry Entry) error {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	secret, err := a.loadKey(true)
+	if err != nil {
+		return a.log.Write(fmt.Sprintf("failed to record audit entry '%s'. ERR: %s", entry.Action, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+
+	err = a.db.Update(func(tx *store.Tx) error {
+		var last Entry
+		key, err := tx.Last(store.BUCKET_AUDIT, &last)
+		if err != nil {
+			return err
+		}
+		position := uint64(0)
+		if key != "" {
+			if position, err = strconv.ParseUint(key, 10, 64); err != nil {
+				return fmt.Errorf("invalid audit key '%s'", key)
+			}
+		}
+
+		entry.Sequence = last.Sequence + 1
+		entry.Previous = last.Hash
+		if entry.Hash, err = entry.digest(); err != nil {
+			return fmt.Errorf("failed to serialize audit entry. ERR: %s", err.Error())
+		}
+		entry.Mac = sign(secret, entry.Hash)
+
+		return tx.Put(store.BUCKET_AUDIT, store.SequenceKey(position+1), entry)
+	})
+	if err != nil {
+		return a.log.Write(fmt.Sprintf("failed to record audit entry '%s'. ERR: %s", entry.Action, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	a.log.Write(fmt.Sprintf("%s by '%s' from %s on '%s', audit entry %d hash %s", entry.Action, entry.User, entry.Address, entry.Instance, entry.Sequence, entry.Hash), MODULE, admin_log.LOG_INFO)
+
+	return nil
+}
+
+// scan calls fn with every entry, oldest first.
+func (a *Audit) scan(fn func(entry Entry) error) error {
+
+	return a.db.View(func(tx *store.Tx) error {
+		return tx.ForEach(store.BUCKET_AUDIT, func(key string, value []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return fmt.Errorf("failed to parse audit entry %s. ERR: %s", key, err.Error())
+			}
+			return fn(entry)
+		})
+	})
+}
+
+// digest is the hex encoded SHA-256 of the entry serialized without its
+// own hash and mac.
+func (e Entry) digest() (string, error) {
+
+	e.Hash = ""
+	e.Mac = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// loadKey reads the audit key, creating it when create is set and the key
+// file does not exist yet.
+func (a *Audit) loadKey(create bool) ([]byte, error) {
+
+	if a.key != nil {
+		return a.key, nil
+	}
+
+	data, err := os.ReadFile(a.KeyFile)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != KEY_SIZE {
+			return nil, fmt.Errorf("invalid audit key '%s'", a.KeyFile)
+		}
+		a.key = key
+		return key, nil
+	}
+	if !os.IsNotExist(err) || !create {
+		return nil, fmt.Errorf("failed to read audit key '%s'. ERR: %s", a.KeyFile, err.Error())
+	}
+
+	key := make([]byte, KEY_SIZE)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate audit key. ERR: %s", err.Error())
+	}
+	if err := os.MkdirAll(filepath.Dir(a.KeyFile), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create directory '%s'. ERR: %s", filepath.Dir(a.KeyFile), err.Error())
+	}
+	if err := os.WriteFile(a.KeyFile, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write audit key '%s'. ERR: %s", a.KeyFile, err.Error())
+	}
+
+	a.log.Write(fmt.Sprintf("audit key created at '%s'", a.KeyFile), MODULE, admin_log.LOG_INFO)
+
+	a.key = key
+	return key, nil
+}
+
+// sign is the hex encoded HMAC-SHA256 of an entry hash with the audit key.
+func sign(key []byte, hash string) string {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(hash))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
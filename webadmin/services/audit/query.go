@@ -0,0 +1,263 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Filter selects audit entries, empty fields match everything. Action
+// matches a whole group when it ends with '*', like 'player.*'.
+type Filter struct {
+	User     string
+	Address  string
+	Action   string
+	Instance string
+	Target   string
+	Since    time.Time
+	Until    time.Time
+	Failed   bool
+	Offset   int
+	Limit    int
+}
+
+// Verification is the result of checking the hash chain of the audit log.
+// Unsigned entries have no hash and Unauthenticated ones no mac, both were
+// written before those were introduced.
+type Verification struct {
+	Valid           bool   `json:"valid"`
+	Entries         int    `json:"entries"`
+	Unsigned        int    `json:"unsigned"`
+	Unauthenticated int    `json:"unauthenticated"`
+	Sequence        uint64 `json:"lastSequence"`
+	Hash            string `json:"lastHash"`
+	Broken          uint64 `json:"brokenAt,omitempty"`
+	Position        int    `json:"position,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+const (
+	EXPORT_JSON = "json"
+	EXPORT_CSV  = "csv"
+)
+
+var (
+	ExportFormats = []string{EXPORT_JSON, EXPORT_CSV}
+)
+
+func (f Filter) matches(entry Entry) bool {
+
+	if f.User != "" && entry.User != f.User {
+		return false
+	}
+	if f.Address != "" && entry.Address != f.Address {
+		return false
+	}
+	if f.Action != "" {
+		if strings.HasSuffix(f.Action, "*") {
+			if !strings.HasPrefix(entry.Action, strings.TrimSuffix(f.Action, "*")) {
+				return false
+			}
+		} else if entry.Action != f.Action {
+			return false
+		}
+	}
+	if f.Instance != "" && entry.Instance != f.Instance {
+		return false
+	}
+	if f.Target != "" && entry.Target != f.Target {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.Time.Before(f.Until) {
+		return false
+	}
+	if f.Failed && entry.Error == "" {
+		return false
+	}
+
+	return true
+}
+
+// Query returns the entries matching the filter, the most recent first,
+// and how many matched before Offset and Limit were applied.
+func (a *Audit) Query(filter Filter) ([]Entry, int, error) {
+
+	entries := make([]Entry, 0)
+	err := a.scan(func(entry Entry) error {
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	total := len(entries)
+	if filter.Offset > 0 {
+		if filter.Offset >= len(entries) {
+			return []Entry{}, total, nil
+		}
+		entries = entries[filter.Offset:]
+	}
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+
+	return entries, total, nil
+}
+
+// Export writes the entries matching the filter, oldest first, as a JSON
+// array or as CSV with the parameters joined as key=value pairs.
+func (a *Audit) Export(w io.Writer, format string, filter Filter) error {
+
+	if format != EXPORT_JSON && format != EXPORT_CSV {
+		return fmt.Errorf("export format '%s' is not one of %v", format, ExportFormats)
+	}
+
+	filter.Offset = 0
+	filter.Limit = 0
+	entries, _, err := a.Query(filter)
+	if err != nil {
+		return err
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	if format == EXPORT_JSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"seq", "time", "user", "address", "action", "instance", "target", "reason", "parameters", "result", "error", "prev", "hash"})
+	for _, entry := range entries {
+		names := make([]string, 0, len(entry.Parameters))
+		for name := range entry.Parameters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parameters := make([]string, 0, len(names))
+		for _, name := range names {
+			parameters = append(parameters, name+"="+entry.Parameters[name])
+		}
+		out.Write([]string{
+			fmt.Sprint(entry.Sequence),
+			entry.Time.Format(time.RFC3339Nano),
+			entry.User,
+			entry.Address,
+			entry.Action,
+			entry.Instance,
+			entry.Target,
+			entry.Reason,
+			strings.Join(parameters, ";"),
+			entry.Result,
+			entry.Error,
+			entry.Previous,
+			entry.Hash,
+		})
+	}
+	out.Flush()
+
+	return out.Error()
+}
+
+// Verify walks the hash chain of the audit log. Entries written before
+// the chain was introduced have no hash and are only counted, but once the
+// chain started every entry must carry the hash of the one before it.
+//
+// A valid chain guarantees that no entry was modified, reordered or removed
+// from the middle of the log, and the macs that it was written by a holder
+// of the audit key, so rebuilding the chain after editing the store is
+// detected as long as the key file is not compromised. Removing the latest
+// entries can not be seen in the chain itself, compare lastSequence and
+// lastHash with the last head recorded in the admin log.
+func (a *Audit) Verify() (Verification, error) {
+
+	a.mutex.Lock()
+	key, keyErr := a.loadKey(false)
+	a.mutex.Unlock()
+
+	var result Verification
+	var authenticated bool
+	var last Entry
+	position := 0
+	err := a.scan(func(entry Entry) error {
+		position++
+		result.Entries++
+		last = entry
+		if result.Error != "" {
+			return nil
+		}
+
+		if entry.Hash == "" {
+			if result.Hash != "" {
+				result.Broken, result.Position = result.Sequence+1, position
+				result.Error = "entry without hash inside the chain"
+			} else {
+				result.Unsigned++
+			}
+			return nil
+		}
+
+		fail := func(message string) {
+			result.Broken, result.Position = entry.Sequence, position
+			result.Error = message
+		}
+
+		hash, err := entry.digest()
+		switch {
+		case err != nil:
+			fail(err.Error())
+		case entry.Previous != result.Hash:
+			fail(fmt.Sprintf("entry %d does not follow entry %d", entry.Sequence, result.Sequence))
+		case entry.Sequence != result.Sequence+1:
+			fail(fmt.Sprintf("entry %d follows entry %d", entry.Sequence, result.Sequence))
+		case hash != entry.Hash:
+			fail(fmt.Sprintf("entry %d was modified", entry.Sequence))
+		case entry.Mac == "" && authenticated:
+			fail(fmt.Sprintf("entry %d has no mac inside the authenticated chain", entry.Sequence))
+		case entry.Mac != "" && keyErr != nil:
+			fail(keyErr.Error())
+		case entry.Mac != "" && !hmac.Equal([]byte(entry.Mac), []byte(sign(key, entry.Hash))):
+			fail(fmt.Sprintf("entry %d was not written with the audit key", entry.Sequence))
+		default:
+			if entry.Mac == "" {
+				result.Unauthenticated++
+			}
+			authenticated = entry.Mac != ""
+			result.Sequence = entry.Sequence
+			result.Hash = entry.Hash
+		}
+
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	// entries are authenticated since the key exists, a log without any
+	// mac at its end was rewritten
+	if result.Error == "" && keyErr == nil && result.Entries > 0 && last.Mac == "" {
+		result.Broken, result.Position = last.Sequence, position
+		result.Error = "the latest entry has no mac although the audit key exists"
+	}
+
+	result.Valid = result.Error == ""
+
+	return result, nil
+}
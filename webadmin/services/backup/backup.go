@@ -0,0 +1,547 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+type Info struct {
+	Name     string    `json:"name"`
+	Instance string    `json:"instance"`
+	Time     time.Time `json:"time"`
+	Trigger  string    `json:"trigger"`
+	Size     int64     `json:"size"`
+	User     string    `json:"user,omitempty"`
+}
+
+type Manifest struct {
+	Instance string    `json:"instance"`
+	Time     time.Time `json:"time"`
+	Trigger  string    `json:"trigger"`
+	User     string    `json:"user,omitempty"`
+	Files    []string  `json:"files"`
+}
+
+type Backups struct {
+	Dir       string `json:"dir"`
+	Keep      int    `json:"keep"`
+	MaxAge    int    `json:"maxAge"`
+	instances *insurgency.Instances
+	db        *store.Store
+	log       *admin_log.Log
+	mutex     sync.Mutex
+}
+
+const (
+	MODULE = "backup"
+
+	TRIGGER_MANUAL    = "manual"
+	TRIGGER_SCHEDULED = "scheduled"
+	TRIGGER_RESTORE   = "restore"
+
+	// names have milliseconds so backups taken in the same second do not
+	// overwrite each other, PARSE_FORMAT still reads the names without them
+	TIME_FORMAT  = "20060102T150405.000"
+	PARSE_FORMAT = "20060102T150405"
+	EXTENSION    = ".tar.gz"
+
+	MANIFEST_FILE = "manifest.json"
+	INSTANCE_FILE = "instance.json"
+	CONFIG_DIR    = "Config"
+	MAPCYCLE_FILE = "MapCycle.txt"
+	ADMINS_FILE   = "Admins.txt"
+	MODS_FILE     = "Mods.txt"
+	BANS_FILE     = "Bans.json"
+)
+
+var (
+	validName = regexp.MustCompile(`^([A-Za-z0-9_-]+)_(\d{8}T\d{6}(?:\.\d{3})?)_(manual|scheduled|restore)\.tar\.gz$`)
+)
+
+func New(conf *config.Configuration, log *admin_log.Log, instances *insurgency.Instances, db *store.Store) *Backups {
+
+	b := new(Backups)
+	b.Dir = conf.Backup.Dir
+	b.Keep = conf.Backup.Keep
+	b.MaxAge = conf.Backup.MaxAge
+	b.instances = instances
+	b.db = db
+	b.log = log
+
+	return b
+}
+
+// SetRetention replaces the retention policy, applied from the next
+// backup on.
+func (b *Backups) SetRetention(keep int, maxAge int) {
+
+	b.mutex.Lock()
+	b.Keep = keep
+	b.MaxAge = maxAge
+	b.mutex.Unlock()
+}
+
+// files maps the name of every saved file inside the archive to its
+// location in the game installation.
+func files(instance *insurgency.Instance) map[string]string {
+
+	return map[string]string{
+		MAPCYCLE_FILE: instance.MapCycleFile(),
+		ADMINS_FILE:   instance.AdminsFile(),
+		MODS_FILE:     instance.ModsFile(),
+		BANS_FILE:     instance.BansFile(),
+	}
+}
+
+func (b *Backups) instanceDir(id string) string {
+
+	return filepath.Join(b.Dir, id)
+}
+
+func (b *Backups) Create(instance *insurgency.Instance, trigger string, user string) (*Info, error) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	i, err := b.create(instance, trigger, user)
+	if err != nil {
+		return nil, err
+	}
+	b.prune(instance.ID)
+
+	return i, nil
+}
+
+func (b *Backups) create(instance *insurgency.Instance, trigger string, user string) (*Info, error) {
+
+	dir := b.instanceDir(instance.ID)
+	now := time.Now()
+	name := fmt.Sprintf("%s_%s_%s", instance.ID, now.Format(TIME_FORMAT), trigger)
+	for utils.FileExists(filepath.Join(dir, name+EXTENSION)) {
+		now = now.Add(time.Millisecond)
+		name = fmt.Sprintf("%s_%s_%s", instance.ID, now.Format(TIME_FORMAT), trigger)
+	}
+
+	staging, err := os.MkdirTemp("", "sandstorm_backup_")
+	if err != nil {
+		return nil, b.log.Write(fmt.Sprintf("failed to create temporary directory. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	defer os.RemoveAll(staging)
+
+	root := filepath.Join(staging, name)
+	if err := os.MkdirAll(root, 0750); err != nil {
+		return nil, b.log.Write(fmt.Sprintf("failed to create directory '%s'. ERR: %s", root, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	manifest := Manifest{Instance: instance.ID, Time: now, Trigger: trigger, User: user, Files: make([]string, 0)}
+
+	if utils.DirectoryExists(instance.ConfigDir()) {
+		if err := utils.CopyDir(instance.ConfigDir(), filepath.Join(root, CONFIG_DIR)); err != nil {
+			return nil, b.log.Write(fmt.Sprintf("failed to copy '%s'. ERR: %s", instance.ConfigDir(), err.Error()), MODULE, admin_log.LOG_ERROR)
+		}
+		manifest.Files = append(manifest.Files, CONFIG_DIR)
+	}
+
+	for name, path := range files(instance) {
+		if !utils.FileExists(path) {
+			continue
+		}
+		if err := utils.CopyFile(path, filepath.Join(root, name)); err != nil {
+			return nil, b.log.Write(fmt.Sprintf("failed to copy '%s'. ERR: %s", path, err.Error()), MODULE, admin_log.LOG_ERROR)
+		}
+		manifest.Files = append(manifest.Files, name)
+	}
+
+	definition, err := json.MarshalIndent(instance, "", "  ")
+	if err != nil {
+		return nil, b.log.Write(fmt.Sprintf("failed to serialize instance '%s'. ERR: %s", instance.ID, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	if err := os.WriteFile(filepath.Join(root, INSTANCE_FILE), definition, 0640); err != nil {
+		return nil, b.log.Write(fmt.Sprintf("failed to write instance definition. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	manifest.Files = append(manifest.Files, INSTANCE_FILE)
+	sort.Strings(manifest.Files)
+
+	data, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := os.WriteFile(filepath.Join(root, MANIFEST_FILE), data, 0640); err != nil {
+		return nil, b.log.Write(fmt.Sprintf("failed to write backup manifest. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, b.log.Write(fmt.Sprintf("failed to create backup directory '%s'. ERR: %s", dir, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	archive := filepath.Join(dir, name+EXTENSION)
+	if err := utils.TarGz(root, archive); err != nil {
+		os.Remove(archive)
+		return nil, b.log.Write(fmt.Sprintf("failed to create backup '%s'. ERR: %s", archive, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	b.log.Write(fmt.Sprintf("backup '%s' of instance '%s' created", name, instance.ID), MODULE, admin_log.LOG_INFO)
+
+	i, err := info(archive)
+	if err != nil {
+		return nil, err
+	}
+	i.User = user
+	b.catalogue(i)
+
+	return i, nil
+}
+
+func (b *Backups) List(id string) ([]Info, error) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.list(id)
+}
+
+// list returns the instance's backups newest first.
+func (b *Backups) list(id string) ([]Info, error) {
+
+	list := make([]Info, 0)
+	dir := b.instanceDir(id)
+	if !utils.DirectoryExists(dir) {
+		return list, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory '%s'. ERR: %s", dir, err.Error())
+	}
+
+	catalogue := b.catalogued(id)
+	for _, entry := range entries {
+		if entry.IsDir() || !validName.MatchString(entry.Name()) {
+			continue
+		}
+		i, err := info(filepath.Join(dir, entry.Name()))
+		if err != nil || i.Instance != id {
+			continue
+		}
+		if known, ok := catalogue[i.Name]; ok {
+			i.User = known.User
+		}
+		list = append(list, *i)
+	}
+
+	sort.Slice(list, func(a, c int) bool {
+		return list[a].Time.After(list[c].Time)
+	})
+
+	return list, nil
+}
+
+// Path validates a backup name and returns the archive location.
+func (b *Backups) Path(id string, name string) (string, error) {
+
+	matches := validName.FindStringSubmatch(name)
+	if matches == nil || matches[1] != id || filepath.Base(name) != name {
+		return "", fmt.Errorf("invalid backup name '%s'", name)
+	}
+
+	path := filepath.Join(b.instanceDir(id), name)
+	if !utils.FileExists(path) {
+		return "", fmt.Errorf("backup '%s' not found", name)
+	}
+
+	return path, nil
+}
+
+func (b *Backups) Remove(id string, name string) error {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	path, err := b.Path(id, name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return b.log.Write(fmt.Sprintf("failed to remove backup '%s'. ERR: %s", path, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	b.forget(id, name)
+
+	return nil
+}
+
+// Restore stops the instance if it is running, takes a safety backup of the
+// current files, puts back every file found in the archive and starts the
+// instance again if it was running before, whether the restore worked or
+// not. The configuration directory is replaced as a whole.
+func (b *Backups) Restore(instance *insurgency.Instance, name string, user string) error {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	path, err := b.Path(instance.ID, name)
+	if err != nil {
+		return err
+	}
+
+	staging, err := os.MkdirTemp("", "sandstorm_restore_")
+	if err != nil {
+		return b.log.Write(fmt.Sprintf("failed to create temporary directory. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	defer os.RemoveAll(staging)
+
+	if err := utils.UntarGz(path, staging); err != nil {
+		return b.log.Write(fmt.Sprintf("failed to extract backup '%s'. ERR: %s", path, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	root := filepath.Join(staging, strings.TrimSuffix(name, EXTENSION))
+	if !utils.FileExists(filepath.Join(root, MANIFEST_FILE)) {
+		return b.log.Write(fmt.Sprintf("backup '%s' has no manifest", name), MODULE, admin_log.LOG_ERROR)
+	}
+
+	running := instance.IsRunning()
+	if running {
+		if err := instance.Stop(); err != nil {
+			return err
+		}
+	}
+
+	err = b.restore(instance, root, name, user)
+
+	if running {
+		if startErr := instance.Start(); startErr != nil && err == nil {
+			err = startErr
+		}
+	}
+
+	return err
+}
+
+func (b *Backups) restore(instance *insurgency.Instance, root string, name string, user string) error {
+
+	// the safety backup is not pruned, it could remove the archive being
+	// restored
+	if _, err := b.create(instance, TRIGGER_RESTORE, user); err != nil {
+		return fmt.Errorf("failed to backup instance '%s' before restoring it, nothing was restored. ERR: %s", instance.ID, err.Error())
+	}
+
+	if err := b.replaceConfigDir(instance, filepath.Join(root, CONFIG_DIR)); err != nil {
+		return err
+	}
+
+	// server files are written as new versions so a restore can be diffed
+	// and rolled back like any other change
+	configurations := instance.Configurations()
+	for file := range instance.ConfigurationFiles() {
+		source := filepath.Join(root, file)
+		if contains(insurgency.IniFiles, file) {
+			source = filepath.Join(root, CONFIG_DIR, file)
+		}
+		if !utils.FileExists(source) {
+			continue
+		}
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return b.log.Write(fmt.Sprintf("failed to read '%s' from backup '%s'. ERR: %s", file, name, err.Error()), MODULE, admin_log.LOG_ERROR)
+		}
+		if _, err := configurations.Write(file, string(content), user, fmt.Sprintf("restored from backup '%s'", name)); err != nil {
+			return b.log.Write(fmt.Sprintf("failed to restore '%s'. ERR: %s", file, err.Error()), MODULE, admin_log.LOG_ERROR)
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(root, INSTANCE_FILE)); err == nil {
+		definition := new(insurgency.Instance)
+		if err := json.Unmarshal(data, definition); err != nil {
+			return b.log.Write(fmt.Sprintf("failed to parse instance definition from '%s'. ERR: %s", name, err.Error()), MODULE, admin_log.LOG_ERROR)
+		}
+		instance.Apply(definition)
+		if err := b.instances.Save(); err != nil {
+			return err
+		}
+	}
+
+	b.log.Write(fmt.Sprintf("backup '%s' restored into instance '%s'", name, instance.ID), MODULE, admin_log.LOG_INFO)
+
+	return nil
+}
+
+// replaceConfigDir puts the configuration directory of a backup in place of
+// the current one, so files created after the backup do not survive. The
+// new directory is prepared next to the current one and swapped in, the
+// current one is left untouched when that fails. The INI files edited
+// through the web admin are kept as they are, restore writes them as new
+// versions.
+func (b *Backups) replaceConfigDir(instance *insurgency.Instance, source string) error {
+
+	target := instance.ConfigDir()
+	staging := target + ".restoring"
+	previous := target + ".previous"
+	os.RemoveAll(staging)
+	os.RemoveAll(previous)
+
+	var err error
+	if utils.DirectoryExists(source) {
+		err = utils.CopyDir(source, staging)
+	} else {
+		err = os.MkdirAll(staging, 0750)
+	}
+	if err == nil {
+		err = keepIniFiles(target, staging)
+	}
+	if err != nil {
+		os.RemoveAll(staging)
+		return b.log.Write(fmt.Sprintf("failed to restore '%s'. ERR: %s", target, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	if utils.DirectoryExists(target) {
+		if err := os.Rename(target, previous); err != nil {
+			os.RemoveAll(staging)
+			return b.log.Write(fmt.Sprintf("failed to restore '%s'. ERR: %s", target, err.Error()), MODULE, admin_log.LOG_ERROR)
+		}
+	}
+
+	if err := os.Rename(staging, target); err != nil {
+		os.Rename(previous, target)
+		os.RemoveAll(staging)
+		return b.log.Write(fmt.Sprintf("failed to restore '%s'. ERR: %s", target, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	if err := os.RemoveAll(previous); err != nil {
+		b.log.Write(fmt.Sprintf("failed to remove '%s'. ERR: %s", previous, err.Error()), MODULE, admin_log.LOG_WARNING)
+	}
+
+	return nil
+}
+
+// prune applies the retention policy, the newest backup is always kept.
+func (b *Backups) prune(id string) {
+
+	list, err := b.list(id)
+	if err != nil {
+		b.log.Write(err.Error(), MODULE, admin_log.LOG_WARNING)
+		return
+	}
+
+	limit := time.Now().AddDate(0, 0, -b.MaxAge)
+	for n, backup := range list {
+		if n == 0 {
+			continue
+		}
+		if (b.Keep > 0 && n >= b.Keep) || (b.MaxAge > 0 && backup.Time.Before(limit)) {
+			path := filepath.Join(b.instanceDir(id), backup.Name)
+			if err := os.Remove(path); err != nil {
+				b.log.Write(fmt.Sprintf("failed to remove old backup '%s'. ERR: %s", path, err.Error()), MODULE, admin_log.LOG_WARNING)
+				continue
+			}
+			b.forget(id, backup.Name)
+			b.log.Write(fmt.Sprintf("old backup '%s' removed", path), MODULE, admin_log.LOG_INFO)
+		}
+	}
+}
+
+// catalogue remembers what the archive name can not tell about a backup,
+// like the user who asked for it.
+func (b *Backups) catalogue(i *Info) {
+
+	err := b.db.Update(func(tx *store.Tx) error {
+		return tx.Put(store.BUCKET_BACKUPS, i.Instance+"/"+i.Name, i)
+	})
+	if err != nil {
+		b.log.Write(fmt.Sprintf("failed to catalogue backup '%s'. ERR: %s", i.Name, err.Error()), MODULE, admin_log.LOG_WARNING)
+	}
+}
+
+func (b *Backups) forget(id string, name string) {
+
+	err := b.db.Update(func(tx *store.Tx) error {
+		return tx.Delete(store.BUCKET_BACKUPS, id+"/"+name)
+	})
+	if err != nil {
+		b.log.Write(fmt.Sprintf("failed to remove backup '%s' from the catalogue. ERR: %s", name, err.Error()), MODULE, admin_log.LOG_WARNING)
+	}
+}
+
+// catalogued returns the catalogue entries of the instance by name.
+func (b *Backups) catalogued(id string) map[string]Info {
+
+	catalogue := make(map[string]Info)
+	err := b.db.View(func(tx *store.Tx) error {
+		return tx.ForEach(store.BUCKET_BACKUPS, func(key string, value []byte) error {
+			if !strings.HasPrefix(key, id+"/") {
+				return nil
+			}
+			var i Info
+			if err := json.Unmarshal(value, &i); err != nil {
+				return err
+			}
+			catalogue[i.Name] = i
+			return nil
+		})
+	})
+	if err != nil {
+		b.log.Write(fmt.Sprintf("failed to read the backup catalogue. ERR: %s", err.Error()), MODULE, admin_log.LOG_WARNING)
+	}
+
+	return catalogue
+}
+
+func info(path string) (*Info, error) {
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := validName.FindStringSubmatch(fi.Name())
+	if matches == nil {
+		return nil, fmt.Errorf("invalid backup name '%s'", fi.Name())
+	}
+
+	t, err := time.ParseInLocation(PARSE_FORMAT, matches[2], time.Local)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Info{Name: fi.Name(), Instance: matches[1], Time: t, Trigger: matches[3], Size: fi.Size()}, nil
+}
+
+// keepIniFiles copies the INI files of the current configuration directory
+// over the ones of the prepared directory.
+func keepIniFiles(current string, staging string) error {
+
+	for _, file := range insurgency.IniFiles {
+		path := filepath.Join(current, file)
+		if utils.FileExists(path) {
+			if err := utils.CopyFile(path, filepath.Join(staging, file)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Remove(filepath.Join(staging, file)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
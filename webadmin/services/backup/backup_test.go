@@ -0,0 +1,88 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+)
+
+func newBackups(t *testing.T) (*Backups, *insurgency.Instance) {
+
+	dir := t.TempDir()
+	log := admin_log.New()
+	conf := config.New(log)
+	conf.WebAdmin.ConfigDir = filepath.Join(dir, "config")
+	conf.Sandstorm.Dir = filepath.Join(dir, "sandstorm")
+	conf.Backup.Dir = filepath.Join(dir, "backups")
+
+	db := store.New(conf, log)
+	if err := db.Open(); err != nil {
+		t.Fatalf("failed to open the store: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	instances := insurgency.NewInstances(conf, log, db)
+	instance := instances.New("one")
+	if err := instances.Add(instance); err != nil {
+		t.Fatalf("failed to add instance: %v", err)
+	}
+
+	return New(conf, log, instances, db), instance
+}
+
+func TestListReadsNamesWithoutMilliseconds(t *testing.T) {
+
+	b, _ := newBackups(t)
+
+	dir := b.instanceDir("one")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"one_20240101T030000_scheduled.tar.gz", "one_20240102T030000.250_manual.tar.gz"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("archive"), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := b.List("one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("listed %d backups, want 2", len(list))
+	}
+	if list[0].Name != "one_20240102T030000.250_manual.tar.gz" || !list[0].Time.Equal(time.Date(2024, 1, 2, 3, 0, 0, 250*int(time.Millisecond), time.Local)) {
+		t.Errorf("newest backup is %s at %s", list[0].Name, list[0].Time)
+	}
+	if list[1].Name != "one_20240101T030000_scheduled.tar.gz" || !list[1].Time.Equal(time.Date(2024, 1, 1, 3, 0, 0, 0, time.Local)) {
+		t.Errorf("oldest backup is %s at %s", list[1].Name, list[1].Time)
+	}
+}
+
+func TestRestoreKeepsTheRestoredArchive(t *testing.T) {
+
+	b, instance := newBackups(t)
+	b.Keep = 1
+
+	backup, err := b.Create(instance, TRIGGER_MANUAL, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Restore(instance, backup.Name, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Path(instance.ID, backup.Name); err != nil {
+		t.Errorf("the restored backup was removed: %v", err)
+	}
+	list, _ := b.List(instance.ID)
+	if len(list) != 2 || list[0].Trigger != TRIGGER_RESTORE {
+		t.Errorf("backups are %+v, want the safety backup and the restored one", list)
+	}
+}
@@ -0,0 +1,249 @@
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+// Manifest describes a bundle: the instance it was made from, the hash of
+// every file in it and the key of the host that signed it. The signature
+// of the manifest covers the files through their hashes.
+type Manifest struct {
+	Format   string    `json:"format"`
+	Version  int       `json:"version"`
+	Instance string    `json:"instance"`
+	Host     string    `json:"host"`
+	Created  time.Time `json:"created"`
+	Signer   string    `json:"signer"`
+	Files    []File    `json:"files"`
+	Mods     []string  `json:"mods"`
+}
+
+type File struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+	Hash string `json:"hash"`
+}
+
+// Bundles moves instances between hosts as signed archives with their
+// definition and server files, but not the game.
+type Bundles struct {
+	trusted   []string
+	instances *insurgency.Instances
+	keyFile   string
+	key       ed25519.PrivateKey
+	log       *admin_log.Log
+	mutex     sync.Mutex
+}
+
+const (
+	MODULE = "bundle"
+
+	FORMAT  = "sandstorm-web-admin-instance"
+	VERSION = 1
+
+	MANIFEST_FILE  = "manifest.json"
+	SIGNATURE_FILE = "manifest.sig"
+	INSTANCE_FILE  = "instance.json"
+	FILES_DIR      = "files"
+	KEY_FILE       = "bundle_signing.key"
+	EXTENSION      = ".bundle.tar.gz"
+
+	MAX_SIZE = 32 << 20
+)
+
+func New(conf *config.Configuration, log *admin_log.Log, instances *insurgency.Instances) *Bundles {
+
+	b := new(Bundles)
+	b.trusted = conf.Bundles.Trusted
+	b.instances = instances
+	b.keyFile = filepath.Join(conf.WebAdmin.ConfigDir, KEY_FILE)
+	b.log = log
+
+	return b
+}
+
+// SetTrusted replaces the keys of the hosts whose bundles can be imported.
+func (b *Bundles) SetTrusted(trusted []string) {
+
+	b.mutex.Lock()
+	b.trusted = trusted
+	b.mutex.Unlock()
+}
+
+// signingKey returns the key of this host, created the first time it is
+// needed.
+func (b *Bundles) signingKey() (ed25519.PrivateKey, error) {
+
+	if b.key != nil {
+		return b.key, nil
+	}
+
+	if utils.FileExists(b.keyFile) {
+		data, err := os.ReadFile(b.keyFile)
+		if err != nil {
+			return nil, b.log.Write(fmt.Sprintf("failed to read the bundle signing key '%s'. ERR: %s", b.keyFile, err.Error()), MODULE, admin_log.LOG_ERROR)
+		}
+		seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, b.log.Write(fmt.Sprintf("invalid bundle signing key '%s'", b.keyFile), MODULE, admin_log.LOG_ERROR)
+		}
+		b.key = ed25519.NewKeyFromSeed(seed)
+		return b.key, nil
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, b.log.Write(fmt.Sprintf("failed to generate the bundle signing key. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	if err := os.MkdirAll(filepath.Dir(b.keyFile), 0750); err != nil {
+		return nil, b.log.Write(fmt.Sprintf("failed to create directory '%s'. ERR: %s", filepath.Dir(b.keyFile), err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	if err := os.WriteFile(b.keyFile, []byte(base64.StdEncoding.EncodeToString(key.Seed())+"\n"), 0600); err != nil {
+		return nil, b.log.Write(fmt.Sprintf("failed to write the bundle signing key '%s'. ERR: %s", b.keyFile, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	b.log.Write(fmt.Sprintf("bundle signing key created in '%s'", b.keyFile), MODULE, admin_log.LOG_INFO)
+	b.key = key
+
+	return b.key, nil
+}
+
+// PublicKey returns the key other hosts trust to import the bundles of
+// this one.
+func (b *Bundles) PublicKey() (string, error) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	key, err := b.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)), nil
+}
+
+// Export writes the bundle of an instance: its definition, the server
+// files it has and the mods they list.
+func (b *Bundles) Export(instance *insurgency.Instance, w io.Writer) (*Manifest, error) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	key, err := b.signingKey()
+	if err != nil {
+		return nil, err
+	}
+
+	contents := make(map[string][]byte)
+	definition, err := json.MarshalIndent(instance, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize instance '%s'. ERR: %s", instance.ID, err.Error())
+	}
+	contents[INSTANCE_FILE] = definition
+
+	manifest := &Manifest{Format: FORMAT, Version: VERSION, Instance: instance.ID, Created: time.Now(), Files: make([]File, 0), Mods: make([]string, 0)}
+	manifest.Host, _ = os.Hostname()
+	manifest.Signer = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+
+	for name, path := range instance.ConfigurationFiles() {
+		if !utils.FileExists(path) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%s'. ERR: %s", path, err.Error())
+		}
+		contents[FILES_DIR+"/"+name] = data
+		if name == insurgency.MODS_TXT {
+			manifest.Mods = mods(string(data))
+		}
+	}
+
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		manifest.Files = append(manifest.Files, File{Name: name, Size: len(contents[name]), Hash: hash(contents[name])})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize the bundle manifest. ERR: %s", err.Error())
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, content []byte) error {
+		header := &tar.Header{Name: name, Mode: 0640, Size: int64(len(content)), ModTime: manifest.Created, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+
+	if err := add(MANIFEST_FILE, data); err != nil {
+		return nil, fmt.Errorf("failed to write the bundle of instance '%s'. ERR: %s", instance.ID, err.Error())
+	}
+	if err := add(SIGNATURE_FILE, []byte(signature+"\n")); err != nil {
+		return nil, fmt.Errorf("failed to write the bundle of instance '%s'. ERR: %s", instance.ID, err.Error())
+	}
+	for _, name := range names {
+		if err := add(name, contents[name]); err != nil {
+			return nil, fmt.Errorf("failed to write the bundle of instance '%s'. ERR: %s", instance.ID, err.Error())
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write the bundle of instance '%s'. ERR: %s", instance.ID, err.Error())
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write the bundle of instance '%s'. ERR: %s", instance.ID, err.Error())
+	}
+
+	return manifest, nil
+}
+
+// mods returns the mod ids of a mod list.
+func mods(content string) []string {
+
+	list := make([]string, 0)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list = append(list, line)
+	}
+
+	return list
+}
+
+func hash(content []byte) string {
+
+	sum := sha256.Sum256(content)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,325 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+// Options change where a bundle is imported: the id of the instance, the
+// one of the bundle when empty, and the game directory, the one of this
+// host when empty. With Preview nothing is created. Insecure imports a
+// bundle of any signer when bundles.trusted is empty.
+type Options struct {
+	ID       string `json:"id"`
+	Dir      string `json:"dir"`
+	Preview  bool   `json:"preview"`
+	Insecure bool   `json:"insecure"`
+}
+
+// Change is a value of the bundle the instance could not keep on this host.
+type Change struct {
+	Item string `json:"item"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type Result struct {
+	Manifest *Manifest            `json:"manifest"`
+	Instance *insurgency.Instance `json:"instance"`
+	Trusted  bool                 `json:"trusted"`
+	Remapped []Change             `json:"remapped"`
+	Files    []string             `json:"files"`
+	Warnings []string             `json:"warnings"`
+	Preview  bool                 `json:"preview"`
+}
+
+const (
+	MODS_ARGUMENT = "-mods"
+	MODIO_SECTION = "/Script/ModKit.ModIOClient"
+)
+
+// Read extracts a bundle and checks its format, its signature and the hash
+// of every file. It returns the manifest and the files by name.
+func Read(r io.Reader) (*Manifest, map[string][]byte, error) {
+
+	gz, err := gzip.NewReader(io.LimitReader(r, MAX_SIZE))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid bundle. ERR: %s", err.Error())
+	}
+	defer gz.Close()
+
+	contents := make(map[string][]byte)
+	tr := tar.NewReader(io.LimitReader(gz, MAX_SIZE))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid bundle. ERR: %s", err.Error())
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		name := path.Clean(header.Name)
+		if header.Typeflag != tar.TypeReg || path.IsAbs(name) || strings.HasPrefix(name, "..") {
+			return nil, nil, fmt.Errorf("invalid bundle entry '%s'", header.Name)
+		}
+		if _, ok := contents[name]; ok {
+			return nil, nil, fmt.Errorf("bundle entry '%s' is repeated", name)
+		}
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, tr); err != nil {
+			return nil, nil, fmt.Errorf("failed to read bundle entry '%s'. ERR: %s", name, err.Error())
+		}
+		contents[name] = buf.Bytes()
+	}
+
+	data, ok := contents[MANIFEST_FILE]
+	if !ok {
+		return nil, nil, fmt.Errorf("the bundle has no manifest")
+	}
+	manifest := new(Manifest)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, nil, fmt.Errorf("invalid bundle manifest. ERR: %s", err.Error())
+	}
+	if manifest.Format != FORMAT {
+		return nil, nil, fmt.Errorf("not an instance bundle, the format is '%s'", manifest.Format)
+	}
+	if manifest.Version < 1 || manifest.Version > VERSION {
+		return nil, nil, fmt.Errorf("bundle version %d is not supported, this web admin reads up to version %d", manifest.Version, VERSION)
+	}
+
+	signer, err := base64.StdEncoding.DecodeString(manifest.Signer)
+	if err != nil || len(signer) != ed25519.PublicKeySize {
+		return nil, nil, fmt.Errorf("invalid bundle signer '%s'", manifest.Signer)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(contents[SIGNATURE_FILE])))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(signer), data, signature) {
+		return nil, nil, fmt.Errorf("the signature of the bundle is not valid")
+	}
+
+	files := make(map[string][]byte)
+	for _, file := range manifest.Files {
+		content, ok := contents[file.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("bundle file '%s' is missing", file.Name)
+		}
+		if len(content) != file.Size || hash(content) != file.Hash {
+			return nil, nil, fmt.Errorf("bundle file '%s' does not match the manifest", file.Name)
+		}
+		files[file.Name] = content
+	}
+	for name := range contents {
+		if _, ok := files[name]; !ok && name != MANIFEST_FILE && name != SIGNATURE_FILE {
+			return nil, nil, fmt.Errorf("bundle file '%s' is not in the manifest", name)
+		}
+	}
+	if _, ok := files[INSTANCE_FILE]; !ok {
+		return nil, nil, fmt.Errorf("the bundle has no instance definition")
+	}
+
+	return manifest, files, nil
+}
+
+// Import creates an instance from a bundle. Ports used by other instances
+// are moved to free ones, the game directory is the one of this host and
+// an address this host does not have is replaced by every address. The
+// server downloads the mods on its next start.
+func (b *Bundles) Import(r io.Reader, options Options, author string) (*Result, error) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	manifest, files, err := Read(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Manifest: manifest, Remapped: make([]Change, 0), Files: make([]string, 0), Warnings: make([]string, 0), Preview: options.Preview}
+
+	trusted := b.trusted
+	if len(trusted) > 0 {
+		if !contains(trusted, manifest.Signer) {
+			return nil, fmt.Errorf("the bundle is signed by '%s' which is not in bundles.trusted", manifest.Signer)
+		}
+		result.Trusted = true
+	} else if options.Insecure {
+		result.Warnings = append(result.Warnings, "bundles.trusted is empty, the bundle was imported without checking who signed it")
+	} else if options.Preview {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("bundles.trusted is empty, the bundle signed by '%s' can only be imported as insecure", manifest.Signer))
+	} else {
+		return nil, fmt.Errorf("bundles.trusted is empty, add '%s' to it or import the bundle as insecure", manifest.Signer)
+	}
+
+	definition := new(insurgency.Instance)
+	if err := json.Unmarshal(files[INSTANCE_FILE], definition); err != nil {
+		return nil, fmt.Errorf("invalid instance definition in the bundle. ERR: %s", err.Error())
+	}
+
+	id := options.ID
+	if id == "" {
+		id = manifest.Instance
+	}
+	if _, err := b.instances.Get(id); err == nil {
+		return nil, fmt.Errorf("instance '%s' already exists", id)
+	}
+
+	instance := b.instances.New(id)
+	instance.Apply(definition)
+	result.Instance = instance
+
+	dir := options.Dir
+	if dir == "" {
+		dir = b.instances.Dir
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return nil, fmt.Errorf("invalid directory '%s'. ERR: %s", options.Dir, err.Error())
+	}
+	result.remap("dir", definition.Dir, dir)
+	instance.Dir = dir
+	if !utils.FileExists(instance.Binary()) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("the game is not installed in '%s', it must be before the instance is started", dir))
+	}
+
+	if instance.Address != "" && instance.Address != insurgency.DEFAULT_ADDRESS && !local(instance.Address) {
+		result.remap("address", instance.Address, insurgency.DEFAULT_ADDRESS)
+		instance.Address = insurgency.DEFAULT_ADDRESS
+	}
+
+	b.instances.FreePorts(instance)
+	result.remap("port", fmt.Sprint(definition.Port), fmt.Sprint(instance.Port))
+	result.remap("queryPort", fmt.Sprint(definition.QueryPort), fmt.Sprint(instance.QueryPort))
+	result.remap("rconPort", fmt.Sprint(definition.RconPort), fmt.Sprint(instance.RconPort))
+
+	contents := make(map[string]string)
+	targets := instance.ConfigurationFiles()
+	for name, content := range files {
+		file := strings.TrimPrefix(name, FILES_DIR+"/")
+		if file == name {
+			continue
+		}
+		if _, ok := targets[file]; !ok {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("'%s' is not a server file of this web admin, it was skipped", file))
+			continue
+		}
+		contents[file] = string(content)
+	}
+
+	// the bans are shared by the instances of a game directory
+	if bans, ok := contents[insurgency.BANS_JSON]; ok && utils.FileExists(targets[insurgency.BANS_JSON]) {
+		if current, err := readFile(targets[insurgency.BANS_JSON]); err != nil || current != bans {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("the bans of the bundle were not imported, '%s' already has bans", targets[insurgency.BANS_JSON]))
+			delete(contents, insurgency.BANS_JSON)
+		}
+	}
+
+	if len(manifest.Mods) > 0 {
+		if !hasArgument(instance.Arguments, MODS_ARGUMENT) {
+			result.remap("arguments", strings.Join(instance.Arguments, " "), strings.Join(append(append([]string{}, instance.Arguments...), MODS_ARGUMENT), " "))
+			instance.Arguments = append(instance.Arguments, MODS_ARGUMENT)
+		}
+		if ini := insurgency.ParseIni(contents[insurgency.GAME_INI]); ini[MODIO_SECTION]["AccessToken"] == "" {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s has no mod.io access token in [%s], the server can not download the mods", insurgency.GAME_INI, MODIO_SECTION))
+		}
+	}
+
+	for name := range contents {
+		result.Files = append(result.Files, name)
+	}
+	sort.Strings(result.Files)
+
+	if options.Preview {
+		return result, nil
+	}
+
+	if err := b.instances.Add(instance); err != nil {
+		return nil, err
+	}
+
+	configurations := instance.Configurations()
+	for _, name := range result.Files {
+		if _, err := configurations.Write(name, contents[name], author, fmt.Sprintf("imported from the bundle of instance '%s' of %s", manifest.Instance, manifest.Host)); err != nil {
+			// an instance without its files is not what the bundle holds
+			if err := b.instances.Remove(instance.ID); err != nil {
+				b.log.Write(fmt.Sprintf("failed to remove the partly imported instance '%s'. ERR: %s", instance.ID, err.Error()), MODULE, admin_log.LOG_ERROR)
+			}
+			return nil, b.log.Write(fmt.Sprintf("failed to import '%s' into instance '%s'. ERR: %s", name, instance.ID, err.Error()), MODULE, admin_log.LOG_ERROR)
+		}
+	}
+
+	b.log.Write(fmt.Sprintf("instance '%s' imported from the bundle of instance '%s' of %s", instance.ID, manifest.Instance, manifest.Host), MODULE, admin_log.LOG_INFO)
+
+	return result, nil
+}
+
+func (r *Result) remap(item string, from string, to string) {
+
+	if from != to {
+		r.Remapped = append(r.Remapped, Change{Item: item, From: from, To: to})
+	}
+}
+
+// local tells if an address belongs to this host.
+func local(address string) bool {
+
+	addresses, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addresses {
+		if ip, _, err := net.ParseCIDR(a.String()); err == nil && ip.String() == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasArgument(arguments []string, argument string) bool {
+
+	for _, a := range arguments {
+		if strings.EqualFold(a, argument) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func readFile(path string) (string, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
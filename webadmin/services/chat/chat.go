@@ -0,0 +1,416 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+// Message is a chat line of an instance, written by a player in game or
+// sent from the web admin by User. Action is what the word filter did
+// about it.
+type Message struct {
+	ID       string    `json:"id"`
+	Instance string    `json:"instance"`
+	Time     time.Time `json:"time"`
+	Name     string    `json:"name"`
+	SteamID  string    `json:"steamId,omitempty"`
+	Channel  string    `json:"channel"`
+	Text     string    `json:"text"`
+	User     string    `json:"user,omitempty"`
+	Action   string    `json:"action,omitempty"`
+}
+
+// Chat follows the chat of every instance, keeps its history and streams
+// it to the subscribers. The messages are written to the store together
+// on every follow interval rather than one transaction each.
+type Chat struct {
+	Audit       *audit.Audit
+	Interval    time.Duration
+	filter      Filter
+	instances   *insurgency.Instances
+	followed    map[string]chan struct{}
+	subscribers map[chan Message]string
+	pending     []Message
+	db          *store.Store
+	log         *admin_log.Log
+	done        chan struct{}
+	mutex       sync.Mutex
+}
+
+const (
+	MODULE = "chat"
+
+	// CHANNEL_ADMIN is the channel of the messages sent from the web admin
+	CHANNEL_ADMIN = "admin"
+
+	FOLLOW_INTERVAL   = 10 * time.Second
+	MAX_MESSAGES      = 10000
+	SUBSCRIBER_BUFFER = 64
+	KEY_FILTER        = "filter"
+)
+
+func New(conf *config.Configuration, log *admin_log.Log, instances *insurgency.Instances, db *store.Store) *Chat {
+
+	c := new(Chat)
+	c.Interval = FOLLOW_INTERVAL
+	c.instances = instances
+	c.followed = make(map[string]chan struct{})
+	c.subscribers = make(map[chan Message]string)
+	c.filter.Rules = make([]Rule, 0)
+	c.db = db
+	c.log = log
+
+	return c
+}
+
+// Load reads the word filter.
+func (c *Chat) Load() error {
+
+	var filter Filter
+	err := c.db.View(func(tx *store.Tx) error {
+		_, err := tx.Get(store.BUCKET_CHAT_FILTER, KEY_FILTER, &filter)
+		return err
+	})
+	if err != nil {
+		return c.log.Write(fmt.Sprintf("failed to load the chat filter. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	if filter.Rules == nil {
+		filter.Rules = make([]Rule, 0)
+	}
+	if err := filter.compile(); err != nil {
+		return c.log.Write(fmt.Sprintf("invalid chat filter. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	c.mutex.Lock()
+	c.filter = filter
+	c.mutex.Unlock()
+
+	return nil
+}
+
+func (c *Chat) Start() {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.done != nil {
+		return
+	}
+
+	c.done = make(chan struct{})
+	go c.loop(c.done)
+}
+
+// Stop stops following the instances and ends the streams of the
+// subscribers.
+func (c *Chat) Stop() {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.done == nil {
+		return
+	}
+	close(c.done)
+	c.done = nil
+
+	for id, stop := range c.followed {
+		close(stop)
+		delete(c.followed, id)
+	}
+	for messages := range c.subscribers {
+		close(messages)
+		delete(c.subscribers, messages)
+	}
+
+	c.save()
+}
+
+func (c *Chat) loop(done chan struct{}) {
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	c.follow()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.follow()
+			c.mutex.Lock()
+			c.save()
+			c.mutex.Unlock()
+			c.prune()
+		}
+	}
+}
+
+// follow reads the log of the instances not followed yet and stops
+// following the removed ones.
+func (c *Chat) follow() {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.done == nil {
+		return
+	}
+
+	ids := make(map[string]bool)
+	for _, instance := range c.instances.List() {
+		ids[instance.ID] = true
+		if _, ok := c.followed[instance.ID]; ok {
+			continue
+		}
+		stop := make(chan struct{})
+		c.followed[instance.ID] = stop
+		go c.read(instance, stop)
+	}
+
+	for id, stop := range c.followed {
+		if !ids[id] {
+			close(stop)
+			delete(c.followed, id)
+		}
+	}
+}
+
+func (c *Chat) read(instance *insurgency.Instance, stop chan struct{}) {
+
+	lines, cancel := instance.Subscribe()
+	defer cancel()
+
+	parser := insurgency.NewLogParser()
+	for {
+		select {
+		case <-stop:
+			return
+		case line := <-lines:
+			if event, ok := parser.Parse(line); ok && event.Type == insurgency.LOG_CHAT_MESSAGE {
+				c.received(instance, event, parser.Joined(event.Name, event.SteamID))
+			}
+		}
+	}
+}
+
+// received keeps a message written in game and applies the word filter.
+// The filter acts only on authors the parser saw join, or that are playing
+// with the name and SteamID of the message, since a name can fake them.
+func (c *Chat) received(instance *insurgency.Instance, event insurgency.LogEvent, joined bool) {
+
+	message := Message{
+		ID:       utils.RandomID(8),
+		Instance: instance.ID,
+		Time:     event.Time,
+		Name:     event.Name,
+		SteamID:  event.SteamID,
+		Channel:  event.Channel,
+		Text:     event.Message,
+	}
+
+	c.mutex.Lock()
+	rule, word := c.filter.match(instance.ID, message.Text)
+	c.mutex.Unlock()
+
+	if rule != nil && !joined && !playing(instance, message) {
+		c.log.Write(fmt.Sprintf("chat filter: not applied to '%s' (%s) on instance '%s', no player has that name and SteamID", message.SteamID, message.Name, instance.ID), MODULE, admin_log.LOG_WARNING)
+		rule = nil
+	}
+	if rule != nil {
+		message.Action = rule.Action
+	}
+
+	c.add(message)
+
+	if rule != nil {
+		c.enforce(instance, message, *rule, word)
+	}
+}
+
+// playing returns whether the author of a message is in the player list of
+// the instance.
+func playing(instance *insurgency.Instance, message Message) bool {
+
+	players, err := instance.Players()
+	if err != nil {
+		return false
+	}
+	for _, player := range players {
+		if player.Name == message.Name && player.SteamID == message.SteamID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Send says a message on an instance through RCON.
+func (c *Chat) Send(instance *insurgency.Instance, text string, user string) (*Message, error) {
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, fmt.Errorf("message is empty")
+	}
+
+	if _, err := instance.Say(text); err != nil {
+		return nil, err
+	}
+
+	message := Message{
+		ID:       utils.RandomID(8),
+		Instance: instance.ID,
+		Time:     time.Now().UTC(),
+		Name:     user,
+		Channel:  CHANNEL_ADMIN,
+		Text:     text,
+		User:     user,
+	}
+	c.add(message)
+
+	return &message, nil
+}
+
+// Broadcast says a message on every running instance and returns the
+// error of each instance it could not be sent to.
+func (c *Chat) Broadcast(text string, user string) ([]Message, map[string]string) {
+
+	sent := make([]Message, 0)
+	failed := make(map[string]string)
+	for _, instance := range c.instances.List() {
+		if !instance.IsRunning() {
+			continue
+		}
+		message, err := c.Send(instance, text, user)
+		if err != nil {
+			failed[instance.ID] = err.Error()
+			continue
+		}
+		sent = append(sent, *message)
+	}
+
+	return sent, failed
+}
+
+// add queues a message to be saved and streams it to the subscribers.
+func (c *Chat) add(message Message) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pending = append(c.pending, message)
+	for messages, instance := range c.subscribers {
+		if instance != "" && instance != message.Instance {
+			continue
+		}
+		select {
+		case messages <- message:
+		default:
+		}
+	}
+}
+
+// save writes the queued messages, called with the mutex held.
+func (c *Chat) save() {
+
+	if len(c.pending) == 0 {
+		return
+	}
+
+	err := c.db.Update(func(tx *store.Tx) error {
+		for _, message := range c.pending {
+			if err := tx.Put(store.BUCKET_CHAT, store.RunKey(message.Time, message.ID), message); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.log.Write(fmt.Sprintf("failed to save %d chat message(s). ERR: %s", len(c.pending), err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	c.pending = nil
+}
+
+// prune keeps the newest MAX_MESSAGES messages.
+func (c *Chat) prune() {
+
+	err := c.db.Update(func(tx *store.Tx) error {
+		keys := tx.Keys(store.BUCKET_CHAT)
+		for n := 0; n < len(keys)-MAX_MESSAGES; n++ {
+			if err := tx.Delete(store.BUCKET_CHAT, keys[n]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.log.Write(fmt.Sprintf("failed to remove old chat messages. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+}
+
+// History returns up to limit of the latest messages of an instance, or
+// of all of them when instance is empty, sent after since. The oldest
+// message comes first.
+func (c *Chat) History(instance string, since time.Time, limit int) ([]Message, error) {
+
+	c.mutex.Lock()
+	c.save()
+	c.mutex.Unlock()
+
+	list := make([]Message, 0)
+	err := c.db.View(func(tx *store.Tx) error {
+		return tx.ForEach(store.BUCKET_CHAT, func(key string, value []byte) error {
+			var message Message
+			if err := json.Unmarshal(value, &message); err != nil {
+				return fmt.Errorf("failed to parse chat message '%s'. ERR: %s", key, err.Error())
+			}
+			if (instance != "" && message.Instance != instance) || !message.Time.After(since) {
+				return nil
+			}
+			list = append(list, message)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && len(list) > limit {
+		list = list[len(list)-limit:]
+	}
+
+	return list, nil
+}
+
+// Subscribe returns a channel receiving the new messages of an instance,
+// or of every instance when it is empty, and a function to stop receiving
+// them. The channel is closed when the chat stops.
+func (c *Chat) Subscribe(instance string) (<-chan Message, func()) {
+
+	messages := make(chan Message, SUBSCRIBER_BUFFER)
+
+	c.mutex.Lock()
+	if c.done == nil {
+		close(messages)
+	} else {
+		c.subscribers[messages] = instance
+	}
+	c.mutex.Unlock()
+
+	return messages, func() {
+		c.mutex.Lock()
+		delete(c.subscribers, messages)
+		c.mutex.Unlock()
+	}
+}
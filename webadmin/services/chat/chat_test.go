@@ -0,0 +1,62 @@
+package chat
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+)
+
+func newChat(t *testing.T) (*Chat, *insurgency.Instance) {
+
+	dir := t.TempDir()
+	log := admin_log.New()
+	conf := config.New(log)
+	conf.WebAdmin.ConfigDir = filepath.Join(dir, "config")
+	conf.Sandstorm.Dir = filepath.Join(dir, "sandstorm")
+
+	db := store.New(conf, log)
+	if err := db.Open(); err != nil {
+		t.Fatalf("failed to open the store: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	instances := insurgency.NewInstances(conf, log, db)
+	instance := instances.New("one")
+	// without rcon the player list of the instance can not be read
+	instance.RconPassword = ""
+
+	c := New(conf, log, instances, db)
+	if err := c.SetFilter(Filter{Enabled: true, Rules: []Rule{{Words: []string{"bad"}, Action: ACTION_WARN, Message: "mind your words"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	return c, instance
+}
+
+func TestFilterActsOnJoinedAuthors(t *testing.T) {
+
+	c, instance := newChat(t)
+
+	c.received(instance, insurgency.LogEvent{Type: insurgency.LOG_CHAT_MESSAGE, Time: time.Now(), Name: "Bob", SteamID: "76561198000000001", Channel: insurgency.CHANNEL_GLOBAL, Message: "bad"}, true)
+
+	if len(c.pending) != 1 || c.pending[0].Action != ACTION_WARN {
+		t.Errorf("messages %+v, want the message warned", c.pending)
+	}
+}
+
+func TestFilterSkipsUnverifiedAuthors(t *testing.T) {
+
+	c, instance := newChat(t)
+
+	// the name of the spoofer ends where a chat line of the victim would
+	c.received(instance, insurgency.LogEvent{Type: insurgency.LOG_CHAT_MESSAGE, Time: time.Now(), Name: "Eve", SteamID: "76561198000000001", Channel: insurgency.CHANNEL_GLOBAL, Message: "bad"}, false)
+
+	if len(c.pending) != 1 || c.pending[0].Action != "" {
+		t.Errorf("messages %+v, want the message kept without action", c.pending)
+	}
+}
@@ -0,0 +1,209 @@
+package chat
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+)
+
+// Rule acts on the players whose messages have one of its words. Words
+// match whole words regardless of case, a trailing "*" matches any word
+// starting with it. Duration is the length of a ban, permanent when empty.
+// Message is the warning or the reason given to the player.
+type Rule struct {
+	Words     []string `json:"words"`
+	Action    string   `json:"action"`
+	Duration  string   `json:"duration,omitempty"`
+	Message   string   `json:"message"`
+	Instances []string `json:"instances,omitempty"`
+	pattern   *regexp.Regexp
+	duration  time.Duration
+}
+
+// Filter is the word filter applied to the messages written in game, the
+// first matching rule is applied.
+type Filter struct {
+	Enabled bool   `json:"enabled"`
+	Rules   []Rule `json:"rules"`
+}
+
+const (
+	ACTION_WARN = "warn"
+	ACTION_KICK = "kick"
+	ACTION_BAN  = "ban"
+
+	// WORD_CHARACTERS are the characters words are made of
+	WORD_CHARACTERS = `\pL\pN_`
+)
+
+var (
+	Actions = []string{ACTION_WARN, ACTION_KICK, ACTION_BAN}
+)
+
+// compile validates the rules and builds their patterns.
+func (f *Filter) compile() error {
+
+	for n := range f.Rules {
+		rule := &f.Rules[n]
+
+		if !contains(Actions, rule.Action) {
+			return fmt.Errorf("rule %d: action '%s' is not one of %v", n+1, rule.Action, Actions)
+		}
+
+		rule.duration = 0
+		if rule.Duration != "" {
+			if rule.Action != ACTION_BAN {
+				return fmt.Errorf("rule %d: only bans have a duration", n+1)
+			}
+			duration, err := time.ParseDuration(rule.Duration)
+			if err != nil || duration <= 0 {
+				return fmt.Errorf("rule %d: invalid duration '%s'", n+1, rule.Duration)
+			}
+			rule.duration = duration
+		}
+
+		words := make([]string, 0, len(rule.Words))
+		for _, word := range rule.Words {
+			word = strings.TrimSpace(word)
+			prefix := strings.HasSuffix(word, "*")
+			word = strings.TrimSuffix(word, "*")
+			if word == "" {
+				continue
+			}
+			pattern := regexp.QuoteMeta(word)
+			if prefix {
+				pattern += "[" + WORD_CHARACTERS + "]*"
+			}
+			words = append(words, pattern)
+		}
+		if len(words) == 0 {
+			return fmt.Errorf("rule %d has no words", n+1)
+		}
+
+		pattern, err := regexp.Compile(fmt.Sprintf(`(?i)(?:^|[^%[1]s])(%[2]s)(?:$|[^%[1]s])`, WORD_CHARACTERS, strings.Join(words, "|")))
+		if err != nil {
+			return fmt.Errorf("rule %d: %s", n+1, err.Error())
+		}
+		rule.pattern = pattern
+	}
+
+	return nil
+}
+
+// match returns the first rule of the instance matching a message and the
+// word matched, called with the mutex held.
+func (f *Filter) match(instance string, text string) (*Rule, string) {
+
+	if !f.Enabled {
+		return nil, ""
+	}
+
+	for n := range f.Rules {
+		rule := &f.Rules[n]
+		if len(rule.Instances) > 0 && !contains(rule.Instances, instance) {
+			continue
+		}
+		if match := rule.pattern.FindStringSubmatch(text); match != nil {
+			return rule, match[1]
+		}
+	}
+
+	return nil, ""
+}
+
+// Filter returns the word filter.
+func (c *Chat) Filter() Filter {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.filter
+}
+
+// SetFilter validates and saves a new word filter.
+func (c *Chat) SetFilter(filter Filter) error {
+
+	if filter.Rules == nil {
+		filter.Rules = make([]Rule, 0)
+	}
+	if err := filter.compile(); err != nil {
+		return err
+	}
+
+	err := c.db.Update(func(tx *store.Tx) error {
+		return tx.Put(store.BUCKET_CHAT_FILTER, KEY_FILTER, filter)
+	})
+	if err != nil {
+		return c.log.Write(fmt.Sprintf("failed to save the chat filter. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	c.mutex.Lock()
+	c.filter = filter
+	c.mutex.Unlock()
+
+	return nil
+}
+
+// enforce applies the action of a rule to the author of a message and
+// records it in the audit log.
+func (c *Chat) enforce(instance *insurgency.Instance, message Message, rule Rule, word string) {
+
+	var out string
+	var err error
+	action := "player." + rule.Action
+	reason := rule.Message
+
+	switch rule.Action {
+	case ACTION_WARN:
+		out, err = instance.Warn(message.SteamID, rule.Message)
+	case ACTION_KICK:
+		out, err = instance.Kick(message.SteamID, reason)
+	case ACTION_BAN:
+		if rule.duration > 0 {
+			action = "player.tempban"
+		}
+		out, err = instance.Ban(message.SteamID, rule.duration, reason)
+	}
+
+	if err != nil {
+		c.log.Write(fmt.Sprintf("failed to %s player '%s' on instance '%s' for the word '%s'. ERR: %s", rule.Action, message.SteamID, instance.ID, word, err.Error()), MODULE, admin_log.LOG_ERROR)
+	} else {
+		c.log.Write(fmt.Sprintf("chat filter: %s player '%s' (%s) on instance '%s' for the word '%s'", rule.Action, message.SteamID, message.Name, instance.ID, word), MODULE, admin_log.LOG_INFO)
+	}
+
+	if c.Audit == nil {
+		return
+	}
+
+	entry := audit.Entry{
+		User:       MODULE,
+		Address:    audit.ADDRESS_LOCAL,
+		Action:     action,
+		Instance:   instance.ID,
+		Target:     message.SteamID,
+		Reason:     reason,
+		Parameters: map[string]string{"name": message.Name, "message": message.Text, "word": word, "duration": rule.Duration},
+		Result:     out,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	c.Audit.Record(entry)
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
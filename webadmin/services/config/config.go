@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
 	"github.com/joho/godotenv"
@@ -15,18 +16,21 @@ type Filesystem struct {
 }
 
 type WebAdmin struct {
-	Address          string `json:"address"`
-	Port             int    `json:"port"`
-	Password         string `json:"password"`
-	SslUse           bool   `json:"sslUse"`
-	SslVerify        bool   `json:"sslVerify"`
-	SslCert          string `json:"sslCert"`
-	SslKey           string `json:"sslKey"`
-	AutomaticUpdates bool   `json:"automaticUpdates"`
-	Dir              string `json:"dir"`
-	ConfigDir        string `json:"configDir"`
-	Env              string `json:"env"`
-	Logs             string `json:"logs"`
+	Address          string   `json:"address"`
+	Port             int      `json:"port"`
+	Password         string   `json:"password"`
+	SslUse           bool     `json:"sslUse"`
+	SslVerify        bool     `json:"sslVerify"`
+	SslCert          string   `json:"sslCert"`
+	SslKey           string   `json:"sslKey"`
+	AutomaticUpdates bool     `json:"automaticUpdates"`
+	KeepInstances    bool     `json:"keepInstances"`
+	Dir              string   `json:"dir"`
+	ConfigDir        string   `json:"configDir"`
+	Env              string   `json:"env"`
+	Logs             string   `json:"logs"`
+	LogLevel         string   `json:"logLevel"`
+	TrustedProxies   []string `json:"trustedProxies"`
 }
 
 type Steam struct {
@@ -41,12 +45,81 @@ type Sandstorm struct {
 	AutomaticUpdates bool   `json:"automaticUpdates"`
 }
 
+type Backup struct {
+	Dir    string `json:"dir"`
+	Keep   int    `json:"keep"`
+	MaxAge int    `json:"maxAge"`
+}
+
+type Certificate struct {
+	KeyType     string   `json:"keyType"`
+	Validity    int      `json:"validity"`
+	DnsNames    []string `json:"dnsNames"`
+	IpAddresses []string `json:"ipAddresses"`
+	LocalCa     bool     `json:"localCa"`
+}
+
+type Mtls struct {
+	Use            bool   `json:"use"`
+	Require        bool   `json:"require"`
+	CaBundle       string `json:"caBundle"`
+	ClientValidity int    `json:"clientValidity"`
+}
+
+type Acme struct {
+	Domains      []string `json:"domains"`
+	Email        string   `json:"email"`
+	DirectoryUrl string   `json:"directoryUrl"`
+	Challenge    string   `json:"challenge"`
+	HttpAddress  string   `json:"httpAddress"`
+	CaCert       string   `json:"caCert"`
+}
+
+type Metrics struct {
+	Use      bool   `json:"use"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Token    string `json:"token"`
+}
+
+// Players is what the player history keeps. Addresses are only recorded
+// when enabled and sessions are kept for Retention days, zero keeps them.
+type Players struct {
+	RecordAddresses bool `json:"recordAddresses"`
+	Retention       int  `json:"retention"`
+}
+
+// Bundles are the instance bundles moved between hosts. Trusted are the
+// base64 public keys of the hosts whose bundles can be imported, when it is
+// empty a bundle is only imported when asked to as insecure.
+type Bundles struct {
+	Trusted []string `json:"trusted"`
+}
+
+// Audit keeps the key that authenticates the audit log. It is stored
+// outside the store, the configuration directory when KeyFile is empty, so
+// rewriting the store is not enough to forge entries.
+type Audit struct {
+	KeyFile string `json:"keyFile"`
+}
+
 type Configuration struct {
 	Directories Filesystem     `json:"directories"`
 	WebAdmin    WebAdmin       `json:"webAdmin"`
 	Steam       Steam          `json:"steam"`
 	Sandstorm   Sandstorm      `json:"sandstorm"`
+	Backup      Backup         `json:"backup"`
+	Certificate Certificate    `json:"certificate"`
+	Mtls        Mtls           `json:"mtls"`
+	Acme        Acme           `json:"acme"`
+	Metrics     Metrics        `json:"metrics"`
+	Players     Players        `json:"players"`
+	Bundles     Bundles        `json:"bundles"`
+	Audit       Audit          `json:"audit"`
+	File        string         `json:"-"`
 	log         *admin_log.Log `json:"-"`
+	problems    []string
+	flags       *flags
 }
 
 const (
@@ -63,9 +136,11 @@ const (
 	ADMIN_SSL_CERT          = ""
 	ADMIN_SSL_KEY           = ""
 	ADMIN_AUTOMATIC_UPDATES = "true"
+	ADMIN_KEEP_INSTANCES    = "false"
 	ADMIN_DIR               = "."
 	ADMIN_ENV               = "./.env"
 	ADMIN_LOGS              = ADMIN_DIR + "/logs"
+	ADMIN_LOG_LEVEL         = "info"
 	ADMIN_CONFIG_DIR        = ADMIN_DIR + "/config"
 
 	STEAM_INSTALLER         = FILESYSTEM_SERVER + "/steam/installer"
@@ -74,10 +149,35 @@ const (
 
 	SANDSTORM_DIR               = FILESYSTEM_SERVER + "/sandstorm"
 	SANDSTORM_AUTOMATIC_UPDATES = false
+
+	BACKUP_DIR     = ADMIN_DIR + "/backups"
+	BACKUP_KEEP    = 10
+	BACKUP_MAX_AGE = 30
+
+	CERTIFICATE_KEY_TYPE = "ecdsa-p256"
+	CERTIFICATE_VALIDITY = 365
+	CERTIFICATE_LOCAL_CA = false
+
+	MTLS_USE             = false
+	MTLS_REQUIRE         = false
+	MTLS_CLIENT_VALIDITY = 365
+
+	ACME_DIRECTORY_URL = "https://acme-v02.api.letsencrypt.org/directory"
+	ACME_CHALLENGE     = "http-01"
+	ACME_HTTP_ADDRESS  = ":80"
+
+	METRICS_USE = false
+
+	PLAYERS_RECORD_ADDRESSES = false
+	PLAYERS_RETENTION        = 365
 )
 
 var envFile string = ADMIN_ENV
 
+// envValues are the values read from the env file, used on reload to tell
+// them apart from the ones set in the process environment.
+var envValues = make(map[string]string)
+
 func New(log *admin_log.Log) *Configuration {
 
 	var err error
@@ -101,13 +201,19 @@ func New(log *admin_log.Log) *Configuration {
 	}
 	c.WebAdmin.SslCert = ADMIN_SSL_CERT
 	c.WebAdmin.SslKey = ADMIN_SSL_KEY
-	c.WebAdmin.AutomaticUpdates, err = strconv.ParseBool(ADMIN_SSL_VERIFY)
+	c.WebAdmin.AutomaticUpdates, err = strconv.ParseBool(ADMIN_AUTOMATIC_UPDATES)
+	if err != nil {
+		c.log.Write(err.Error(), MODULE, admin_log.LOG_CRITICAL)
+	}
+	c.WebAdmin.KeepInstances, err = strconv.ParseBool(ADMIN_KEEP_INSTANCES)
 	if err != nil {
 		c.log.Write(err.Error(), MODULE, admin_log.LOG_CRITICAL)
 	}
 	c.WebAdmin.Dir = ADMIN_DIR
 	c.WebAdmin.ConfigDir = ADMIN_CONFIG_DIR
 	c.WebAdmin.Logs = ADMIN_LOGS
+	c.WebAdmin.LogLevel = ADMIN_LOG_LEVEL
+	c.WebAdmin.TrustedProxies = make([]string, 0)
 
 	c.Steam.DownloadUrls = make(map[string]string)
 	c.Steam.Installer = STEAM_INSTALLER
@@ -117,6 +223,32 @@ func New(log *admin_log.Log) *Configuration {
 	c.Sandstorm.Dir = SANDSTORM_DIR
 	c.Sandstorm.AutomaticUpdates = SANDSTORM_AUTOMATIC_UPDATES
 
+	c.Backup.Dir = BACKUP_DIR
+	c.Backup.Keep = BACKUP_KEEP
+	c.Backup.MaxAge = BACKUP_MAX_AGE
+
+	c.Certificate.KeyType = CERTIFICATE_KEY_TYPE
+	c.Certificate.Validity = CERTIFICATE_VALIDITY
+	c.Certificate.DnsNames = make([]string, 0)
+	c.Certificate.IpAddresses = make([]string, 0)
+	c.Certificate.LocalCa = CERTIFICATE_LOCAL_CA
+
+	c.Mtls.Use = MTLS_USE
+	c.Mtls.Require = MTLS_REQUIRE
+	c.Mtls.ClientValidity = MTLS_CLIENT_VALIDITY
+
+	c.Acme.Domains = make([]string, 0)
+	c.Acme.DirectoryUrl = ACME_DIRECTORY_URL
+	c.Acme.Challenge = ACME_CHALLENGE
+	c.Acme.HttpAddress = ACME_HTTP_ADDRESS
+
+	c.Metrics.Use = METRICS_USE
+
+	c.Players.RecordAddresses = PLAYERS_RECORD_ADDRESSES
+	c.Players.Retention = PLAYERS_RETENTION
+
+	c.Bundles.Trusted = make([]string, 0)
+
 	return c
 }
 
@@ -124,11 +256,15 @@ func (c *Configuration) SetFile(path string) {
 	envFile = path
 }
 
+// Read loads the configuration in layers, each one overriding the previous:
+// the defaults, the configuration file, the environment (including the .env
+// file) and the command line flags. Every problem found is logged and
+// reported at once.
 func (c *Configuration) Read() error {
 
-	var err error
+	c.problems = make([]string, 0)
 
-	if err = godotenv.Load(envFile); err != nil {
+	if err := godotenv.Load(envFile); err != nil {
 		c.log.Write(fmt.Sprintf("failed to read env file '%s'. ERR: %s", envFile, err.Error()), MODULE, admin_log.LOG_WARNING)
 		err = c.Write()
 		if err != nil {
@@ -136,6 +272,40 @@ func (c *Configuration) Read() error {
 		}
 		_ = godotenv.Load(envFile)
 	}
+	if values, err := godotenv.Read(envFile); err == nil {
+		envValues = values
+	}
+
+	c.readFile()
+	c.readEnv()
+	c.applyFlags()
+
+	c.problems = append(c.problems, c.Validate()...)
+	if len(c.problems) == 0 {
+		return nil
+	}
+
+	for _, problem := range c.problems {
+		c.log.Write(problem, MODULE, admin_log.LOG_ERROR)
+	}
+
+	return fmt.Errorf("the configuration has %d problem(s)", len(c.problems))
+}
+
+// Problems returns every problem found by the last Read.
+func (c *Configuration) Problems() []string {
+
+	return c.problems
+}
+
+func (c *Configuration) invalid(name string, err error) {
+
+	c.problems = append(c.problems, fmt.Sprintf("invalid %s: %s", name, err.Error()))
+}
+
+func (c *Configuration) readEnv() {
+
+	var err error
 
 	temp := os.Getenv("FILESYSTEM_BASE")
 	if temp != "" {
@@ -156,8 +326,7 @@ func (c *Configuration) Read() error {
 	if temp != "" {
 		c.WebAdmin.Port, err = strconv.Atoi(temp)
 		if err != nil {
-			err = fmt.Errorf("invalid ADMIN_PORT. ERR: %s", err.Error())
-			c.log.Write(err.Error(), MODULE, admin_log.LOG_CRITICAL)
+			c.invalid("ADMIN_PORT", err)
 		}
 	}
 
@@ -170,8 +339,7 @@ func (c *Configuration) Read() error {
 	if temp != "" {
 		c.WebAdmin.SslUse, err = strconv.ParseBool(temp)
 		if err != nil {
-			err = fmt.Errorf("invalid ADMIN_SSL_USE. ERR: %s", err.Error())
-			c.log.Write(err.Error(), MODULE, admin_log.LOG_CRITICAL)
+			c.invalid("ADMIN_SSL_USE", err)
 		}
 	}
 
@@ -179,20 +347,33 @@ func (c *Configuration) Read() error {
 	if temp != "" {
 		c.WebAdmin.SslVerify, err = strconv.ParseBool(temp)
 		if err != nil {
-			err = fmt.Errorf("invalid ADMIN_SSL_VERIFY. ERR: %s", err.Error())
-			c.log.Write(err.Error(), MODULE, admin_log.LOG_CRITICAL)
+			c.invalid("ADMIN_SSL_VERIFY", err)
 		}
 	}
 
-	c.WebAdmin.SslCert = os.Getenv("ADMIN_SSL_CERT")
-	c.WebAdmin.SslKey = os.Getenv("ADMIN_SSL_KEY")
+	temp = os.Getenv("ADMIN_SSL_CERT")
+	if temp != "" {
+		c.WebAdmin.SslCert = temp
+	}
+
+	temp = os.Getenv("ADMIN_SSL_KEY")
+	if temp != "" {
+		c.WebAdmin.SslKey = temp
+	}
 
 	temp = os.Getenv("ADMIN_AUTOMATIC_UPDATES")
 	if temp != "" {
 		c.WebAdmin.AutomaticUpdates, err = strconv.ParseBool(temp)
 		if err != nil {
-			err = fmt.Errorf("invalid ADMIN_AUTOMATIC_UPDATES: %s", err.Error())
-			c.log.Write(err.Error(), MODULE, admin_log.LOG_CRITICAL)
+			c.invalid("ADMIN_AUTOMATIC_UPDATES", err)
+		}
+	}
+
+	temp = os.Getenv("ADMIN_KEEP_INSTANCES")
+	if temp != "" {
+		c.WebAdmin.KeepInstances, err = strconv.ParseBool(temp)
+		if err != nil {
+			c.invalid("ADMIN_KEEP_INSTANCES", err)
 		}
 	}
 
@@ -201,42 +382,241 @@ func (c *Configuration) Read() error {
 		c.WebAdmin.Dir = temp
 	}
 
+	temp = os.Getenv("ADMIN_CONFIG_DIR")
+	if temp != "" {
+		c.WebAdmin.ConfigDir = temp
+	}
+
 	temp = os.Getenv("ADMIN_LOGS")
 	if temp != "" {
 		c.WebAdmin.Logs = temp
 	}
 
-	c.Steam.Installer = os.Getenv("STEAM_INSTALLER")
-	c.Steam.Dir = os.Getenv("STEAM_DIR")
+	temp = os.Getenv("ADMIN_LOG_LEVEL")
+	if temp != "" {
+		c.WebAdmin.LogLevel = temp
+	}
+
+	temp = os.Getenv("ADMIN_TRUSTED_PROXIES")
+	if temp != "" {
+		c.WebAdmin.TrustedProxies = list(temp)
+	}
+
+	temp = os.Getenv("STEAM_INSTALLER")
+	if temp != "" {
+		c.Steam.Installer = temp
+	}
+
+	temp = os.Getenv("STEAM_DIR")
+	if temp != "" {
+		c.Steam.Dir = temp
+	}
+
 	temp = os.Getenv("STEAM_AUTOMATIC_UPDATES")
 	if temp != "" {
 		c.Steam.AutomaticUpdates, err = strconv.ParseBool(temp)
 		if err != nil {
-			err = fmt.Errorf("invalid STEAM_AUTOMATIC_UPDATES: %s", err.Error())
-			c.log.Write(err.Error(), MODULE, admin_log.LOG_CRITICAL)
+			c.invalid("STEAM_AUTOMATIC_UPDATES", err)
+		}
+	}
+
+	urls := map[string]string{"linux": "STEAM_CMD_LINUX", "darwin": "STEAM_CMD_OSX", "windows": "STEAM_CMD_WINDOWS"}
+	for platform, name := range urls {
+		if temp = os.Getenv(name); temp != "" {
+			c.Steam.DownloadUrls[platform] = temp
 		}
 	}
 
-	c.Steam.DownloadUrls["linux"] = os.Getenv("STEAM_CMD_LINUX")
-	c.Steam.DownloadUrls["darwin"] = os.Getenv("STEAM_CMD_OSX")
-	c.Steam.DownloadUrls["windows"] = os.Getenv("STEAM_CMD_WINDOWS")
+	temp = os.Getenv("SANDSTORM_DIR")
+	if temp != "" {
+		c.Sandstorm.Dir = temp
+	}
 
-	c.Sandstorm.Dir = os.Getenv("SANDSTORM_DIR")
 	temp = os.Getenv("SANDSTORM_AUTOMATIC_UPDATES")
 	if temp != "" {
 		c.Sandstorm.AutomaticUpdates, err = strconv.ParseBool(temp)
 		if err != nil {
-			err = fmt.Errorf("invalid SANDSTORM_AUTOMATIC_UPDATES: %s", err.Error())
-			c.log.Write(err.Error(), MODULE, admin_log.LOG_CRITICAL)
+			c.invalid("SANDSTORM_AUTOMATIC_UPDATES", err)
 		}
 	}
 
-	return nil
+	temp = os.Getenv("BACKUP_DIR")
+	if temp != "" {
+		c.Backup.Dir = temp
+	}
+
+	temp = os.Getenv("BACKUP_KEEP")
+	if temp != "" {
+		c.Backup.Keep, err = strconv.Atoi(temp)
+		if err != nil {
+			c.invalid("BACKUP_KEEP", err)
+		}
+	}
+
+	temp = os.Getenv("BACKUP_MAX_AGE")
+	if temp != "" {
+		c.Backup.MaxAge, err = strconv.Atoi(temp)
+		if err != nil {
+			c.invalid("BACKUP_MAX_AGE", err)
+		}
+	}
+
+	temp = os.Getenv("ADMIN_SSL_KEY_TYPE")
+	if temp != "" {
+		c.Certificate.KeyType = temp
+	}
+
+	temp = os.Getenv("ADMIN_SSL_VALIDITY")
+	if temp != "" {
+		c.Certificate.Validity, err = strconv.Atoi(temp)
+		if err != nil {
+			c.invalid("ADMIN_SSL_VALIDITY", err)
+		}
+	}
+
+	temp = os.Getenv("ADMIN_SSL_DNS_NAMES")
+	if temp != "" {
+		c.Certificate.DnsNames = list(temp)
+	}
+
+	temp = os.Getenv("ADMIN_SSL_IP_ADDRESSES")
+	if temp != "" {
+		c.Certificate.IpAddresses = list(temp)
+	}
+
+	temp = os.Getenv("ADMIN_SSL_LOCAL_CA")
+	if temp != "" {
+		c.Certificate.LocalCa, err = strconv.ParseBool(temp)
+		if err != nil {
+			c.invalid("ADMIN_SSL_LOCAL_CA", err)
+		}
+	}
+
+	temp = os.Getenv("ADMIN_MTLS_USE")
+	if temp != "" {
+		c.Mtls.Use, err = strconv.ParseBool(temp)
+		if err != nil {
+			c.invalid("ADMIN_MTLS_USE", err)
+		}
+	}
+
+	temp = os.Getenv("ADMIN_MTLS_REQUIRE")
+	if temp != "" {
+		c.Mtls.Require, err = strconv.ParseBool(temp)
+		if err != nil {
+			c.invalid("ADMIN_MTLS_REQUIRE", err)
+		}
+	}
+
+	temp = os.Getenv("ADMIN_MTLS_CA_BUNDLE")
+	if temp != "" {
+		c.Mtls.CaBundle = temp
+	}
+
+	temp = os.Getenv("ADMIN_MTLS_CLIENT_VALIDITY")
+	if temp != "" {
+		c.Mtls.ClientValidity, err = strconv.Atoi(temp)
+		if err != nil {
+			c.invalid("ADMIN_MTLS_CLIENT_VALIDITY", err)
+		}
+	}
+
+	temp = os.Getenv("ACME_DOMAINS")
+	if temp != "" {
+		c.Acme.Domains = list(temp)
+	}
+
+	temp = os.Getenv("ACME_EMAIL")
+	if temp != "" {
+		c.Acme.Email = temp
+	}
+
+	temp = os.Getenv("ACME_DIRECTORY_URL")
+	if temp != "" {
+		c.Acme.DirectoryUrl = temp
+	}
+
+	temp = os.Getenv("ACME_CHALLENGE")
+	if temp != "" {
+		c.Acme.Challenge = temp
+	}
+
+	temp = os.Getenv("ACME_HTTP_ADDRESS")
+	if temp != "" {
+		c.Acme.HttpAddress = temp
+	}
+
+	temp = os.Getenv("ACME_CA_CERT")
+	if temp != "" {
+		c.Acme.CaCert = temp
+	}
+
+	temp = os.Getenv("METRICS_USE")
+	if temp != "" {
+		c.Metrics.Use, err = strconv.ParseBool(temp)
+		if err != nil {
+			c.invalid("METRICS_USE", err)
+		}
+	}
+
+	temp = os.Getenv("METRICS_USERNAME")
+	if temp != "" {
+		c.Metrics.Username = temp
+	}
+
+	temp = os.Getenv("METRICS_PASSWORD")
+	if temp != "" {
+		c.Metrics.Password = temp
+	}
+
+	temp = os.Getenv("METRICS_TOKEN")
+	if temp != "" {
+		c.Metrics.Token = temp
+	}
+
+	temp = os.Getenv("PLAYERS_RECORD_ADDRESSES")
+	if temp != "" {
+		c.Players.RecordAddresses, err = strconv.ParseBool(temp)
+		if err != nil {
+			c.invalid("PLAYERS_RECORD_ADDRESSES", err)
+		}
+	}
+
+	temp = os.Getenv("PLAYERS_RETENTION")
+	if temp != "" {
+		c.Players.Retention, err = strconv.Atoi(temp)
+		if err != nil {
+			c.invalid("PLAYERS_RETENTION", err)
+		}
+	}
+
+	temp = os.Getenv("BUNDLES_TRUSTED")
+	if temp != "" {
+		c.Bundles.Trusted = list(temp)
+	}
+
+	temp = os.Getenv("AUDIT_KEY_FILE")
+	if temp != "" {
+		c.Audit.KeyFile = temp
+	}
+}
+
+// list splits a comma separated environment value.
+func list(value string) []string {
+
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
 }
 
 func (c *Configuration) Write() error {
 
-	f, err := os.OpenFile(ADMIN_ENV, os.O_RDWR|os.O_CREATE, 0660)
+	f, err := os.OpenFile(envFile, os.O_RDWR|os.O_CREATE, 0660)
 	if err != nil {
 		return c.log.Write(err.Error(), MODULE, admin_log.LOG_CRITICAL)
 	}
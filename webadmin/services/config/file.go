@@ -0,0 +1,199 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CONFIG_FILE = "web_admin"
+)
+
+var (
+	configFile      string = ""
+	configFileTypes        = []string{".yaml", ".yml", ".toml", ".json"}
+)
+
+// SetConfigFile sets the structured configuration file to read instead of
+// looking for one in the web admin directory.
+func (c *Configuration) SetConfigFile(path string) {
+	configFile = path
+}
+
+// findFile returns the configuration file to read: the one set on the
+// command line, the one in ADMIN_CONFIG_FILE or web_admin.(yaml|yml|toml|json)
+// in the web admin directory.
+func (c *Configuration) findFile() string {
+
+	if configFile != "" {
+		return configFile
+	}
+
+	if temp := os.Getenv("ADMIN_CONFIG_FILE"); temp != "" {
+		return temp
+	}
+
+	dir := ADMIN_DIR
+	if temp := os.Getenv("ADMIN_DIR"); temp != "" {
+		dir = temp
+	}
+
+	for _, ext := range configFileTypes {
+		path := filepath.Join(dir, CONFIG_FILE+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// readFile applies the structured configuration file on top of the
+// defaults. YAML and TOML documents are converted to JSON so the same field
+// names are used by every format.
+func (c *Configuration) readFile() {
+
+	path := c.findFile()
+	if path == "" {
+		return
+	}
+	c.File = path
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.problems = append(c.problems, fmt.Sprintf("failed to read configuration file '%s'. ERR: %s", path, err.Error()))
+		return
+	}
+
+	document := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &document)
+	case ".toml":
+		err = toml.Unmarshal(data, &document)
+	case ".json":
+		err = json.Unmarshal(data, &document)
+	default:
+		err = fmt.Errorf("unknown file type, use one of %s", strings.Join(configFileTypes, ", "))
+	}
+	if err != nil {
+		c.problems = append(c.problems, fmt.Sprintf("failed to parse configuration file '%s'. ERR: %s", path, err.Error()))
+		return
+	}
+
+	for _, key := range unknownKeys(document, reflect.TypeOf(*c), "") {
+		c.problems = append(c.problems, fmt.Sprintf("unknown setting '%s' in '%s'", key, path))
+	}
+
+	c.decode(document, path)
+}
+
+// decode applies a document keyed by the JSON field names, reporting every
+// setting with a wrong type instead of stopping at the first one.
+func (c *Configuration) decode(document map[string]any, source string) {
+
+	for section, value := range document {
+		field, ok := jsonField(reflect.TypeOf(*c), section)
+		if !ok {
+			continue
+		}
+		target := reflect.ValueOf(c).Elem().FieldByIndex(field.Index)
+
+		values, isSection := value.(map[string]any)
+		if !isSection || target.Kind() != reflect.Struct {
+			c.assign(target, value, section, source)
+			continue
+		}
+
+		for name, value := range values {
+			field, ok := jsonField(target.Type(), name)
+			if !ok {
+				continue
+			}
+			c.assign(target.FieldByIndex(field.Index), value, section+"."+name, source)
+		}
+	}
+}
+
+func (c *Configuration) assign(target reflect.Value, value any, key string, source string) {
+
+	data, err := json.Marshal(value)
+	if err == nil {
+		holder := reflect.New(target.Type())
+		if err = json.Unmarshal(data, holder.Interface()); err == nil {
+			target.Set(holder.Elem())
+			return
+		}
+	}
+
+	c.problems = append(c.problems, fmt.Sprintf("invalid setting '%s' in '%s': expected %s", key, source, typeName(target.Type())))
+}
+
+func unknownKeys(document map[string]any, t reflect.Type, prefix string) []string {
+
+	unknown := make([]string, 0)
+	for key, value := range document {
+		field, ok := jsonField(t, key)
+		if !ok {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+		if values, isSection := value.(map[string]any); isSection && field.Type.Kind() == reflect.Struct {
+			unknown = append(unknown, unknownKeys(values, field.Type, prefix+key+".")...)
+		}
+	}
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+// jsonField finds the exported field of a struct by its JSON name.
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+
+	for n := 0; n < t.NumField(); n++ {
+		field := t.Field(n)
+		if !field.IsExported() {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		if tag == name {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+func typeName(t reflect.Type) string {
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int:
+		return "an integer"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice:
+		return "a list of " + strings.TrimPrefix(strings.TrimPrefix(typeName(t.Elem()), "a "), "an ") + "s"
+	case reflect.Map:
+		return "a map of " + strings.TrimPrefix(strings.TrimPrefix(typeName(t.Elem()), "a "), "an ") + "s"
+	case reflect.Struct:
+		return "a section"
+	}
+
+	return t.String()
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type flags struct {
+	address  string
+	port     int
+	dir      string
+	logLevel string
+	settings settings
+}
+
+// settings collects repeated -set section.name=value flags.
+type settings []string
+
+func (s *settings) String() string {
+
+	return strings.Join(*s, ", ")
+}
+
+func (s *settings) Set(value string) error {
+
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected section.name=value")
+	}
+	*s = append(*s, value)
+
+	return nil
+}
+
+// Flags registers the configuration flags. They are applied by Read on top
+// of the configuration file and the environment.
+func (c *Configuration) Flags(set *flag.FlagSet) {
+
+	c.flags = new(flags)
+	set.Func("config", "configuration file (yaml, toml or json)", func(value string) error {
+		c.SetConfigFile(value)
+		return nil
+	})
+	set.Func("env", "environment file", func(value string) error {
+		c.SetFile(value)
+		return nil
+	})
+	set.StringVar(&c.flags.address, "address", "", "web admin listen address")
+	set.IntVar(&c.flags.port, "port", 0, "web admin listen port")
+	set.StringVar(&c.flags.dir, "dir", "", "web admin directory")
+	set.StringVar(&c.flags.logLevel, "log-level", "", "log level: critical, error, warning, info or debug")
+	set.Var(&c.flags.settings, "set", "override any setting as section.name=value, can be repeated")
+}
+
+func (c *Configuration) applyFlags() {
+
+	if c.flags == nil {
+		return
+	}
+
+	if c.flags.address != "" {
+		c.WebAdmin.Address = c.flags.address
+	}
+
+	if c.flags.port != 0 {
+		c.WebAdmin.Port = c.flags.port
+	}
+
+	if c.flags.dir != "" {
+		c.WebAdmin.Dir = c.flags.dir
+	}
+
+	if c.flags.logLevel != "" {
+		c.WebAdmin.LogLevel = c.flags.logLevel
+	}
+
+	for _, setting := range c.flags.settings {
+		key, value, _ := strings.Cut(setting, "=")
+		section, name, ok := strings.Cut(key, ".")
+		if !ok {
+			c.problems = append(c.problems, fmt.Sprintf("invalid flag -set %s: expected section.name=value", setting))
+			continue
+		}
+
+		sectionField, ok := jsonField(reflect.TypeOf(*c), section)
+		if !ok || sectionField.Type.Kind() != reflect.Struct {
+			c.problems = append(c.problems, fmt.Sprintf("unknown setting '%s' in flag -set", key))
+			continue
+		}
+		field, ok := jsonField(sectionField.Type, name)
+		if !ok {
+			c.problems = append(c.problems, fmt.Sprintf("unknown setting '%s' in flag -set", key))
+			continue
+		}
+
+		// strings are taken as they are, lists may be comma separated and
+		// anything else is read as JSON so numbers and booleans keep their type
+		var parsed any = value
+		switch {
+		case field.Type.Kind() == reflect.String:
+		case field.Type.Kind() == reflect.Slice && !strings.HasPrefix(value, "["):
+			parsed = list(value)
+		default:
+			if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+				parsed = value
+			}
+		}
+
+		document := map[string]any{section: map[string]any{name: parsed}}
+		c.decode(document, "flag -set")
+	}
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Reload reads the configuration again with the same file and flags and
+// returns it when it is valid. The current configuration is left as it is,
+// the services read it without a lock and the changes are applied through
+// their setters. Values of the env file are applied again unless the
+// process environment overrides them.
+func (c *Configuration) Reload() (*Configuration, error) {
+
+	values, err := godotenv.Read(envFile)
+	if err == nil {
+		for key, previous := range envValues {
+			if _, ok := values[key]; !ok && os.Getenv(key) == previous {
+				os.Unsetenv(key)
+			}
+		}
+		for key, value := range values {
+			if current, ok := os.LookupEnv(key); !ok || current == envValues[key] {
+				os.Setenv(key, value)
+			}
+		}
+	}
+
+	fresh := New(c.log)
+	fresh.flags = c.flags
+	if err := fresh.Read(); err != nil {
+		return nil, err
+	}
+
+	return fresh, nil
+}
+
+// Changed returns the settings that differ between two configurations as
+// section.name.
+func Changed(current *Configuration, fresh *Configuration) []string {
+
+	changed := make([]string, 0)
+	t := reflect.TypeOf(*current)
+	for n := 0; n < t.NumField(); n++ {
+		section := t.Field(n)
+		if !section.IsExported() || section.Type.Kind() != reflect.Struct {
+			continue
+		}
+		from, to := reflect.ValueOf(*current).Field(n), reflect.ValueOf(*fresh).Field(n)
+		for f := 0; f < section.Type.NumField(); f++ {
+			field := section.Type.Field(f)
+			before, _ := json.Marshal(from.Field(f).Interface())
+			after, _ := json.Marshal(to.Field(f).Interface())
+			if string(before) != string(after) {
+				changed = append(changed, tag(section)+"."+tag(field))
+			}
+		}
+	}
+
+	return changed
+}
+
+func tag(field reflect.StructField) string {
+
+	if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" {
+		return name
+	}
+
+	return field.Name
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+const (
+	SCHEMA_DRAFT = "http://json-schema.org/draft-07/schema#"
+)
+
+// enums lists the allowed values of the settings that have them.
+var enums = map[string][]string{
+	"certificate.keyType": KeyTypes,
+	"acme.challenge":      AcmeChallenges,
+}
+
+// Schema returns the JSON schema of the configuration file generated from
+// the configuration sections, with the defaults of New.
+func (c *Configuration) Schema() ([]byte, error) {
+
+	defaults := New(c.log)
+	schema := schemaOf(reflect.TypeOf(*defaults), reflect.ValueOf(*defaults), "")
+	schema["$schema"] = SCHEMA_DRAFT
+	schema["title"] = "Sandstorm Web Admin configuration"
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func schemaOf(t reflect.Type, v reflect.Value, path string) map[string]any {
+
+	schema := make(map[string]any)
+
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]any)
+		for n := 0; n < t.NumField(); n++ {
+			field := t.Field(n)
+			if !field.IsExported() {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			key := name
+			if path != "" {
+				key = path + "." + name
+			}
+			properties[name] = schemaOf(field.Type, v.Field(n), key)
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+		return schema
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int:
+		schema["type"] = "integer"
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Slice:
+		schema["type"] = "array"
+		schema["items"] = schemaOf(t.Elem(), reflect.Zero(t.Elem()), path)
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = schemaOf(t.Elem(), reflect.Zero(t.Elem()), path)
+	}
+
+	if values, ok := enums[path]; ok {
+		schema["enum"] = values
+	}
+
+	empty := (t.Kind() == reflect.Slice || t.Kind() == reflect.Map) && v.IsValid() && v.Len() == 0
+	if v.IsValid() && !v.IsZero() && !empty {
+		schema["default"] = v.Interface()
+	}
+
+	return schema
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+)
+
+var (
+	KeyTypes       = []string{"rsa-2048", "rsa-4096", "ecdsa-p256", "ecdsa-p384", "ed25519"}
+	AcmeChallenges = []string{"http-01", "tls-alpn-01"}
+)
+
+// Validate checks the settings that can not be verified while they are
+// parsed and returns every problem found.
+func (c *Configuration) Validate() []string {
+
+	problems := make([]string, 0)
+	problem := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.WebAdmin.Address == "" {
+		problem("webAdmin.address is required")
+	}
+
+	if c.WebAdmin.Port < 1 || c.WebAdmin.Port > 65535 {
+		problem("webAdmin.port %d is not between 1 and 65535", c.WebAdmin.Port)
+	}
+
+	required := []struct{ name, value string }{
+		{"webAdmin.dir", c.WebAdmin.Dir},
+		{"webAdmin.configDir", c.WebAdmin.ConfigDir},
+		{"webAdmin.logs", c.WebAdmin.Logs},
+		{"steam.dir", c.Steam.Dir},
+		{"sandstorm.dir", c.Sandstorm.Dir},
+		{"backup.dir", c.Backup.Dir},
+	}
+	for _, setting := range required {
+		if setting.value == "" {
+			problem("%s is required", setting.name)
+		}
+	}
+
+	if _, err := admin_log.ParseSeverity(c.WebAdmin.LogLevel); err != nil {
+		problem("webAdmin.logLevel '%s' is not one of critical, error, warning, info or debug", c.WebAdmin.LogLevel)
+	}
+
+	for _, proxy := range c.WebAdmin.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			problem("webAdmin.trustedProxies '%s' is not an ip address or network", proxy)
+		}
+	}
+
+	if (c.WebAdmin.SslCert == "") != (c.WebAdmin.SslKey == "") {
+		problem("webAdmin.sslCert and webAdmin.sslKey must be set together")
+	}
+	for _, path := range []string{c.WebAdmin.SslCert, c.WebAdmin.SslKey, c.Mtls.CaBundle, c.Acme.CaCert} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			problem("file '%s' can not be read. ERR: %s", path, err.Error())
+		}
+	}
+
+	if c.Backup.Keep < 0 {
+		problem("backup.keep %d can not be negative", c.Backup.Keep)
+	}
+	if c.Backup.MaxAge < 0 {
+		problem("backup.maxAge %d can not be negative", c.Backup.MaxAge)
+	}
+
+	if !contains(KeyTypes, c.Certificate.KeyType) {
+		problem("certificate.keyType '%s' is not one of %v", c.Certificate.KeyType, KeyTypes)
+	}
+	if c.Certificate.Validity <= 0 {
+		problem("certificate.validity %d must be a positive number of days", c.Certificate.Validity)
+	}
+	for _, ip := range c.Certificate.IpAddresses {
+		if net.ParseIP(ip) == nil {
+			problem("certificate.ipAddresses '%s' is not an ip address", ip)
+		}
+	}
+
+	if c.Mtls.Use && !c.WebAdmin.SslUse {
+		problem("mtls.use requires webAdmin.sslUse")
+	}
+	if c.Mtls.ClientValidity <= 0 {
+		problem("mtls.clientValidity %d must be a positive number of days", c.Mtls.ClientValidity)
+	}
+
+	if c.WebAdmin.SslUse && c.WebAdmin.SslVerify && c.WebAdmin.SslCert == "" && len(c.Acme.Domains) == 0 {
+		problem("webAdmin.sslVerify requires acme.domains or a certificate in webAdmin.sslCert")
+	}
+	if !contains(AcmeChallenges, c.Acme.Challenge) {
+		problem("acme.challenge '%s' is not one of %v", c.Acme.Challenge, AcmeChallenges)
+	}
+	if u, err := url.Parse(c.Acme.DirectoryUrl); err != nil || u.Scheme == "" || u.Host == "" {
+		problem("acme.directoryUrl '%s' is not a valid url", c.Acme.DirectoryUrl)
+	}
+
+	if (c.Metrics.Username == "") != (c.Metrics.Password == "") {
+		problem("metrics.username and metrics.password must be set together")
+	}
+	if c.Metrics.Use && c.Metrics.Token == "" && c.Metrics.Username == "" {
+		problem("metrics.use requires metrics.token or metrics.username and metrics.password")
+	}
+
+	if c.Players.Retention < 0 {
+		problem("players.retention %d must not be negative", c.Players.Retention)
+	}
+
+	for _, key := range c.Bundles.Trusted {
+		if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != ed25519.PublicKeySize {
+			problem("bundles.trusted '%s' is not a base64 ed25519 public key", key)
+		}
+	}
+
+	return problems
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,106 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+)
+
+// Daemon holds what the web admin needs to run as a system service: the
+// PID file that also works as a single instance lock and the systemd
+// notification socket.
+type Daemon struct {
+	Dir      string `json:"dir"`
+	PidFile  string `json:"pidFile"`
+	file     *os.File
+	socket   string
+	keep     bool
+	watchdog chan struct{}
+	log      *admin_log.Log
+}
+
+const (
+	MODULE = "daemon"
+
+	PID_FILE = "web_admin.pid"
+)
+
+func New(conf *config.Configuration, log *admin_log.Log) *Daemon {
+
+	d := new(Daemon)
+	d.Dir = conf.WebAdmin.Dir
+	d.PidFile = filepath.Join(conf.WebAdmin.Dir, PID_FILE)
+	d.socket = os.Getenv("NOTIFY_SOCKET")
+	d.keep = conf.WebAdmin.KeepInstances
+	d.log = log
+
+	return d
+}
+
+// Lock writes the PID file and keeps it locked while the web admin runs so
+// a second web admin using the same directory refuses to start.
+func (d *Daemon) Lock() error {
+
+	if err := os.MkdirAll(d.Dir, 0750); err != nil {
+		return d.log.Write(fmt.Sprintf("failed to create directory '%s'. ERR: %s", d.Dir, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	file, err := os.OpenFile(d.PidFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return d.log.Write(fmt.Sprintf("failed to open pid file '%s'. ERR: %s", d.PidFile, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	if err := lock(file); err != nil {
+		file.Close()
+		if pid := d.Pid(); pid > 0 {
+			return d.log.Write(fmt.Sprintf("the web admin is already running in '%s' with pid %d", d.Dir, pid), MODULE, admin_log.LOG_ERROR)
+		}
+		return d.log.Write(fmt.Sprintf("failed to lock pid file '%s'. ERR: %s", d.PidFile, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	if err := file.Truncate(0); err == nil {
+		_, err = file.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+	}
+	if err != nil {
+		file.Close()
+		return d.log.Write(fmt.Sprintf("failed to write pid file '%s'. ERR: %s", d.PidFile, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	d.file = file
+	d.log.Write(fmt.Sprintf("pid %d written to '%s'", os.Getpid(), d.PidFile), MODULE, admin_log.LOG_DEBUG)
+
+	return nil
+}
+
+// Unlock removes the PID file written by Lock.
+func (d *Daemon) Unlock() {
+
+	if d.file == nil {
+		return
+	}
+
+	os.Remove(d.PidFile)
+	d.file.Close()
+	d.file = nil
+}
+
+// Pid returns the pid written in the PID file or 0 when there is none.
+func (d *Daemon) Pid() int {
+
+	data, err := os.ReadFile(d.PidFile)
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+
+	return pid
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd
+
+package daemon
+
+import (
+	"os"
+)
+
+// lock is a no-op where flock is not available, the PID file is still
+// written.
+func lock(file *os.File) error {
+
+	return nil
+}
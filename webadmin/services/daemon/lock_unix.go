@@ -0,0 +1,13 @@
+//go:build linux || darwin || freebsd
+
+package daemon
+
+import (
+	"os"
+	"syscall"
+)
+
+func lock(file *os.File) error {
+
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
@@ -0,0 +1,120 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+)
+
+const (
+	NOTIFY_READY     = "READY=1"
+	NOTIFY_RELOADING = "RELOADING=1"
+	NOTIFY_STOPPING  = "STOPPING=1"
+	NOTIFY_WATCHDOG  = "WATCHDOG=1"
+)
+
+// Notify sends a state to systemd through NOTIFY_SOCKET. It does nothing
+// when the web admin was not started by systemd with Type=notify.
+func (d *Daemon) Notify(state string) error {
+
+	if d.socket == "" {
+		return nil
+	}
+
+	// a leading @ is an abstract socket
+	address := &net.UnixAddr{Name: d.socket, Net: "unixgram"}
+	if address.Name[0] == '@' {
+		address.Name = "\x00" + address.Name[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, address)
+	if err != nil {
+		return d.log.Write(fmt.Sprintf("failed to connect to notify socket '%s'. ERR: %s", d.socket, err.Error()), MODULE, admin_log.LOG_WARNING)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return d.log.Write(fmt.Sprintf("failed to notify '%s'. ERR: %s", state, err.Error()), MODULE, admin_log.LOG_WARNING)
+	}
+
+	return nil
+}
+
+func (d *Daemon) Ready() error {
+
+	return d.Notify(NOTIFY_READY)
+}
+
+func (d *Daemon) Reloading() error {
+
+	return d.Notify(NOTIFY_RELOADING)
+}
+
+func (d *Daemon) Stopping() error {
+
+	return d.Notify(NOTIFY_STOPPING)
+}
+
+// Status sets the status line shown by systemctl status.
+func (d *Daemon) Status(status string) error {
+
+	return d.Notify("STATUS=" + status)
+}
+
+// Watchdog pings the systemd watchdog at half the interval it was given in
+// WATCHDOG_USEC, but only while healthy reports that the web admin works.
+func (d *Daemon) Watchdog(healthy func() bool) {
+
+	interval := watchdogInterval()
+	if interval <= 0 || d.socket == "" || d.watchdog != nil {
+		return
+	}
+
+	d.log.Write(fmt.Sprintf("systemd watchdog enabled, pinging every %s", interval/2), MODULE, admin_log.LOG_INFO)
+
+	d.watchdog = make(chan struct{})
+	go func(done chan struct{}) {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if healthy == nil || healthy() {
+					d.Notify(NOTIFY_WATCHDOG)
+				} else {
+					d.log.Write("health check failed, skipping watchdog ping", MODULE, admin_log.LOG_WARNING)
+				}
+			}
+		}
+	}(d.watchdog)
+}
+
+// Stop stops the watchdog pings.
+func (d *Daemon) Stop() {
+
+	if d.watchdog != nil {
+		close(d.watchdog)
+		d.watchdog = nil
+	}
+}
+
+func watchdogInterval() time.Duration {
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	// the watchdog is meant for another process when its pid is set
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
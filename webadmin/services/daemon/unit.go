@@ -0,0 +1,133 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+)
+
+// Unit is a systemd service unit running the web admin with Type=notify.
+type Unit struct {
+	Description      string
+	User             string
+	Group            string
+	WorkingDirectory string
+	ExecStart        string
+	PidFile          string
+	WatchdogSec      int
+	KillMode         string
+	TimeoutStopSec   int
+}
+
+const (
+	UNIT_DESCRIPTION = "Insurgency Sandstorm Web Admin"
+	UNIT_WATCHDOG    = 60
+)
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description={{.Description}}
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+{{- if .User}}
+User={{.User}}
+{{- end}}
+{{- if .Group}}
+Group={{.Group}}
+{{- end}}
+WorkingDirectory={{.WorkingDirectory}}
+ExecStart={{.ExecStart}}
+ExecReload=/bin/kill -HUP $MAINPID
+PIDFile={{.PidFile}}
+Restart=on-failure
+RestartSec=5
+{{- if .WatchdogSec}}
+WatchdogSec={{.WatchdogSec}}
+{{- end}}
+{{- if eq .KillMode "process"}}
+# the game servers keep running and are reattached when the web admin starts
+{{- else}}
+# SIGTERM only goes to the web admin, which stops the game servers itself
+{{- end}}
+KillMode={{.KillMode}}
+TimeoutStopSec={{.TimeoutStopSec}}
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// NewUnit describes a unit starting this executable from the current
+// directory, so relative paths in the configuration keep working, with the
+// given command line arguments.
+func (d *Daemon) NewUnit(args []string) (*Unit, error) {
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the web admin executable. ERR: %s", err.Error())
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the working directory. ERR: %s", err.Error())
+	}
+
+	pidFile, err := filepath.Abs(d.PidFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate absolute path from '%s'. ERR: %s", d.PidFile, err.Error())
+	}
+
+	command := []string{quote(executable)}
+	for _, arg := range args {
+		command = append(command, quote(arg))
+	}
+	command = append(command, "serve")
+
+	u := new(Unit)
+	u.Description = UNIT_DESCRIPTION
+	u.WorkingDirectory = dir
+	u.ExecStart = strings.Join(command, " ")
+	u.PidFile = pidFile
+	u.WatchdogSec = UNIT_WATCHDOG
+	u.KillMode = "mixed"
+	if d.keep {
+		u.KillMode = "process"
+	}
+	// every instance may take the whole stop timeout, they are stopped together
+	u.TimeoutStopSec = int((insurgency.STOP_TIMEOUT + time.Minute).Seconds())
+
+	return u, nil
+}
+
+func (u *Unit) Render() ([]byte, error) {
+
+	var out bytes.Buffer
+	if err := unitTemplate.Execute(&out, u); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// quote quotes an ExecStart argument when systemd would split it.
+func quote(arg string) string {
+
+	if !strings.ContainsAny(arg, " \t\"'\\$%") {
+		return arg
+	}
+
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	arg = strings.ReplaceAll(arg, `"`, `\"`)
+	arg = strings.ReplaceAll(arg, "$", "$$")
+	arg = strings.ReplaceAll(arg, "%", "%%")
+
+	return `"` + arg + `"`
+}
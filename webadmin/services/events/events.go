@@ -0,0 +1,82 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is something that happened to the web admin or one of its
+// instances that users may want to be told about.
+type Event struct {
+	Type     string            `json:"type"`
+	Time     time.Time         `json:"time"`
+	Level    string            `json:"level"`
+	Instance string            `json:"instance,omitempty"`
+	Title    string            `json:"title"`
+	Message  string            `json:"message"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+type Handler func(event Event)
+
+// Bus hands the published events to every subscriber. Handlers are called
+// synchronously and must not block. A nil bus drops every event so
+// services can publish without checking whether anybody listens.
+type Bus struct {
+	handlers []Handler
+	mutex    sync.RWMutex
+}
+
+const (
+	LEVEL_INFO    = "info"
+	LEVEL_WARNING = "warning"
+	LEVEL_ERROR   = "error"
+
+	INSTANCE_STARTED     = "instance.started"
+	INSTANCE_STOPPED     = "instance.stopped"
+	INSTANCE_CRASHED     = "instance.crashed"
+	UPDATE_AVAILABLE     = "update.available"
+	UPDATE_APPLIED       = "update.applied"
+	PLAYER_BANNED        = "player.banned"
+	CERTIFICATE_EXPIRING = "certificate.expiring"
+	DISK_LOW             = "disk.low"
+	TEST                 = "test"
+)
+
+var (
+	Types = []string{INSTANCE_STARTED, INSTANCE_STOPPED, INSTANCE_CRASHED, UPDATE_AVAILABLE, UPDATE_APPLIED, PLAYER_BANNED, CERTIFICATE_EXPIRING, DISK_LOW, TEST}
+)
+
+func New() *Bus {
+
+	return new(Bus)
+}
+
+func (b *Bus) Subscribe(handler Handler) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *Bus) Publish(event Event) {
+
+	if b == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Level == "" {
+		event.Level = LEVEL_INFO
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, handler := range b.handlers {
+		handler(event)
+	}
+}
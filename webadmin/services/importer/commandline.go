@@ -0,0 +1,186 @@
+package importer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// POSIX_ESCAPES are the characters a backslash escapes in double quotes
+	POSIX_ESCAPES = "$`\"\\\n"
+)
+
+var (
+	windowsPath = regexp.MustCompile(`(?:^|[\s"=])[A-Za-z]:\\`)
+)
+
+// LaunchLine finds the command line that starts the server in a launch
+// script or a systemd unit, joining the lines continued with a backslash,
+// or with a caret in a batch file.
+func LaunchLine(content string, batch bool) (string, error) {
+
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if batch {
+		content = strings.ReplaceAll(content, "^\n", " ")
+	} else {
+		content = strings.ReplaceAll(content, "\\\n", " ")
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if batch {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "@"))
+		}
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "::") || strings.HasPrefix(strings.ToLower(line), "rem ") {
+			continue
+		}
+		if value, ok := cutKey(line, "ExecStart"); ok {
+			// systemd prefixes changing how the command runs
+			return strings.TrimLeft(value, "-@+!:"), nil
+		}
+		if strings.Contains(line, SERVER_BINARY) {
+			return line, nil
+		}
+	}
+
+	return "", fmt.Errorf("no command line starting %s found", SERVER_BINARY)
+}
+
+// ServerArguments splits a command line and returns the binary that starts
+// the server and the arguments after it. The line of a batch file or of a
+// Windows binary is split like cmd does, where a backslash separates the
+// directories of a path.
+func ServerArguments(line string, batch bool) (string, []string, error) {
+
+	words, err := split(line, batch || windows(line))
+	if err != nil {
+		return "", nil, err
+	}
+
+	for n, word := range words {
+		if strings.Contains(filepath.Base(filepath.FromSlash(word)), SERVER_BINARY) {
+			return word, words[n+1:], nil
+		}
+	}
+
+	return "", nil, fmt.Errorf("no %s binary on the command line", SERVER_BINARY)
+}
+
+// InstallDir returns the game directory of a server binary, the one that
+// holds the Insurgency directory.
+func InstallDir(binary string) string {
+
+	path := filepath.FromSlash(binary)
+	for dir := filepath.Dir(path); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if filepath.Base(dir) == GAME_DIR {
+			return filepath.Dir(dir)
+		}
+	}
+
+	// InsurgencyServer.sh lives in the game directory
+	return filepath.Dir(path)
+}
+
+// Batch returns whether a launch script is a Windows batch file.
+func Batch(path string) bool {
+
+	extension := strings.ToLower(filepath.Ext(path))
+
+	return extension == ".bat" || extension == ".cmd"
+}
+
+// windows returns whether a command line starts a Windows binary.
+func windows(line string) bool {
+
+	lower := strings.ToLower(line)
+
+	return strings.Contains(lower, ".exe") || strings.Contains(lower, "win64") || windowsPath.MatchString(line)
+}
+
+// split breaks a command line into words. Like a POSIX shell it has single
+// quotes, double quotes where a backslash only escapes POSIX_ESCAPES, and
+// backslash escapes outside of them. Like cmd, with windows, it only has
+// double quotes and caret escapes.
+func split(line string, windows bool) ([]string, error) {
+
+	words := make([]string, 0)
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	runes := []rune(line)
+
+	for n := 0; n < len(runes); n++ {
+		r := runes[n]
+		next := rune(0)
+		if n+1 < len(runes) {
+			next = runes[n+1]
+		}
+		switch {
+		case windows && r == '^' && quote == 0 && next != 0:
+			word.WriteRune(next)
+			inWord = true
+			n++
+		case !windows && r == '\\' && quote == 0 && next != 0:
+			// a backslash before the end of a line joins it to the next
+			if next != '\n' {
+				word.WriteRune(next)
+				inWord = true
+			}
+			n++
+		case !windows && r == '\\' && quote == '"' && strings.ContainsRune(POSIX_ESCAPES, next):
+			if next != '\n' {
+				word.WriteRune(next)
+			}
+			n++
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || (r == '\'' && !windows):
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in '%s'", line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+func cutKey(line string, key string) (string, bool) {
+
+	name, value, ok := strings.Cut(line, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(name), key) {
+		return "", false
+	}
+
+	return strings.TrimSpace(value), true
+}
+
+func readFile(path string) (string, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read '%s'. ERR: %s", path, err.Error())
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,60 @@
+package importer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLaunchLine(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		content string
+		batch   bool
+		line    string
+	}{
+		{"shell", "#!/bin/sh\n# start the server\ncd /srv/sandstorm\n./Insurgency/Binaries/Linux/InsurgencyServer-Linux-Shipping Farmhouse?Scenario=Scenario_Farmhouse_Checkpoint_Security \\\n  -Port=27102 \\\n  -log\n", false, "./Insurgency/Binaries/Linux/InsurgencyServer-Linux-Shipping Farmhouse?Scenario=Scenario_Farmhouse_Checkpoint_Security    -Port=27102    -log"},
+		{"systemd", "[Unit]\nDescription=Sandstorm\n\n[Service]\nUser=sandstorm\nExecStart=-/srv/sandstorm/Insurgency/Binaries/Linux/InsurgencyServer-Linux-Shipping -Port=27102\nRestart=always\n", false, "/srv/sandstorm/Insurgency/Binaries/Linux/InsurgencyServer-Linux-Shipping -Port=27102"},
+		{"batch", "@echo off\r\nrem start the server\r\n:: on every boot\r\n@\"C:\\Sandstorm\\Insurgency\\Binaries\\Win64\\InsurgencyServer-Win64-Shipping.exe\" Farmhouse ^\r\n -Port=27102\r\n", true, "\"C:\\Sandstorm\\Insurgency\\Binaries\\Win64\\InsurgencyServer-Win64-Shipping.exe\" Farmhouse   -Port=27102"},
+	}
+
+	for _, test := range tests {
+		line, err := LaunchLine(test.content, test.batch)
+		if err != nil || line != test.line {
+			t.Errorf("%s: line %q, %v, want %q", test.name, line, err, test.line)
+		}
+	}
+
+	if _, err := LaunchLine("#!/bin/sh\necho nothing\n", false); err == nil {
+		t.Error("a script without the server found a line")
+	}
+}
+
+func TestServerArguments(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		line      string
+		batch     bool
+		binary    string
+		arguments []string
+	}{
+		{"shell", `./InsurgencyServer-Linux-Shipping Farmhouse -hostname="My \"best\" server" -motd='a \ b' -password=a\ b`, false, "./InsurgencyServer-Linux-Shipping", []string{"Farmhouse", `-hostname=My "best" server`, `-motd=a \ b`, "-password=a b"}},
+		{"shell double quotes", `./InsurgencyServer-Linux-Shipping "-hostname=100\$ \` + "`" + `x\` + "`" + ` \\ \n"`, false, "./InsurgencyServer-Linux-Shipping", []string{"-hostname=100$ `x` \\ \\n"}},
+		{"systemd", `/srv/sandstorm/Insurgency/Binaries/Linux/InsurgencyServer-Linux-Shipping -Port=27102 "-hostname=Sandstorm server"`, false, "/srv/sandstorm/Insurgency/Binaries/Linux/InsurgencyServer-Linux-Shipping", []string{"-Port=27102", "-hostname=Sandstorm server"}},
+		{"batch", `"C:\Sandstorm\Insurgency\Binaries\Win64\InsurgencyServer-Win64-Shipping.exe" Farmhouse -hostname="Bob's server" -ModDownloadTravelTo=C:\Mods\ -log`, true, `C:\Sandstorm\Insurgency\Binaries\Win64\InsurgencyServer-Win64-Shipping.exe`, []string{"Farmhouse", "-hostname=Bob's server", `-ModDownloadTravelTo=C:\Mods\`, "-log"}},
+		{"batch caret", `InsurgencyServer-Win64-Shipping.exe -hostname=Tom^&Jerry`, true, "InsurgencyServer-Win64-Shipping.exe", []string{"-hostname=Tom&Jerry"}},
+		{"windows binary", `C:\Sandstorm\Insurgency\Binaries\Win64\InsurgencyServer-Win64-Shipping.exe -configsubdir=C:\Config\Server`, false, `C:\Sandstorm\Insurgency\Binaries\Win64\InsurgencyServer-Win64-Shipping.exe`, []string{`-configsubdir=C:\Config\Server`}},
+	}
+
+	for _, test := range tests {
+		binary, arguments, err := ServerArguments(test.line, test.batch)
+		if err != nil || binary != test.binary || !reflect.DeepEqual(arguments, test.arguments) {
+			t.Errorf("%s: binary %q with %q, %v, want %q with %q", test.name, binary, arguments, err, test.binary, test.arguments)
+		}
+	}
+
+	if _, _, err := ServerArguments(`./InsurgencyServer-Linux-Shipping "-hostname=open`, false); err == nil {
+		t.Error("an unterminated quote was accepted")
+	}
+}
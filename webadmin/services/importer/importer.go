@@ -0,0 +1,322 @@
+package importer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+// Source is a server that was set up by hand: its game directory and the
+// launch script or systemd unit starting it, or the command line itself.
+// The directory is found from the binary when it is not given.
+type Source struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Dir         string `json:"dir"`
+	Script      string `json:"script"`
+	CommandLine string `json:"commandLine"`
+	Preview     bool   `json:"preview"`
+}
+
+// Item is something of the source the instance does not have as it was.
+type Item struct {
+	Item   string `json:"item"`
+	Value  string `json:"value,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// File is a server file copied into the instance.
+type File struct {
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	content string
+}
+
+type Result struct {
+	Instance *insurgency.Instance `json:"instance"`
+	Files    []File               `json:"files"`
+	Unmapped []Item               `json:"unmapped"`
+	Preview  bool                 `json:"preview"`
+}
+
+type Importer struct {
+	instances *insurgency.Instances
+	log       *admin_log.Log
+	mutex     sync.Mutex
+}
+
+const (
+	MODULE = "importer"
+
+	SERVER_BINARY = "InsurgencyServer"
+	GAME_DIR      = "Insurgency"
+
+	LINUX_CONFIG_DIR   = "LinuxServer"
+	WINDOWS_CONFIG_DIR = "WindowsServer"
+	MAPCYCLE           = "MapCycle"
+	ADMINS             = "Admins"
+	MODS               = "Mods"
+)
+
+func New(conf *config.Configuration, log *admin_log.Log, instances *insurgency.Instances) *Importer {
+
+	i := new(Importer)
+	i.instances = instances
+	i.log = log
+
+	return i
+}
+
+// Import creates an instance from an existing server, keeping its ports,
+// map cycle, mods, admins and INI files. Its bans are in the game
+// directory the instance uses. With Preview nothing is created.
+func (i *Importer) Import(source Source, author string) (*Result, error) {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	line := source.CommandLine
+	batch := false
+	if line == "" {
+		if source.Script == "" {
+			return nil, fmt.Errorf("a launch script, systemd unit or command line is needed")
+		}
+		content, err := readFile(source.Script)
+		if err != nil {
+			return nil, err
+		}
+		batch = Batch(source.Script)
+		if line, err = LaunchLine(content, batch); err != nil {
+			return nil, fmt.Errorf("%s in '%s'", err.Error(), source.Script)
+		}
+	}
+
+	binary, args, err := ServerArguments(line, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := source.Dir
+	if dir == "" {
+		dir = InstallDir(binary)
+	}
+	if !utils.DirectoryExists(filepath.Join(dir, GAME_DIR)) {
+		return nil, fmt.Errorf("'%s' is not a sandstorm server directory", dir)
+	}
+
+	instance := i.instances.New(source.ID)
+	instance.Dir = dir
+	result := &Result{Instance: instance, Files: make([]File, 0), Unmapped: make([]Item, 0), Preview: source.Preview}
+
+	names := map[string]string{
+		"configsubdir": LINUX_CONFIG_DIR,
+		"mapcycle":     MAPCYCLE,
+		"adminlist":    ADMINS,
+		"modlist":      MODS,
+	}
+	if lower := strings.ToLower(binary); strings.Contains(lower, "win64") || strings.HasSuffix(lower, ".exe") {
+		names["configsubdir"] = WINDOWS_CONFIG_DIR
+	}
+	rcon := false
+
+	for n, arg := range args {
+		if n == 0 && !strings.HasPrefix(arg, "-") {
+			result.travel(instance, arg)
+			continue
+		}
+		name, value, _ := strings.Cut(strings.TrimPrefix(arg, "-"), "=")
+		if strings.Contains(arg, "$") || strings.Contains(arg, "%") {
+			result.unmapped(arg, "", "uses a variable of the script, it is kept as an argument and must be replaced by its value")
+			instance.Arguments = append(instance.Arguments, arg)
+			continue
+		}
+		switch key := strings.ToLower(name); key {
+		case "port", "queryport", "rconlistenport":
+			port, err := strconv.Atoi(value)
+			if err != nil || port <= 0 || port > 65535 {
+				result.unmapped(arg, "", "invalid port, the default is used")
+				continue
+			}
+			switch key {
+			case "port":
+				instance.Port = port
+			case "queryport":
+				instance.QueryPort = port
+			default:
+				instance.RconPort = port
+			}
+		case "multihome":
+			instance.Address = value
+		case "hostname":
+			instance.Name = value
+		case "rcon":
+			rcon = true
+		case "rconpassword":
+			instance.RconPassword = value
+		case "configsubdir", "mapcycle", "adminlist", "modlist":
+			names[key] = value
+		case "log":
+			result.unmapped(arg, "", fmt.Sprintf("the instance logs to %s.log", instance.ID))
+		default:
+			instance.Arguments = append(instance.Arguments, arg)
+			if !strings.HasPrefix(arg, "-") {
+				result.unmapped(arg, "", "unexpected argument, it is kept as an argument")
+			}
+		}
+	}
+	if source.Name != "" {
+		instance.Name = source.Name
+	}
+
+	if rcon && instance.RconPassword == "" {
+		result.unmapped("-Rcon", "", "RCON has no password, the web admin can not use it until one is set")
+	}
+	if !rcon && instance.RconPassword != "" {
+		result.unmapped("-RconPassword", "", "RCON was not enabled, the web admin enables it")
+	}
+	if !utils.FileExists(instance.Binary()) {
+		result.unmapped("binary", instance.Binary(), "not found, the game must be installed before the instance is started")
+	}
+
+	if err := i.conflicts(instance); err != nil {
+		return nil, err
+	}
+	if err := result.files(instance, names); err != nil {
+		return nil, err
+	}
+
+	if source.Preview {
+		return result, nil
+	}
+
+	if err := i.instances.Add(instance); err != nil {
+		return nil, err
+	}
+
+	configurations := instance.Configurations()
+	for _, file := range result.Files {
+		if _, err := configurations.Write(file.Name, file.content, author, fmt.Sprintf("imported from %s", file.Source)); err != nil {
+			return nil, i.log.Write(fmt.Sprintf("failed to import '%s' into instance '%s'. ERR: %s", file.Source, instance.ID, err.Error()), MODULE, admin_log.LOG_ERROR)
+		}
+	}
+
+	i.log.Write(fmt.Sprintf("instance '%s' imported from '%s' with %d unmapped items", instance.ID, dir, len(result.Unmapped)), MODULE, admin_log.LOG_INFO)
+
+	return result, nil
+}
+
+// travel reads the map, scenario, game mode and players of the travel URL
+// the server starts with, such as Oilfield?Scenario=...?MaxPlayers=28.
+func (r *Result) travel(instance *insurgency.Instance, url string) {
+
+	parts := strings.Split(url, "?")
+	instance.Map = parts[0]
+	instance.Scenario = ""
+
+	for _, option := range parts[1:] {
+		name, value, _ := strings.Cut(option, "=")
+		switch strings.ToLower(name) {
+		case "scenario":
+			instance.Scenario = value
+		case "game":
+			instance.Mode = value
+		case "maxplayers":
+			players, err := strconv.Atoi(value)
+			if err != nil || players <= 0 {
+				r.unmapped("?"+option, "", "invalid number of players, the default is used")
+				continue
+			}
+			instance.MaxPlayers = players
+		default:
+			r.unmapped("?"+option, "", "travel option the instance does not have")
+		}
+	}
+}
+
+// files collects the server files of the source that go to the instance.
+func (r *Result) files(instance *insurgency.Instance, names map[string]string) error {
+
+	configDir := filepath.Join(instance.Dir, GAME_DIR, "Saved", "Config", names["configsubdir"])
+
+	sources := make(map[string]string)
+	for _, name := range insurgency.IniFiles {
+		sources[name] = filepath.Join(configDir, name)
+	}
+	sources[insurgency.MAPCYCLE_TXT] = filepath.Join(instance.ServerConfigDir(), names["mapcycle"]+".txt")
+	sources[insurgency.ADMINS_TXT] = filepath.Join(instance.ServerConfigDir(), names["adminlist"]+".txt")
+	sources[insurgency.MODS_TXT] = filepath.Join(instance.ServerConfigDir(), names["modlist"]+".txt")
+
+	for _, name := range append(append([]string{}, insurgency.IniFiles...), insurgency.MAPCYCLE_TXT, insurgency.ADMINS_TXT, insurgency.MODS_TXT) {
+		path := sources[name]
+		if !utils.FileExists(path) {
+			continue
+		}
+		content, err := readFile(path)
+		if err != nil {
+			return err
+		}
+		r.Files = append(r.Files, File{Name: name, Source: path, content: content})
+	}
+
+	if filepath.Clean(configDir) == filepath.Clean(instance.ConfigDir()) {
+		return nil
+	}
+	entries, err := os.ReadDir(configDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			r.unmapped("Saved/Config", configDir, "not found, the instance starts with the default INI files")
+			return nil
+		}
+		return fmt.Errorf("failed to read '%s'. ERR: %s", configDir, err.Error())
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || contains(insurgency.IniFiles, entry.Name()) {
+			continue
+		}
+		r.unmapped(entry.Name(), filepath.Join(configDir, entry.Name()), "not managed by the web admin, it is left where it is")
+	}
+
+	return nil
+}
+
+func (r *Result) unmapped(item string, value string, reason string) {
+
+	r.Unmapped = append(r.Unmapped, Item{Item: item, Value: value, Reason: reason})
+}
+
+// conflicts checks the ports of an imported instance are not used by
+// another one, they are kept as the source had them.
+func (i *Importer) conflicts(instance *insurgency.Instance) error {
+
+	for _, other := range i.instances.List() {
+		if other.ID == instance.ID {
+			return fmt.Errorf("instance '%s' already exists", instance.ID)
+		}
+		for _, port := range []int{instance.Port, instance.QueryPort, instance.RconPort} {
+			if port == other.Port || port == other.QueryPort || port == other.RconPort {
+				return fmt.Errorf("port %d is already used by instance '%s'", port, other.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1 +1,187 @@
 package insurgency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+type Version struct {
+	Number  int       `json:"number"`
+	Author  string    `json:"author"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message,omitempty"`
+	Size    int       `json:"size"`
+	Hash    string    `json:"hash"`
+}
+
+// Configuration is one server file edited through the web admin together
+// with the history of every version written to it.
+type Configuration struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Versions []Version `json:"versions"`
+	dir      string
+}
+
+const (
+	CONFIGURATION_INDEX = "index.json"
+	MAX_VERSIONS        = 200
+	AUTHOR_ORIGINAL     = "original"
+)
+
+func newConfiguration(name string, path string, dir string) (*Configuration, error) {
+
+	c := new(Configuration)
+	c.Name = name
+	c.Path = path
+	c.Versions = make([]Version, 0)
+	c.dir = dir
+
+	index := filepath.Join(dir, CONFIGURATION_INDEX)
+	if !utils.FileExists(index) {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration history '%s'. ERR: %s", index, err.Error())
+	}
+
+	if err := json.Unmarshal(data, &c.Versions); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration history '%s'. ERR: %s", index, err.Error())
+	}
+
+	return c, nil
+}
+
+func (c *Configuration) Read() (string, error) {
+
+	if !utils.FileExists(c.Path) {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(c.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read '%s'. ERR: %s", c.Path, err.Error())
+	}
+
+	return string(data), nil
+}
+
+func (c *Configuration) Latest() *Version {
+
+	if len(c.Versions) == 0 {
+		return nil
+	}
+
+	return &c.Versions[len(c.Versions)-1]
+}
+
+func (c *Configuration) Version(number int) (*Version, string, error) {
+
+	for n := range c.Versions {
+		if c.Versions[n].Number != number {
+			continue
+		}
+		data, err := os.ReadFile(c.versionFile(number))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read version %d of '%s'. ERR: %s", number, c.Name, err.Error())
+		}
+		return &c.Versions[n], string(data), nil
+	}
+
+	return nil, "", fmt.Errorf("version %d of '%s' not found", number, c.Name)
+}
+
+// write saves content to the server file. The file on disk is recorded
+// first when it was changed outside the web admin, so it can always be
+// rolled back to.
+func (c *Configuration) write(content string, author string, message string) (*Version, error) {
+
+	current, err := c.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	latest := c.Latest()
+	if utils.FileExists(c.Path) && (latest == nil || latest.Hash != hash(current)) {
+		if _, err := c.record(current, AUTHOR_ORIGINAL, "changed outside the web admin"); err != nil {
+			return nil, err
+		}
+	}
+
+	if latest = c.Latest(); latest != nil && current == content {
+		return latest, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create directory '%s'. ERR: %s", filepath.Dir(c.Path), err.Error())
+	}
+
+	if err := os.WriteFile(c.Path, []byte(content), 0640); err != nil {
+		return nil, fmt.Errorf("failed to write '%s'. ERR: %s", c.Path, err.Error())
+	}
+
+	return c.record(content, author, message)
+}
+
+func (c *Configuration) record(content string, author string, message string) (*Version, error) {
+
+	number := 1
+	if latest := c.Latest(); latest != nil {
+		number = latest.Number + 1
+	}
+
+	if err := os.MkdirAll(c.dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create directory '%s'. ERR: %s", c.dir, err.Error())
+	}
+
+	if err := os.WriteFile(c.versionFile(number), []byte(content), 0640); err != nil {
+		return nil, fmt.Errorf("failed to save version %d of '%s'. ERR: %s", number, c.Name, err.Error())
+	}
+
+	c.Versions = append(c.Versions, Version{
+		Number:  number,
+		Author:  author,
+		Time:    time.Now(),
+		Message: message,
+		Size:    len(content),
+		Hash:    hash(content),
+	})
+
+	for len(c.Versions) > MAX_VERSIONS {
+		os.Remove(c.versionFile(c.Versions[0].Number))
+		c.Versions = c.Versions[1:]
+	}
+
+	data, err := json.MarshalIndent(c.Versions, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize configuration history. ERR: %s", err.Error())
+	}
+
+	index := filepath.Join(c.dir, CONFIGURATION_INDEX)
+	if err := os.WriteFile(index, data, 0640); err != nil {
+		return nil, fmt.Errorf("failed to write configuration history '%s'. ERR: %s", index, err.Error())
+	}
+
+	return c.Latest(), nil
+}
+
+func (c *Configuration) versionFile(number int) string {
+
+	return filepath.Join(c.dir, fmt.Sprintf("%06d", number))
+}
+
+func hash(content string) string {
+
+	sum := sha256.Sum256([]byte(content))
+
+	return hex.EncodeToString(sum[:])
+}
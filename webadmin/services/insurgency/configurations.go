@@ -1 +1,201 @@
 package insurgency
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+// Configurations is the versioned store of an instance's server files.
+type Configurations struct {
+	Instance string `json:"instance"`
+	dir      string
+	files    map[string]string
+	mutex    sync.Mutex
+}
+
+const (
+	CONFIGURATIONS_DIR = "configurations"
+
+	GAME_INI               = "Game.ini"
+	ENGINE_INI             = "Engine.ini"
+	GAME_USER_SETTINGS_INI = "GameUserSettings.ini"
+	MAPCYCLE_TXT           = "MapCycle.txt"
+	ADMINS_TXT             = "Admins.txt"
+	MODS_TXT               = "Mods.txt"
+	BANS_JSON              = "Bans.json"
+)
+
+// ConfigurationFiles maps the name of every file that can be edited through
+// the web admin to its location.
+func (i *Instance) ConfigurationFiles() map[string]string {
+
+	return map[string]string{
+		GAME_INI:               filepath.Join(i.ConfigDir(), GAME_INI),
+		ENGINE_INI:             filepath.Join(i.ConfigDir(), ENGINE_INI),
+		GAME_USER_SETTINGS_INI: filepath.Join(i.ConfigDir(), GAME_USER_SETTINGS_INI),
+		MAPCYCLE_TXT:           i.MapCycleFile(),
+		ADMINS_TXT:             i.AdminsFile(),
+		MODS_TXT:               i.ModsFile(),
+		BANS_JSON:              i.BansFile(),
+	}
+}
+
+func (i *Instance) Configurations() *Configurations {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.configurations == nil {
+		i.configurations = new(Configurations)
+		i.configurations.Instance = i.ID
+		i.configurations.dir = filepath.Join(i.dataDir, CONFIGURATIONS_DIR, i.ID)
+	}
+	// the game directory can change, Write and List read the files under
+	// the lock of the configurations
+	files := i.ConfigurationFiles()
+	i.configurations.mutex.Lock()
+	i.configurations.files = files
+	i.configurations.mutex.Unlock()
+
+	return i.configurations
+}
+
+func (c *Configurations) get(name string) (*Configuration, error) {
+
+	path, ok := c.files[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown configuration file '%s'", name)
+	}
+
+	return newConfiguration(name, path, filepath.Join(c.dir, name))
+}
+
+// List returns every configuration file with its history.
+func (c *Configurations) List() ([]*Configuration, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	names := make([]string, 0, len(c.files))
+	for name := range c.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]*Configuration, 0, len(names))
+	for _, name := range names {
+		configuration, err := c.get(name)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, configuration)
+	}
+
+	return list, nil
+}
+
+func (c *Configurations) Get(name string) (*Configuration, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.get(name)
+}
+
+func (c *Configurations) Read(name string) (string, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	configuration, err := c.get(name)
+	if err != nil {
+		return "", err
+	}
+
+	return configuration.Read()
+}
+
+// Write replaces a server file keeping the previous contents in the
+// history.
+func (c *Configurations) Write(name string, content string, author string, message string) (*Version, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	configuration, err := c.get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return configuration.write(content, author, message)
+}
+
+func (c *Configurations) Version(name string, number int) (*Version, string, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	configuration, err := c.get(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return configuration.Version(number)
+}
+
+// Diff returns the unified diff between two versions of a file, a zero
+// version number stands for the file as it currently is on disk.
+func (c *Configurations) Diff(name string, from int, to int) (string, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	configuration, err := c.get(name)
+	if err != nil {
+		return "", err
+	}
+
+	content := func(number int) (string, string, error) {
+		if number == 0 {
+			text, err := configuration.Read()
+			return text, fmt.Sprintf("%s (current)", name), err
+		}
+		_, text, err := configuration.Version(number)
+		return text, fmt.Sprintf("%s (version %d)", name, number), err
+	}
+
+	a, aName, err := content(from)
+	if err != nil {
+		return "", err
+	}
+
+	b, bName, err := content(to)
+	if err != nil {
+		return "", err
+	}
+
+	return utils.UnifiedDiff(a, b, aName, bName), nil
+}
+
+// Rollback writes an older version back as a new version.
+func (c *Configurations) Rollback(name string, number int, author string) (*Version, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	configuration, err := c.get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, content, err := configuration.Version(number)
+	if err != nil {
+		return nil, err
+	}
+
+	return configuration.write(content, author, fmt.Sprintf("rollback to version %d", number))
+}
@@ -0,0 +1,89 @@
+package insurgency
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+type CrashReport struct {
+	Time     time.Time `json:"time"`
+	Instance string    `json:"instance"`
+	ExitCode int       `json:"exitCode"`
+	Reason   string    `json:"reason"`
+	Error    string    `json:"error,omitempty"`
+	Uptime   int64     `json:"uptime"`
+	Restart  bool      `json:"restart"`
+	Delay    int64     `json:"delay"`
+	GaveUp   bool      `json:"gaveUp"`
+	Log      []string  `json:"log"`
+}
+
+const (
+	CRASH_EXIT = "exit"
+
+	CRASHES_DIR       = "crashes"
+	MAX_CRASH_REPORTS = 50
+)
+
+func (i *Instance) crashesFile() string {
+
+	return filepath.Join(i.dataDir, CRASHES_DIR, fmt.Sprintf("%s.json", i.ID))
+}
+
+// Crashes returns the instance's crash history, newest first.
+func (i *Instance) Crashes() ([]CrashReport, error) {
+
+	path := i.crashesFile()
+	reports := make([]CrashReport, 0)
+	if !utils.FileExists(path) {
+		return reports, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read crash history '%s'. ERR: %s", path, err.Error())
+	}
+
+	if err := json.Unmarshal(data, &reports); err != nil {
+		return nil, fmt.Errorf("failed to parse crash history '%s'. ERR: %s", path, err.Error())
+	}
+
+	return reports, nil
+}
+
+func (i *Instance) saveCrash(report CrashReport) error {
+
+	i.crashMutex.Lock()
+	defer i.crashMutex.Unlock()
+
+	reports, err := i.Crashes()
+	if err != nil {
+		reports = make([]CrashReport, 0)
+	}
+
+	reports = append([]CrashReport{report}, reports...)
+	if len(reports) > MAX_CRASH_REPORTS {
+		reports = reports[:MAX_CRASH_REPORTS]
+	}
+
+	data, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize crash history. ERR: %s", err.Error())
+	}
+
+	path := i.crashesFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("failed to create directory '%s'. ERR: %s", filepath.Dir(path), err.Error())
+	}
+
+	if err := os.WriteFile(path, data, 0640); err != nil {
+		return fmt.Errorf("failed to write crash history '%s'. ERR: %s", path, err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package insurgency
+
+import (
+	"os/exec"
+)
+
+func detach(cmd *exec.Cmd) {
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+
+package insurgency
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach starts the server in its own session so it does not receive the
+// signals sent to the web admin's process group and keeps running when the
+// web admin exits.
+func detach(cmd *exec.Cmd) {
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
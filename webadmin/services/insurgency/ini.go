@@ -0,0 +1,115 @@
+package insurgency
+
+import (
+	"sort"
+	"strings"
+)
+
+// Ini holds the values of an INI file by section and key.
+type Ini map[string]map[string]string
+
+// IniFiles are the configuration files that are INI files.
+var IniFiles = []string{GAME_INI, ENGINE_INI, GAME_USER_SETTINGS_INI}
+
+// ParseIni reads the values of an INI file, the last one wins when a key
+// is repeated in a section.
+func ParseIni(content string) Ini {
+
+	ini := make(Ini)
+	section := ""
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if _, ok := ini[section]; !ok {
+				ini[section] = make(map[string]string)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section == "" {
+			continue
+		}
+		ini[section][strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	return ini
+}
+
+// SetIni changes the values of an INI file keeping the rest of it as it
+// is. Keys and sections that are not in the file are added to it.
+func SetIni(content string, values Ini) string {
+
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	if content == "" {
+		lines = lines[:0]
+	}
+
+	sections := make([]string, 0, len(values))
+	for section := range values {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	for _, section := range sections {
+		start, end := -1, len(lines)
+		for n, line := range lines {
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+				continue
+			}
+			if start >= 0 {
+				end = n
+				break
+			}
+			if strings.TrimSpace(line[1:len(line)-1]) == section {
+				start = n
+			}
+		}
+		if start < 0 {
+			if len(lines) > 0 {
+				lines = append(lines, "")
+			}
+			lines = append(lines, "["+section+"]")
+			start, end = len(lines)-1, len(lines)
+		}
+
+		added := make([]string, 0)
+		for _, key := range sortedKeys(values[section]) {
+			value := values[section][key]
+			found := false
+			for n := start + 1; n < end; n++ {
+				if k, _, ok := strings.Cut(lines[n], "="); ok && strings.TrimSpace(k) == key {
+					lines[n] = key + "=" + value
+					found = true
+					break
+				}
+			}
+			if !found {
+				added = append(added, key+"="+value)
+			}
+		}
+		// new keys go after the last value of the section, before the blank
+		// lines that separate it from the next one
+		for end > start+1 && strings.TrimSpace(lines[end-1]) == "" {
+			end--
+		}
+		lines = append(lines[:end], append(added, lines[end:]...)...)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func sortedKeys(values map[string]string) []string {
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
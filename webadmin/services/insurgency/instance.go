@@ -1 +1,448 @@
 package insurgency
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/a2s"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/rcon"
+)
+
+type State string
+
+type Instance struct {
+	ID               string        `json:"id"`
+	Name             string        `json:"name"`
+	Dir              string        `json:"dir"`
+	Address          string        `json:"address"`
+	Port             int           `json:"port"`
+	QueryPort        int           `json:"queryPort"`
+	RconPort         int           `json:"rconPort"`
+	RconPassword     string        `json:"rconPassword"`
+	Map              string        `json:"map"`
+	Scenario         string        `json:"scenario"`
+	Mode             string        `json:"mode,omitempty"`
+	MaxPlayers       int           `json:"maxPlayers"`
+	Arguments        []string      `json:"arguments"`
+	State            State         `json:"state"`
+	StartedAt        time.Time     `json:"startedAt"`
+	Restart          RestartPolicy `json:"restart"`
+	Restarts         int           `json:"restarts"`
+	log              *admin_log.Log
+	dataDir          string
+	events           *events.Bus
+	cmd              *exec.Cmd
+	rcon             *rcon.Client
+	rconErrors       uint64
+	crashes          uint64
+	watchdog         chan struct{}
+	hang             string
+	restartTimer     *time.Timer
+	restartTimes     []time.Time
+	failures         int
+	outbox           []events.Event
+	crashReports     []CrashReport
+	crashMutex       sync.Mutex
+	configurations   *Configurations
+	tail             *follower
+	subscribers      map[chan string]struct{}
+	mutex            sync.Mutex
+	subscribersMutex sync.Mutex
+}
+
+const (
+	STATE_STOPPED  State = "stopped"
+	STATE_STARTING State = "starting"
+	STATE_RUNNING  State = "running"
+	STATE_STOPPING State = "stopping"
+	STATE_WAITING  State = "waiting"
+
+	DEFAULT_ADDRESS     = "0.0.0.0"
+	DEFAULT_PORT        = 27102
+	DEFAULT_QUERY_PORT  = 27131
+	DEFAULT_RCON_PORT   = 27015
+	DEFAULT_MAX_PLAYERS = 28
+	DEFAULT_MAP         = "Oilfield"
+	DEFAULT_SCENARIO    = "Scenario_Refinery_Checkpoint_Security"
+
+	STOP_TIMEOUT = 30 * time.Second
+)
+
+func NewInstance(id string, dir string, log *admin_log.Log) *Instance {
+
+	i := new(Instance)
+	i.ID = id
+	i.Name = id
+	i.Dir = dir
+	i.Address = DEFAULT_ADDRESS
+	i.Port = DEFAULT_PORT
+	i.QueryPort = DEFAULT_QUERY_PORT
+	i.RconPort = DEFAULT_RCON_PORT
+	i.Map = DEFAULT_MAP
+	i.Scenario = DEFAULT_SCENARIO
+	i.MaxPlayers = DEFAULT_MAX_PLAYERS
+	i.Arguments = make([]string, 0)
+	i.State = STATE_STOPPED
+	i.Restart = DefaultRestartPolicy()
+	i.log = log
+
+	return i
+}
+
+// Apply copies the definition of another instance, everything that is
+// saved in the instances file except the id and the runtime state.
+func (i *Instance) Apply(definition *Instance) {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.Name = definition.Name
+	i.Dir = definition.Dir
+	i.Address = definition.Address
+	i.Port = definition.Port
+	i.QueryPort = definition.QueryPort
+	i.RconPort = definition.RconPort
+	i.RconPassword = definition.RconPassword
+	i.Map = definition.Map
+	i.Scenario = definition.Scenario
+	i.Mode = definition.Mode
+	i.MaxPlayers = definition.MaxPlayers
+	i.Arguments = append([]string{}, definition.Arguments...)
+	i.Restart = definition.Restart
+}
+
+func (i *Instance) Binary() string {
+
+	if runtime.GOOS == "windows" {
+		return filepath.Join(i.Dir, "Insurgency", "Binaries", "Win64", "InsurgencyServer-Win64-Shipping.exe")
+	}
+
+	return filepath.Join(i.Dir, "Insurgency", "Binaries", "Linux", "InsurgencyServer-Linux-Shipping")
+}
+
+// ConfigDir is where the instance's Game.ini and Engine.ini live. Every
+// instance uses its own config sub directory so several instances can
+// share the same game installation.
+func (i *Instance) ConfigDir() string {
+
+	return filepath.Join(i.Dir, "Insurgency", "Saved", "Config", i.ID)
+}
+
+func (i *Instance) ServerConfigDir() string {
+
+	return filepath.Join(i.Dir, "Insurgency", "Config", "Server")
+}
+
+func (i *Instance) LogFile() string {
+
+	return filepath.Join(i.Dir, "Insurgency", "Saved", "Logs", fmt.Sprintf("%s.log", i.ID))
+}
+
+func (i *Instance) MapCycleFile() string {
+
+	return filepath.Join(i.ServerConfigDir(), fmt.Sprintf("MapCycle_%s.txt", i.ID))
+}
+
+func (i *Instance) AdminsFile() string {
+
+	return filepath.Join(i.ServerConfigDir(), fmt.Sprintf("Admins_%s.txt", i.ID))
+}
+
+func (i *Instance) ModsFile() string {
+
+	return filepath.Join(i.ServerConfigDir(), fmt.Sprintf("Mods_%s.txt", i.ID))
+}
+
+func (i *Instance) BansFile() string {
+
+	return filepath.Join(i.ServerConfigDir(), "Bans.json")
+}
+
+func (i *Instance) CommandLine() []string {
+
+	travel := i.Map
+	if i.Scenario != "" {
+		travel = fmt.Sprintf("%s?Scenario=%s", travel, i.Scenario)
+	}
+	if i.Mode != "" {
+		travel = fmt.Sprintf("%s?game=%s", travel, i.Mode)
+	}
+	travel = fmt.Sprintf("%s?MaxPlayers=%d", travel, i.MaxPlayers)
+
+	args := []string{
+		travel,
+		fmt.Sprintf("-Port=%d", i.Port),
+		fmt.Sprintf("-QueryPort=%d", i.QueryPort),
+		fmt.Sprintf("-ConfigSubDir=%s", i.ID),
+		fmt.Sprintf("-log=%s.log", i.ID),
+		fmt.Sprintf("-hostname=%s", i.Name),
+		fmt.Sprintf("-MapCycle=MapCycle_%s", i.ID),
+		fmt.Sprintf("-AdminList=Admins_%s", i.ID),
+		fmt.Sprintf("-ModList=Mods_%s", i.ID),
+	}
+
+	if i.Address != "" && i.Address != DEFAULT_ADDRESS {
+		args = append(args, fmt.Sprintf("-MultiHome=%s", i.Address))
+	}
+
+	if i.RconPassword != "" {
+		args = append(args, "-Rcon", fmt.Sprintf("-RconPassword=%s", i.RconPassword), fmt.Sprintf("-RconListenPort=%d", i.RconPort))
+	}
+
+	return append(args, i.Arguments...)
+}
+
+func (i *Instance) Start() error {
+
+	i.mutex.Lock()
+	defer i.flush()
+	defer i.mutex.Unlock()
+
+	if i.State == STATE_WAITING {
+		i.cancelRestart()
+		i.State = STATE_STOPPED
+	}
+
+	i.failures = 0
+	i.restartTimes = nil
+
+	return i.start()
+}
+
+func (i *Instance) start() error {
+
+	if i.State != STATE_STOPPED {
+		return i.log.Write(fmt.Sprintf("instance '%s' is already %s", i.ID, i.State), MODULE, admin_log.LOG_WARNING)
+	}
+
+	binary := i.Binary()
+	if _, err := os.Stat(binary); err != nil {
+		return i.log.Write(fmt.Sprintf("sandstorm server binary '%s' not found. ERR: %s", binary, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	i.State = STATE_STARTING
+	i.cmd = exec.Command(binary, i.CommandLine()...)
+	i.cmd.Dir = i.Dir
+	detach(i.cmd)
+	if err := i.cmd.Start(); err != nil {
+		i.State = STATE_STOPPED
+		i.cmd = nil
+		return i.log.Write(fmt.Sprintf("failed to start instance '%s'. ERR: %s", i.ID, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	i.StartedAt = time.Now()
+	i.State = STATE_RUNNING
+	i.hang = ""
+	i.watchdog = make(chan struct{})
+	runtime := i.runtime()
+	i.saveRuntime(runtime)
+	i.follow(runtime, true)
+	i.log.Write(fmt.Sprintf("instance '%s' started with pid %d", i.ID, i.cmd.Process.Pid), MODULE, admin_log.LOG_INFO)
+	i.queue(events.Event{
+		Type:     events.INSTANCE_STARTED,
+		Instance: i.ID,
+		Title:    fmt.Sprintf("Instance %s started", i.Name),
+		Message:  fmt.Sprintf("instance '%s' started with pid %d", i.ID, i.cmd.Process.Pid),
+		Fields:   map[string]string{"pid": fmt.Sprint(i.cmd.Process.Pid), "map": i.Map, "scenario": i.Scenario},
+	})
+
+	go i.wait(i.cmd)
+	go i.watch(i.cmd, i.watchdog)
+
+	return nil
+}
+
+func (i *Instance) wait(cmd *exec.Cmd) {
+
+	i.ended(cmd, cmd.Wait())
+}
+
+// ended is called once the server process exited, whether it was started by
+// this web admin or reattached to.
+func (i *Instance) ended(cmd *exec.Cmd, err error) {
+
+	i.mutex.Lock()
+	defer i.flush()
+	defer i.mutex.Unlock()
+
+	if i.cmd != cmd {
+		return
+	}
+
+	requested := i.State == STATE_STOPPING
+	if requested {
+		i.log.Write(fmt.Sprintf("instance '%s' stopped", i.ID), MODULE, admin_log.LOG_INFO)
+	} else if err != nil {
+		i.log.Write(fmt.Sprintf("instance '%s' exited. ERR: %s", i.ID, err.Error()), MODULE, admin_log.LOG_WARNING)
+	} else {
+		i.log.Write(fmt.Sprintf("instance '%s' exited", i.ID), MODULE, admin_log.LOG_WARNING)
+	}
+
+	close(i.watchdog)
+	i.cmd = nil
+	i.State = STATE_STOPPED
+	i.closeRcon()
+	i.unfollow()
+	i.removeRuntime()
+
+	if requested {
+		i.queue(events.Event{
+			Type:     events.INSTANCE_STOPPED,
+			Instance: i.ID,
+			Title:    fmt.Sprintf("Instance %s stopped", i.Name),
+			Message:  fmt.Sprintf("instance '%s' stopped", i.ID),
+		})
+		return
+	}
+
+	i.exited(cmd, err)
+}
+
+func (i *Instance) Stop() error {
+
+	i.mutex.Lock()
+	if i.State == STATE_WAITING {
+		i.cancelRestart()
+		i.State = STATE_STOPPED
+		i.mutex.Unlock()
+		i.log.Write(fmt.Sprintf("pending restart of instance '%s' cancelled", i.ID), MODULE, admin_log.LOG_INFO)
+		return nil
+	}
+	cmd := i.cmd
+	if cmd == nil || i.State == STATE_STOPPED {
+		i.mutex.Unlock()
+		return i.log.Write(fmt.Sprintf("instance '%s' is not running", i.ID), MODULE, admin_log.LOG_WARNING)
+	}
+	i.State = STATE_STOPPING
+	i.mutex.Unlock()
+
+	if err := interrupt(cmd.Process); err != nil {
+		cmd.Process.Kill()
+	}
+
+	deadline := time.Now().Add(STOP_TIMEOUT)
+	for time.Now().Before(deadline) {
+		if !i.IsRunning() {
+			return nil
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	i.log.Write(fmt.Sprintf("instance '%s' did not stop in %s, killing it", i.ID, STOP_TIMEOUT), MODULE, admin_log.LOG_WARNING)
+	if err := cmd.Process.Kill(); err != nil {
+		return i.log.Write(fmt.Sprintf("failed to kill instance '%s'. ERR: %s", i.ID, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	return nil
+}
+
+// Status returns the current state of the instance.
+func (i *Instance) Status() State {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return i.State
+}
+
+// RestartCount returns how many times the instance was restarted after
+// exiting on its own.
+func (i *Instance) RestartCount() int {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return i.Restarts
+}
+
+func (i *Instance) IsRunning() bool {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return i.cmd != nil && i.State != STATE_STOPPED && i.State != STATE_WAITING
+}
+
+func (i *Instance) Rcon() (*rcon.Client, error) {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.RconPassword == "" {
+		return nil, fmt.Errorf("rcon is not enabled for instance '%s'", i.ID)
+	}
+
+	if i.rcon == nil {
+		i.rcon = rcon.New(i.localAddress(i.RconPort), i.RconPassword)
+	}
+
+	return i.rcon, nil
+}
+
+func (i *Instance) Query() *a2s.Client {
+
+	return a2s.New(i.localAddress(i.QueryPort))
+}
+
+// CrashCount returns how many times the server process failed since the
+// web admin started, unlike the crash history it is never trimmed.
+func (i *Instance) CrashCount() uint64 {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return i.crashes
+}
+
+// RconErrors returns how many RCON connections and commands failed since
+// the web admin started.
+func (i *Instance) RconErrors() uint64 {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	errors := i.rconErrors
+	if i.rcon != nil {
+		errors += i.rcon.Errors()
+	}
+
+	return errors
+}
+
+func (i *Instance) closeRcon() {
+
+	if i.rcon != nil {
+		i.rconErrors += i.rcon.Errors()
+		i.rcon.Close()
+		i.rcon = nil
+	}
+}
+
+func (i *Instance) localAddress(port int) string {
+
+	address := i.Address
+	if address == "" || address == DEFAULT_ADDRESS {
+		address = "127.0.0.1"
+	}
+
+	return fmt.Sprintf("%s:%d", address, port)
+}
+
+func (i *Instance) attach(log *admin_log.Log, dataDir string, events *events.Bus) {
+
+	i.log = log
+	i.dataDir = dataDir
+	i.events = events
+}
+
+func interrupt(process *os.Process) error {
+
+	return process.Signal(os.Interrupt)
+}
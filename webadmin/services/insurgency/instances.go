@@ -1 +1,201 @@
 package insurgency
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+)
+
+type Instances struct {
+	Instances map[string]*Instance `json:"instances"`
+	Dir       string               `json:"dir"`
+	Events    *events.Bus          `json:"-"`
+	db        *store.Store
+	dataDir   string
+	log       *admin_log.Log
+	mutex     sync.RWMutex
+}
+
+var (
+	validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+func NewInstances(conf *config.Configuration, log *admin_log.Log, db *store.Store) *Instances {
+
+	i := new(Instances)
+	i.Instances = make(map[string]*Instance)
+	i.Dir = conf.Sandstorm.Dir
+	i.db = db
+	i.dataDir = conf.WebAdmin.ConfigDir
+	i.log = log
+
+	return i
+}
+
+func (i *Instances) Load() error {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	instances := make(map[string]*Instance)
+	err := i.db.View(func(tx *store.Tx) error {
+		return tx.ForEach(store.BUCKET_INSTANCES, func(id string, value []byte) error {
+			instance := new(Instance)
+			if err := json.Unmarshal(value, instance); err != nil {
+				return fmt.Errorf("failed to parse instance '%s'. ERR: %s", id, err.Error())
+			}
+			instances[id] = instance
+			return nil
+		})
+	})
+	if err != nil {
+		return i.log.Write(fmt.Sprintf("failed to load instances. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	for id, instance := range instances {
+		instance.ID = id
+		instance.State = STATE_STOPPED
+		instance.attach(i.log, i.dataDir, i.Events)
+		i.Instances[id] = instance
+	}
+
+	i.log.Write(fmt.Sprintf("%d instances loaded", len(i.Instances)), MODULE, admin_log.LOG_INFO)
+
+	return nil
+}
+
+func (i *Instances) Save() error {
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	return i.save()
+}
+
+func (i *Instances) save() error {
+
+	err := i.db.Update(func(tx *store.Tx) error {
+		for _, id := range tx.Keys(store.BUCKET_INSTANCES) {
+			if _, ok := i.Instances[id]; !ok {
+				tx.Delete(store.BUCKET_INSTANCES, id)
+			}
+		}
+		for id, instance := range i.Instances {
+			if err := tx.Put(store.BUCKET_INSTANCES, id, instance); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return i.log.Write(fmt.Sprintf("failed to save instances. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	return nil
+}
+
+func (i *Instances) Get(id string) (*Instance, error) {
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	instance, ok := i.Instances[id]
+	if !ok {
+		return nil, fmt.Errorf("instance '%s' not found", id)
+	}
+
+	return instance, nil
+}
+
+func (i *Instances) List() []*Instance {
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	list := make([]*Instance, 0, len(i.Instances))
+	for _, instance := range i.Instances {
+		list = append(list, instance)
+	}
+
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].ID < list[b].ID
+	})
+
+	return list
+}
+
+func (i *Instances) New(id string) *Instance {
+
+	return NewInstance(id, i.Dir, i.log)
+}
+
+// FreePorts moves the ports of an instance that is not added yet to the
+// first ones no other instance uses.
+func (i *Instances) FreePorts(instance *Instance) {
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	used := make(map[int]bool)
+	for id, other := range i.Instances {
+		if id == instance.ID {
+			continue
+		}
+		used[other.Port] = true
+		used[other.QueryPort] = true
+		used[other.RconPort] = true
+	}
+
+	for _, port := range []*int{&instance.Port, &instance.QueryPort, &instance.RconPort} {
+		for used[*port] {
+			*port++
+		}
+		used[*port] = true
+	}
+}
+
+func (i *Instances) Add(instance *Instance) error {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if !validID.MatchString(instance.ID) {
+		return fmt.Errorf("invalid instance id '%s'", instance.ID)
+	}
+
+	if _, ok := i.Instances[instance.ID]; ok {
+		return fmt.Errorf("instance '%s' already exists", instance.ID)
+	}
+
+	instance.State = STATE_STOPPED
+	instance.attach(i.log, i.dataDir, i.Events)
+	i.Instances[instance.ID] = instance
+
+	return i.save()
+}
+
+func (i *Instances) Remove(id string) error {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	instance, ok := i.Instances[id]
+	if !ok {
+		return fmt.Errorf("instance '%s' not found", id)
+	}
+
+	if instance.IsRunning() {
+		return fmt.Errorf("instance '%s' is running", id)
+	}
+
+	delete(i.Instances, id)
+
+	return i.save()
+}
@@ -18,7 +18,7 @@ type Insurgency struct {
 
 const (
 	MODULE = "insurgency"
-	GAMEID = 581320
+	GAMEID = 581330
 )
 
 func New(config *config.Configuration, log *admin_log.Log, steamcmdPath string) *Insurgency {
@@ -26,6 +26,7 @@ func New(config *config.Configuration, log *admin_log.Log, steamcmdPath string)
 	i := new(Insurgency)
 
 	i.log = log
+	i.steamcmdPath = steamcmdPath
 	i.Dir = config.Sandstorm.Dir
 	i.AutomaticUpdates = config.Sandstorm.AutomaticUpdates
 
@@ -46,7 +47,24 @@ func (i *Insurgency) IsInstalled() bool {
 func (i *Insurgency) Install() bool {
 
 	// steamcmd +force_install_dir ../cs1_ds +login anonymous +app_update 730 +quit
-	cmd := exec.Command(i.steamcmdPath, "+force_install_dir", i.Dir, "+login", "anonymous", "+app_update", fmt.Sprintf("%d", 581320, "+quit"))
+	cmd := exec.Command(i.steamcmdPath, "+force_install_dir", i.Dir, "+login", "anonymous", "+app_update", fmt.Sprintf("%d", GAMEID), "+quit")
+	if err := cmd.Run(); err != nil {
+		i.log.Write(fmt.Sprintf("failed to install sandstorm server into '%s'. ERR: %s", i.Dir, err.Error()), MODULE, admin_log.LOG_ERROR)
+		return false
+	}
+
+	return true
+}
+
+// Update updates the game server files and validates them against the
+// steam depot.
+func (i *Insurgency) Update() bool {
+
+	cmd := exec.Command(i.steamcmdPath, "+force_install_dir", i.Dir, "+login", "anonymous", "+app_update", fmt.Sprintf("%d", GAMEID), "validate", "+quit")
+	if err := cmd.Run(); err != nil {
+		i.log.Write(fmt.Sprintf("failed to update sandstorm server in '%s'. ERR: %s", i.Dir, err.Error()), MODULE, admin_log.LOG_ERROR)
+		return false
+	}
 
 	return true
 }
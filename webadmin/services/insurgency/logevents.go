@@ -0,0 +1,211 @@
+package insurgency
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogEvent is a game event read from a line of the server log.
+type LogEvent struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Name       string    `json:"name"`
+	SteamID    string    `json:"steamId"`
+	Address    string    `json:"address,omitempty"`
+	Team       int       `json:"team"`
+	Victim     string    `json:"victim,omitempty"`
+	VictimID   string    `json:"victimId,omitempty"`
+	VictimTeam int       `json:"victimTeam"`
+	Weapon     string    `json:"weapon,omitempty"`
+	Channel    string    `json:"channel,omitempty"`
+	Message    string    `json:"message,omitempty"`
+}
+
+const (
+	LOG_PLAYER_JOINED = "PlayerJoined"
+	LOG_PLAYER_LEFT   = "PlayerLeft"
+	LOG_PLAYER_KILLED = "PlayerKilled"
+	LOG_CHAT_MESSAGE  = "ChatMessage"
+
+	CHANNEL_GLOBAL = "global"
+	CHANNEL_TEAM   = "team"
+
+	// LOG_TIME_LAYOUT is the time prefix of the log lines, in UTC and
+	// followed by the milliseconds
+	LOG_TIME_LAYOUT = "2006.01.02-15.04.05"
+
+	// BOT_ID is the id the kill lines give to bots
+	BOT_ID = "INVALID"
+
+	// LOG_PREFIX anchors the patterns after the time and frame of a line,
+	// so a chat message can not pass for another event
+	LOG_PREFIX = `^(?:\[[^\]]*\])*`
+
+	// MAX_LOGINS bounds the logins kept for refused players that never join
+	MAX_LOGINS = 256
+)
+
+var (
+	logTime     = regexp.MustCompile(`^\[(\d{4}\.\d{2}\.\d{2}-\d{2}\.\d{2}\.\d{2}):(\d{3})\]`)
+	logLogin    = regexp.MustCompile(LOG_PREFIX + `LogNet: Login request: .*\?Name=([^?]*?)(?:\?.*)? userId: (?:` + NETID_STEAM_PREFIX + `)?(\d+)`)
+	logJoin     = regexp.MustCompile(LOG_PREFIX + `LogNet: Join succeeded: (.+)$`)
+	logClose    = regexp.MustCompile(LOG_PREFIX + `LogNet: UChannel::Close: .*RemoteAddr: ([^,]+),.*UniqueId: (?:` + NETID_STEAM_PREFIX + `)?(\d+)`)
+	logKill     = regexp.MustCompile(LOG_PREFIX + `LogGameplayEvents: Display: (.+) killed (.+) with (\S+)\s*$`)
+	logKillSide = regexp.MustCompile(`^(.*)\[([^,\]]*), team (\d+)\]$`)
+	logChat     = regexp.MustCompile(LOG_PREFIX + `LogChat: Display: (.+)$`)
+	logChatFrom = regexp.MustCompile(`\((\d+)\) (Global|Team) Chat: `)
+)
+
+// LogParser turns the lines of a server log into events. Players are
+// announced by their login, which has their SteamID, and join when the
+// login succeeds, so a parser keeps the logins in progress and the
+// players that joined.
+type LogParser struct {
+	logins  map[string]string
+	players map[string]string
+}
+
+func NewLogParser() *LogParser {
+
+	p := new(LogParser)
+	p.logins = make(map[string]string)
+	p.players = make(map[string]string)
+
+	return p
+}
+
+// Joined returns whether the parser saw the player join with the name and
+// SteamID, which a chat message can not fake.
+func (p *LogParser) Joined(name string, steamID string) bool {
+
+	id, ok := p.players[name]
+
+	return ok && id == steamID
+}
+
+// Parse returns the event of a log line and whether the line has one.
+func (p *LogParser) Parse(line string) (LogEvent, bool) {
+
+	event := LogEvent{Time: time.Now().UTC()}
+	if match := logTime.FindStringSubmatch(line); match != nil {
+		if t, err := time.Parse(LOG_TIME_LAYOUT, match[1]); err == nil {
+			milliseconds, _ := strconv.Atoi(match[2])
+			event.Time = t.Add(time.Duration(milliseconds) * time.Millisecond)
+		}
+	}
+
+	if match := logLogin.FindStringSubmatch(line); match != nil {
+		if len(p.logins) >= MAX_LOGINS {
+			p.logins = make(map[string]string)
+		}
+		p.logins[match[1]] = match[2]
+		return event, false
+	}
+
+	if match := logJoin.FindStringSubmatch(line); match != nil {
+		name := strings.TrimSpace(match[1])
+		steamID, ok := p.logins[name]
+		if !ok {
+			return event, false
+		}
+		delete(p.logins, name)
+		if len(p.players) >= MAX_LOGINS {
+			p.players = make(map[string]string)
+		}
+		p.players[name] = steamID
+		event.Type = LOG_PLAYER_JOINED
+		event.Name = name
+		event.SteamID = steamID
+		return event, true
+	}
+
+	if match := logClose.FindStringSubmatch(line); match != nil {
+		for name, steamID := range p.players {
+			if steamID == match[2] {
+				delete(p.players, name)
+			}
+		}
+		event.Type = LOG_PLAYER_LEFT
+		event.SteamID = match[2]
+		event.Address = match[1]
+		if host, _, err := net.SplitHostPort(match[1]); err == nil {
+			event.Address = host
+		}
+		return event, true
+	}
+
+	if match := logChat.FindStringSubmatch(line); match != nil {
+		return p.chat(event, strings.TrimRight(match[1], "\r"))
+	}
+
+	if match := logKill.FindStringSubmatch(line); match != nil {
+		// assists are listed after the killer, joined with " + "
+		killer := strings.Split(match[1], " + ")[0]
+		var ok bool
+		if event.Name, event.SteamID, event.Team, ok = killSide(killer); !ok {
+			return event, false
+		}
+		if event.Victim, event.VictimID, event.VictimTeam, ok = killSide(match[2]); !ok {
+			return event, false
+		}
+		event.Type = LOG_PLAYER_KILLED
+		event.Weapon = match[3]
+		return event, true
+	}
+
+	return event, false
+}
+
+// chat reads the author and the message of a chat line. Both the name and
+// the message can have a "(id) Global Chat: " of their own, so the author
+// is the player that joined with the name and id of one of the splits,
+// else the first split, which Joined then refuses.
+func (p *LogParser) chat(event LogEvent, text string) (LogEvent, bool) {
+
+	var split []int
+	for _, s := range logChatFrom.FindAllStringSubmatchIndex(text, -1) {
+		if s[0] == 0 {
+			continue
+		}
+		if split == nil {
+			split = s
+		}
+		if p.Joined(text[:s[0]], text[s[2]:s[3]]) {
+			split = s
+			break
+		}
+	}
+	if split == nil {
+		return event, false
+	}
+
+	event.Type = LOG_CHAT_MESSAGE
+	event.Name = text[:split[0]]
+	event.SteamID = text[split[2]:split[3]]
+	event.Channel = strings.ToLower(text[split[4]:split[5]])
+	event.Message = text[split[1]:]
+
+	return event, true
+}
+
+// killSide reads a "name[id, team n]" part of a kill line.
+func killSide(side string) (string, string, int, bool) {
+
+	match := logKillSide.FindStringSubmatch(strings.TrimSpace(side))
+	if match == nil {
+		return "", "", 0, false
+	}
+
+	team, _ := strconv.Atoi(match[3])
+
+	return match[1], match[2], team, true
+}
+
+// IsBot tells if the id of a kill line side is a bot's.
+func IsBot(id string) bool {
+
+	return id == "" || id == BOT_ID
+}
@@ -0,0 +1,82 @@
+package insurgency
+
+import (
+	"testing"
+)
+
+const (
+	VICTIM_ID   = "76561198000000001"
+	SPOOFER_ID  = "76561198000000002"
+	SPOOF_NAME  = "Eve(" + VICTIM_ID + ") Global Chat: hi"
+	LINE_PREFIX = "[2024.01.02-03.04.05:678][123]"
+)
+
+// joined returns a parser that saw the players join.
+func joined(t *testing.T, players map[string]string) *LogParser {
+
+	t.Helper()
+
+	p := NewLogParser()
+	for name, steamID := range players {
+		p.Parse(LINE_PREFIX + "LogNet: Login request: ?Name=" + name + "?SplitscreenCount=1 userId: " + NETID_STEAM_PREFIX + steamID + " platform: Steam")
+		if event, ok := p.Parse(LINE_PREFIX + "LogNet: Join succeeded: " + name); !ok || event.Type != LOG_PLAYER_JOINED {
+			t.Fatalf("player '%s' did not join", name)
+		}
+	}
+
+	return p
+}
+
+func TestChatAuthor(t *testing.T) {
+
+	players := map[string]string{"Bob": VICTIM_ID, SPOOF_NAME: SPOOFER_ID}
+
+	tests := []struct {
+		name    string
+		line    string
+		author  string
+		steamID string
+		channel string
+		message string
+		joined  bool
+	}{
+		{"plain", "Bob(" + VICTIM_ID + ") Global Chat: hello", "Bob", VICTIM_ID, CHANNEL_GLOBAL, "hello", true},
+		{"team", "Bob(" + VICTIM_ID + ") Team Chat: hello", "Bob", VICTIM_ID, CHANNEL_TEAM, "hello", true},
+		{"name with a chat line", SPOOF_NAME + "(" + SPOOFER_ID + ") Global Chat: bad word", SPOOF_NAME, SPOOFER_ID, CHANNEL_GLOBAL, "bad word", true},
+		{"message with a chat line", "Bob(" + VICTIM_ID + ") Team Chat: x(" + SPOOFER_ID + ") Global Chat: y", "Bob", VICTIM_ID, CHANNEL_TEAM, "x(" + SPOOFER_ID + ") Global Chat: y", true},
+		{"unknown author", "Mallory(" + VICTIM_ID + ") Global Chat: x(" + SPOOFER_ID + ") Global Chat: y", "Mallory", VICTIM_ID, CHANNEL_GLOBAL, "x(" + SPOOFER_ID + ") Global Chat: y", false},
+	}
+
+	for _, test := range tests {
+		p := joined(t, players)
+		event, ok := p.Parse(LINE_PREFIX + "LogChat: Display: " + test.line + "\r")
+		if !ok || event.Type != LOG_CHAT_MESSAGE {
+			t.Errorf("%s: not a chat message", test.name)
+			continue
+		}
+		if event.Name != test.author || event.SteamID != test.steamID || event.Channel != test.channel || event.Message != test.message {
+			t.Errorf("%s: read %q (%s) on %s saying %q", test.name, event.Name, event.SteamID, event.Channel, event.Message)
+		}
+		if p.Joined(event.Name, event.SteamID) != test.joined {
+			t.Errorf("%s: Joined = %v, want %v", test.name, !test.joined, test.joined)
+		}
+	}
+}
+
+func TestChatAuthorLeaves(t *testing.T) {
+
+	p := joined(t, map[string]string{"Bob": VICTIM_ID})
+	p.Parse(LINE_PREFIX + "LogNet: UChannel::Close: Sending CloseBunch. ChIndex == 0. Name: [UChannel] ChIndex: 0, Closing: 0 [UNetConnection] RemoteAddr: 203.0.113.7:7777, Name: IpConnection_1, Driver: GameNetDriver IpNetDriver_0, IsServer: YES, PC: BP_PlayerController_C_0, Owner: BP_PlayerController_C_0, UniqueId: " + NETID_STEAM_PREFIX + VICTIM_ID)
+
+	if p.Joined("Bob", VICTIM_ID) {
+		t.Error("the player is still joined after leaving")
+	}
+}
+
+func TestChatWithoutAuthor(t *testing.T) {
+
+	p := NewLogParser()
+	if _, ok := p.Parse(LINE_PREFIX + "LogChat: Display: (" + VICTIM_ID + ") Global Chat: hello"); ok {
+		t.Error("a chat line without a name was read")
+	}
+}
@@ -0,0 +1,91 @@
+package insurgency
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// CycleEntry is a scenario of a map cycle, with the lighting it is played
+// with when the cycle sets one.
+type CycleEntry struct {
+	Scenario string `json:"scenario"`
+	Lighting string `json:"lighting,omitempty"`
+}
+
+var (
+	mapCycleScenario = regexp.MustCompile(`(?i)Scenario\s*=\s*"?([^",)]+)"?`)
+	mapCycleLighting = regexp.MustCompile(`(?i)Lighting\s*=\s*"?([^",)]+)"?`)
+)
+
+// MapCycle returns the scenarios of the map cycle of the instance in
+// order.
+func (i *Instance) MapCycle() ([]CycleEntry, error) {
+
+	data, err := os.ReadFile(i.MapCycleFile())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the map cycle of instance '%s'. ERR: %s", i.ID, err.Error())
+	}
+
+	return ParseMapCycle(string(data)), nil
+}
+
+// ParseMapCycle returns the scenarios of a map cycle. Entries are scenario
+// names, or a list of properties with one named Scenario.
+func ParseMapCycle(content string) []CycleEntry {
+
+	cycle := make([]CycleEntry, 0)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "(") {
+			cycle = append(cycle, CycleEntry{Scenario: line})
+			continue
+		}
+		match := mapCycleScenario.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		entry := CycleEntry{Scenario: strings.TrimSpace(match[1])}
+		if match := mapCycleLighting.FindStringSubmatch(line); match != nil {
+			entry.Lighting = strings.TrimSpace(match[1])
+		}
+		cycle = append(cycle, entry)
+	}
+
+	return cycle
+}
+
+// ScenarioMap returns the map of a scenario named like
+// Scenario_<Map>_<Mode>_<Side>.
+func ScenarioMap(scenario string) string {
+
+	parts := strings.Split(scenario, "_")
+	if len(parts) < 2 || !strings.EqualFold(parts[0], SCENARIO_PREFIX) {
+		return scenario
+	}
+
+	return parts[1]
+}
+
+// NextScenario returns the entry that follows the current scenario, or
+// map given by its level or scenario name, in a map cycle. It is the
+// first one when the current is not in the cycle.
+func NextScenario(cycle []CycleEntry, current string) (CycleEntry, bool) {
+
+	if len(cycle) == 0 {
+		return CycleEntry{}, false
+	}
+
+	for n, entry := range cycle {
+		name := ScenarioMap(entry.Scenario)
+		if strings.EqualFold(entry.Scenario, current) || strings.EqualFold(name, current) || strings.EqualFold(MapLevel(name), current) {
+			return cycle[(n+1)%len(cycle)], true
+		}
+	}
+
+	return cycle[0], true
+}
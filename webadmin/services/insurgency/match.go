@@ -0,0 +1,191 @@
+package insurgency
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Travel is where a running server is sent: a scenario with the lighting
+// and the game mode to play it with. Map is the level of scenarios that
+// are not in the catalogue, such as those of mods.
+type Travel struct {
+	Scenario string `json:"scenario"`
+	Lighting string `json:"lighting"`
+	Mode     string `json:"mode"`
+	Map      string `json:"map"`
+}
+
+var (
+	propertyName  = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+	propertyValue = regexp.MustCompile(`^[^\s"]+$`)
+	levelName     = regexp.MustCompile(`^[A-Za-z0-9_/]+$`)
+)
+
+// Catalogue returns the known scenarios with those of the map cycle of the
+// instance.
+func (i *Instance) Catalogue() Catalogue {
+
+	cycle, _ := i.MapCycle()
+
+	return NewCatalogue(cycle)
+}
+
+// TravelCommand checks a travel against the catalogue of the instance and
+// returns the command that does it.
+func (i *Instance) TravelCommand(travel Travel) (string, error) {
+
+	return i.Catalogue().TravelCommand(travel)
+}
+
+// TravelCommand checks a travel against the catalogue and returns the
+// command that does it.
+func (c Catalogue) TravelCommand(travel Travel) (string, error) {
+
+	scenario, err := c.Find(travel.Scenario)
+	if err != nil {
+		return "", err
+	}
+
+	level := travel.Map
+	if scenario.Map != nil {
+		level = scenario.Map.Level
+	}
+	if level == "" {
+		return "", fmt.Errorf("scenario '%s' is not in the catalogue, its map must be given", scenario.Name)
+	}
+	// the level is sent as is, it can not add options to the command
+	if !levelName.MatchString(level) {
+		return "", fmt.Errorf("invalid map '%s', it can only have letters, digits, '_' and '/'", level)
+	}
+
+	command := fmt.Sprintf("travel %s?Scenario=%s", level, scenario.Name)
+
+	if travel.Lighting != "" {
+		lighting := ""
+		for _, l := range Lightings {
+			if strings.EqualFold(l, travel.Lighting) {
+				lighting = l
+			}
+		}
+		if lighting == "" {
+			return "", fmt.Errorf("lighting '%s' is not one of %v", travel.Lighting, Lightings)
+		}
+		command += fmt.Sprintf("?Lighting=%s", lighting)
+	}
+
+	if travel.Mode != "" {
+		modes := make([]string, 0)
+		if scenario.Mode != nil {
+			modes = scenario.Mode.Variants
+		} else {
+			for _, mode := range Modes {
+				modes = append(modes, mode.Variants...)
+			}
+		}
+		mode := ""
+		for _, m := range modes {
+			if strings.EqualFold(m, travel.Mode) {
+				mode = m
+			}
+		}
+		if mode == "" {
+			return "", fmt.Errorf("game mode '%s' can not be played on scenario '%s', the modes are %v", travel.Mode, scenario.Name, modes)
+		}
+		command += fmt.Sprintf("?game=%s", mode)
+	}
+
+	return command, nil
+}
+
+// Travel sends the running server to another scenario and returns the
+// command sent with the answer of the server.
+func (i *Instance) Travel(travel Travel) (string, string, error) {
+
+	command, err := i.TravelCommand(travel)
+	if err != nil {
+		return "", "", err
+	}
+
+	out, err := i.matchCommand(command)
+
+	return command, out, err
+}
+
+// NextMap travels to the scenario that follows the current map in the map
+// cycle.
+func (i *Instance) NextMap() (Travel, string, error) {
+
+	cycle, err := i.MapCycle()
+	if err != nil {
+		return Travel{}, "", err
+	}
+
+	current := ""
+	if info, err := i.Query().Info(); err == nil {
+		current = info.Map
+	}
+
+	next, ok := NextScenario(cycle, current)
+	if !ok {
+		return Travel{}, "", fmt.Errorf("the map cycle of instance '%s' is empty", i.ID)
+	}
+
+	travel := Travel{Scenario: next.Scenario, Lighting: next.Lighting, Map: MapLevel(ScenarioMap(next.Scenario))}
+	_, out, err := i.Travel(travel)
+
+	return travel, out, err
+}
+
+// RestartRound restarts the current round of the running game.
+func (i *Instance) RestartRound() (string, error) {
+
+	return i.matchCommand("restartround")
+}
+
+// Property returns the value of a game mode property.
+func (i *Instance) Property(name string) (string, error) {
+
+	if err := CheckProperty(name, ""); err != nil {
+		return "", err
+	}
+
+	return i.matchCommand(fmt.Sprintf("gamemodeproperty %s", name))
+}
+
+// SetProperty changes a game mode property, such as RoundTime or bBots,
+// of the running game.
+func (i *Instance) SetProperty(name string, value string) (string, error) {
+
+	if err := CheckProperty(name, value); err != nil {
+		return "", err
+	}
+	if value == "" {
+		return "", fmt.Errorf("game mode property '%s' needs a value", name)
+	}
+
+	return i.matchCommand(fmt.Sprintf("gamemodeproperty %s %s", name, value))
+}
+
+// CheckProperty validates the name of a game mode property and, when
+// given, its value which can not have spaces or quotes.
+func CheckProperty(name string, value string) error {
+
+	if !propertyName.MatchString(name) {
+		return fmt.Errorf("invalid game mode property '%s'", name)
+	}
+	if value != "" && !propertyValue.MatchString(value) {
+		return fmt.Errorf("invalid value '%s' for game mode property '%s'", value, name)
+	}
+
+	return nil
+}
+
+func (i *Instance) matchCommand(command string) (string, error) {
+
+	if !i.IsRunning() {
+		return "", fmt.Errorf("instance '%s' is not running", i.ID)
+	}
+
+	return i.Command(command)
+}
@@ -0,0 +1,35 @@
+package insurgency
+
+import (
+	"testing"
+)
+
+func TestTravelCommandMap(t *testing.T) {
+
+	catalogue := NewCatalogue([]CycleEntry{{Scenario: "Scenario_Mod_Checkpoint_Security"}})
+
+	tests := []struct {
+		name    string
+		travel  Travel
+		command string
+	}{
+		{"catalogue level", Travel{Scenario: "Scenario_Crossing_Checkpoint_Security", Map: "Other?game=Push"}, "travel Canyon?Scenario=Scenario_Crossing_Checkpoint_Security"},
+		{"mod level", Travel{Scenario: "Scenario_Mod_Checkpoint_Security", Map: "/Game/Mods/Mod/Maps/Mod_1"}, "travel /Game/Mods/Mod/Maps/Mod_1?Scenario=Scenario_Mod_Checkpoint_Security"},
+		{"options in the map", Travel{Scenario: "Scenario_Mod_Checkpoint_Security", Map: "Mod?game=Push"}, ""},
+		{"command in the map", Travel{Scenario: "Scenario_Mod_Checkpoint_Security", Map: "Mod; quit"}, ""},
+		{"no map", Travel{Scenario: "Scenario_Mod_Checkpoint_Security"}, ""},
+	}
+
+	for _, test := range tests {
+		command, err := catalogue.TravelCommand(test.travel)
+		if test.command == "" {
+			if err == nil {
+				t.Errorf("%s: travel accepted as '%s'", test.name, command)
+			}
+			continue
+		}
+		if err != nil || command != test.command {
+			t.Errorf("%s: command '%s', %v, want '%s'", test.name, command, err, test.command)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package insurgency
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+)
+
+type Player struct {
+	ID        int           `json:"id"`
+	Name      string        `json:"name"`
+	SteamID   string        `json:"steamId"`
+	IP        string        `json:"ip"`
+	Score     int           `json:"score"`
+	Ping      int           `json:"ping"`
+	Team      string        `json:"team"`
+	Connected time.Duration `json:"connected"`
+}
+
+const (
+	NETID_STEAM_PREFIX = "SteamNWI:"
+	STEAM_ID_LENGTH    = 17
+)
+
+// Players combines the RCON listplayers table, which knows the players'
+// SteamIDs, with the A2S_PLAYER answer, which knows how long each one has
+// been connected. Players are matched by name.
+func (i *Instance) Players() ([]Player, error) {
+
+	client, err := i.Rcon()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := client.Execute("listplayers")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list players on instance '%s'. ERR: %s", i.ID, err.Error())
+	}
+
+	players := parseListPlayers(out)
+
+	queried, err := i.Query().Players()
+	if err != nil {
+		i.log.Write(fmt.Sprintf("failed to query players on instance '%s'. ERR: %s", i.ID, err.Error()), MODULE, admin_log.LOG_DEBUG)
+		return players, nil
+	}
+
+	for _, q := range queried {
+		for p := range players {
+			if players[p].Name == q.Name {
+				players[p].Connected = q.Duration
+				if players[p].Score == 0 {
+					players[p].Score = int(q.Score)
+				}
+				break
+			}
+		}
+	}
+
+	return players, nil
+}
+
+func (i *Instance) Player(steamID string) (*Player, error) {
+
+	players, err := i.Players()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range players {
+		if p.SteamID == steamID {
+			return &p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("player '%s' is not connected to instance '%s'", steamID, i.ID)
+}
+
+func (i *Instance) Kick(steamID string, reason string) (string, error) {
+
+	if !IsSteamID(steamID) {
+		return "", fmt.Errorf("'%s' is not a SteamID", steamID)
+	}
+
+	return i.Command(fmt.Sprintf("kick %s %s", steamID, quote(reason)))
+}
+
+// Ban bans a player permanently when duration is zero, otherwise for the
+// given duration rounded up to whole minutes.
+func (i *Instance) Ban(steamID string, duration time.Duration, reason string) (string, error) {
+
+	if !IsSteamID(steamID) {
+		return "", fmt.Errorf("'%s' is not a SteamID", steamID)
+	}
+
+	command := fmt.Sprintf("permban %s %s", steamID, quote(reason))
+	length := "permanent"
+	if duration > 0 {
+		minutes := int((duration + time.Minute - 1) / time.Minute)
+		command = fmt.Sprintf("ban %s %d %s", steamID, minutes, quote(reason))
+		length = fmt.Sprintf("%d minutes", minutes)
+	}
+
+	out, err := i.Command(command)
+	if err != nil {
+		return out, err
+	}
+
+	i.events.Publish(events.Event{
+		Type:     events.PLAYER_BANNED,
+		Instance: i.ID,
+		Title:    fmt.Sprintf("Player banned on %s", i.Name),
+		Message:  fmt.Sprintf("player %s was banned (%s): %s", steamID, length, reason),
+		Fields:   map[string]string{"steamId": steamID, "duration": length, "reason": reason},
+	})
+
+	return out, nil
+}
+
+// Warn addresses a warning to a player. Sandstorm's RCON has no private
+// channel, so the warning is said to every player with the name of the
+// warned one as prefix.
+func (i *Instance) Warn(steamID string, message string) (string, error) {
+
+	player, err := i.Player(steamID)
+	if err != nil {
+		return "", err
+	}
+
+	return i.Say(fmt.Sprintf("@%s: %s", player.Name, message))
+}
+
+// IsSteamID tells if id is a SteamID64, the only form RCON commands take.
+func IsSteamID(id string) bool {
+
+	if len(id) != STEAM_ID_LENGTH {
+		return false
+	}
+	for _, r := range id {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (i *Instance) Say(message string) (string, error) {
+
+	return i.Command(fmt.Sprintf("say %s", message))
+}
+
+func (i *Instance) Command(command string) (string, error) {
+
+	client, err := i.Rcon()
+	if err != nil {
+		return "", err
+	}
+
+	out, err := client.Execute(command)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute '%s' on instance '%s'. ERR: %s", strings.Fields(command)[0], i.ID, err.Error())
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// parseListPlayers reads the table printed by listplayers. The header line
+// names the columns so optional ones such as team or ping are picked up
+// when the server version prints them.
+func parseListPlayers(out string) []Player {
+
+	players := make([]Player, 0)
+	columns := make(map[string]int)
+
+	for _, line := range strings.Split(out, "\n") {
+
+		if strings.HasPrefix(strings.TrimSpace(line), "===") || !strings.Contains(line, "|") {
+			continue
+		}
+
+		fields := strings.Split(line, "|")
+		for f := range fields {
+			fields[f] = strings.TrimSpace(fields[f])
+		}
+
+		if len(columns) == 0 {
+			for f, name := range fields {
+				if name != "" {
+					columns[strings.ToLower(name)] = f
+				}
+			}
+			continue
+		}
+
+		get := func(name string) string {
+			if f, ok := columns[name]; ok && f < len(fields) {
+				return fields[f]
+			}
+			return ""
+		}
+
+		netID := get("netid")
+		if !strings.HasPrefix(netID, NETID_STEAM_PREFIX) {
+			// bots and the listen slot report an INVALID net id
+			continue
+		}
+
+		var p Player
+		p.ID, _ = strconv.Atoi(get("id"))
+		p.Name = get("name")
+		p.SteamID = strings.TrimPrefix(netID, NETID_STEAM_PREFIX)
+		p.IP = get("ip")
+		p.Score, _ = strconv.Atoi(get("score"))
+		p.Ping, _ = strconv.Atoi(get("ping"))
+		p.Team = get("team")
+
+		players = append(players, p)
+	}
+
+	return players
+}
+
+func quote(value string) string {
+
+	value = strings.ReplaceAll(value, "\"", "'")
+	if value == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("\"%s\"", value)
+}
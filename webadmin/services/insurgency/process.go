@@ -0,0 +1,177 @@
+package insurgency
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+)
+
+const (
+	PROC_DIR = "/proc"
+)
+
+// FindProcess looks for a server process of this instance that was not
+// started by this web admin process, matching the binary and the config
+// sub directory on its command line.
+func (i *Instance) FindProcess() (*os.Process, error) {
+
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("looking up server processes is only supported on linux")
+	}
+
+	entries, err := os.ReadDir(PROC_DIR)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s'. ERR: %s", PROC_DIR, err.Error())
+	}
+
+	binary := filepath.Base(i.Binary())
+	subDir := fmt.Sprintf("-ConfigSubDir=%s", i.ID)
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		args, err := processCommandLine(pid)
+		if err != nil || filepath.Base(args[0]) != binary {
+			continue
+		}
+		for _, arg := range args[1:] {
+			if arg == subDir {
+				return os.FindProcess(pid)
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no running server process found for instance '%s'", i.ID)
+}
+
+// Terminate stops a server process found with FindProcess, killing it when
+// it does not exit in time.
+func (i *Instance) Terminate(process *os.Process) error {
+
+	if err := interrupt(process); err != nil {
+		return i.log.Write(fmt.Sprintf("failed to interrupt instance '%s' (pid %d). ERR: %s", i.ID, process.Pid, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	deadline := time.Now().Add(STOP_TIMEOUT)
+	for time.Now().Before(deadline) {
+		if !alive(process) {
+			i.log.Write(fmt.Sprintf("instance '%s' (pid %d) stopped", i.ID, process.Pid), MODULE, admin_log.LOG_INFO)
+			return nil
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	i.log.Write(fmt.Sprintf("instance '%s' did not stop in %s, killing it", i.ID, STOP_TIMEOUT), MODULE, admin_log.LOG_WARNING)
+	if err := process.Kill(); err != nil {
+		return i.log.Write(fmt.Sprintf("failed to kill instance '%s'. ERR: %s", i.ID, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	return nil
+}
+
+// processCommandLine returns the binary and arguments of a running process.
+func processCommandLine(pid int) ([]string, error) {
+
+	cmdline, err := os.ReadFile(filepath.Join(PROC_DIR, strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	if len(cmdline) == 0 {
+		return nil, fmt.Errorf("process %d has no command line", pid)
+	}
+
+	args := make([]string, 0)
+	for _, arg := range bytes.Split(bytes.TrimRight(cmdline, "\x00"), []byte{0}) {
+		args = append(args, string(arg))
+	}
+
+	return args, nil
+}
+
+// processPorts returns the UDP and TCP ports a process has bound, found by
+// matching the inodes of its open sockets with the kernel socket tables.
+func processPorts(pid int) (map[int]bool, map[int]bool, error) {
+
+	dir := filepath.Join(PROC_DIR, strconv.Itoa(pid))
+	fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inodes := make(map[string]bool)
+	for _, fd := range fds {
+		link, err := os.Readlink(filepath.Join(dir, "fd", fd.Name()))
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] = true
+	}
+
+	udp := make(map[int]bool)
+	tcp := make(map[int]bool)
+	for _, table := range []string{"udp", "udp6", "tcp", "tcp6"} {
+		ports := udp
+		if strings.HasPrefix(table, "tcp") {
+			ports = tcp
+		}
+		if err := socketPorts(filepath.Join(dir, "net", table), inodes, ports); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return udp, tcp, nil
+}
+
+// socketPorts reads a /proc/net socket table, adding the local port of the
+// sockets in inodes. TCP sockets only count while listening.
+func socketPorts(path string, inodes map[string]bool, ports map[int]bool) error {
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tcp := strings.Contains(filepath.Base(path), "tcp")
+	scanner := bufio.NewScanner(file)
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || !inodes[fields[9]] {
+			continue
+		}
+		// 0A is TCP_LISTEN
+		if tcp && fields[3] != "0A" {
+			continue
+		}
+		_, hexPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		if port, err := strconv.ParseInt(hexPort, 16, 32); err == nil {
+			ports[int(port)] = true
+		}
+	}
+
+	return scanner.Err()
+}
+
+// alive reports whether a process still exists.
+func alive(process *os.Process) bool {
+
+	return process.Signal(syscall.Signal(0)) == nil
+}
@@ -0,0 +1,255 @@
+package insurgency
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+// Runtime is what is known about a running server process. It is saved
+// while the server runs so a restarted web admin can reattach to it.
+type Runtime struct {
+	Pid         int       `json:"pid"`
+	StartedAt   time.Time `json:"startedAt"`
+	Binary      string    `json:"binary"`
+	CommandLine []string  `json:"commandLine"`
+	LogOffset   int64     `json:"logOffset"`
+}
+
+const (
+	RUNTIME_DIR = "runtime"
+)
+
+func (i *Instance) runtimeFile() string {
+
+	return filepath.Join(i.dataDir, RUNTIME_DIR, fmt.Sprintf("%s.json", i.ID))
+}
+
+func (i *Instance) saveRuntime(runtime Runtime) {
+
+	data, err := json.MarshalIndent(runtime, "", "  ")
+	if err != nil {
+		i.log.Write(fmt.Sprintf("failed to serialize runtime of instance '%s'. ERR: %s", i.ID, err.Error()), MODULE, admin_log.LOG_ERROR)
+		return
+	}
+
+	path := i.runtimeFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		i.log.Write(fmt.Sprintf("failed to create directory '%s'. ERR: %s", filepath.Dir(path), err.Error()), MODULE, admin_log.LOG_ERROR)
+		return
+	}
+
+	if err := os.WriteFile(path, data, 0640); err != nil {
+		i.log.Write(fmt.Sprintf("failed to write runtime file '%s'. ERR: %s", path, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+}
+
+func (i *Instance) loadRuntime() (*Runtime, error) {
+
+	path := i.runtimeFile()
+	if !utils.FileExists(path) {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read runtime file '%s'. ERR: %s", path, err.Error())
+	}
+
+	runtime := new(Runtime)
+	if err := json.Unmarshal(data, runtime); err != nil {
+		return nil, fmt.Errorf("failed to parse runtime file '%s'. ERR: %s", path, err.Error())
+	}
+
+	return runtime, nil
+}
+
+func (i *Instance) removeRuntime() {
+
+	if err := os.Remove(i.runtimeFile()); err != nil && !os.IsNotExist(err) {
+		i.log.Write(fmt.Sprintf("failed to remove runtime file '%s'. ERR: %s", i.runtimeFile(), err.Error()), MODULE, admin_log.LOG_WARNING)
+	}
+}
+
+// Reattach takes over the server process of this instance left running by
+// a previous web admin. The process must still have the command line it
+// was started with. Servers started before their runtime was saved are
+// found by their command line.
+func (i *Instance) Reattach() bool {
+
+	runtime, err := i.loadRuntime()
+	if err != nil {
+		i.log.Write(err.Error(), MODULE, admin_log.LOG_WARNING)
+	}
+
+	if runtime == nil {
+		process, err := i.FindProcess()
+		if err != nil {
+			return false
+		}
+		args, err := processCommandLine(process.Pid)
+		if err != nil {
+			return false
+		}
+		runtime = &Runtime{Pid: process.Pid, Binary: args[0], CommandLine: args[1:]}
+		if fi, err := os.Stat(filepath.Join(PROC_DIR, fmt.Sprintf("%d", process.Pid))); err == nil {
+			runtime.StartedAt = fi.ModTime()
+		}
+		if fi, err := os.Stat(i.LogFile()); err == nil {
+			runtime.LogOffset = fi.Size()
+		}
+	}
+
+	process, err := i.verify(runtime)
+	if err != nil {
+		i.log.Write(fmt.Sprintf("not reattaching to instance '%s' pid %d: %s", i.ID, runtime.Pid, err.Error()), MODULE, admin_log.LOG_INFO)
+		i.removeRuntime()
+		return false
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.State != STATE_STOPPED {
+		return false
+	}
+
+	// the process is not a child of this web admin, it can not be waited
+	// for and is polled instead
+	cmd := &exec.Cmd{Path: runtime.Binary, Args: append([]string{runtime.Binary}, runtime.CommandLine...), Process: process}
+	i.cmd = cmd
+	i.StartedAt = runtime.StartedAt
+	i.State = STATE_RUNNING
+	i.hang = ""
+	i.watchdog = make(chan struct{})
+	i.saveRuntime(*runtime)
+	i.follow(*runtime, false)
+
+	if !equal(runtime.CommandLine, i.CommandLine()) {
+		i.log.Write(fmt.Sprintf("instance '%s' was started with another configuration, restart it to apply the current one", i.ID), MODULE, admin_log.LOG_WARNING)
+	}
+	i.log.Write(fmt.Sprintf("reattached to instance '%s' with pid %d, running since %s", i.ID, process.Pid, runtime.StartedAt.Format(time.RFC3339)), MODULE, admin_log.LOG_INFO)
+
+	go i.poll(cmd)
+	go i.watch(cmd, i.watchdog)
+
+	return true
+}
+
+// verify checks that the process of a runtime is still the server that
+// was started: the same command line and, once it had time to start, the
+// game and query ports bound.
+func (i *Instance) verify(runtime *Runtime) (*os.Process, error) {
+
+	process, err := os.FindProcess(runtime.Pid)
+	if err != nil || !alive(process) {
+		return nil, fmt.Errorf("the process is not running")
+	}
+
+	args, err := processCommandLine(runtime.Pid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the command line. ERR: %s", err.Error())
+	}
+	if args[0] != runtime.Binary || !equal(args[1:], runtime.CommandLine) {
+		return nil, fmt.Errorf("the process has another command line")
+	}
+
+	udp, tcp, err := processPorts(runtime.Pid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the ports. ERR: %s", err.Error())
+	}
+	port, queryPort, rconPort := commandLinePorts(runtime.CommandLine)
+	if time.Since(runtime.StartedAt) > time.Duration(i.Restart.StartupGrace)*time.Second {
+		if !udp[port] || !udp[queryPort] {
+			return nil, fmt.Errorf("the process is not bound to ports %d and %d", port, queryPort)
+		}
+		if rconPort > 0 && !tcp[rconPort] {
+			i.log.Write(fmt.Sprintf("instance '%s' is not listening for rcon on port %d", i.ID, rconPort), MODULE, admin_log.LOG_WARNING)
+		}
+	}
+
+	return process, nil
+}
+
+// poll waits for a process that is not a child of this web admin.
+func (i *Instance) poll(cmd *exec.Cmd) {
+
+	for alive(cmd.Process) {
+		time.Sleep(time.Second)
+	}
+
+	i.ended(cmd, fmt.Errorf("the exit status of a reattached server is unknown"))
+}
+
+// Detach stops following a running server without stopping it, saving
+// what is needed to reattach to it later.
+func (i *Instance) Detach() {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.cmd == nil || i.State == STATE_STOPPED {
+		return
+	}
+
+	runtime := i.runtime()
+	runtime.LogOffset = i.unfollow()
+	i.saveRuntime(runtime)
+}
+
+// runtime describes the current process, called with the mutex held.
+func (i *Instance) runtime() Runtime {
+
+	return Runtime{
+		Pid:         i.cmd.Process.Pid,
+		StartedAt:   i.StartedAt,
+		Binary:      i.cmd.Path,
+		CommandLine: append([]string{}, i.cmd.Args[1:]...),
+	}
+}
+
+func commandLinePorts(args []string) (int, int, int) {
+
+	var port, queryPort, rconPort int
+	for _, arg := range args {
+		fmt.Sscanf(arg, "-Port=%d", &port)
+		fmt.Sscanf(arg, "-QueryPort=%d", &queryPort)
+		fmt.Sscanf(arg, "-RconListenPort=%d", &rconPort)
+	}
+
+	return port, queryPort, rconPort
+}
+
+func equal(a []string, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+	for n := range a {
+		if a[n] != b[n] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reattach reattaches every instance to the server left running by a
+// previous web admin and returns how many were found.
+func (i *Instances) Reattach() int {
+
+	count := 0
+	for _, instance := range i.List() {
+		if instance.Reattach() {
+			count++
+		}
+	}
+
+	return count
+}
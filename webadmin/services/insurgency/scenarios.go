@@ -0,0 +1,179 @@
+package insurgency
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Map is a map of the game: the level the server travels to and the name
+// its scenarios use.
+type Map struct {
+	Level    string `json:"level"`
+	Scenario string `json:"scenario"`
+}
+
+// Mode is a game mode with the sides its scenarios are played from.
+// Variants are the game modes that can be played on its scenarios.
+type Mode struct {
+	Name     string   `json:"name"`
+	Sides    []string `json:"sides"`
+	Variants []string `json:"variants"`
+	Coop     bool     `json:"coop"`
+}
+
+// Catalogue is what the web admin knows about the scenarios of the game,
+// scenarios are named Scenario_<Map>_<Mode>[_<Side>].
+type Catalogue struct {
+	Maps      []Map    `json:"maps"`
+	Modes     []Mode   `json:"modes"`
+	Lightings []string `json:"lightings"`
+	Scenarios []string `json:"scenarios"`
+}
+
+const (
+	SCENARIO_PREFIX = "Scenario"
+
+	LIGHTING_DAY   = "Day"
+	LIGHTING_NIGHT = "Night"
+)
+
+var (
+	Maps = []Map{
+		{Level: "Bab", Scenario: "Bab"},
+		{Level: "Canyon", Scenario: "Crossing"},
+		{Level: "Citadel", Scenario: "Citadel"},
+		{Level: "Compound", Scenario: "Outskirts"},
+		{Level: "Farmhouse", Scenario: "Farmhouse"},
+		{Level: "Gap", Scenario: "Gap"},
+		{Level: "Ministry", Scenario: "Ministry"},
+		{Level: "Mountain", Scenario: "Summit"},
+		{Level: "Oilfield", Scenario: "Refinery"},
+		{Level: "PowerPlant", Scenario: "PowerPlant"},
+		{Level: "Precinct", Scenario: "Precinct"},
+		{Level: "Prison", Scenario: "Prison"},
+		{Level: "Sinjar", Scenario: "Hillside"},
+		{Level: "Buhriz", Scenario: "Tideway"},
+		{Level: "Tell", Scenario: "Tell"},
+		{Level: "Town", Scenario: "Hideout"},
+	}
+
+	Modes = []Mode{
+		{Name: "Checkpoint", Sides: []string{"Security", "Insurgents"}, Variants: []string{"Checkpoint", "CheckpointHardcore"}, Coop: true},
+		{Name: "Outpost", Sides: []string{"Security", "Insurgents"}, Variants: []string{"Outpost"}, Coop: true},
+		{Name: "Survival", Sides: []string{"Security", "Insurgents"}, Variants: []string{"Survival"}, Coop: true},
+		{Name: "Push", Sides: []string{"Security", "Insurgents"}, Variants: []string{"Push", "PushHardcore"}},
+		{Name: "Firefight", Sides: []string{"East", "West"}, Variants: []string{"Firefight", "FirefightHardcore"}},
+		{Name: "Skirmish", Variants: []string{"Skirmish"}},
+		{Name: "Domination", Variants: []string{"Domination"}},
+		{Name: "Frontline", Variants: []string{"Frontline"}},
+		{Name: "Ambush", Variants: []string{"Ambush"}},
+	}
+
+	Lightings = []string{LIGHTING_DAY, LIGHTING_NIGHT}
+)
+
+// Scenarios lists every scenario of the catalogue.
+func Scenarios() []string {
+
+	scenarios := make([]string, 0)
+	for _, m := range Maps {
+		for _, mode := range Modes {
+			if len(mode.Sides) == 0 {
+				scenarios = append(scenarios, fmt.Sprintf("%s_%s_%s", SCENARIO_PREFIX, m.Scenario, mode.Name))
+				continue
+			}
+			for _, side := range mode.Sides {
+				scenarios = append(scenarios, fmt.Sprintf("%s_%s_%s_%s", SCENARIO_PREFIX, m.Scenario, mode.Name, side))
+			}
+		}
+	}
+	sort.Strings(scenarios)
+
+	return scenarios
+}
+
+// NewCatalogue returns the known scenarios and those of a map cycle, which
+// may come from mods.
+func NewCatalogue(cycle []CycleEntry) Catalogue {
+
+	c := Catalogue{Maps: Maps, Modes: Modes, Lightings: Lightings, Scenarios: Scenarios()}
+	for _, entry := range cycle {
+		if !containsFold(c.Scenarios, entry.Scenario) {
+			c.Scenarios = append(c.Scenarios, entry.Scenario)
+		}
+	}
+
+	return c
+}
+
+// Scenario is a scenario of the catalogue split into its parts.
+type Scenario struct {
+	Name  string
+	Map   *Map
+	Mode  *Mode
+	Side  string
+	Known bool
+}
+
+// Find returns a scenario of the catalogue, with the case it is spelled
+// with there. Scenarios only in the map cycle are known by name only.
+func (c Catalogue) Find(name string) (*Scenario, error) {
+
+	found := ""
+	for _, scenario := range c.Scenarios {
+		if strings.EqualFold(scenario, name) {
+			found = scenario
+			break
+		}
+	}
+	if found == "" {
+		return nil, fmt.Errorf("unknown scenario '%s'", name)
+	}
+
+	s := &Scenario{Name: found}
+	parts := strings.Split(found, "_")
+	if len(parts) < 3 {
+		return s, nil
+	}
+	for n := range Maps {
+		if Maps[n].Scenario == parts[1] {
+			s.Map = &Maps[n]
+		}
+	}
+	for n := range Modes {
+		if Modes[n].Name == parts[2] {
+			s.Mode = &Modes[n]
+		}
+	}
+	if len(parts) > 3 {
+		s.Side = parts[3]
+	}
+	s.Known = s.Map != nil && s.Mode != nil
+
+	return s, nil
+}
+
+// MapLevel returns the level of a map given by its level or scenario name,
+// the name itself when it is not in the catalogue.
+func MapLevel(name string) string {
+
+	for _, m := range Maps {
+		if strings.EqualFold(m.Level, name) || strings.EqualFold(m.Scenario, name) {
+			return m.Level
+		}
+	}
+
+	return name
+}
+
+func containsFold(values []string, value string) bool {
+
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
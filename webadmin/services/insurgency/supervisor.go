@@ -0,0 +1,335 @@
+package insurgency
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+// RestartPolicy controls what happens when an instance exits without being
+// asked to. All durations are in seconds, a zero hang timeout disables that
+// kind of hang detection.
+type RestartPolicy struct {
+	Policy        string `json:"policy"`
+	Backoff       int    `json:"backoff"`
+	MaxBackoff    int    `json:"maxBackoff"`
+	MaxRestarts   int    `json:"maxRestarts"`
+	Window        int    `json:"window"`
+	StartupGrace  int    `json:"startupGrace"`
+	CheckInterval int    `json:"checkInterval"`
+	QueryTimeout  int    `json:"queryTimeout"`
+	LogSilence    int    `json:"logSilence"`
+	CrashLogLines int    `json:"crashLogLines"`
+}
+
+const (
+	RESTART_NEVER      = "never"
+	RESTART_ON_FAILURE = "on-failure"
+	RESTART_ALWAYS     = "always"
+
+	HANG_QUERY = "query-timeout"
+	HANG_LOG   = "log-silence"
+
+	DEFAULT_BACKOFF         = 5
+	DEFAULT_MAX_BACKOFF     = 300
+	DEFAULT_MAX_RESTARTS    = 5
+	DEFAULT_RESTART_WINDOW  = 3600
+	DEFAULT_STARTUP_GRACE   = 180
+	DEFAULT_CHECK_INTERVAL  = 15
+	DEFAULT_QUERY_TIMEOUT   = 120
+	DEFAULT_LOG_SILENCE     = 0
+	DEFAULT_CRASH_LOG_LINES = 100
+)
+
+func DefaultRestartPolicy() RestartPolicy {
+
+	return RestartPolicy{
+		Policy:        RESTART_ON_FAILURE,
+		Backoff:       DEFAULT_BACKOFF,
+		MaxBackoff:    DEFAULT_MAX_BACKOFF,
+		MaxRestarts:   DEFAULT_MAX_RESTARTS,
+		Window:        DEFAULT_RESTART_WINDOW,
+		StartupGrace:  DEFAULT_STARTUP_GRACE,
+		CheckInterval: DEFAULT_CHECK_INTERVAL,
+		QueryTimeout:  DEFAULT_QUERY_TIMEOUT,
+		LogSilence:    DEFAULT_LOG_SILENCE,
+		CrashLogLines: DEFAULT_CRASH_LOG_LINES,
+	}
+}
+
+func (p *RestartPolicy) Validate() error {
+
+	switch p.Policy {
+	case RESTART_NEVER, RESTART_ON_FAILURE, RESTART_ALWAYS:
+	case "":
+		p.Policy = RESTART_NEVER
+	default:
+		return fmt.Errorf("invalid restart policy '%s'", p.Policy)
+	}
+
+	if p.Backoff < 0 || p.MaxBackoff < 0 || p.MaxRestarts < 0 || p.Window < 0 || p.StartupGrace < 0 || p.CheckInterval < 0 || p.QueryTimeout < 0 || p.LogSilence < 0 || p.CrashLogLines < 0 {
+		return fmt.Errorf("restart policy values can not be negative")
+	}
+
+	if p.MaxBackoff > 0 && p.MaxBackoff < p.Backoff {
+		return fmt.Errorf("restart policy maxBackoff must not be lower than backoff")
+	}
+
+	return nil
+}
+
+func (i *Instance) SetRestartPolicy(policy RestartPolicy) error {
+
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.Restart = policy
+
+	return nil
+}
+
+// delay is the exponential backoff for the current run of failures.
+func (p RestartPolicy) delay(failures int) time.Duration {
+
+	delay := time.Duration(p.Backoff) * time.Second
+	for n := 1; n < failures && delay > 0; n++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= time.Duration(p.MaxBackoff)*time.Second {
+			break
+		}
+	}
+
+	if p.MaxBackoff > 0 && delay > time.Duration(p.MaxBackoff)*time.Second {
+		delay = time.Duration(p.MaxBackoff) * time.Second
+	}
+
+	return delay
+}
+
+// watch looks for a server that is still running but no longer serving,
+// either because it stopped answering A2S queries or because its log went
+// quiet. A hung server is killed so wait can treat it as a crash.
+func (i *Instance) watch(cmd *exec.Cmd, done chan struct{}) {
+
+	i.mutex.Lock()
+	policy := i.Restart
+	started := i.StartedAt
+	i.mutex.Unlock()
+
+	interval := time.Duration(policy.CheckInterval) * time.Second
+	if interval <= 0 || (policy.QueryTimeout == 0 && policy.LogSilence == 0) {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	grace := started.Add(time.Duration(policy.StartupGrace) * time.Second)
+	lastQuery := grace
+	lastLog := grace
+	var lastSize int64 = -1
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+
+			if now.Before(grace) {
+				continue
+			}
+
+			hang := ""
+
+			if policy.QueryTimeout > 0 {
+				if _, err := i.Query().Info(); err == nil {
+					lastQuery = now
+				} else if now.Sub(lastQuery) > time.Duration(policy.QueryTimeout)*time.Second {
+					hang = HANG_QUERY
+				}
+			}
+
+			if policy.LogSilence > 0 && hang == "" {
+				if fi, err := os.Stat(i.LogFile()); err == nil && fi.Size() != lastSize {
+					lastSize = fi.Size()
+					lastLog = now
+				} else if now.Sub(lastLog) > time.Duration(policy.LogSilence)*time.Second {
+					hang = HANG_LOG
+				}
+			}
+
+			if hang == "" {
+				continue
+			}
+
+			i.mutex.Lock()
+			if i.cmd != cmd {
+				i.mutex.Unlock()
+				return
+			}
+			i.hang = hang
+			i.mutex.Unlock()
+
+			i.log.Write(fmt.Sprintf("instance '%s' seems to be hung (%s), killing it", i.ID, hang), MODULE, admin_log.LOG_ERROR)
+			cmd.Process.Kill()
+			return
+		}
+	}
+}
+
+// exited is called with the mutex held after the process ended without
+// being asked to stop. It queues a crash report for failures and schedules
+// a restart when the policy allows it.
+func (i *Instance) exited(cmd *exec.Cmd, err error) {
+
+	policy := i.Restart
+	now := time.Now()
+	uptime := now.Sub(i.StartedAt)
+
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+
+	failed := err != nil || exitCode != 0 || i.hang != ""
+
+	// a run that lasted longer than the window resets the backoff
+	if uptime > time.Duration(policy.Window)*time.Second {
+		i.failures = 0
+	}
+
+	restart := policy.Policy == RESTART_ALWAYS || (policy.Policy == RESTART_ON_FAILURE && failed)
+
+	window := now.Add(-time.Duration(policy.Window) * time.Second)
+	recent := make([]time.Time, 0, len(i.restartTimes))
+	for _, t := range i.restartTimes {
+		if t.After(window) {
+			recent = append(recent, t)
+		}
+	}
+	i.restartTimes = recent
+
+	gaveUp := false
+	if restart && policy.MaxRestarts > 0 && len(i.restartTimes) >= policy.MaxRestarts {
+		restart = false
+		gaveUp = true
+		i.log.Write(fmt.Sprintf("instance '%s' restarted %d times in %ds, giving up", i.ID, len(i.restartTimes), policy.Window), MODULE, admin_log.LOG_ERROR)
+	}
+
+	var delay time.Duration
+	if restart {
+		i.failures++
+		delay = policy.delay(i.failures)
+	}
+
+	if failed {
+		report := CrashReport{
+			Time:     now,
+			Instance: i.ID,
+			ExitCode: exitCode,
+			Reason:   CRASH_EXIT,
+			Uptime:   int64(uptime.Seconds()),
+			Restart:  restart,
+			Delay:    int64(delay.Seconds()),
+			GaveUp:   gaveUp,
+		}
+		if i.hang != "" {
+			report.Reason = i.hang
+		}
+		if err != nil {
+			report.Error = err.Error()
+		}
+		i.crashes++
+		i.crashReports = append(i.crashReports, report)
+		i.queue(events.Event{
+			Type:     events.INSTANCE_CRASHED,
+			Level:    events.LEVEL_ERROR,
+			Instance: i.ID,
+			Title:    fmt.Sprintf("Instance %s crashed", i.Name),
+			Message:  fmt.Sprintf("instance '%s' exited with code %d after %s (%s)", i.ID, exitCode, uptime.Round(time.Second), report.Reason),
+			Fields: map[string]string{
+				"exitCode": fmt.Sprint(exitCode),
+				"reason":   report.Reason,
+				"restart":  fmt.Sprint(restart),
+				"delay":    delay.String(),
+				"gaveUp":   fmt.Sprint(gaveUp),
+			},
+		})
+	} else {
+		i.queue(events.Event{
+			Type:     events.INSTANCE_STOPPED,
+			Instance: i.ID,
+			Title:    fmt.Sprintf("Instance %s exited", i.Name),
+			Message:  fmt.Sprintf("instance '%s' exited on its own after %s", i.ID, uptime.Round(time.Second)),
+		})
+	}
+
+	if !restart {
+		return
+	}
+
+	i.log.Write(fmt.Sprintf("restarting instance '%s' in %s", i.ID, delay), MODULE, admin_log.LOG_INFO)
+	i.State = STATE_WAITING
+	i.restartTimer = time.AfterFunc(delay, func() {
+
+		i.mutex.Lock()
+		defer i.flush()
+		defer i.mutex.Unlock()
+
+		if i.State != STATE_WAITING {
+			return
+		}
+
+		i.restartTimer = nil
+		i.State = STATE_STOPPED
+		i.restartTimes = append(i.restartTimes, time.Now())
+		i.Restarts++
+		i.start()
+	})
+}
+
+// queue keeps an event while the mutex is held, it is sent by flush
+// once the mutex is released so handlers can call back into the instance.
+func (i *Instance) queue(event events.Event) {
+
+	i.outbox = append(i.outbox, event)
+}
+
+// flush writes the queued crash reports and sends the queued events. It is
+// called without the mutex, deferred before the one unlocking it.
+func (i *Instance) flush() {
+
+	i.mutex.Lock()
+	outbox, reports := i.outbox, i.crashReports
+	i.outbox, i.crashReports = nil, nil
+	lines := i.Restart.CrashLogLines
+	i.mutex.Unlock()
+
+	for _, report := range reports {
+		report.Log, _ = utils.TailLines(i.LogFile(), lines)
+		if err := i.saveCrash(report); err != nil {
+			i.log.Write(err.Error(), MODULE, admin_log.LOG_ERROR)
+		}
+	}
+
+	for _, event := range outbox {
+		i.events.Publish(event)
+	}
+}
+
+func (i *Instance) cancelRestart() {
+
+	if i.restartTimer != nil {
+		i.restartTimer.Stop()
+		i.restartTimer = nil
+	}
+}
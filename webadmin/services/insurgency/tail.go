@@ -0,0 +1,183 @@
+package insurgency
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	TAIL_INTERVAL      = 500 * time.Millisecond
+	TAIL_SAVE_INTERVAL = 30 * time.Second
+	TAIL_MAX_READ      = 1 << 20
+	SUBSCRIBER_BUFFER  = 256
+)
+
+// follower reads the lines appended to a server log. The offset is kept
+// in the runtime file so a web admin that reattaches to the server resumes
+// where the previous one stopped.
+type follower struct {
+	offset   int64
+	path     string
+	file     os.FileInfo
+	runtime  Runtime
+	publish  func(string)
+	save     func(Runtime)
+	done     chan struct{}
+	finished chan struct{}
+}
+
+// follow starts following the log. A new server keeps the lines that are
+// already in the log file out, a reattached one continues from offset.
+func (i *Instance) follow(runtime Runtime, fresh bool) {
+
+	f := new(follower)
+	f.path = i.LogFile()
+	f.runtime = runtime
+	f.publish = i.publish
+	f.save = i.saveRuntime
+	f.done = make(chan struct{})
+	f.finished = make(chan struct{})
+	f.offset = runtime.LogOffset
+	if fresh {
+		if fi, err := os.Stat(f.path); err == nil {
+			f.file = fi
+			f.offset = fi.Size()
+		}
+	}
+
+	i.tail = f
+	go f.run()
+}
+
+// unfollow stops following the log and returns the offset reached.
+func (i *Instance) unfollow() int64 {
+
+	if i.tail == nil {
+		return 0
+	}
+
+	f := i.tail
+	i.tail = nil
+	close(f.done)
+	<-f.finished
+
+	return atomic.LoadInt64(&f.offset)
+}
+
+func (f *follower) run() {
+
+	defer close(f.finished)
+
+	ticker := time.NewTicker(TAIL_INTERVAL)
+	defer ticker.Stop()
+
+	saved := atomic.LoadInt64(&f.offset)
+	lastSave := time.Now()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case now := <-ticker.C:
+			f.read()
+			if offset := atomic.LoadInt64(&f.offset); offset != saved && now.Sub(lastSave) >= TAIL_SAVE_INTERVAL {
+				f.runtime.LogOffset = offset
+				f.save(f.runtime)
+				saved = offset
+				lastSave = now
+			}
+		}
+	}
+}
+
+// read publishes the complete lines written since the last read. A log
+// that was replaced or truncated is read from its start.
+func (f *follower) read() {
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return
+	}
+
+	offset := atomic.LoadInt64(&f.offset)
+	if (f.file != nil && !os.SameFile(f.file, fi)) || fi.Size() < offset {
+		offset = 0
+	}
+	f.file = fi
+
+	if fi.Size() == offset {
+		atomic.StoreInt64(&f.offset, offset)
+		return
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	size := fi.Size() - offset
+	if size > TAIL_MAX_READ {
+		size = TAIL_MAX_READ
+	}
+	data := make([]byte, size)
+	n, err := file.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return
+	}
+	data = data[:n]
+
+	// a partial line is read again once it is complete, unless it is too
+	// long to ever fit
+	consumed := bytes.LastIndexByte(data, '\n') + 1
+	if consumed == 0 && n == TAIL_MAX_READ {
+		data = append(data, '\n')
+		consumed = n
+	}
+	if consumed == 0 {
+		atomic.StoreInt64(&f.offset, offset)
+		return
+	}
+
+	for _, line := range bytes.Split(data[:bytes.LastIndexByte(data, '\n')], []byte{'\n'}) {
+		f.publish(string(bytes.TrimRight(line, "\r")))
+	}
+	atomic.StoreInt64(&f.offset, offset+int64(consumed))
+}
+
+// Subscribe returns a channel receiving the new lines of the server log and
+// a function to stop receiving them. Lines are dropped for subscribers that
+// do not keep up.
+func (i *Instance) Subscribe() (<-chan string, func()) {
+
+	lines := make(chan string, SUBSCRIBER_BUFFER)
+
+	i.subscribersMutex.Lock()
+	if i.subscribers == nil {
+		i.subscribers = make(map[chan string]struct{})
+	}
+	i.subscribers[lines] = struct{}{}
+	i.subscribersMutex.Unlock()
+
+	return lines, func() {
+		i.subscribersMutex.Lock()
+		delete(i.subscribers, lines)
+		i.subscribersMutex.Unlock()
+	}
+}
+
+func (i *Instance) publish(line string) {
+
+	i.subscribersMutex.Lock()
+	defer i.subscribersMutex.Unlock()
+
+	for lines := range i.subscribers {
+		select {
+		case lines <- line:
+		default:
+		}
+	}
+}
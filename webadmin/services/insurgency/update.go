@@ -0,0 +1,79 @@
+package insurgency
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	STEAMAPPS_DIR = "steamapps"
+)
+
+var (
+	buildID = regexp.MustCompile(`"buildid"\s+"(\d+)"`)
+)
+
+// InstalledBuild returns the steam build id of the installed server files,
+// read from the app manifest steamcmd writes when it installs them.
+func (i *Insurgency) InstalledBuild() (string, error) {
+
+	path := filepath.Join(i.Dir, STEAMAPPS_DIR, fmt.Sprintf("appmanifest_%d.acf", GAMEID))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read app manifest '%s'. ERR: %s", path, err.Error())
+	}
+
+	match := buildID.FindSubmatch(data)
+	if match == nil {
+		return "", fmt.Errorf("no build id found in app manifest '%s'", path)
+	}
+
+	return string(match[1]), nil
+}
+
+// LatestBuild asks steam for the build id of the public branch.
+func (i *Insurgency) LatestBuild() (string, error) {
+
+	cmd := exec.Command(i.steamcmdPath, "+login", "anonymous", "+app_info_update", "1", "+app_info_print", fmt.Sprintf("%d", GAMEID), "+quit")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the app info of %d from steam. ERR: %s", GAMEID, err.Error())
+	}
+
+	info := string(output)
+	branches := strings.Index(info, `"branches"`)
+	if branches < 0 {
+		return "", fmt.Errorf("no branches found in the app info of %d", GAMEID)
+	}
+	public := strings.Index(info[branches:], `"public"`)
+	if public < 0 {
+		return "", fmt.Errorf("no public branch found in the app info of %d", GAMEID)
+	}
+
+	match := buildID.FindStringSubmatch(info[branches+public:])
+	if match == nil {
+		return "", fmt.Errorf("no build id found for the public branch of %d", GAMEID)
+	}
+
+	return match[1], nil
+}
+
+// CheckUpdate compares the installed build with the latest public one.
+func (i *Insurgency) CheckUpdate() (string, string, bool, error) {
+
+	installed, err := i.InstalledBuild()
+	if err != nil {
+		return "", "", false, err
+	}
+
+	latest, err := i.LatestBuild()
+	if err != nil {
+		return installed, "", false, err
+	}
+
+	return installed, latest, installed != latest, nil
+}
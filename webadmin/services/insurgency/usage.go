@@ -0,0 +1,133 @@
+package insurgency
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Usage is a sample of the resources used by a server process.
+type Usage struct {
+	Time     time.Time `json:"time"`
+	Pid      int       `json:"pid"`
+	CpuTime  float64   `json:"cpuTime"`
+	Rss      int64     `json:"rss"`
+	Fds      int       `json:"fds"`
+	Threads  int       `json:"threads"`
+	UdpPorts []int     `json:"udpPorts"`
+	TcpPorts []int     `json:"tcpPorts"`
+}
+
+const (
+	// CLOCK_TICKS is USER_HZ, the unit of the times in /proc/<pid>/stat
+	CLOCK_TICKS = 100
+)
+
+// Pid returns the pid of the server process or 0 when it is not running.
+func (i *Instance) Pid() int {
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.cmd == nil || i.cmd.Process == nil {
+		return 0
+	}
+
+	return i.cmd.Process.Pid
+}
+
+// Usage samples the resources used by the server process from /proc.
+func (i *Instance) Usage() (*Usage, error) {
+
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("resource usage is only supported on linux")
+	}
+
+	pid := i.Pid()
+	if pid == 0 {
+		return nil, fmt.Errorf("instance '%s' is not running", i.ID)
+	}
+
+	dir := filepath.Join(PROC_DIR, strconv.Itoa(pid))
+	u := &Usage{Time: time.Now(), Pid: pid}
+
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the status of process %d. ERR: %s", pid, err.Error())
+	}
+	// the process name is in parentheses and may contain spaces
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	if len(fields) < 18 {
+		return nil, fmt.Errorf("unexpected status of process %d", pid)
+	}
+	utime, _ := strconv.ParseFloat(fields[11], 64)
+	stime, _ := strconv.ParseFloat(fields[12], 64)
+	u.CpuTime = (utime + stime) / CLOCK_TICKS
+	u.Threads, _ = strconv.Atoi(fields[17])
+
+	u.Rss, err = statusValue(filepath.Join(dir, "status"), "VmRSS")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the memory of process %d. ERR: %s", pid, err.Error())
+	}
+
+	fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the file descriptors of process %d. ERR: %s", pid, err.Error())
+	}
+	u.Fds = len(fds)
+
+	udp, tcp, err := processPorts(pid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the ports of process %d. ERR: %s", pid, err.Error())
+	}
+	u.UdpPorts = sortedPorts(udp)
+	u.TcpPorts = sortedPorts(tcp)
+
+	return u, nil
+}
+
+// statusValue reads a size in kB from /proc/<pid>/status, in bytes.
+func statusValue(path string, name string) (int64, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || key != name {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			break
+		}
+		kb, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return kb * 1024, nil
+	}
+
+	return 0, scanner.Err()
+}
+
+func sortedPorts(ports map[int]bool) []int {
+
+	list := make([]int, 0, len(ports))
+	for port := range ports {
+		list = append(list, port)
+	}
+	sort.Ints(list)
+
+	return list
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Labels map[string]string
+
+// Writer writes metrics in the Prometheus text exposition format.
+type Writer struct {
+	buffer bytes.Buffer
+}
+
+const (
+	CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+	COUNTER   = "counter"
+	GAUGE     = "gauge"
+	HISTOGRAM = "histogram"
+)
+
+// Metric starts a metric family, its samples must follow.
+func (w *Writer) Metric(name string, kind string, help string) {
+
+	fmt.Fprintf(&w.buffer, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(&w.buffer, "# TYPE %s %s\n", name, kind)
+}
+
+func (w *Writer) Sample(name string, labels Labels, value float64) {
+
+	w.buffer.WriteString(name)
+	if len(labels) > 0 {
+		names := make([]string, 0, len(labels))
+		for label := range labels {
+			names = append(names, label)
+		}
+		sort.Strings(names)
+
+		w.buffer.WriteByte('{')
+		for n, label := range names {
+			if n > 0 {
+				w.buffer.WriteByte(',')
+			}
+			fmt.Fprintf(&w.buffer, `%s="%s"`, label, escape(labels[label]))
+		}
+		w.buffer.WriteByte('}')
+	}
+	w.buffer.WriteByte(' ')
+	w.buffer.WriteString(format(value))
+	w.buffer.WriteByte('\n')
+}
+
+func (w *Writer) Bytes() []byte {
+
+	return w.buffer.Bytes()
+}
+
+// Bool is 1 for true and 0 for false.
+func Bool(value bool) float64 {
+
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+func escape(value string) string {
+
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func format(value float64) string {
+
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
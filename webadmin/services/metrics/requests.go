@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Requests counts the web admin HTTP requests and their latencies.
+type Requests struct {
+	counts     map[requestKey]uint64
+	histograms map[routeKey]*histogram
+	mutex      sync.Mutex
+}
+
+type requestKey struct {
+	method string
+	route  string
+	status int
+}
+
+type routeKey struct {
+	method string
+	route  string
+}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+var (
+	// Buckets are the upper bounds of the request latency histogram, in
+	// seconds.
+	Buckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+func NewRequests() *Requests {
+
+	r := new(Requests)
+	r.counts = make(map[requestKey]uint64)
+	r.histograms = make(map[routeKey]*histogram)
+
+	return r
+}
+
+// Observe records a request. The route is the registered path, not the
+// requested one, so the number of series stays bounded.
+func (r *Requests) Observe(method string, route string, status int, duration time.Duration) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.counts[requestKey{method, route, status}]++
+
+	key := routeKey{method, route}
+	h, ok := r.histograms[key]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(Buckets))}
+		r.histograms[key] = h
+	}
+
+	seconds := duration.Seconds()
+	for n, bound := range Buckets {
+		if seconds <= bound {
+			h.buckets[n]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (r *Requests) Write(w *Writer) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	counts := make([]requestKey, 0, len(r.counts))
+	for key := range r.counts {
+		counts = append(counts, key)
+	}
+	sort.Slice(counts, func(a, b int) bool {
+		if counts[a].route != counts[b].route {
+			return counts[a].route < counts[b].route
+		}
+		if counts[a].method != counts[b].method {
+			return counts[a].method < counts[b].method
+		}
+		return counts[a].status < counts[b].status
+	})
+
+	w.Metric("webadmin_http_requests_total", COUNTER, "HTTP requests handled by the web admin.")
+	for _, key := range counts {
+		w.Sample("webadmin_http_requests_total", Labels{"method": key.method, "route": key.route, "status": strconv.Itoa(key.status)}, float64(r.counts[key]))
+	}
+
+	routes := make([]routeKey, 0, len(r.histograms))
+	for key := range r.histograms {
+		routes = append(routes, key)
+	}
+	sort.Slice(routes, func(a, b int) bool {
+		if routes[a].route != routes[b].route {
+			return routes[a].route < routes[b].route
+		}
+		return routes[a].method < routes[b].method
+	})
+
+	w.Metric("webadmin_http_request_duration_seconds", HISTOGRAM, "Latency of the HTTP requests handled by the web admin.")
+	for _, key := range routes {
+		h := r.histograms[key]
+		for n, bound := range Buckets {
+			w.Sample("webadmin_http_request_duration_seconds_bucket", Labels{"method": key.method, "route": key.route, "le": format(bound)}, float64(h.buckets[n]))
+		}
+		w.Sample("webadmin_http_request_duration_seconds_bucket", Labels{"method": key.method, "route": key.route, "le": format(math.Inf(1))}, float64(h.count))
+		w.Sample("webadmin_http_request_duration_seconds_sum", Labels{"method": key.method, "route": key.route}, h.sum)
+		w.Sample("webadmin_http_request_duration_seconds_count", Labels{"method": key.method, "route": key.route}, float64(h.count))
+	}
+}
@@ -0,0 +1,99 @@
+package monitor
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+)
+
+// Disk is the usage of the file system holding one of the directories the
+// web admin installs into.
+type Disk struct {
+	Path    string  `json:"path"`
+	Total   uint64  `json:"total"`
+	Free    uint64  `json:"free"`
+	Used    uint64  `json:"used"`
+	UsedPct float64 `json:"usedPct"`
+	Low     bool    `json:"low"`
+	Error   string  `json:"error,omitempty"`
+}
+
+const (
+	// DISK_LOW is the free space under which game updates may fail
+	DISK_LOW = 10 << 30
+)
+
+// Disks returns the usage of the disks of the game and steamcmd
+// directories.
+func (m *Monitor) Disks() []Disk {
+
+	disks := make([]Disk, 0, len(m.disks))
+	for _, dir := range m.disks {
+		path, err := filepath.Abs(dir)
+		if err != nil {
+			path = dir
+		}
+		disk := Disk{Path: path}
+		total, free, err := diskUsage(existing(path))
+		if err != nil {
+			disk.Error = err.Error()
+		} else {
+			disk.Total = total
+			disk.Free = free
+			disk.Used = total - free
+			if total > 0 {
+				disk.UsedPct = float64(disk.Used) / float64(total) * 100
+			}
+			disk.Low = free < DISK_LOW
+		}
+		disks = append(disks, disk)
+	}
+
+	return disks
+}
+
+// checkDisks warns once every time a disk goes under DISK_LOW.
+func (m *Monitor) checkDisks() {
+
+	for _, disk := range m.Disks() {
+		if disk.Error != "" {
+			continue
+		}
+		m.mutex.Lock()
+		warned := m.low[disk.Path]
+		m.low[disk.Path] = disk.Low
+		m.mutex.Unlock()
+		if disk.Low && !warned {
+			m.log.Write(fmt.Sprintf("only %d MiB free on the disk of '%s', game updates may fail", disk.Free>>20, disk.Path), MODULE, admin_log.LOG_WARNING)
+			m.Events.Publish(events.Event{
+				Type:    events.DISK_LOW,
+				Level:   events.LEVEL_WARNING,
+				Title:   "Disk space low",
+				Message: fmt.Sprintf("only %d MiB free on the disk of '%s', game updates may fail", disk.Free>>20, disk.Path),
+				Fields: map[string]string{
+					"path":    disk.Path,
+					"free":    fmt.Sprintf("%d MiB", disk.Free>>20),
+					"usedPct": fmt.Sprintf("%.1f", disk.UsedPct),
+				},
+			})
+		}
+	}
+}
+
+// existing returns the closest existing parent, the game may not be
+// installed yet.
+func existing(path string) string {
+
+	for {
+		if _, _, err := diskUsage(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
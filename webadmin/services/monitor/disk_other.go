@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd
+
+package monitor
+
+import (
+	"fmt"
+)
+
+func diskUsage(path string) (uint64, uint64, error) {
+
+	return 0, 0, fmt.Errorf("disk usage is not supported on this system")
+}
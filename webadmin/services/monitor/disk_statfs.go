@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package monitor
+
+import (
+	"syscall"
+)
+
+func diskUsage(path string) (uint64, uint64, error) {
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	return uint64(stat.Blocks) * uint64(stat.Bsize), uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,202 @@
+package monitor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+)
+
+// Sample is the resource usage of an instance at one point in time. Cpu is
+// the percentage of one core used since the previous sample. The players
+// and the query latency, in seconds, come from an A2S_INFO query.
+type Sample struct {
+	Time         time.Time `json:"time"`
+	Pid          int       `json:"pid"`
+	Cpu          float64   `json:"cpu"`
+	Rss          int64     `json:"rss"`
+	Fds          int       `json:"fds"`
+	Threads      int       `json:"threads"`
+	UdpPorts     []int     `json:"udpPorts"`
+	TcpPorts     []int     `json:"tcpPorts"`
+	QueryUp      bool      `json:"queryUp"`
+	QueryLatency float64   `json:"queryLatency"`
+	Players      int       `json:"players"`
+	MaxPlayers   int       `json:"maxPlayers"`
+}
+
+// Metrics is the recent resource usage of an instance, oldest first.
+type Metrics struct {
+	Instance string   `json:"instance"`
+	Running  bool     `json:"running"`
+	Interval int      `json:"interval"`
+	Current  *Sample  `json:"current"`
+	History  []Sample `json:"history"`
+}
+
+type Monitor struct {
+	Interval  time.Duration
+	History   int
+	Events    *events.Bus
+	instances *insurgency.Instances
+	disks     []string
+	low       map[string]bool
+	samples   map[string][]Sample
+	cpuTimes  map[string]insurgency.Usage
+	log       *admin_log.Log
+	done      chan struct{}
+	mutex     sync.RWMutex
+}
+
+const (
+	MODULE = "monitor"
+
+	MONITOR_INTERVAL = 10 * time.Second
+	MONITOR_HISTORY  = 360
+)
+
+func New(conf *config.Configuration, log *admin_log.Log, instances *insurgency.Instances) *Monitor {
+
+	m := new(Monitor)
+	m.Interval = MONITOR_INTERVAL
+	m.History = MONITOR_HISTORY
+	m.instances = instances
+	m.disks = []string{conf.Sandstorm.Dir, conf.Steam.Dir}
+	m.low = make(map[string]bool)
+	m.samples = make(map[string][]Sample)
+	m.cpuTimes = make(map[string]insurgency.Usage)
+	m.log = log
+
+	return m
+}
+
+func (m *Monitor) Start() {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.done != nil {
+		return
+	}
+
+	m.done = make(chan struct{})
+	go m.loop(m.done)
+}
+
+func (m *Monitor) Stop() {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.done != nil {
+		close(m.done)
+		m.done = nil
+	}
+}
+
+func (m *Monitor) loop(done chan struct{}) {
+
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	m.sample()
+	m.checkDisks()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			m.sample()
+			m.checkDisks()
+		}
+	}
+}
+
+// sample records the usage of every running instance.
+func (m *Monitor) sample() {
+
+	for _, instance := range m.instances.List() {
+		if !instance.IsRunning() {
+			continue
+		}
+
+		usage, err := instance.Usage()
+		if err != nil {
+			m.log.Write(fmt.Sprintf("failed to sample instance '%s'. ERR: %s", instance.ID, err.Error()), MODULE, admin_log.LOG_DEBUG)
+			continue
+		}
+
+		sample := Sample{
+			Time:     usage.Time,
+			Pid:      usage.Pid,
+			Rss:      usage.Rss,
+			Fds:      usage.Fds,
+			Threads:  usage.Threads,
+			UdpPorts: usage.UdpPorts,
+			TcpPorts: usage.TcpPorts,
+		}
+
+		start := time.Now()
+		if info, err := instance.Query().Info(); err == nil {
+			sample.QueryUp = true
+			sample.QueryLatency = time.Since(start).Seconds()
+			sample.Players = int(info.Players)
+			sample.MaxPlayers = int(info.MaxPlayers)
+		}
+
+		m.mutex.Lock()
+		if previous, ok := m.cpuTimes[instance.ID]; ok && previous.Pid == usage.Pid {
+			if elapsed := usage.Time.Sub(previous.Time).Seconds(); elapsed > 0 {
+				sample.Cpu = (usage.CpuTime - previous.CpuTime) / elapsed * 100
+			}
+		}
+		m.cpuTimes[instance.ID] = *usage
+
+		samples := append(m.samples[instance.ID], sample)
+		if len(samples) > m.History {
+			samples = samples[len(samples)-m.History:]
+		}
+		m.samples[instance.ID] = samples
+		m.mutex.Unlock()
+	}
+}
+
+// Latest returns the last sample of a running instance.
+func (m *Monitor) Latest(instance *insurgency.Instance) *Sample {
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	samples := m.samples[instance.ID]
+	if len(samples) == 0 || !instance.IsRunning() || samples[len(samples)-1].Pid != instance.Pid() {
+		return nil
+	}
+	sample := samples[len(samples)-1]
+
+	return &sample
+}
+
+// Metrics returns the usage history of an instance.
+func (m *Monitor) Metrics(instance *insurgency.Instance) Metrics {
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	metrics := Metrics{
+		Instance: instance.ID,
+		Running:  instance.IsRunning(),
+		Interval: int(m.Interval.Seconds()),
+		History:  append([]Sample{}, m.samples[instance.ID]...),
+	}
+	if n := len(metrics.History); n > 0 && metrics.Running && metrics.History[n-1].Pid == instance.Pid() {
+		current := metrics.History[n-1]
+		metrics.Current = &current
+	}
+
+	return metrics
+}
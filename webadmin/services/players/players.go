@@ -0,0 +1,676 @@
+package players
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+)
+
+// Stats are the totals of the closed sessions of a player. Playtime is in
+// seconds.
+type Stats struct {
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Playtime  int64     `json:"playtime"`
+	Sessions  int       `json:"sessions"`
+	Kills     int       `json:"kills"`
+	Deaths    int       `json:"deaths"`
+	TeamKills int       `json:"teamKills"`
+}
+
+// Seen is a name or an address a player used and when.
+type Seen struct {
+	Value     string    `json:"value"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// Player is everything known about a player on every instance. Online
+// lists the instances the player is connected to.
+type Player struct {
+	SteamID   string           `json:"steamId"`
+	Name      string           `json:"name"`
+	Names     []Seen           `json:"names"`
+	Addresses []Seen           `json:"addresses,omitempty"`
+	Online    []string         `json:"online,omitempty"`
+	Total     Stats            `json:"total"`
+	Instances map[string]Stats `json:"instances"`
+}
+
+// Session is the time a player spent on an instance, from joining to
+// leaving. The end of an open session is when the player was last seen.
+type Session struct {
+	ID        string    `json:"id"`
+	SteamID   string    `json:"steamId"`
+	Instance  string    `json:"instance"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address,omitempty"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Open      bool      `json:"open"`
+	Kills     int       `json:"kills"`
+	Deaths    int       `json:"deaths"`
+	TeamKills int       `json:"teamKills"`
+}
+
+// Players keeps the history of the players of every instance, built from
+// the events of the server logs and checked against the RCON player list.
+// The changes made by the events are written together with the open
+// sessions on every poll, not one transaction per log line.
+type Players struct {
+	Interval        time.Duration
+	RecordAddresses bool
+	Retention       int
+	instances       *insurgency.Instances
+	open            map[string]map[string]*Session
+	followed        map[string]chan struct{}
+	pending         []func(tx *store.Tx) error
+	pruned          time.Time
+	db              *store.Store
+	log             *admin_log.Log
+	done            chan struct{}
+	mutex           sync.Mutex
+}
+
+const (
+	MODULE = "players"
+
+	POLL_INTERVAL  = 30 * time.Second
+	PRUNE_INTERVAL = 24 * time.Hour
+	MAX_NAMES      = 20
+	MAX_ADDRESSES  = 20
+)
+
+func New(conf *config.Configuration, log *admin_log.Log, instances *insurgency.Instances, db *store.Store) *Players {
+
+	p := new(Players)
+	p.Interval = POLL_INTERVAL
+	p.RecordAddresses = conf.Players.RecordAddresses
+	p.Retention = conf.Players.Retention
+	p.instances = instances
+	p.open = make(map[string]map[string]*Session)
+	p.followed = make(map[string]chan struct{})
+	p.db = db
+	p.log = log
+
+	return p
+}
+
+// Load closes the sessions left open by a web admin that did not stop
+// cleanly, at the time their player was last seen. The addresses recorded
+// earlier are removed when recording them is disabled.
+func (p *Players) Load() error {
+
+	closed := 0
+	err := p.db.Update(func(tx *store.Tx) error {
+		return tx.ForEach(store.BUCKET_SESSIONS, func(key string, value []byte) error {
+			session := new(Session)
+			if err := json.Unmarshal(value, session); err != nil {
+				return fmt.Errorf("failed to parse player session '%s'. ERR: %s", key, err.Error())
+			}
+			if !session.Open {
+				return nil
+			}
+			session.Open = false
+			if err := p.fold(tx, session); err != nil {
+				return err
+			}
+			closed++
+			return tx.Put(store.BUCKET_SESSIONS, key, session)
+		})
+	})
+	if err != nil {
+		return p.log.Write(fmt.Sprintf("failed to load the player sessions. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	if closed > 0 {
+		p.log.Write(fmt.Sprintf("closed %d player session(s) left open", closed), MODULE, admin_log.LOG_INFO)
+	}
+
+	if !p.RecordAddresses {
+		return p.forget()
+	}
+
+	return nil
+}
+
+// SetOptions changes what the history keeps. The addresses recorded so far
+// are removed when recording them is disabled.
+func (p *Players) SetOptions(recordAddresses bool, retention int) error {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	disabled := p.RecordAddresses && !recordAddresses
+	p.RecordAddresses = recordAddresses
+	p.Retention = retention
+	if !disabled {
+		return nil
+	}
+
+	for _, sessions := range p.open {
+		for _, session := range sessions {
+			session.Address = ""
+		}
+	}
+	p.save()
+
+	return p.forget()
+}
+
+// forget removes the recorded addresses from the sessions and the player
+// records.
+func (p *Players) forget() error {
+
+	forgotten := 0
+	err := p.db.Update(func(tx *store.Tx) error {
+		err := tx.ForEach(store.BUCKET_SESSIONS, func(key string, value []byte) error {
+			session := new(Session)
+			if err := json.Unmarshal(value, session); err != nil {
+				return fmt.Errorf("failed to parse player session '%s'. ERR: %s", key, err.Error())
+			}
+			if session.Address == "" {
+				return nil
+			}
+			session.Address = ""
+			forgotten++
+			return tx.Put(store.BUCKET_SESSIONS, key, session)
+		})
+		if err != nil {
+			return err
+		}
+		return tx.ForEach(store.BUCKET_PLAYERS, func(key string, value []byte) error {
+			player := new(Player)
+			if err := json.Unmarshal(value, player); err != nil {
+				return fmt.Errorf("failed to parse player '%s'. ERR: %s", key, err.Error())
+			}
+			if len(player.Addresses) == 0 {
+				return nil
+			}
+			player.Addresses = nil
+			forgotten++
+			return tx.Put(store.BUCKET_PLAYERS, key, player)
+		})
+	})
+	if err != nil {
+		return p.log.Write(fmt.Sprintf("failed to remove the player addresses. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	if forgotten > 0 {
+		p.log.Write(fmt.Sprintf("removed the addresses of %d player record(s), recording them is disabled", forgotten), MODULE, admin_log.LOG_INFO)
+	}
+
+	return nil
+}
+
+func (p *Players) Start() {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.done != nil {
+		return
+	}
+
+	p.done = make(chan struct{})
+	go p.loop(p.done)
+}
+
+// Stop stops following the instances and closes the open sessions, the
+// players still connected are found again on the next start.
+func (p *Players) Stop() {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.done == nil {
+		return
+	}
+	close(p.done)
+	p.done = nil
+
+	for id, stop := range p.followed {
+		close(stop)
+		delete(p.followed, id)
+	}
+
+	now := time.Now().UTC()
+	for _, sessions := range p.open {
+		for _, session := range sessions {
+			p.close(session, now)
+		}
+	}
+	p.save()
+}
+
+func (p *Players) loop(done chan struct{}) {
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	p.tick()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			p.tick()
+		}
+	}
+}
+
+func (p *Players) tick() {
+
+	p.follow()
+	p.poll()
+	p.flush()
+
+	p.mutex.Lock()
+	retention := p.Retention
+	p.mutex.Unlock()
+
+	if retention > 0 && time.Since(p.pruned) >= PRUNE_INTERVAL {
+		p.prune(retention)
+		p.pruned = time.Now()
+	}
+}
+
+// follow reads the log of the instances not followed yet and stops
+// following the removed ones.
+func (p *Players) follow() {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.done == nil {
+		return
+	}
+
+	ids := make(map[string]bool)
+	for _, instance := range p.instances.List() {
+		ids[instance.ID] = true
+		if _, ok := p.followed[instance.ID]; ok {
+			continue
+		}
+		stop := make(chan struct{})
+		p.followed[instance.ID] = stop
+		go p.read(instance, stop)
+	}
+
+	now := time.Now().UTC()
+	for id, stop := range p.followed {
+		if ids[id] {
+			continue
+		}
+		close(stop)
+		delete(p.followed, id)
+		for _, session := range p.open[id] {
+			p.close(session, now)
+		}
+	}
+}
+
+func (p *Players) read(instance *insurgency.Instance, stop chan struct{}) {
+
+	lines, cancel := instance.Subscribe()
+	defer cancel()
+
+	parser := insurgency.NewLogParser()
+	for {
+		select {
+		case <-stop:
+			return
+		case line := <-lines:
+			if event, ok := parser.Parse(line); ok {
+				p.handle(instance.ID, event)
+			}
+		}
+	}
+}
+
+// handle updates the sessions with an event of the log of an instance.
+// Players that joined before the web admin followed the log get their
+// session from their first kill or from the next poll.
+func (p *Players) handle(instance string, event insurgency.LogEvent) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.done == nil {
+		return
+	}
+
+	switch event.Type {
+	case insurgency.LOG_PLAYER_JOINED:
+		p.join(instance, event.SteamID, event.Name, "", event.Time)
+	case insurgency.LOG_PLAYER_LEFT:
+		session := p.open[instance][event.SteamID]
+		if session == nil {
+			return
+		}
+		if p.RecordAddresses && event.Address != "" {
+			session.Address = event.Address
+		}
+		p.close(session, event.Time)
+	case insurgency.LOG_PLAYER_KILLED:
+		var killer, victim *Session
+		if !insurgency.IsBot(event.SteamID) {
+			killer = p.join(instance, event.SteamID, event.Name, "", event.Time)
+		}
+		if !insurgency.IsBot(event.VictimID) {
+			victim = p.join(instance, event.VictimID, event.Victim, "", event.Time)
+		}
+		if victim != nil {
+			victim.Deaths++
+		}
+		if killer == nil || event.SteamID == event.VictimID {
+			return
+		}
+		if event.Team == event.VictimTeam {
+			killer.TeamKills++
+		} else {
+			killer.Kills++
+		}
+	}
+}
+
+// poll checks the sessions against the players listed by RCON, which also
+// knows their addresses, and closes the sessions of stopped instances.
+func (p *Players) poll() {
+
+	for _, instance := range p.instances.List() {
+
+		var listed []insurgency.Player
+		if instance.IsRunning() {
+			var err error
+			if listed, err = instance.Players(); err != nil {
+				p.log.Write(fmt.Sprintf("failed to list the players of instance '%s'. ERR: %s", instance.ID, err.Error()), MODULE, admin_log.LOG_DEBUG)
+				continue
+			}
+		}
+
+		now := time.Now().UTC()
+		p.mutex.Lock()
+		if p.done == nil {
+			p.mutex.Unlock()
+			return
+		}
+		connected := make(map[string]bool)
+		for _, player := range listed {
+			connected[player.SteamID] = true
+			address := ""
+			if p.RecordAddresses {
+				address = player.IP
+			}
+			p.join(instance.ID, player.SteamID, player.Name, address, now)
+		}
+		for steamID, session := range p.open[instance.ID] {
+			if !connected[steamID] {
+				p.close(session, now)
+			}
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// flush saves the pending changes and the open sessions with the time
+// their player was last seen.
+func (p *Players) flush() {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now().UTC()
+	err := p.db.Update(func(tx *store.Tx) error {
+		if err := p.write(tx); err != nil {
+			return err
+		}
+		for _, sessions := range p.open {
+			for _, session := range sessions {
+				session.End = now
+				if err := tx.Put(store.BUCKET_SESSIONS, session.ID, session); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		p.log.Write(fmt.Sprintf("failed to save the player sessions. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+}
+
+// queue adds a change to the next write, called with the mutex held.
+func (p *Players) queue(fn func(tx *store.Tx) error) {
+
+	p.pending = append(p.pending, fn)
+}
+
+// save writes the pending changes, called with the mutex held.
+func (p *Players) save() {
+
+	if len(p.pending) == 0 {
+		return
+	}
+
+	if err := p.db.Update(p.write); err != nil {
+		p.log.Write(fmt.Sprintf("failed to save the player sessions. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+}
+
+// write applies the pending changes to a transaction. They are dropped
+// once applied, a failed transaction does not retry them forever.
+func (p *Players) write(tx *store.Tx) error {
+
+	pending := p.pending
+	p.pending = nil
+	for _, fn := range pending {
+		if err := fn(tx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prune removes the sessions that ended before the retention, the player
+// totals keep counting them.
+func (p *Players) prune(retention int) {
+
+	limit := time.Now().AddDate(0, 0, -retention)
+	removed := 0
+	err := p.db.Update(func(tx *store.Tx) error {
+		return tx.ForEach(store.BUCKET_SESSIONS, func(key string, value []byte) error {
+			session := new(Session)
+			if err := json.Unmarshal(value, session); err != nil {
+				return fmt.Errorf("failed to parse player session '%s'. ERR: %s", key, err.Error())
+			}
+			if session.Open || !session.End.Before(limit) {
+				return nil
+			}
+			removed++
+			return tx.Delete(store.BUCKET_SESSIONS, key)
+		})
+	})
+	if err != nil {
+		p.log.Write(fmt.Sprintf("failed to remove old player sessions. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+		return
+	}
+
+	if removed > 0 {
+		p.log.Write(fmt.Sprintf("removed %d player session(s) older than %d days", removed, retention), MODULE, admin_log.LOG_INFO)
+	}
+}
+
+// join returns the open session of a player, opening one when the player
+// has none, called with the mutex held.
+func (p *Players) join(instance string, steamID string, name string, address string, t time.Time) *Session {
+
+	if session := p.open[instance][steamID]; session != nil {
+		if name != "" {
+			session.Name = name
+		}
+		if address != "" {
+			session.Address = address
+		}
+		return session
+	}
+
+	session := &Session{
+		ID:       store.RunKey(t, fmt.Sprintf("%s_%s", steamID, instance)),
+		SteamID:  steamID,
+		Instance: instance,
+		Name:     name,
+		Address:  address,
+		Start:    t,
+		End:      t,
+		Open:     true,
+	}
+	if p.open[instance] == nil {
+		p.open[instance] = make(map[string]*Session)
+	}
+	p.open[instance][steamID] = session
+
+	saved := *session
+	p.queue(func(tx *store.Tx) error {
+		if err := tx.Put(store.BUCKET_SESSIONS, saved.ID, &saved); err != nil {
+			return err
+		}
+		player, err := p.player(tx, saved.SteamID)
+		if err != nil {
+			return err
+		}
+		p.touch(player, &saved)
+		return tx.Put(store.BUCKET_PLAYERS, saved.SteamID, player)
+	})
+
+	return session
+}
+
+// close ends a session and adds it to the totals of its player, called
+// with the mutex held.
+func (p *Players) close(session *Session, t time.Time) {
+
+	delete(p.open[session.Instance], session.SteamID)
+
+	session.Open = false
+	session.End = t
+	if session.End.Before(session.Start) {
+		session.End = session.Start
+	}
+
+	saved := *session
+	p.queue(func(tx *store.Tx) error {
+		if err := tx.Put(store.BUCKET_SESSIONS, saved.ID, &saved); err != nil {
+			return err
+		}
+		return p.fold(tx, &saved)
+	})
+}
+
+// fold adds a closed session to the totals of its player.
+func (p *Players) fold(tx *store.Tx, session *Session) error {
+
+	player, err := p.player(tx, session.SteamID)
+	if err != nil {
+		return err
+	}
+
+	p.touch(player, session)
+	player.Total.add(session)
+	stats := player.Instances[session.Instance]
+	stats.add(session)
+	player.Instances[session.Instance] = stats
+
+	return tx.Put(store.BUCKET_PLAYERS, player.SteamID, player)
+}
+
+// player reads the record of a player, a new one when there is none.
+func (p *Players) player(tx *store.Tx, steamID string) (*Player, error) {
+
+	player := new(Player)
+	if _, err := tx.Get(store.BUCKET_PLAYERS, steamID, player); err != nil {
+		return nil, err
+	}
+	player.SteamID = steamID
+	if player.Instances == nil {
+		player.Instances = make(map[string]Stats)
+	}
+
+	return player, nil
+}
+
+// touch records the name and address of a session and when the player
+// was first and last seen.
+func (p *Players) touch(player *Player, session *Session) {
+
+	if session.Name != "" {
+		player.Name = session.Name
+		player.Names = seen(player.Names, session.Name, session.Start, session.End, MAX_NAMES)
+	}
+	if p.RecordAddresses && session.Address != "" {
+		player.Addresses = seen(player.Addresses, session.Address, session.Start, session.End, MAX_ADDRESSES)
+	}
+
+	player.Total.seen(session)
+	stats := player.Instances[session.Instance]
+	stats.seen(session)
+	player.Instances[session.Instance] = stats
+}
+
+func (s *Stats) seen(session *Session) {
+
+	if s.FirstSeen.IsZero() || session.Start.Before(s.FirstSeen) {
+		s.FirstSeen = session.Start
+	}
+	if session.End.After(s.LastSeen) {
+		s.LastSeen = session.End
+	}
+}
+
+func (s *Stats) add(session *Session) {
+
+	s.seen(session)
+	s.Playtime += int64(session.End.Sub(session.Start) / time.Second)
+	s.Sessions++
+	s.Kills += session.Kills
+	s.Deaths += session.Deaths
+	s.TeamKills += session.TeamKills
+}
+
+// seen records a value in a list of names or addresses, keeping the most
+// recently seen ones.
+func seen(list []Seen, value string, first time.Time, last time.Time, max int) []Seen {
+
+	found := false
+	for n := range list {
+		if list[n].Value != value {
+			continue
+		}
+		if first.Before(list[n].FirstSeen) {
+			list[n].FirstSeen = first
+		}
+		if last.After(list[n].LastSeen) {
+			list[n].LastSeen = last
+		}
+		found = true
+	}
+	if !found {
+		list = append(list, Seen{Value: value, FirstSeen: first, LastSeen: last})
+	}
+
+	sort.Slice(list, func(a, b int) bool { return list[a].LastSeen.After(list[b].LastSeen) })
+	if len(list) > max {
+		list = list[:max]
+	}
+
+	return list
+}
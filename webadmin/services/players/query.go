@@ -0,0 +1,372 @@
+package players
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+)
+
+// Filter selects players by SteamID or a part of any name they used, and
+// by the instance they played on.
+type Filter struct {
+	Query    string
+	Instance string
+	Offset   int
+	Limit    int
+}
+
+// Profile is a player with their sessions, newest first.
+type Profile struct {
+	Player
+	Sessions      []Session `json:"sessions"`
+	TotalSessions int       `json:"totalSessions"`
+}
+
+// Board selects the sessions a leaderboard counts: of one instance or of
+// all, that overlap the time window. Without a window the totals of every
+// session ever played are used.
+type Board struct {
+	Instance string
+	Since    time.Time
+	Until    time.Time
+	By       string
+	Limit    int
+}
+
+// Rank is the position of a player in a leaderboard. Ratio is the kills
+// per death.
+type Rank struct {
+	Position  int     `json:"position"`
+	SteamID   string  `json:"steamId"`
+	Name      string  `json:"name"`
+	Playtime  int64   `json:"playtime"`
+	Sessions  int     `json:"sessions"`
+	Kills     int     `json:"kills"`
+	Deaths    int     `json:"deaths"`
+	TeamKills int     `json:"teamKills"`
+	Ratio     float64 `json:"ratio"`
+}
+
+const (
+	BY_KILLS    = "kills"
+	BY_RATIO    = "kd"
+	BY_PLAYTIME = "playtime"
+	BY_SESSIONS = "sessions"
+)
+
+var (
+	Orders = []string{BY_KILLS, BY_RATIO, BY_PLAYTIME, BY_SESSIONS}
+)
+
+// Search returns the players matching the filter, the most recently seen
+// first, with the number of matches.
+func (p *Players) Search(filter Filter) ([]Player, int, error) {
+
+	p.mutex.Lock()
+	p.save()
+	p.mutex.Unlock()
+
+	query := strings.ToLower(strings.TrimSpace(filter.Query))
+
+	matches := make([]Player, 0)
+	err := p.db.View(func(tx *store.Tx) error {
+		return tx.ForEach(store.BUCKET_PLAYERS, func(key string, value []byte) error {
+			player := new(Player)
+			if err := json.Unmarshal(value, player); err != nil {
+				return fmt.Errorf("failed to parse player '%s'. ERR: %s", key, err.Error())
+			}
+			if filter.Instance != "" {
+				if _, ok := player.Instances[filter.Instance]; !ok {
+					return nil
+				}
+			}
+			if query != "" && !player.matches(query) {
+				return nil
+			}
+			matches = append(matches, *player)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	p.mutex.Lock()
+	for n := range matches {
+		p.current(&matches[n])
+	}
+	p.mutex.Unlock()
+
+	sort.Slice(matches, func(a, b int) bool { return matches[a].Total.LastSeen.After(matches[b].Total.LastSeen) })
+
+	total := len(matches)
+	if filter.Offset >= total {
+		return []Player{}, total, nil
+	}
+	matches = matches[filter.Offset:]
+	if filter.Limit > 0 && len(matches) > filter.Limit {
+		matches = matches[:filter.Limit]
+	}
+
+	return matches, total, nil
+}
+
+func (player *Player) matches(query string) bool {
+
+	if player.SteamID == query {
+		return true
+	}
+	for _, name := range player.Names {
+		if strings.Contains(strings.ToLower(name.Value), query) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get returns the profile of a player with up to limit sessions, all of
+// them when limit is zero.
+func (p *Players) Get(steamID string, limit int) (*Profile, error) {
+
+	p.mutex.Lock()
+	p.save()
+	p.mutex.Unlock()
+
+	profile := new(Profile)
+	profile.Sessions = make([]Session, 0)
+	err := p.db.View(func(tx *store.Tx) error {
+		found, err := tx.Get(store.BUCKET_PLAYERS, steamID, &profile.Player)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("player '%s' not found", steamID)
+		}
+		return tx.ForEach(store.BUCKET_SESSIONS, func(key string, value []byte) error {
+			session := new(Session)
+			if err := json.Unmarshal(value, session); err != nil {
+				return fmt.Errorf("failed to parse player session '%s'. ERR: %s", key, err.Error())
+			}
+			if session.SteamID == steamID {
+				profile.Sessions = append(profile.Sessions, *session)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	p.current(&profile.Player)
+	for n := range profile.Sessions {
+		if open := p.open[profile.Sessions[n].Instance][steamID]; open != nil && open.ID == profile.Sessions[n].ID {
+			profile.Sessions[n] = *open
+			profile.Sessions[n].End = time.Now().UTC()
+		}
+	}
+	p.mutex.Unlock()
+
+	// the session keys start with their start time
+	for a, b := 0, len(profile.Sessions)-1; a < b; a, b = a+1, b-1 {
+		profile.Sessions[a], profile.Sessions[b] = profile.Sessions[b], profile.Sessions[a]
+	}
+	profile.TotalSessions = len(profile.Sessions)
+	if limit > 0 && len(profile.Sessions) > limit {
+		profile.Sessions = profile.Sessions[:limit]
+	}
+
+	return profile, nil
+}
+
+// Leaderboard ranks the players by the order of the board. Sessions that
+// overlap the window count their playtime within it and all their kills.
+func (p *Players) Leaderboard(board Board) ([]Rank, error) {
+
+	if board.By == "" {
+		board.By = BY_KILLS
+	}
+	if !contains(Orders, board.By) {
+		return nil, fmt.Errorf("leaderboard order '%s' is not one of %v", board.By, Orders)
+	}
+
+	windowed := !board.Since.IsZero() || !board.Until.IsZero()
+	until := board.Until
+	if until.IsZero() {
+		until = time.Now().UTC()
+	}
+	if windowed && !until.After(board.Since) {
+		return nil, fmt.Errorf("the leaderboard window ends before it starts")
+	}
+
+	p.mutex.Lock()
+	p.save()
+	p.mutex.Unlock()
+
+	ranks := make(map[string]*Rank)
+	rank := func(steamID string, name string) *Rank {
+		r, ok := ranks[steamID]
+		if !ok {
+			r = &Rank{SteamID: steamID}
+			ranks[steamID] = r
+		}
+		if name != "" {
+			r.Name = name
+		}
+		return r
+	}
+	count := func(session *Session) {
+		start, end := session.Start, session.End
+		if windowed {
+			if end.Before(board.Since) || start.After(until) {
+				return
+			}
+			if start.Before(board.Since) {
+				start = board.Since
+			}
+			if end.After(until) {
+				end = until
+			}
+		}
+		r := rank(session.SteamID, session.Name)
+		r.Playtime += int64(end.Sub(start) / time.Second)
+		r.Sessions++
+		r.Kills += session.Kills
+		r.Deaths += session.Deaths
+		r.TeamKills += session.TeamKills
+	}
+
+	err := p.db.View(func(tx *store.Tx) error {
+		if windowed {
+			return tx.ForEach(store.BUCKET_SESSIONS, func(key string, value []byte) error {
+				session := new(Session)
+				if err := json.Unmarshal(value, session); err != nil {
+					return fmt.Errorf("failed to parse player session '%s'. ERR: %s", key, err.Error())
+				}
+				if session.Open || (board.Instance != "" && session.Instance != board.Instance) {
+					return nil
+				}
+				count(session)
+				return nil
+			})
+		}
+		return tx.ForEach(store.BUCKET_PLAYERS, func(key string, value []byte) error {
+			player := new(Player)
+			if err := json.Unmarshal(value, player); err != nil {
+				return fmt.Errorf("failed to parse player '%s'. ERR: %s", key, err.Error())
+			}
+			stats := player.Total
+			if board.Instance != "" {
+				var ok bool
+				if stats, ok = player.Instances[board.Instance]; !ok {
+					return nil
+				}
+			}
+			r := rank(player.SteamID, player.Name)
+			r.Playtime += stats.Playtime
+			r.Sessions += stats.Sessions
+			r.Kills += stats.Kills
+			r.Deaths += stats.Deaths
+			r.TeamKills += stats.TeamKills
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	now := time.Now().UTC()
+	for instance, sessions := range p.open {
+		if board.Instance != "" && instance != board.Instance {
+			continue
+		}
+		for _, open := range sessions {
+			session := *open
+			session.End = now
+			count(&session)
+		}
+	}
+	p.mutex.Unlock()
+
+	list := make([]Rank, 0, len(ranks))
+	for _, r := range ranks {
+		deaths := r.Deaths
+		if deaths == 0 {
+			deaths = 1
+		}
+		r.Ratio = float64(r.Kills) / float64(deaths)
+		list = append(list, *r)
+	}
+
+	sort.Slice(list, func(a, b int) bool {
+		x, y := list[a], list[b]
+		switch board.By {
+		case BY_RATIO:
+			if x.Ratio != y.Ratio {
+				return x.Ratio > y.Ratio
+			}
+		case BY_PLAYTIME:
+			if x.Playtime != y.Playtime {
+				return x.Playtime > y.Playtime
+			}
+		case BY_SESSIONS:
+			if x.Sessions != y.Sessions {
+				return x.Sessions > y.Sessions
+			}
+		}
+		if x.Kills != y.Kills {
+			return x.Kills > y.Kills
+		}
+		return x.SteamID < y.SteamID
+	})
+
+	if board.Limit > 0 && len(list) > board.Limit {
+		list = list[:board.Limit]
+	}
+	for n := range list {
+		list[n].Position = n + 1
+	}
+
+	return list, nil
+}
+
+// current adds the open sessions of a player to their totals, called with
+// the mutex held.
+func (p *Players) current(player *Player) {
+
+	now := time.Now().UTC()
+	for instance, sessions := range p.open {
+		open := sessions[player.SteamID]
+		if open == nil {
+			continue
+		}
+		session := *open
+		session.End = now
+		player.Online = append(player.Online, instance)
+		player.Total.add(&session)
+		if player.Instances == nil {
+			player.Instances = make(map[string]Stats)
+		}
+		stats := player.Instances[instance]
+		stats.add(&session)
+		player.Instances[instance] = stats
+	}
+	sort.Strings(player.Online)
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,197 @@
+package rcon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Client struct {
+	errors   uint64
+	Address  string `json:"address"`
+	Timeout  time.Duration
+	password string
+	conn     net.Conn
+	id       int32
+	mutex    sync.Mutex
+}
+
+const (
+	MODULE = "rcon"
+
+	SERVERDATA_AUTH           int32 = 3
+	SERVERDATA_AUTH_RESPONSE  int32 = 2
+	SERVERDATA_EXECCOMMAND    int32 = 2
+	SERVERDATA_RESPONSE_VALUE int32 = 0
+
+	DEFAULT_TIMEOUT = 5 * time.Second
+	MAX_PACKET_SIZE = 1024 * 1024
+)
+
+func New(address string, password string) *Client {
+
+	c := new(Client)
+	c.Address = address
+	c.Timeout = DEFAULT_TIMEOUT
+	c.password = password
+
+	return c
+}
+
+func (c *Client) Connect() error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	err := c.connect()
+	if err != nil {
+		atomic.AddUint64(&c.errors, 1)
+	}
+
+	return err
+}
+
+// Errors returns how many connections and commands failed.
+func (c *Client) Errors() uint64 {
+
+	return atomic.LoadUint64(&c.errors)
+}
+
+func (c *Client) connect() error {
+
+	if c.conn != nil {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", c.Address, c.Timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to rcon at '%s'. ERR: %s", c.Address, err.Error())
+	}
+	c.conn = conn
+
+	id, err := c.write(SERVERDATA_AUTH, c.password)
+	if err != nil {
+		c.close()
+		return err
+	}
+
+	// some servers send an empty SERVERDATA_RESPONSE_VALUE before the auth response
+	for {
+		rid, rtype, _, err := c.read()
+		if err != nil {
+			c.close()
+			return err
+		}
+		if rtype != SERVERDATA_AUTH_RESPONSE {
+			continue
+		}
+		if rid == -1 || rid != id {
+			c.close()
+			return fmt.Errorf("rcon authentication failed at '%s'", c.Address)
+		}
+		break
+	}
+
+	return nil
+}
+
+func (c *Client) Execute(command string) (string, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.connect(); err != nil {
+		atomic.AddUint64(&c.errors, 1)
+		return "", err
+	}
+
+	id, err := c.write(SERVERDATA_EXECCOMMAND, command)
+	if err != nil {
+		atomic.AddUint64(&c.errors, 1)
+		c.close()
+		return "", err
+	}
+
+	for {
+		rid, rtype, body, err := c.read()
+		if err != nil {
+			atomic.AddUint64(&c.errors, 1)
+			c.close()
+			return "", err
+		}
+		if rid == id && rtype == SERVERDATA_RESPONSE_VALUE {
+			return body, nil
+		}
+	}
+}
+
+func (c *Client) Close() error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.close()
+}
+
+func (c *Client) close() error {
+
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	c.conn = nil
+
+	return err
+}
+
+func (c *Client) write(packetType int32, body string) (int32, error) {
+
+	c.id++
+	id := c.id
+
+	buf := new(bytes.Buffer)
+	size := int32(4 + 4 + len(body) + 2)
+	binary.Write(buf, binary.LittleEndian, size)
+	binary.Write(buf, binary.LittleEndian, id)
+	binary.Write(buf, binary.LittleEndian, packetType)
+	buf.WriteString(body)
+	buf.Write([]byte{0, 0})
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.Timeout))
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return 0, fmt.Errorf("failed to send rcon packet to '%s'. ERR: %s", c.Address, err.Error())
+	}
+
+	return id, nil
+}
+
+func (c *Client) read() (int32, int32, string, error) {
+
+	var size, id, packetType int32
+
+	c.conn.SetReadDeadline(time.Now().Add(c.Timeout))
+	if err := binary.Read(c.conn, binary.LittleEndian, &size); err != nil {
+		return 0, 0, "", fmt.Errorf("failed to read rcon packet from '%s'. ERR: %s", c.Address, err.Error())
+	}
+
+	if size < 10 || size > MAX_PACKET_SIZE {
+		return 0, 0, "", fmt.Errorf("invalid rcon packet size %d from '%s'", size, c.Address)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.conn, data); err != nil {
+		return 0, 0, "", fmt.Errorf("failed to read rcon packet from '%s'. ERR: %s", c.Address, err.Error())
+	}
+
+	id = int32(binary.LittleEndian.Uint32(data[0:4]))
+	packetType = int32(binary.LittleEndian.Uint32(data[4:8]))
+	body := string(bytes.TrimRight(data[8:], "\x00"))
+
+	return id, packetType, body, nil
+}
@@ -0,0 +1,73 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int, hour int, minute int) time.Time {
+
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestCronNext(t *testing.T) {
+
+	// 2024-01-01 is a monday
+	tests := []struct {
+		name       string
+		expression string
+		from       time.Time
+		want       time.Time
+	}{
+		{"same day", "0 4 * * *", date(2024, 1, 1, 3, 59), date(2024, 1, 1, 4, 0)},
+		{"strictly after", "0 4 * * *", date(2024, 1, 1, 4, 0), date(2024, 1, 2, 4, 0)},
+		{"seconds are ignored", "* * * * *", date(2024, 1, 1, 4, 0).Add(30 * time.Second), date(2024, 1, 1, 4, 1)},
+		{"step", "*/15 * * * *", date(2024, 1, 1, 10, 7), date(2024, 1, 1, 10, 15)},
+		{"step from a start", "5/20 * * * *", date(2024, 1, 1, 10, 26), date(2024, 1, 1, 10, 45)},
+		{"step in a range", "0 9-17/4 * * *", date(2024, 1, 1, 10, 0), date(2024, 1, 1, 13, 0)},
+		{"step in a range wraps to the next day", "0 9-17/4 * * *", date(2024, 1, 1, 17, 0), date(2024, 1, 2, 9, 0)},
+		{"list", "0 6,18 * * *", date(2024, 1, 1, 7, 0), date(2024, 1, 1, 18, 0)},
+		{"day of month only", "0 0 13 * *", date(2024, 1, 1, 0, 0), date(2024, 1, 13, 0, 0)},
+		{"day of week only", "0 0 * * 5", date(2024, 1, 6, 0, 0), date(2024, 1, 12, 0, 0)},
+		{"day of month or day of week, the week first", "0 0 13 * 5", date(2024, 1, 1, 0, 0), date(2024, 1, 5, 0, 0)},
+		{"day of month or day of week, the month first", "0 0 13 * 5", date(2024, 1, 12, 0, 0), date(2024, 1, 13, 0, 0)},
+		{"sunday as 0", "0 12 * * 0", date(2024, 1, 1, 0, 0), date(2024, 1, 7, 12, 0)},
+		{"sunday as 7", "0 12 * * 7", date(2024, 1, 1, 0, 0), date(2024, 1, 7, 12, 0)},
+		{"range ending on sunday as 7", "0 12 * * 5-7", date(2024, 1, 6, 13, 0), date(2024, 1, 7, 12, 0)},
+		{"names", "30 6 * feb mon", date(2024, 1, 1, 0, 0), date(2024, 2, 5, 6, 30)},
+		{"leap day", "0 0 29 2 *", date(2023, 3, 1, 0, 0), date(2024, 2, 29, 0, 0)},
+		{"macro", "@hourly", date(2024, 1, 1, 10, 30), date(2024, 1, 1, 11, 0)},
+		{"never", "0 0 31 4 *", date(2024, 1, 1, 0, 0), time.Time{}},
+	}
+
+	for _, test := range tests {
+		cron, err := ParseCron(test.expression)
+		if err != nil {
+			t.Fatalf("%s: ParseCron(%q) failed: %v", test.name, test.expression, err)
+		}
+		if got := cron.Next(test.from); !got.Equal(test.want) {
+			t.Errorf("%s: Next(%s) of %q = %s, want %s", test.name, test.from, test.expression, got, test.want)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	} {
+		if _, err := ParseCron(expression); err == nil {
+			t.Errorf("ParseCron(%q) should fail", expression)
+		}
+	}
+}
@@ -0,0 +1,777 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/backup"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+type Schedule struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Instance       string    `json:"instance"`
+	Task           string    `json:"task"`
+	Cron           string    `json:"cron"`
+	Command        string    `json:"command"`
+	Warnings       []int     `json:"warnings"`
+	WarningMessage string    `json:"warningMessage"`
+	Enabled        bool      `json:"enabled"`
+	Created        time.Time `json:"created"`
+	Updated        time.Time `json:"updated"`
+	LastRun        time.Time `json:"lastRun"`
+	NextRun        time.Time `json:"nextRun"`
+	cron           *Cron
+	warned         map[int]bool
+}
+
+type Run struct {
+	ID       string    `json:"id"`
+	Schedule string    `json:"schedule"`
+	Instance string    `json:"instance"`
+	Task     string    `json:"task"`
+	Manual   bool      `json:"manual"`
+	User     string    `json:"user,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Result   string    `json:"result,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Handler executes a task against an instance and returns a short result.
+type Handler func(instance *insurgency.Instance, schedule *Schedule) (string, error)
+
+// Clock is the time source of the scheduler, tests replace it with a fake
+// one to move time forward without waiting.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+type Scheduler struct {
+	Schedules map[string]*Schedule `json:"schedules"`
+	History   []Run                `json:"history"`
+	Audit     *audit.Audit         `json:"-"`
+	instances *insurgency.Instances
+	handlers  map[string]Handler
+	running   map[string]bool
+	announce  func(instance *insurgency.Instance, message string) error
+	clock     Clock
+	db        *store.Store
+	done      chan struct{}
+	log       *admin_log.Log
+	mutex     sync.Mutex
+}
+
+const (
+	MODULE = "scheduler"
+
+	TASK_START     = "start"
+	TASK_STOP      = "stop"
+	TASK_RESTART   = "restart"
+	TASK_UPDATE    = "update"
+	TASK_BACKUP    = "backup"
+	TASK_RCON      = "rcon"
+	TASK_BROADCAST = "broadcast"
+	TASK_CHECK     = "check-update"
+
+	MAX_HISTORY = 500
+
+	DEFAULT_WARNING_MESSAGE = "Server {task} in {minutes} minute(s)"
+)
+
+var (
+	DEFAULT_WARNINGS = []int{5, 1}
+
+	disruptive = map[string]string{
+		TASK_STOP:    "stopping",
+		TASK_RESTART: "restarting",
+		TASK_UPDATE:  "updating",
+	}
+)
+
+func (realClock) Now() time.Time {
+
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+
+	return time.After(d)
+}
+
+func New(conf *config.Configuration, log *admin_log.Log, instances *insurgency.Instances, db *store.Store) *Scheduler {
+
+	s := new(Scheduler)
+	s.Schedules = make(map[string]*Schedule)
+	s.History = make([]Run, 0)
+	s.instances = instances
+	s.handlers = make(map[string]Handler)
+	s.running = make(map[string]bool)
+	s.announce = announce
+	s.clock = realClock{}
+	s.db = db
+	s.log = log
+
+	s.handlers[TASK_START] = startTask
+	s.handlers[TASK_STOP] = stopTask
+	s.handlers[TASK_RESTART] = restartTask
+	s.handlers[TASK_RCON] = rconTask
+	s.handlers[TASK_BROADCAST] = broadcastTask
+
+	return s
+}
+
+func (s *Scheduler) SetClock(clock Clock) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.clock = clock
+}
+
+// Register adds or replaces the handler for a task type. Tasks that need
+// other services, such as updates and backups, are registered by main.
+func (s *Scheduler) Register(task string, handler Handler) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.handlers[task] = handler
+}
+
+func (s *Scheduler) Load() error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.db.View(func(tx *store.Tx) error {
+		err := tx.ForEach(store.BUCKET_SCHEDULES, func(id string, value []byte) error {
+			schedule := new(Schedule)
+			if err := json.Unmarshal(value, schedule); err != nil {
+				return fmt.Errorf("failed to parse schedule '%s'. ERR: %s", id, err.Error())
+			}
+			s.Schedules[id] = schedule
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.ForEach(store.BUCKET_RUNS, func(key string, value []byte) error {
+			var run Run
+			if err := json.Unmarshal(value, &run); err != nil {
+				return fmt.Errorf("failed to parse schedule run '%s'. ERR: %s", key, err.Error())
+			}
+			s.History = append(s.History, run)
+			return nil
+		})
+	})
+	if err != nil {
+		return s.log.Write(fmt.Sprintf("failed to load schedules. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	// runs missed while the web admin was down are skipped
+	now := s.clock.Now()
+	for id, schedule := range s.Schedules {
+		schedule.ID = id
+		cron, err := ParseCron(schedule.Cron)
+		if err != nil {
+			s.log.Write(fmt.Sprintf("schedule '%s' disabled. ERR: %s", id, err.Error()), MODULE, admin_log.LOG_WARNING)
+			schedule.Enabled = false
+			continue
+		}
+		schedule.cron = cron
+		schedule.warned = make(map[int]bool)
+		schedule.NextRun = cron.Next(now)
+	}
+
+	s.log.Write(fmt.Sprintf("%d schedules loaded", len(s.Schedules)), MODULE, admin_log.LOG_INFO)
+
+	return nil
+}
+
+func (s *Scheduler) save() error {
+
+	err := s.db.Update(func(tx *store.Tx) error {
+		for _, id := range tx.Keys(store.BUCKET_SCHEDULES) {
+			if _, ok := s.Schedules[id]; !ok {
+				tx.Delete(store.BUCKET_SCHEDULES, id)
+			}
+		}
+		for id, schedule := range s.Schedules {
+			if err := tx.Put(store.BUCKET_SCHEDULES, id, schedule); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return s.log.Write(fmt.Sprintf("failed to save schedules. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	return nil
+}
+
+// saveRun stores a run and forgets the ones dropped from the history.
+func (s *Scheduler) saveRun(run Run, dropped []Run) error {
+
+	err := s.db.Update(func(tx *store.Tx) error {
+		for _, old := range dropped {
+			tx.Delete(store.BUCKET_RUNS, store.RunKey(old.Started, old.ID))
+		}
+		return tx.Put(store.BUCKET_RUNS, store.RunKey(run.Started, run.ID), run)
+	})
+	if err != nil {
+		return s.log.Write(fmt.Sprintf("failed to save schedule run. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	return nil
+}
+
+func (s *Scheduler) validate(schedule *Schedule) error {
+
+	if _, err := s.instances.Get(schedule.Instance); err != nil {
+		return err
+	}
+
+	if _, ok := s.handlers[schedule.Task]; !ok {
+		return fmt.Errorf("unknown task '%s'", schedule.Task)
+	}
+
+	if (schedule.Task == TASK_RCON || schedule.Task == TASK_BROADCAST) && strings.TrimSpace(schedule.Command) == "" {
+		return fmt.Errorf("task '%s' needs a command", schedule.Task)
+	}
+
+	for _, w := range schedule.Warnings {
+		if w <= 0 || w > 24*60 {
+			return fmt.Errorf("invalid warning of %d minutes", w)
+		}
+	}
+
+	cron, err := ParseCron(schedule.Cron)
+	if err != nil {
+		return err
+	}
+	schedule.cron = cron
+
+	return nil
+}
+
+// defaults gives the disruptive tasks without warnings the default
+// countdown, an empty list keeps them silent.
+func defaults(schedule *Schedule) {
+
+	if schedule.Warnings == nil && disruptive[schedule.Task] != "" {
+		schedule.Warnings = append([]int{}, DEFAULT_WARNINGS...)
+	}
+}
+
+func (s *Scheduler) Add(schedule *Schedule) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.validate(schedule); err != nil {
+		return err
+	}
+	defaults(schedule)
+
+	now := s.clock.Now()
+	schedule.ID = utils.RandomID(8)
+	schedule.Created = now
+	schedule.Updated = now
+	schedule.LastRun = time.Time{}
+	schedule.NextRun = schedule.cron.Next(now)
+	schedule.warned = make(map[int]bool)
+	s.Schedules[schedule.ID] = schedule
+
+	return s.save()
+}
+
+func (s *Scheduler) Update(id string, schedule *Schedule) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, ok := s.Schedules[id]
+	if !ok {
+		return fmt.Errorf("schedule '%s' not found", id)
+	}
+
+	if err := s.validate(schedule); err != nil {
+		return err
+	}
+	defaults(schedule)
+
+	now := s.clock.Now()
+	schedule.ID = id
+	schedule.Created = current.Created
+	schedule.Updated = now
+	schedule.LastRun = current.LastRun
+	schedule.NextRun = schedule.cron.Next(now)
+	schedule.warned = make(map[int]bool)
+	s.Schedules[id] = schedule
+
+	return s.save()
+}
+
+func (s *Scheduler) Remove(id string) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.Schedules[id]; !ok {
+		return fmt.Errorf("schedule '%s' not found", id)
+	}
+
+	delete(s.Schedules, id)
+
+	return s.save()
+}
+
+func (s *Scheduler) Get(id string) (*Schedule, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	schedule, ok := s.Schedules[id]
+	if !ok {
+		return nil, fmt.Errorf("schedule '%s' not found", id)
+	}
+
+	// a copy, Tick changes the runs of the schedule while it is serialized
+	snapshot := *schedule
+
+	return &snapshot, nil
+}
+
+func (s *Scheduler) List(instance string) []*Schedule {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := make([]*Schedule, 0, len(s.Schedules))
+	for _, schedule := range s.Schedules {
+		if instance == "" || schedule.Instance == instance {
+			snapshot := *schedule
+			list = append(list, &snapshot)
+		}
+	}
+
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].NextRun.Before(list[b].NextRun)
+	})
+
+	return list
+}
+
+// NextDisruption returns when the next enabled schedule stops, restarts
+// or updates an instance, a zero time when none is due.
+func (s *Scheduler) NextDisruption(instance string) time.Time {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var next time.Time
+	for _, schedule := range s.Schedules {
+		if !schedule.Enabled || schedule.NextRun.IsZero() || disruptive[schedule.Task] == "" {
+			continue
+		}
+		if schedule.Instance != instance {
+			continue
+		}
+		if next.IsZero() || schedule.NextRun.Before(next) {
+			next = schedule.NextRun
+		}
+	}
+
+	return next
+}
+
+// Runs returns the run history newest first, optionally only for one
+// schedule or instance.
+func (s *Scheduler) Runs(schedule string, instance string) []Run {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	runs := make([]Run, 0)
+	for r := len(s.History) - 1; r >= 0; r-- {
+		run := s.History[r]
+		if (schedule == "" || run.Schedule == schedule) && (instance == "" || run.Instance == instance) {
+			runs = append(runs, run)
+		}
+	}
+
+	return runs
+}
+
+func (s *Scheduler) Start() {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.done != nil {
+		return
+	}
+
+	s.done = make(chan struct{})
+	go s.loop(s.done)
+}
+
+func (s *Scheduler) Stop() {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.done != nil {
+		close(s.done)
+		s.done = nil
+	}
+}
+
+func (s *Scheduler) loop(done chan struct{}) {
+
+	for {
+		s.mutex.Lock()
+		clock := s.clock
+		s.mutex.Unlock()
+
+		now := clock.Now()
+		wait := now.Truncate(time.Minute).Add(time.Minute).Sub(now)
+
+		select {
+		case <-done:
+			return
+		case <-clock.After(wait):
+			s.Tick(clock.Now())
+		}
+	}
+}
+
+// Tick sends the countdown warnings and starts the tasks due at now
+// without waiting for them, so a long update does not hold back the other
+// schedules. A schedule still running when it is due again is skipped.
+func (s *Scheduler) Tick(now time.Time) {
+
+	type warning struct {
+		schedule *Schedule
+		minutes  int
+	}
+
+	warnings := make([]warning, 0)
+	due := make([]*Schedule, 0)
+
+	s.mutex.Lock()
+	for _, schedule := range s.Schedules {
+
+		if !schedule.Enabled || schedule.cron == nil || schedule.NextRun.IsZero() {
+			continue
+		}
+
+		for _, w := range schedule.Warnings {
+			at := schedule.NextRun.Add(-time.Duration(w) * time.Minute)
+			if !now.Before(at) && now.Before(schedule.NextRun) && !schedule.warned[w] {
+				schedule.warned[w] = true
+				warnings = append(warnings, warning{schedule, w})
+			}
+		}
+
+		if !now.Before(schedule.NextRun) {
+			schedule.LastRun = schedule.NextRun
+			schedule.NextRun = schedule.cron.Next(now)
+			schedule.warned = make(map[int]bool)
+			if s.running[schedule.ID] {
+				s.log.Write(fmt.Sprintf("schedule '%s' skipped, its previous run has not finished", schedule.ID), MODULE, admin_log.LOG_WARNING)
+				continue
+			}
+			s.running[schedule.ID] = true
+			snapshot := *schedule
+			due = append(due, &snapshot)
+		}
+	}
+	if len(due) > 0 {
+		s.save()
+	}
+	s.mutex.Unlock()
+
+	for _, w := range warnings {
+		s.warn(w.schedule, w.minutes)
+	}
+
+	for _, schedule := range due {
+		go func(schedule *Schedule) {
+			s.run(schedule, false, "")
+			s.mutex.Lock()
+			delete(s.running, schedule.ID)
+			s.mutex.Unlock()
+		}(schedule)
+	}
+}
+
+// RunNow executes a schedule immediately without countdown warnings. It
+// fails while the schedule is already running, manual or scheduled.
+func (s *Scheduler) RunNow(id string, user string) (Run, error) {
+
+	s.mutex.Lock()
+	current, ok := s.Schedules[id]
+	if !ok {
+		s.mutex.Unlock()
+		return Run{}, fmt.Errorf("schedule '%s' not found", id)
+	}
+	if s.running[id] {
+		s.mutex.Unlock()
+		return Run{}, fmt.Errorf("schedule '%s' is already running", id)
+	}
+	s.running[id] = true
+	schedule := *current
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.running, id)
+		s.mutex.Unlock()
+	}()
+
+	return s.run(&schedule, true, user), nil
+}
+
+func (s *Scheduler) warn(schedule *Schedule, minutes int) {
+
+	instance, err := s.instances.Get(schedule.Instance)
+	if err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	announce := s.announce
+	s.mutex.Unlock()
+
+	if err := announce(instance, warningMessage(schedule, minutes)); err != nil {
+		s.log.Write(fmt.Sprintf("failed to send warning for schedule '%s'. ERR: %s", schedule.ID, err.Error()), MODULE, admin_log.LOG_WARNING)
+	}
+}
+
+// warningMessage fills the countdown message of a schedule.
+func warningMessage(schedule *Schedule, minutes int) string {
+
+	message := schedule.WarningMessage
+	if message == "" {
+		message = DEFAULT_WARNING_MESSAGE
+	}
+
+	verb := disruptive[schedule.Task]
+	if verb == "" {
+		verb = schedule.Task
+	}
+
+	return strings.NewReplacer("{task}", verb, "{minutes}", strconv.Itoa(minutes), "{name}", schedule.Name).Replace(message)
+}
+
+// announce says a countdown warning on a running instance, warnings for a
+// stopped one are dropped.
+func announce(instance *insurgency.Instance, message string) error {
+
+	if !instance.IsRunning() {
+		return nil
+	}
+
+	_, err := instance.Say(message)
+
+	return err
+}
+
+func (s *Scheduler) run(schedule *Schedule, manual bool, user string) Run {
+
+	s.mutex.Lock()
+	handler := s.handlers[schedule.Task]
+	clock := s.clock
+	s.mutex.Unlock()
+
+	run := Run{
+		ID:       utils.RandomID(8),
+		Schedule: schedule.ID,
+		Instance: schedule.Instance,
+		Task:     schedule.Task,
+		Manual:   manual,
+		User:     user,
+		Started:  clock.Now(),
+	}
+
+	instance, err := s.instances.Get(schedule.Instance)
+	if err == nil && handler == nil {
+		err = fmt.Errorf("no handler for task '%s'", schedule.Task)
+	}
+	if err == nil {
+		run.Result, err = handler(instance, schedule)
+	}
+	if err != nil {
+		run.Error = err.Error()
+		s.log.Write(fmt.Sprintf("schedule '%s' (%s on '%s') failed. ERR: %s", schedule.ID, schedule.Task, schedule.Instance, err.Error()), MODULE, admin_log.LOG_ERROR)
+	} else {
+		s.log.Write(fmt.Sprintf("schedule '%s' (%s on '%s') done", schedule.ID, schedule.Task, schedule.Instance), MODULE, admin_log.LOG_INFO)
+	}
+	run.Finished = clock.Now()
+
+	// manual runs are recorded by whoever asked for them
+	if !manual && s.Audit != nil {
+		s.Audit.Record(audit.Entry{
+			Time:       run.Started,
+			User:       MODULE,
+			Address:    audit.ADDRESS_LOCAL,
+			Action:     "schedule.run",
+			Instance:   run.Instance,
+			Target:     run.Schedule,
+			Parameters: map[string]string{"task": run.Task, "command": schedule.Command},
+			Result:     run.Result,
+			Error:      run.Error,
+		})
+	}
+
+	s.mutex.Lock()
+	s.History = append(s.History, run)
+	var dropped []Run
+	if len(s.History) > MAX_HISTORY {
+		dropped = s.History[:len(s.History)-MAX_HISTORY]
+		s.History = s.History[len(s.History)-MAX_HISTORY:]
+	}
+	s.saveRun(run, dropped)
+	s.mutex.Unlock()
+
+	return run
+}
+
+func startTask(instance *insurgency.Instance, schedule *Schedule) (string, error) {
+
+	if instance.IsRunning() {
+		return "already running", nil
+	}
+
+	return "started", instance.Start()
+}
+
+func stopTask(instance *insurgency.Instance, schedule *Schedule) (string, error) {
+
+	if !instance.IsRunning() {
+		return "not running", nil
+	}
+
+	return "stopped", instance.Stop()
+}
+
+func restartTask(instance *insurgency.Instance, schedule *Schedule) (string, error) {
+
+	if instance.IsRunning() {
+		if err := instance.Stop(); err != nil {
+			return "", err
+		}
+	}
+
+	return "restarted", instance.Start()
+}
+
+func rconTask(instance *insurgency.Instance, schedule *Schedule) (string, error) {
+
+	return instance.Command(schedule.Command)
+}
+
+func broadcastTask(instance *insurgency.Instance, schedule *Schedule) (string, error) {
+
+	return instance.Say(schedule.Command)
+}
+
+// UpdateTask updates the shared game installation, stopping the instance
+// while steamcmd runs and starting it again if it was running.
+func UpdateTask(game *insurgency.Insurgency, bus *events.Bus) Handler {
+
+	return func(instance *insurgency.Instance, schedule *Schedule) (string, error) {
+
+		running := instance.IsRunning()
+		if running {
+			if err := instance.Stop(); err != nil {
+				return "", err
+			}
+		}
+
+		if !game.Install() {
+			return "", fmt.Errorf("failed to update the sandstorm server")
+		}
+
+		build, _ := game.InstalledBuild()
+		bus.Publish(events.Event{
+			Type:     events.UPDATE_APPLIED,
+			Instance: instance.ID,
+			Title:    "Sandstorm server updated",
+			Message:  fmt.Sprintf("the sandstorm server was updated to build %s", build),
+			Fields:   map[string]string{"build": build},
+		})
+
+		if running {
+			return "updated", instance.Start()
+		}
+
+		return "updated", nil
+	}
+}
+
+// CheckUpdateTask looks for a newer build of the game and announces each
+// new one once.
+func CheckUpdateTask(game *insurgency.Insurgency, bus *events.Bus) Handler {
+
+	var announced string
+	var mutex sync.Mutex
+
+	return func(instance *insurgency.Instance, schedule *Schedule) (string, error) {
+
+		installed, latest, available, err := game.CheckUpdate()
+		if err != nil {
+			return "", err
+		}
+		if !available {
+			return fmt.Sprintf("build %s is up to date", installed), nil
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if announced != latest {
+			announced = latest
+			bus.Publish(events.Event{
+				Type:     events.UPDATE_AVAILABLE,
+				Instance: instance.ID,
+				Title:    "Sandstorm server update available",
+				Message:  fmt.Sprintf("build %s is available, build %s is installed", latest, installed),
+				Fields:   map[string]string{"installed": installed, "latest": latest},
+			})
+		}
+
+		return fmt.Sprintf("build %s available", latest), nil
+	}
+}
+
+func BackupTask(backups *backup.Backups) Handler {
+
+	return func(instance *insurgency.Instance, schedule *Schedule) (string, error) {
+
+		info, err := backups.Create(instance, backup.TRIGGER_SCHEDULED, "")
+		if err != nil {
+			return "", err
+		}
+
+		return info.Name, nil
+	}
+}
@@ -0,0 +1,396 @@
+package scheduler
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+)
+
+// fakeClock only moves when a test says so, the loop is not started and
+// the tests call Tick themselves.
+type fakeClock struct {
+	now   time.Time
+	mutex sync.Mutex
+}
+
+func (c *fakeClock) Now() time.Time {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+
+	return make(chan time.Time)
+}
+
+func (c *fakeClock) Set(now time.Time) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = now
+}
+
+type announcement struct {
+	instance string
+	message  string
+}
+
+type fixture struct {
+	scheduler     *Scheduler
+	clock         *fakeClock
+	announcements chan announcement
+}
+
+func newFixture(t *testing.T) *fixture {
+
+	dir := t.TempDir()
+	log := admin_log.New()
+	conf := config.New(log)
+	conf.WebAdmin.ConfigDir = dir
+	conf.Sandstorm.Dir = dir
+
+	db := store.New(conf, log)
+	if err := db.Open(); err != nil {
+		t.Fatalf("failed to open the store: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	instances := insurgency.NewInstances(conf, log, db)
+	for _, id := range []string{"one", "two"} {
+		if err := instances.Add(instances.New(id)); err != nil {
+			t.Fatalf("failed to add instance '%s': %v", id, err)
+		}
+	}
+
+	f := &fixture{clock: &fakeClock{now: date(2024, 1, 1, 3, 0)}, announcements: make(chan announcement, 16)}
+	f.scheduler = New(conf, log, instances, db)
+	f.scheduler.SetClock(f.clock)
+	f.scheduler.announce = func(instance *insurgency.Instance, message string) error {
+		f.announcements <- announcement{instance.ID, message}
+		return nil
+	}
+
+	// the runs started by Tick write to the store in the temporary directory
+	t.Cleanup(func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			f.scheduler.mutex.Lock()
+			running := len(f.scheduler.running)
+			f.scheduler.mutex.Unlock()
+			if running == 0 {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Error("scheduled runs did not finish")
+	})
+
+	return f
+}
+
+// add creates an enabled schedule with a handler that reports every call
+// and blocks until release is closed, when it is not nil.
+func (f *fixture) add(t *testing.T, instance string, task string, cron string, release chan struct{}) (*Schedule, chan string) {
+
+	calls := make(chan string, 16)
+	f.scheduler.Register(task, func(instance *insurgency.Instance, schedule *Schedule) (string, error) {
+		calls <- instance.ID
+		if release != nil {
+			<-release
+		}
+		return "done", nil
+	})
+
+	schedule := &Schedule{Name: task, Instance: instance, Task: task, Cron: cron, Enabled: true}
+	if err := f.scheduler.Add(schedule); err != nil {
+		t.Fatalf("failed to add schedule: %v", err)
+	}
+
+	return schedule, calls
+}
+
+func receive(t *testing.T, c chan string, what string) string {
+
+	select {
+	case value := <-c:
+		return value
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+
+	return ""
+}
+
+func nothing(t *testing.T, c chan string, what string) {
+
+	select {
+	case value := <-c:
+		t.Fatalf("unexpected %s '%s'", what, value)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func waitRuns(t *testing.T, s *Scheduler, schedule string, count int) []Run {
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		runs := s.Runs(schedule, "")
+		if len(runs) >= count {
+			return runs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("schedule '%s' has %d runs, want %d", schedule, len(runs), count)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTickRunsDueSchedules(t *testing.T) {
+
+	f := newFixture(t)
+	schedule, calls := f.add(t, "one", "probe", "0 4 * * *", nil)
+
+	if !schedule.NextRun.Equal(date(2024, 1, 1, 4, 0)) {
+		t.Fatalf("next run is %s, want 04:00", schedule.NextRun)
+	}
+
+	f.scheduler.Tick(date(2024, 1, 1, 3, 59))
+	nothing(t, calls, "run before the schedule is due")
+
+	f.clock.Set(date(2024, 1, 1, 4, 0))
+	f.scheduler.Tick(date(2024, 1, 1, 4, 0))
+	if instance := receive(t, calls, "the due run"); instance != "one" {
+		t.Fatalf("task ran on instance '%s', want 'one'", instance)
+	}
+
+	runs := waitRuns(t, f.scheduler, schedule.ID, 1)
+	if runs[0].Manual || runs[0].Result != "done" || runs[0].Error != "" {
+		t.Errorf("unexpected run %+v", runs[0])
+	}
+
+	current, err := f.scheduler.Get(schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !current.LastRun.Equal(date(2024, 1, 1, 4, 0)) || !current.NextRun.Equal(date(2024, 1, 2, 4, 0)) {
+		t.Errorf("last run %s and next run %s, want 04:00 today and tomorrow", current.LastRun, current.NextRun)
+	}
+
+	// the same minute does not run it twice
+	f.scheduler.Tick(date(2024, 1, 1, 4, 0))
+	nothing(t, calls, "second run in the same minute")
+}
+
+func TestTickDoesNotWaitForTasks(t *testing.T) {
+
+	f := newFixture(t)
+	release := make(chan struct{})
+	defer close(release)
+	slow, slowCalls := f.add(t, "one", "slow", "*/5 * * * *", release)
+	_, fastCalls := f.add(t, "two", "fast", "* * * * *", nil)
+
+	ticked := make(chan struct{})
+	go func() {
+		f.scheduler.Tick(date(2024, 1, 1, 3, 5))
+		close(ticked)
+	}()
+	select {
+	case <-ticked:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Tick waited for a running task")
+	}
+	receive(t, slowCalls, "the slow task")
+	receive(t, fastCalls, "the fast task")
+
+	// the slow task is still running, the other schedule keeps its pace
+	for minute := 6; minute <= 10; minute++ {
+		f.scheduler.Tick(date(2024, 1, 1, 3, minute))
+		receive(t, fastCalls, fmt.Sprintf("the fast task at 03:%02d", minute))
+	}
+	nothing(t, slowCalls, "overlapping run of the slow task")
+
+	current, _ := f.scheduler.Get(slow.ID)
+	if !current.NextRun.Equal(date(2024, 1, 1, 3, 15)) {
+		t.Errorf("next run of the skipped schedule is %s, want 03:15", current.NextRun)
+	}
+}
+
+func TestTickWarnings(t *testing.T) {
+
+	f := newFixture(t)
+	schedule, calls := f.add(t, "one", TASK_RESTART, "0 4 * * *", nil)
+
+	if len(schedule.Warnings) != 2 || schedule.Warnings[0] != 5 || schedule.Warnings[1] != 1 {
+		t.Fatalf("warnings are %v, want the default %v", schedule.Warnings, DEFAULT_WARNINGS)
+	}
+
+	expect := func(now time.Time, want string) {
+		t.Helper()
+		f.scheduler.Tick(now)
+		select {
+		case a := <-f.announcements:
+			if want == "" {
+				t.Fatalf("%s: unexpected warning '%s'", now.Format("15:04"), a.message)
+			}
+			if a.instance != "one" || a.message != want {
+				t.Fatalf("%s: warning '%s' on '%s', want '%s' on 'one'", now.Format("15:04"), a.message, a.instance, want)
+			}
+		default:
+			if want != "" {
+				t.Fatalf("%s: no warning, want '%s'", now.Format("15:04"), want)
+			}
+		}
+	}
+
+	expect(date(2024, 1, 1, 3, 54), "")
+	expect(date(2024, 1, 1, 3, 55), "Server restarting in 5 minute(s)")
+	expect(date(2024, 1, 1, 3, 56), "")
+	expect(date(2024, 1, 1, 3, 59), "Server restarting in 1 minute(s)")
+	expect(date(2024, 1, 1, 4, 0), "")
+	receive(t, calls, "the restart")
+
+	// the countdown starts again for the next run
+	expect(date(2024, 1, 2, 3, 55), "Server restarting in 5 minute(s)")
+
+	f.clock.Set(date(2024, 1, 2, 4, 0))
+	custom := &Schedule{Name: "nightly", Instance: "two", Task: TASK_RESTART, Cron: "30 4 * * *", Warnings: []int{10}, WarningMessage: "{name}: {task} in {minutes} min", Enabled: true}
+	if err := f.scheduler.Add(custom); err != nil {
+		t.Fatal(err)
+	}
+	expect(date(2024, 1, 2, 4, 19), "")
+	f.scheduler.Tick(date(2024, 1, 2, 4, 20))
+	select {
+	case a := <-f.announcements:
+		if a.instance != "two" || a.message != "nightly: restarting in 10 min" {
+			t.Errorf("custom warning '%s' on '%s'", a.message, a.instance)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("no custom warning")
+	}
+}
+
+func TestRunNow(t *testing.T) {
+
+	f := newFixture(t)
+	schedule, calls := f.add(t, "two", "probe", "0 4 * * *", nil)
+
+	run, err := f.scheduler.RunNow(schedule.ID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receive(t, calls, "the manual run") != "two" {
+		t.Fatal("the manual run used the wrong instance")
+	}
+	if !run.Manual || run.User != "alice" || run.Result != "done" || run.Schedule != schedule.ID {
+		t.Errorf("unexpected run %+v", run)
+	}
+
+	runs := f.scheduler.Runs("", "two")
+	if len(runs) != 1 || runs[0].ID != run.ID {
+		t.Errorf("history is %+v, want the manual run", runs)
+	}
+
+	current, _ := f.scheduler.Get(schedule.ID)
+	if !current.LastRun.IsZero() || !current.NextRun.Equal(date(2024, 1, 1, 4, 0)) {
+		t.Errorf("a manual run moved the schedule to last %s next %s", current.LastRun, current.NextRun)
+	}
+
+	if _, err := f.scheduler.RunNow("missing", "alice"); err == nil {
+		t.Error("RunNow of a missing schedule should fail")
+	}
+}
+
+func TestRunNowDoesNotOverlap(t *testing.T) {
+
+	f := newFixture(t)
+	release := make(chan struct{})
+	schedule, calls := f.add(t, "one", "slow", "0 4 * * *", release)
+
+	f.scheduler.Tick(date(2024, 1, 1, 4, 0))
+	receive(t, calls, "the scheduled run")
+
+	if _, err := f.scheduler.RunNow(schedule.ID, "alice"); err == nil {
+		t.Error("RunNow of a running schedule should fail")
+	}
+	close(release)
+	waitRuns(t, f.scheduler, schedule.ID, 1)
+
+	// running is cleared just after the run is recorded
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := f.scheduler.RunNow(schedule.ID, "alice"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("RunNow still fails after the scheduled run finished")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	receive(t, calls, "the manual run")
+}
+
+func TestUpdateDefaultWarnings(t *testing.T) {
+
+	f := newFixture(t)
+	schedule, _ := f.add(t, "one", "probe", "0 4 * * *", nil)
+
+	update := &Schedule{Name: "restart", Instance: "one", Task: TASK_RESTART, Cron: "0 4 * * *", Enabled: true}
+	if err := f.scheduler.Update(schedule.ID, update); err != nil {
+		t.Fatal(err)
+	}
+	current, _ := f.scheduler.Get(schedule.ID)
+	if len(current.Warnings) != len(DEFAULT_WARNINGS) || current.Warnings[0] != DEFAULT_WARNINGS[0] {
+		t.Errorf("warnings are %v, want the default %v", current.Warnings, DEFAULT_WARNINGS)
+	}
+
+	silent := &Schedule{Name: "restart", Instance: "one", Task: TASK_RESTART, Cron: "0 4 * * *", Warnings: []int{}, Enabled: true}
+	if err := f.scheduler.Update(schedule.ID, silent); err != nil {
+		t.Fatal(err)
+	}
+	current, _ = f.scheduler.Get(schedule.ID)
+	if len(current.Warnings) != 0 {
+		t.Errorf("warnings are %v, want none", current.Warnings)
+	}
+}
+
+func TestSchedulesAreCopies(t *testing.T) {
+
+	f := newFixture(t)
+	schedule, _ := f.add(t, "one", "probe", "0 4 * * *", nil)
+
+	current, _ := f.scheduler.Get(schedule.ID)
+	current.NextRun = time.Time{}
+	for _, listed := range f.scheduler.List("") {
+		listed.NextRun = time.Time{}
+	}
+
+	again, _ := f.scheduler.Get(schedule.ID)
+	if !again.NextRun.Equal(date(2024, 1, 1, 4, 0)) {
+		t.Errorf("changing a returned schedule changed the scheduler")
+	}
+}
+
+func TestNextDisruption(t *testing.T) {
+
+	f := newFixture(t)
+	f.add(t, "one", "probe", "0 1 * * *", nil)
+	f.add(t, "one", TASK_UPDATE, "0 5 * * *", nil)
+	f.add(t, "one", TASK_RESTART, "0 4 * * *", nil)
+
+	if next := f.scheduler.NextDisruption("one"); !next.Equal(date(2024, 1, 1, 4, 0)) {
+		t.Errorf("next disruption of 'one' is %s, want 04:00", next)
+	}
+	if next := f.scheduler.NextDisruption("two"); !next.IsZero() {
+		t.Errorf("next disruption of 'two' is %s, want none", next)
+	}
+}
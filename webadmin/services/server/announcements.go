@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/announcer"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+)
+
+func (s *Server) getAnnouncements(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, s.Announcer.Get(instance.ID))
+}
+
+func (s *Server) setAnnouncements(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	var body announcer.Announcements
+	if !bind(c, &body) {
+		return
+	}
+
+	announcements, err := s.Announcer.Set(instance.ID, body)
+	s.record(c, audit.Entry{Action: "announcements.set", Instance: instance.ID, Parameters: map[string]string{"enabled": fmt.Sprint(body.Enabled), "interval": fmt.Sprint(body.Interval), "messages": strings.Join(body.Messages, "\n"), "welcome": body.Welcome}}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, announcements)
+}
+
+func (s *Server) removeAnnouncements(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	err := s.Announcer.Remove(instance.ID)
+	s.record(c, audit.Entry{Action: "announcements.remove", Instance: instance.ID}, err)
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// previewAnnouncements renders the announcements with the current values
+// of the instance.
+func (s *Server) previewAnnouncements(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	messages, welcome := s.Announcer.Preview(instance)
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages, "welcome": welcome, "variables": announcer.Variables})
+}
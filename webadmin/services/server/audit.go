@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+)
+
+const (
+	AUDIT_LIMIT     = 100
+	AUDIT_MAX_LIMIT = 1000
+)
+
+func (s *Server) listAudit(c *gin.Context) {
+
+	filter, err := auditFilter(c)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, total, err := s.Audit.Query(filter)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"total": total, "offset": filter.Offset, "limit": filter.Limit, "entries": entries})
+}
+
+func (s *Server) exportAudit(c *gin.Context) {
+
+	filter, err := auditFilter(c)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	format := c.DefaultQuery("format", audit.EXPORT_JSON)
+	contentType := "application/json"
+	if format == audit.EXPORT_CSV {
+		contentType = "text/csv"
+	} else if format != audit.EXPORT_JSON {
+		fail(c, http.StatusBadRequest, fmt.Errorf("export format '%s' is not one of %v", format, audit.ExportFormats))
+		return
+	}
+
+	s.record(c, audit.Entry{Action: "audit.export", Parameters: map[string]string{"format": format, "query": c.Request.URL.RawQuery}}, nil)
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"audit-%s.%s\"", time.Now().Format("20060102-150405"), format))
+	c.Status(http.StatusOK)
+	if err := s.Audit.Export(c.Writer, format, filter); err != nil {
+		c.Error(err)
+	}
+}
+
+func (s *Server) verifyAudit(c *gin.Context) {
+
+	result, err := s.Audit.Verify()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// auditFilter reads the filter from the query string, times are RFC 3339.
+func auditFilter(c *gin.Context) (audit.Filter, error) {
+
+	filter := audit.Filter{
+		User:     c.Query("user"),
+		Address:  c.Query("address"),
+		Action:   c.Query("action"),
+		Instance: c.Query("instance"),
+		Target:   c.Query("target"),
+		Failed:   c.Query("failed") == "true",
+		Limit:    AUDIT_LIMIT,
+	}
+
+	for name, value := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if c.Query(name) == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, c.Query(name))
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s time '%s', expected RFC 3339", name, c.Query(name))
+		}
+		*value = t
+	}
+
+	for name, value := range map[string]*int{"offset": &filter.Offset, "limit": &filter.Limit} {
+		if c.Query(name) == "" {
+			continue
+		}
+		n, err := strconv.Atoi(c.Query(name))
+		if err != nil || n < 0 {
+			return filter, fmt.Errorf("invalid %s '%s'", name, c.Query(name))
+		}
+		*value = n
+	}
+	if filter.Limit == 0 || filter.Limit > AUDIT_MAX_LIMIT {
+		filter.Limit = AUDIT_MAX_LIMIT
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/backup"
+)
+
+func (s *Server) listBackups(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	list, err := s.Backups.List(instance.ID)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func (s *Server) createBackup(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	info, err := s.Backups.Create(instance, backup.TRIGGER_MANUAL, c.GetString(CONTEXT_USER))
+	entry := audit.Entry{Action: "backup.create", Instance: instance.ID}
+	if info != nil {
+		entry.Target = info.Name
+	}
+	s.record(c, entry, err)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, info)
+}
+
+func (s *Server) downloadBackup(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	path, err := s.Backups.Path(instance.ID, c.Param("name"))
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.FileAttachment(path, c.Param("name"))
+}
+
+func (s *Server) restoreBackup(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	err := s.Backups.Restore(instance, c.Param("name"), c.GetString(CONTEXT_USER))
+	s.record(c, audit.Entry{Action: "backup.restore", Instance: instance.ID, Target: c.Param("name")}, err)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, instance)
+}
+
+func (s *Server) removeBackup(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	err := s.Backups.Remove(instance.ID, c.Param("name"))
+	s.record(c, audit.Entry{Action: "backup.remove", Instance: instance.ID, Target: c.Param("name")}, err)
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
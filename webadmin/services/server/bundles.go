@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/bundle"
+)
+
+// exportBundle downloads the signed bundle of an instance to import it on
+// another host.
+func (s *Server) exportBundle(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	_, err := s.Bundles.Export(instance, &buf)
+	s.record(c, audit.Entry{Action: "instance.export", Instance: instance.ID}, err)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	name := fmt.Sprintf("%s_%s%s", instance.ID, time.Now().Format("20060102-150405"), bundle.EXTENSION)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", name))
+	c.Data(http.StatusOK, "application/gzip", buf.Bytes())
+}
+
+// importBundle creates an instance from a bundle sent as the request body.
+// The id and dir query parameters change the instance id and the game
+// directory, preview only reports what would be imported and insecure
+// accepts any signer when bundles.trusted is empty.
+func (s *Server) importBundle(c *gin.Context) {
+
+	preview, _ := strconv.ParseBool(c.Query("preview"))
+	insecure, _ := strconv.ParseBool(c.Query("insecure"))
+	options := bundle.Options{ID: c.Query("id"), Dir: c.Query("dir"), Preview: preview, Insecure: insecure}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, bundle.MAX_SIZE)
+	result, err := s.Bundles.Import(body, options, c.GetString(CONTEXT_USER))
+	if !preview {
+		entry := audit.Entry{Action: "instance.import-bundle", Instance: options.ID}
+		if result != nil {
+			entry.Instance = result.Instance.ID
+			entry.Parameters = map[string]string{"source": result.Manifest.Instance, "host": result.Manifest.Host, "signer": result.Manifest.Signer}
+		}
+		s.record(c, entry, err)
+	}
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if preview {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// bundleKey returns the public key other hosts add to bundles.trusted to
+// import the bundles of this one.
+func (s *Server) bundleKey(c *gin.Context) {
+
+	key, err := s.Bundles.PublicKey()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"key": key})
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/chat"
+)
+
+const (
+	CHAT_LIMIT     = 100
+	CHAT_KEEPALIVE = 30 * time.Second
+)
+
+type chatMessage struct {
+	Message string `json:"message"`
+}
+
+// chatHistory returns the latest messages of the instance in the path, or
+// of every instance, sent after "since".
+func (s *Server) chatHistory(c *gin.Context) {
+
+	id := ""
+	if c.Param("id") != "" {
+		instance := s.instance(c)
+		if instance == nil {
+			return
+		}
+		id = instance.ID
+	}
+
+	var since time.Time
+	if c.Query("since") != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, c.Query("since")); err != nil {
+			fail(c, http.StatusBadRequest, fmt.Errorf("invalid since time '%s', expected RFC 3339", c.Query("since")))
+			return
+		}
+	}
+
+	limit, ok := number(c, "limit", CHAT_LIMIT)
+	if !ok {
+		return
+	}
+
+	messages, err := s.Chat.History(id, since, limit)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
+
+// streamChat sends the new messages of the instance in the path, or of
+// every instance, as server-sent events until the client goes away.
+func (s *Server) streamChat(c *gin.Context) {
+
+	id := ""
+	if c.Param("id") != "" {
+		instance := s.instance(c)
+		if instance == nil {
+			return
+		}
+		id = instance.ID
+	}
+
+	messages, cancel := s.Chat.Subscribe(id)
+	defer cancel()
+
+	keepalive := time.NewTicker(CHAT_KEEPALIVE)
+	defer keepalive.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Header("Content-Type", "text/event-stream")
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				return false
+			}
+			c.SSEvent("message", message)
+			return true
+		case <-keepalive.C:
+			c.SSEvent("ping", time.Now().UTC())
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func (s *Server) sendChat(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	var body chatMessage
+	if !bind(c, &body) {
+		return
+	}
+
+	message, err := s.Chat.Send(instance, body.Message, c.GetString(CONTEXT_USER))
+	s.record(c, audit.Entry{Action: "chat.say", Instance: instance.ID, Parameters: map[string]string{"message": body.Message}}, err)
+	if err != nil {
+		fail(c, http.StatusBadGateway, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
+
+// broadcastChat says a message on every running instance.
+func (s *Server) broadcastChat(c *gin.Context) {
+
+	var body chatMessage
+	if !bind(c, &body) {
+		return
+	}
+
+	if strings.TrimSpace(body.Message) == "" {
+		fail(c, http.StatusBadRequest, fmt.Errorf("message is empty"))
+		return
+	}
+
+	sent, failed := s.Chat.Broadcast(body.Message, c.GetString(CONTEXT_USER))
+	entry := audit.Entry{Action: "chat.broadcast", Parameters: map[string]string{"message": body.Message}, Result: fmt.Sprintf("sent to %d instance(s)", len(sent))}
+	var err error
+	if len(failed) > 0 {
+		err = fmt.Errorf("failed on %d instance(s)", len(failed))
+	}
+	s.record(c, entry, err)
+
+	c.JSON(http.StatusOK, gin.H{"sent": sent, "failed": failed})
+}
+
+func (s *Server) getChatFilter(c *gin.Context) {
+
+	c.JSON(http.StatusOK, s.Chat.Filter())
+}
+
+func (s *Server) setChatFilter(c *gin.Context) {
+
+	var filter chat.Filter
+	if !bind(c, &filter) {
+		return
+	}
+
+	err := s.Chat.SetFilter(filter)
+	s.record(c, audit.Entry{Action: "chat.filter", Parameters: map[string]string{"enabled": fmt.Sprint(filter.Enabled), "rules": fmt.Sprint(len(filter.Rules))}}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, s.Chat.Filter())
+}
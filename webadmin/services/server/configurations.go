@@ -0,0 +1,169 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+)
+
+type configurationWrite struct {
+	Content string `json:"content"`
+	Message string `json:"message"`
+}
+
+func (s *Server) listConfigurations(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	list, err := instance.Configurations().List()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func (s *Server) readConfiguration(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	content, err := instance.Configurations().Read(c.Param("file"))
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"name": c.Param("file"), "content": content})
+}
+
+func (s *Server) writeConfiguration(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	var body configurationWrite
+	if err := c.ShouldBindJSON(&body); err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	version, err := instance.Configurations().Write(c.Param("file"), body.Content, c.GetString(CONTEXT_USER), body.Message)
+	entry := audit.Entry{Action: "configuration.write", Instance: instance.ID, Target: c.Param("file"), Reason: body.Message}
+	if version != nil {
+		entry.Result = fmt.Sprintf("version %d", version.Number)
+	}
+	s.record(c, entry, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, version)
+}
+
+func (s *Server) listVersions(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	configuration, err := instance.Configurations().Get(c.Param("file"))
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, configuration.Versions)
+}
+
+func (s *Server) getVersion(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		fail(c, http.StatusBadRequest, fmt.Errorf("invalid version '%s'", c.Param("version")))
+		return
+	}
+
+	version, content, err := instance.Configurations().Version(c.Param("file"), number)
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"version": version, "content": content})
+}
+
+// diffConfiguration compares the versions in the from and to query
+// parameters, a missing or zero version means the file currently on disk.
+func (s *Server) diffConfiguration(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	from, err := strconv.Atoi(c.DefaultQuery("from", "0"))
+	if err != nil {
+		fail(c, http.StatusBadRequest, fmt.Errorf("invalid version '%s'", c.Query("from")))
+		return
+	}
+
+	to, err := strconv.Atoi(c.DefaultQuery("to", "0"))
+	if err != nil {
+		fail(c, http.StatusBadRequest, fmt.Errorf("invalid version '%s'", c.Query("to")))
+		return
+	}
+
+	diff, err := instance.Configurations().Diff(c.Param("file"), from, to)
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.String(http.StatusOK, diff)
+}
+
+func (s *Server) rollbackConfiguration(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		fail(c, http.StatusBadRequest, fmt.Errorf("invalid version '%s'", c.Param("version")))
+		return
+	}
+
+	version, err := instance.Configurations().Rollback(c.Param("file"), number, c.GetString(CONTEXT_USER))
+	entry := audit.Entry{Action: "configuration.rollback", Instance: instance.ID, Target: c.Param("file"), Parameters: map[string]string{"version": c.Param("version")}}
+	if version != nil {
+		entry.Result = fmt.Sprintf("version %d", version.Number)
+	}
+	s.record(c, entry, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, version)
+}
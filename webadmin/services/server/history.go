@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/players"
+)
+
+const (
+	PLAYERS_LIMIT     = 50
+	PLAYERS_MAX_LIMIT = 500
+	SESSIONS_LIMIT    = 50
+	LEADERBOARD_LIMIT = 20
+)
+
+// searchPlayers finds the players by SteamID or name with "q", on one
+// instance with "instance".
+func (s *Server) searchPlayers(c *gin.Context) {
+
+	filter := players.Filter{Query: c.Query("q"), Instance: c.Query("instance"), Limit: PLAYERS_LIMIT}
+	for name, value := range map[string]*int{"offset": &filter.Offset, "limit": &filter.Limit} {
+		n, ok := number(c, name, *value)
+		if !ok {
+			return
+		}
+		*value = n
+	}
+	if filter.Limit == 0 || filter.Limit > PLAYERS_MAX_LIMIT {
+		filter.Limit = PLAYERS_MAX_LIMIT
+	}
+
+	list, total, err := s.Players.Search(filter)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"total": total, "offset": filter.Offset, "limit": filter.Limit, "players": list})
+}
+
+func (s *Server) getPlayerProfile(c *gin.Context) {
+
+	limit, ok := number(c, "sessions", SESSIONS_LIMIT)
+	if !ok {
+		return
+	}
+
+	profile, err := s.Players.Get(c.Param("steamId"), limit)
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// leaderboard ranks the players of every instance, or of the one in the
+// path, by "by". The window is given by "since" and "until" in RFC 3339
+// or by "window", a duration such as "168h" ending now.
+func (s *Server) leaderboard(c *gin.Context) {
+
+	board := players.Board{Instance: c.Query("instance"), By: c.DefaultQuery("by", players.BY_KILLS)}
+	if c.Param("id") != "" {
+		instance := s.instance(c)
+		if instance == nil {
+			return
+		}
+		board.Instance = instance.ID
+	}
+
+	for name, value := range map[string]*time.Time{"since": &board.Since, "until": &board.Until} {
+		if c.Query(name) == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, c.Query(name))
+		if err != nil {
+			fail(c, http.StatusBadRequest, fmt.Errorf("invalid %s time '%s', expected RFC 3339", name, c.Query(name)))
+			return
+		}
+		*value = t
+	}
+	if window := c.Query("window"); window != "" {
+		duration, err := time.ParseDuration(window)
+		if err != nil || duration <= 0 {
+			fail(c, http.StatusBadRequest, fmt.Errorf("invalid window '%s'", window))
+			return
+		}
+		board.Until = time.Now().UTC()
+		board.Since = board.Until.Add(-duration)
+	}
+
+	var ok bool
+	if board.Limit, ok = number(c, "limit", LEADERBOARD_LIMIT); !ok {
+		return
+	}
+
+	ranks, err := s.Players.Leaderboard(board)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"instance": board.Instance, "by": board.By, "since": board.Since, "until": board.Until, "ranks": ranks})
+}
+
+// number reads a non negative number from the query string, failing the
+// request when it is not one.
+func number(c *gin.Context, name string, value int) (int, bool) {
+
+	if c.Query(name) == "" {
+		return value, true
+	}
+
+	n, err := strconv.Atoi(c.Query(name))
+	if err != nil || n < 0 {
+		fail(c, http.StatusBadRequest, fmt.Errorf("invalid %s '%s'", name, c.Query(name)))
+		return 0, false
+	}
+
+	return n, true
+}
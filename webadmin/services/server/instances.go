@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/importer"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+)
+
+func (s *Server) listInstances(c *gin.Context) {
+
+	c.JSON(http.StatusOK, s.Instances.List())
+}
+
+func (s *Server) getInstance(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, instance)
+}
+
+func (s *Server) startInstance(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	err := instance.Start()
+	s.record(c, audit.Entry{Action: "instance.start", Instance: instance.ID}, err)
+	if err != nil {
+		fail(c, http.StatusConflict, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, instance)
+}
+
+func (s *Server) stopInstance(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	err := instance.Stop()
+	s.record(c, audit.Entry{Action: "instance.stop", Instance: instance.ID}, err)
+	if err != nil {
+		fail(c, http.StatusConflict, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, instance)
+}
+
+func (s *Server) setRestartPolicy(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	var policy insurgency.RestartPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err := instance.SetRestartPolicy(policy)
+	if err == nil {
+		err = s.Instances.Save()
+	}
+	s.record(c, audit.Entry{Action: "instance.restart-policy", Instance: instance.ID, Parameters: map[string]string{"policy": policy.Policy}}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, instance.Restart)
+}
+
+func (s *Server) listCrashes(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	crashes, err := instance.Crashes()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, crashes)
+}
+
+// importInstance creates an instance from a server set up by hand, or
+// only reports what would be imported when preview is set.
+func (s *Server) importInstance(c *gin.Context) {
+
+	var source importer.Source
+	if err := c.ShouldBindJSON(&source); err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := s.Importer.Import(source, c.GetString(CONTEXT_USER))
+	if !source.Preview {
+		s.record(c, audit.Entry{Action: "instance.import", Instance: source.ID, Parameters: map[string]string{"dir": source.Dir, "script": source.Script}}, err)
+	}
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if source.Preview {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+)
+
+// listScenarios returns the scenario catalogue, with the scenarios of the
+// map cycle of an instance when one is given.
+func (s *Server) listScenarios(c *gin.Context) {
+
+	id := c.Query("instance")
+	if id == "" {
+		c.JSON(http.StatusOK, insurgency.NewCatalogue(nil))
+		return
+	}
+
+	instance, err := s.Instances.Get(id)
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, instance.Catalogue())
+}
+
+func (s *Server) travel(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	var body insurgency.Travel
+	if !bind(c, &body) {
+		return
+	}
+
+	command, err := instance.TravelCommand(body)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	_, out, err := instance.Travel(body)
+	s.record(c, audit.Entry{Action: "match.travel", Instance: instance.ID, Target: body.Scenario, Parameters: map[string]string{"lighting": body.Lighting, "mode": body.Mode, "command": command}, Result: out}, err)
+	if err != nil {
+		fail(c, http.StatusBadGateway, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"command": command, "result": out})
+}
+
+// nextMap travels to the scenario after the current one in the map cycle.
+func (s *Server) nextMap(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	travel, out, err := instance.NextMap()
+	s.record(c, audit.Entry{Action: "match.next", Instance: instance.ID, Target: travel.Scenario, Result: out}, err)
+	if err != nil {
+		fail(c, http.StatusBadGateway, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"scenario": travel.Scenario, "lighting": travel.Lighting, "result": out})
+}
+
+func (s *Server) restartRound(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	out, err := instance.RestartRound()
+	s.record(c, audit.Entry{Action: "match.restart-round", Instance: instance.ID, Result: out}, err)
+	if err != nil {
+		fail(c, http.StatusBadGateway, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": out})
+}
+
+func (s *Server) getProperty(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	name := c.Param("name")
+	if err := insurgency.CheckProperty(name, ""); err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	out, err := instance.Property(name)
+	if err != nil {
+		fail(c, http.StatusBadGateway, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"name": name, "result": out})
+}
+
+// setProperties changes game mode properties of the running game, given
+// by name with their values. They are all checked before any is set.
+func (s *Server) setProperties(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	body := make(map[string]string)
+	if !bind(c, &body) {
+		return
+	}
+	if len(body) == 0 {
+		fail(c, http.StatusBadRequest, fmt.Errorf("no game mode properties given"))
+		return
+	}
+
+	names := make([]string, 0, len(body))
+	for name, value := range body {
+		if err := insurgency.CheckProperty(name, value); err != nil {
+			fail(c, http.StatusBadRequest, err)
+			return
+		}
+		if value == "" {
+			fail(c, http.StatusBadRequest, fmt.Errorf("game mode property '%s' needs a value", name))
+			return
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make(map[string]string)
+	for _, name := range names {
+		out, err := instance.SetProperty(name, body[name])
+		s.record(c, audit.Entry{Action: "match.property", Instance: instance.ID, Target: name, Parameters: map[string]string{"value": body[name]}, Result: out}, err)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": err.Error(), "results": results})
+			return
+		}
+		results[name] = out
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
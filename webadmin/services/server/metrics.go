@@ -0,0 +1,185 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/metrics"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/monitor"
+)
+
+var states = []insurgency.State{
+	insurgency.STATE_STOPPED,
+	insurgency.STATE_STARTING,
+	insurgency.STATE_RUNNING,
+	insurgency.STATE_STOPPING,
+	insurgency.STATE_WAITING,
+}
+
+// observe counts every request by its registered route.
+func (s *Server) observe(c *gin.Context) {
+
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	s.requests.Observe(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+}
+
+// SetMetrics replaces the credentials of /metrics, the endpoint itself is
+// only added or removed on restart.
+func (s *Server) SetMetrics(metrics config.Metrics) {
+
+	s.mutex.Lock()
+	s.Metrics = metrics
+	s.mutex.Unlock()
+}
+
+// authenticateMetrics protects /metrics with its own credentials, a
+// bearer token or basic auth, so scrapers do not need an admin account.
+// Without credentials configured every request is refused.
+func (s *Server) authenticateMetrics(c *gin.Context) {
+
+	s.mutex.Lock()
+	credentials := s.Metrics
+	s.mutex.Unlock()
+
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") && credentials.Token != "" {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(credentials.Token)) == 1 {
+			c.Next()
+			return
+		}
+	}
+
+	if name, password, ok := c.Request.BasicAuth(); ok && credentials.Username != "" {
+		if subtle.ConstantTimeCompare([]byte(name), []byte(credentials.Username)) == 1 && subtle.ConstantTimeCompare([]byte(password), []byte(credentials.Password)) == 1 {
+			c.Next()
+			return
+		}
+	}
+
+	if credentials.Username != "" {
+		c.Header("WWW-Authenticate", "Basic realm=\"Sandstorm Web Admin metrics\"")
+	}
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+func (s *Server) serveMetrics(c *gin.Context) {
+
+	w := new(metrics.Writer)
+
+	s.requests.Write(w)
+	s.writeInstances(w)
+
+	if s.Steam != nil {
+		w.Metric("webadmin_steam_downloading", metrics.GAUGE, "Whether steamcmd is being downloaded.")
+		w.Sample("webadmin_steam_downloading", nil, metrics.Bool(s.Steam.Downloading))
+		w.Metric("webadmin_steam_updating", metrics.GAUGE, "Whether steamcmd is installing or updating.")
+		w.Sample("webadmin_steam_updating", nil, metrics.Bool(s.Steam.Updating))
+	}
+
+	if s.Ssl != nil && s.SslUse {
+		if certificate, err := s.Ssl.Details(); err == nil {
+			w.Metric("webadmin_certificate_expiry_timestamp_seconds", metrics.GAUGE, "Expiry time of the web admin certificate.")
+			w.Sample("webadmin_certificate_expiry_timestamp_seconds", nil, float64(certificate.NotAfter.Unix()))
+		}
+	}
+
+	if s.Monitor != nil {
+		disks := s.Monitor.Disks()
+		w.Metric("webadmin_disk_free_bytes", metrics.GAUGE, "Free space on the disks of the game and steamcmd directories.")
+		for _, disk := range disks {
+			if disk.Error == "" {
+				w.Sample("webadmin_disk_free_bytes", metrics.Labels{"path": disk.Path}, float64(disk.Free))
+			}
+		}
+		w.Metric("webadmin_disk_size_bytes", metrics.GAUGE, "Size of the disks of the game and steamcmd directories.")
+		for _, disk := range disks {
+			if disk.Error == "" {
+				w.Sample("webadmin_disk_size_bytes", metrics.Labels{"path": disk.Path}, float64(disk.Total))
+			}
+		}
+	}
+
+	c.Data(http.StatusOK, metrics.CONTENT_TYPE, w.Bytes())
+}
+
+func (s *Server) writeInstances(w *metrics.Writer) {
+
+	instances := s.Instances.List()
+
+	w.Metric("sandstorm_instance_up", metrics.GAUGE, "Whether the instance's server process is running.")
+	for _, instance := range instances {
+		w.Sample("sandstorm_instance_up", metrics.Labels{"instance": instance.ID}, metrics.Bool(instance.IsRunning()))
+	}
+
+	w.Metric("sandstorm_instance_state", metrics.GAUGE, "State of the instance, 1 for the current one.")
+	for _, instance := range instances {
+		state := instance.Status()
+		for _, s := range states {
+			w.Sample("sandstorm_instance_state", metrics.Labels{"instance": instance.ID, "state": string(s)}, metrics.Bool(s == state))
+		}
+	}
+
+	w.Metric("sandstorm_instance_restarts_total", metrics.COUNTER, "Automatic restarts of the instance.")
+	for _, instance := range instances {
+		w.Sample("sandstorm_instance_restarts_total", metrics.Labels{"instance": instance.ID}, float64(instance.RestartCount()))
+	}
+
+	w.Metric("sandstorm_instance_crashes_total", metrics.COUNTER, "Crashes of the instance's server process.")
+	for _, instance := range instances {
+		w.Sample("sandstorm_instance_crashes_total", metrics.Labels{"instance": instance.ID}, float64(instance.CrashCount()))
+	}
+
+	w.Metric("sandstorm_instance_rcon_errors_total", metrics.COUNTER, "Failed RCON connections and commands.")
+	for _, instance := range instances {
+		w.Sample("sandstorm_instance_rcon_errors_total", metrics.Labels{"instance": instance.ID}, float64(instance.RconErrors()))
+	}
+
+	if s.Monitor == nil {
+		return
+	}
+
+	samples := make(map[string]*monitor.Sample)
+	for _, instance := range instances {
+		if sample := s.Monitor.Latest(instance); sample != nil {
+			samples[instance.ID] = sample
+		}
+	}
+
+	gauges := []struct {
+		name  string
+		help  string
+		value func(*monitor.Sample) (float64, bool)
+	}{
+		{"sandstorm_instance_players", "Players connected, from the last A2S query.", func(m *monitor.Sample) (float64, bool) { return float64(m.Players), m.QueryUp }},
+		{"sandstorm_instance_max_players", "Player slots, from the last A2S query.", func(m *monitor.Sample) (float64, bool) { return float64(m.MaxPlayers), m.QueryUp }},
+		{"sandstorm_instance_query_up", "Whether the last A2S query was answered.", func(m *monitor.Sample) (float64, bool) { return metrics.Bool(m.QueryUp), true }},
+		{"sandstorm_instance_query_latency_seconds", "Latency of the last A2S query.", func(m *monitor.Sample) (float64, bool) { return m.QueryLatency, m.QueryUp }},
+		{"sandstorm_instance_cpu_percent", "CPU used by the server process, in percent of one core.", func(m *monitor.Sample) (float64, bool) { return m.Cpu, true }},
+		{"sandstorm_instance_memory_rss_bytes", "Resident memory of the server process.", func(m *monitor.Sample) (float64, bool) { return float64(m.Rss), true }},
+		{"sandstorm_instance_open_fds", "Open file descriptors of the server process.", func(m *monitor.Sample) (float64, bool) { return float64(m.Fds), true }},
+		{"sandstorm_instance_threads", "Threads of the server process.", func(m *monitor.Sample) (float64, bool) { return float64(m.Threads), true }},
+	}
+
+	for _, gauge := range gauges {
+		w.Metric(gauge.name, metrics.GAUGE, gauge.help)
+		for _, instance := range instances {
+			sample, ok := samples[instance.ID]
+			if !ok {
+				continue
+			}
+			if value, ok := gauge.value(sample); ok {
+				w.Sample(gauge.name, metrics.Labels{"instance": instance.ID}, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) instanceMetrics(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, s.Monitor.Metrics(instance))
+}
+
+func (s *Server) listDisks(c *gin.Context) {
+
+	c.JSON(http.StatusOK, s.Monitor.Disks())
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+)
+
+type playerAction struct {
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+	Message  string `json:"message"`
+}
+
+func (s *Server) listPlayers(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	players, err := instance.Players()
+	if err != nil {
+		fail(c, http.StatusBadGateway, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, players)
+}
+
+func (s *Server) kickPlayer(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	var body playerAction
+	if !bind(c, &body) {
+		return
+	}
+
+	steamID, ok := playerID(c)
+	if !ok {
+		return
+	}
+	out, err := instance.Kick(steamID, body.Reason)
+	s.record(c, audit.Entry{Action: "player.kick", Instance: instance.ID, Target: steamID, Reason: body.Reason, Result: out}, err)
+	if err != nil {
+		fail(c, http.StatusBadGateway, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": out})
+}
+
+// banPlayer bans permanently unless a duration such as "30m" or "24h" is
+// given, in which case the ban is temporary.
+func (s *Server) banPlayer(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	var body playerAction
+	if !bind(c, &body) {
+		return
+	}
+
+	var duration time.Duration
+	if body.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(body.Duration)
+		if err != nil || duration <= 0 {
+			fail(c, http.StatusBadRequest, fmt.Errorf("invalid ban duration '%s'", body.Duration))
+			return
+		}
+	}
+
+	action := "player.ban"
+	if duration > 0 {
+		action = "player.tempban"
+	}
+
+	steamID, ok := playerID(c)
+	if !ok {
+		return
+	}
+	out, err := instance.Ban(steamID, duration, body.Reason)
+	s.record(c, audit.Entry{Action: action, Instance: instance.ID, Target: steamID, Reason: body.Reason, Parameters: map[string]string{"duration": body.Duration}, Result: out}, err)
+	if err != nil {
+		fail(c, http.StatusBadGateway, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": out})
+}
+
+// messagePlayer would send a private message to a player. Sandstorm's
+// RCON only has say, which every player on the server reads, so the
+// request is refused rather than made public.
+func (s *Server) messagePlayer(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	var body playerAction
+	if !bind(c, &body) {
+		return
+	}
+
+	steamID, ok := playerID(c)
+	if !ok {
+		return
+	}
+
+	err := fmt.Errorf("private messages are not supported, the RCON of instance '%s' can only say a message to every player", instance.ID)
+	s.record(c, audit.Entry{Action: "player.message", Instance: instance.ID, Target: steamID, Reason: body.Reason, Parameters: map[string]string{"message": body.Message}}, err)
+	fail(c, http.StatusNotImplemented, err)
+}
+
+// playerID returns the SteamID of the request path, failing the request
+// when it is not a SteamID64.
+func playerID(c *gin.Context) (string, bool) {
+
+	steamID := c.Param("steamId")
+	if !insurgency.IsSteamID(steamID) {
+		fail(c, http.StatusBadRequest, fmt.Errorf("'%s' is not a SteamID", steamID))
+		return "", false
+	}
+
+	return steamID, true
+}
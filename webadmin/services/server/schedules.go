@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/scheduler"
+)
+
+func (s *Server) listSchedules(c *gin.Context) {
+
+	c.JSON(http.StatusOK, s.Scheduler.List(c.Query("instance")))
+}
+
+func (s *Server) getSchedule(c *gin.Context) {
+
+	schedule, err := s.Scheduler.Get(c.Param("id"))
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (s *Server) addSchedule(c *gin.Context) {
+
+	schedule := new(scheduler.Schedule)
+	if err := c.ShouldBindJSON(schedule); err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err := s.Scheduler.Add(schedule)
+	s.record(c, audit.Entry{Action: "schedule.add", Instance: schedule.Instance, Target: schedule.ID, Parameters: map[string]string{"task": schedule.Task, "cron": schedule.Cron, "command": schedule.Command}}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, schedule)
+}
+
+func (s *Server) updateSchedule(c *gin.Context) {
+
+	schedule := new(scheduler.Schedule)
+	if err := c.ShouldBindJSON(schedule); err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err := s.Scheduler.Update(c.Param("id"), schedule)
+	s.record(c, audit.Entry{Action: "schedule.update", Instance: schedule.Instance, Target: c.Param("id"), Parameters: map[string]string{"task": schedule.Task, "cron": schedule.Cron, "command": schedule.Command}}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (s *Server) removeSchedule(c *gin.Context) {
+
+	err := s.Scheduler.Remove(c.Param("id"))
+	s.record(c, audit.Entry{Action: "schedule.remove", Target: c.Param("id")}, err)
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) runSchedule(c *gin.Context) {
+
+	if _, err := s.Scheduler.Get(c.Param("id")); err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	run, err := s.Scheduler.RunNow(c.Param("id"), c.GetString(CONTEXT_USER))
+	if err != nil {
+		fail(c, http.StatusConflict, err)
+		return
+	}
+
+	s.record(c, audit.Entry{Action: "schedule.run", Instance: run.Instance, Target: run.Schedule, Parameters: map[string]string{"task": run.Task}, Result: run.Result, Error: run.Error}, nil)
+
+	c.JSON(http.StatusOK, run)
+}
+
+func (s *Server) listScheduleRuns(c *gin.Context) {
+
+	c.JSON(http.StatusOK, s.Scheduler.Runs(c.Param("id"), c.Query("instance")))
+}
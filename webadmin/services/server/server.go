@@ -1,10 +1,336 @@
 package server
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/announcer"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/backup"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/bundle"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/chat"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/importer"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/metrics"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/monitor"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/players"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/scheduler"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/ssl"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/steam"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/templates"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/users"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/webhooks"
+)
+
 type Server struct {
-	Address   string `json:"address"`
-	Port      int    `json:"port"`
-	SslUse    int    `json:"sslUse"`
-	SslVerify int    `json:"sslVerify"`
-	SslCert   int    `json:"sslCert"`
-	SslKey    int    `json:"sslKey"`
+	Address   string   `json:"address"`
+	Port      int      `json:"port"`
+	SslUse    bool     `json:"sslUse"`
+	SslCert   string   `json:"sslCert"`
+	SslKey    string   `json:"sslKey"`
+	Proxies   []string `json:"proxies"`
+	Metrics   config.Metrics
+	Instances *insurgency.Instances
+	Users     *users.Users
+	Audit     *audit.Audit
+	Scheduler *scheduler.Scheduler
+	Backups   *backup.Backups
+	Ssl       *ssl.Ssl
+	Monitor   *monitor.Monitor
+	Steam     *steam.Steam
+	Webhooks  *webhooks.Webhooks
+	Players   *players.Players
+	Chat      *chat.Chat
+	Announcer *announcer.Announcer
+	Templates *templates.Templates
+	Importer  *importer.Importer
+	Bundles   *bundle.Bundles
+	Store     *store.Store
+	Ready     func()
+	router    *gin.Engine
+	requests  *metrics.Requests
+	http      *http.Server
+	log       *admin_log.Log
+	mutex     sync.Mutex
+}
+
+const (
+	MODULE = "server"
+
+	CONTEXT_USER = "user"
+)
+
+func New(conf *config.Configuration, log *admin_log.Log) *Server {
+
+	s := new(Server)
+	s.Address = conf.WebAdmin.Address
+	s.Port = conf.WebAdmin.Port
+	s.SslUse = conf.WebAdmin.SslUse
+	s.SslCert = conf.WebAdmin.SslCert
+	s.SslKey = conf.WebAdmin.SslKey
+	s.Proxies = conf.WebAdmin.TrustedProxies
+	s.Metrics = conf.Metrics
+	s.requests = metrics.NewRequests()
+	s.log = log
+
+	return s
+}
+
+func (s *Server) Run() error {
+
+	gin.SetMode(gin.ReleaseMode)
+	s.router = gin.New()
+	s.router.Use(gin.Recovery(), s.observe)
+	// the client address is only read from X-Forwarded-For when a trusted
+	// proxy sent the request, no proxy is trusted by default
+	if err := s.router.SetTrustedProxies(s.Proxies); err != nil {
+		return s.log.Write(fmt.Sprintf("invalid trusted proxies. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	s.routes()
+
+	address := fmt.Sprintf("%s:%d", s.Address, s.Port)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return s.log.Write(fmt.Sprintf("failed to listen on '%s'. ERR: %s", address, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	s.log.Write(fmt.Sprintf("listening on '%s'", address), MODULE, admin_log.LOG_INFO)
+
+	s.mutex.Lock()
+	s.http = &http.Server{Addr: address, Handler: s.router}
+	if s.SslUse && s.Ssl != nil {
+		// the certificate is served from memory so it can be replaced while running
+		s.http.TLSConfig = s.Ssl.TLSConfig()
+	}
+	server := s.http
+	s.mutex.Unlock()
+
+	if s.Ready != nil {
+		s.Ready()
+	}
+
+	if s.SslUse && s.Ssl != nil {
+		err = server.ServeTLS(listener, "", "")
+	} else if s.SslUse {
+		err = server.ServeTLS(listener, s.SslCert, s.SslKey)
+	} else {
+		err = server.Serve(listener)
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return s.log.Write(fmt.Sprintf("failed to serve on '%s'. ERR: %s", address, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	return nil
+}
+
+// Shutdown stops accepting connections and waits for the running requests
+// until the context is done. Run returns once it is called.
+func (s *Server) Shutdown(ctx context.Context) error {
+
+	s.mutex.Lock()
+	server := s.http
+	s.mutex.Unlock()
+
+	if server == nil {
+		return nil
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		return s.log.Write(fmt.Sprintf("failed to shut down the server. ERR: %s", err.Error()), MODULE, admin_log.LOG_WARNING)
+	}
+
+	return nil
+}
+
+func (s *Server) routes() {
+
+	if s.Metrics.Use {
+		s.router.GET("/metrics", s.authenticateMetrics, s.serveMetrics)
+	}
+
+	v1 := s.router.Group("/api/v1", s.authenticate)
+	{
+		v1.GET("/instances", s.listInstances)
+		v1.POST("/instances/import", s.importInstance)
+		v1.POST("/instances/bundle", s.importBundle)
+		v1.GET("/instances/:id", s.getInstance)
+		v1.POST("/instances/:id/start", s.startInstance)
+		v1.POST("/instances/:id/stop", s.stopInstance)
+		v1.PUT("/instances/:id/restart-policy", s.setRestartPolicy)
+		v1.GET("/instances/:id/crashes", s.listCrashes)
+		v1.GET("/instances/:id/metrics", s.instanceMetrics)
+		v1.GET("/instances/:id/bundle", s.exportBundle)
+
+		v1.GET("/instances/:id/backups", s.listBackups)
+		v1.POST("/instances/:id/backups", s.createBackup)
+		v1.GET("/instances/:id/backups/:name", s.downloadBackup)
+		v1.POST("/instances/:id/backups/:name/restore", s.restoreBackup)
+		v1.DELETE("/instances/:id/backups/:name", s.removeBackup)
+
+		v1.GET("/instances/:id/configurations", s.listConfigurations)
+		v1.GET("/instances/:id/configurations/:file", s.readConfiguration)
+		v1.PUT("/instances/:id/configurations/:file", s.writeConfiguration)
+		v1.GET("/instances/:id/configurations/:file/versions", s.listVersions)
+		v1.GET("/instances/:id/configurations/:file/versions/:version", s.getVersion)
+		v1.POST("/instances/:id/configurations/:file/versions/:version/rollback", s.rollbackConfiguration)
+		v1.GET("/instances/:id/configurations/:file/diff", s.diffConfiguration)
+
+		v1.GET("/instances/:id/players", s.listPlayers)
+		v1.POST("/instances/:id/players/:steamId/kick", s.kickPlayer)
+		v1.POST("/instances/:id/players/:steamId/ban", s.banPlayer)
+		v1.POST("/instances/:id/players/:steamId/message", s.messagePlayer)
+		v1.GET("/instances/:id/leaderboard", s.leaderboard)
+		v1.GET("/instances/:id/chat", s.chatHistory)
+		v1.GET("/instances/:id/chat/stream", s.streamChat)
+		v1.POST("/instances/:id/chat", s.sendChat)
+		v1.GET("/instances/:id/announcements", s.getAnnouncements)
+		v1.PUT("/instances/:id/announcements", s.setAnnouncements)
+		v1.DELETE("/instances/:id/announcements", s.removeAnnouncements)
+		v1.GET("/instances/:id/announcements/preview", s.previewAnnouncements)
+		v1.POST("/instances/:id/match/travel", s.travel)
+		v1.POST("/instances/:id/match/next", s.nextMap)
+		v1.POST("/instances/:id/match/restart-round", s.restartRound)
+		v1.GET("/instances/:id/match/properties/:name", s.getProperty)
+		v1.PUT("/instances/:id/match/properties", s.setProperties)
+
+		v1.GET("/scenarios", s.listScenarios)
+		v1.GET("/bundles/key", s.bundleKey)
+
+		v1.GET("/templates", s.listTemplates)
+		v1.POST("/templates", s.addTemplate)
+		v1.GET("/templates/:id", s.getTemplate)
+		v1.PUT("/templates/:id", s.updateTemplate)
+		v1.DELETE("/templates/:id", s.removeTemplate)
+		v1.POST("/templates/:id/instances", s.createFromTemplate)
+		v1.POST("/instances/:id/templates", s.templateFromInstance)
+		v1.GET("/instances/:id/templates/:template/diff", s.previewTemplate)
+		v1.POST("/instances/:id/templates/:template/apply", s.applyTemplate)
+
+		v1.GET("/players", s.searchPlayers)
+		v1.GET("/players/leaderboard", s.leaderboard)
+		v1.GET("/players/:steamId", s.getPlayerProfile)
+
+		v1.GET("/chat", s.chatHistory)
+		v1.GET("/chat/stream", s.streamChat)
+		v1.POST("/chat", s.broadcastChat)
+		v1.GET("/chat/filter", s.getChatFilter)
+		v1.PUT("/chat/filter", s.setChatFilter)
+
+		v1.GET("/host/disks", s.listDisks)
+
+		v1.GET("/schedules", s.listSchedules)
+		v1.POST("/schedules", s.addSchedule)
+		v1.GET("/schedules/runs", s.listScheduleRuns)
+		v1.GET("/schedules/:id", s.getSchedule)
+		v1.PUT("/schedules/:id", s.updateSchedule)
+		v1.DELETE("/schedules/:id", s.removeSchedule)
+		v1.POST("/schedules/:id/run", s.runSchedule)
+		v1.GET("/schedules/:id/runs", s.listScheduleRuns)
+
+		v1.GET("/audit", s.listAudit)
+		v1.GET("/audit/export", s.exportAudit)
+		v1.GET("/audit/verify", s.verifyAudit)
+
+		v1.GET("/store/export", s.exportStore)
+
+		v1.GET("/events", s.listEventTypes)
+		v1.GET("/webhooks", s.listWebhooks)
+		v1.POST("/webhooks", s.addWebhook)
+		v1.GET("/webhooks/deliveries", s.listDeliveries)
+		v1.GET("/webhooks/:id", s.getWebhook)
+		v1.PUT("/webhooks/:id", s.updateWebhook)
+		v1.DELETE("/webhooks/:id", s.removeWebhook)
+		v1.POST("/webhooks/:id/test", s.testWebhook)
+		v1.GET("/webhooks/:id/deliveries", s.listDeliveries)
+
+		v1.GET("/ssl", s.getCertificate)
+		v1.POST("/ssl", s.replaceCertificate)
+		v1.GET("/ssl/ca", s.getAuthority)
+		v1.GET("/ssl/clients", s.listClients)
+		v1.POST("/ssl/clients", s.addClient)
+		v1.DELETE("/ssl/clients/:id", s.removeClient)
+		v1.POST("/users/:name/certificates", s.issueClient)
+	}
+}
+
+func (s *Server) authenticate(c *gin.Context) {
+
+	// a verified client certificate mapped to a user replaces the password
+	if s.Ssl != nil && s.Ssl.Mtls.Use && c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+		name, ok := s.Ssl.ClientUser(c.Request.TLS.VerifiedChains[0][0])
+		if ok && s.Users != nil && s.Users.Exists(name) {
+			c.Set(CONTEXT_USER, name)
+			c.Next()
+			return
+		}
+		if s.Ssl.Mtls.Require {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "client certificate is not mapped to a user"})
+			return
+		}
+	}
+
+	name, password, ok := c.Request.BasicAuth()
+	if !ok || s.Users == nil || !s.Users.Authenticate(name, password) {
+		c.Header("WWW-Authenticate", "Basic realm=\"Sandstorm Web Admin\"")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	c.Set(CONTEXT_USER, name)
+	c.Next()
+}
+
+func (s *Server) record(c *gin.Context, entry audit.Entry, err error) {
+
+	if s.Audit == nil {
+		return
+	}
+
+	entry.User = c.GetString(CONTEXT_USER)
+	entry.Address = c.ClientIP()
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	s.Audit.Record(entry)
+}
+
+func (s *Server) instance(c *gin.Context) *insurgency.Instance {
+
+	instance, err := s.Instances.Get(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil
+	}
+
+	return instance
+}
+
+func fail(c *gin.Context, status int, err error) {
+
+	c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+}
+
+// bind decodes an optional JSON body, an empty body leaves obj untouched.
+func bind(c *gin.Context, obj any) bool {
+
+	if c.Request.ContentLength == 0 {
+		return true
+	}
+
+	if err := c.ShouldBindJSON(obj); err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return false
+	}
+
+	return true
 }
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/ssl"
+)
+
+type certificateRequest struct {
+	Certificate string `json:"certificate" binding:"required"`
+	Key         string `json:"key" binding:"required"`
+}
+
+type issueRequest struct {
+	Validity int `json:"validity"`
+}
+
+func (s *Server) getCertificate(c *gin.Context) {
+
+	if s.Ssl == nil || !s.SslUse {
+		fail(c, http.StatusNotFound, fmt.Errorf("ssl is not enabled"))
+		return
+	}
+
+	certificate, err := s.Ssl.Details()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, certificate)
+}
+
+func (s *Server) replaceCertificate(c *gin.Context) {
+
+	if s.Ssl == nil || !s.SslUse {
+		fail(c, http.StatusNotFound, fmt.Errorf("ssl is not enabled"))
+		return
+	}
+
+	request := new(certificateRequest)
+	if err := c.ShouldBindJSON(request); err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err := s.Ssl.Replace([]byte(request.Certificate), []byte(request.Key))
+	s.record(c, audit.Entry{Action: "ssl.replace"}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	certificate, err := s.Ssl.Details()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, certificate)
+}
+
+func (s *Server) getAuthority(c *gin.Context) {
+
+	if s.Ssl == nil || !s.SslUse {
+		fail(c, http.StatusNotFound, fmt.Errorf("ssl is not enabled"))
+		return
+	}
+
+	data, err := s.Ssl.Authority()
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=\"web_admin_ca.pem\"")
+	c.Data(http.StatusOK, "application/x-pem-file", data)
+}
+
+func (s *Server) listClients(c *gin.Context) {
+
+	if s.Ssl == nil || !s.Ssl.Mtls.Use {
+		fail(c, http.StatusNotFound, fmt.Errorf("mutual TLS is not enabled"))
+		return
+	}
+
+	c.JSON(http.StatusOK, s.Ssl.Clients())
+}
+
+func (s *Server) addClient(c *gin.Context) {
+
+	if s.Ssl == nil || !s.Ssl.Mtls.Use {
+		fail(c, http.StatusNotFound, fmt.Errorf("mutual TLS is not enabled"))
+		return
+	}
+
+	mapping := new(ssl.ClientMapping)
+	if err := c.ShouldBindJSON(mapping); err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.Users.Exists(mapping.User) {
+		fail(c, http.StatusBadRequest, fmt.Errorf("user '%s' not found", mapping.User))
+		return
+	}
+
+	err := s.Ssl.AddClient(mapping)
+	s.record(c, audit.Entry{Action: "ssl.client.add", Target: mapping.User, Parameters: map[string]string{"fingerprint": mapping.Fingerprint, "subject": mapping.Subject}}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, mapping)
+}
+
+func (s *Server) removeClient(c *gin.Context) {
+
+	if s.Ssl == nil || !s.Ssl.Mtls.Use {
+		fail(c, http.StatusNotFound, fmt.Errorf("mutual TLS is not enabled"))
+		return
+	}
+
+	err := s.Ssl.RemoveClient(c.Param("id"))
+	s.record(c, audit.Entry{Action: "ssl.client.remove", Target: c.Param("id")}, err)
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) issueClient(c *gin.Context) {
+
+	if s.Ssl == nil || !s.Ssl.Mtls.Use {
+		fail(c, http.StatusNotFound, fmt.Errorf("mutual TLS is not enabled"))
+		return
+	}
+
+	name := c.Param("name")
+	if !s.Users.Exists(name) {
+		fail(c, http.StatusNotFound, fmt.Errorf("user '%s' not found", name))
+		return
+	}
+
+	request := new(issueRequest)
+	if !bind(c, request) {
+		return
+	}
+
+	certificate, err := s.Ssl.IssueClient(name, request.Validity)
+	parameters := map[string]string{}
+	if certificate != nil {
+		parameters["fingerprint"] = certificate.Mapping.Fingerprint
+	}
+	s.record(c, audit.Entry{Action: "ssl.client.issue", Target: name, Parameters: parameters}, err)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, certificate)
+}
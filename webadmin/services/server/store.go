@@ -0,0 +1,24 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+)
+
+// exportStore downloads the whole store. Importing it is only possible
+// from the command line while the web admin is stopped.
+func (s *Server) exportStore(c *gin.Context) {
+
+	s.record(c, audit.Entry{Action: "store.export"}, nil)
+
+	c.Header("Content-Type", "application/json")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"web_admin-%s.json\"", time.Now().Format("20060102-150405")))
+	c.Status(http.StatusOK)
+	if err := s.Store.Export(c.Writer); err != nil {
+		c.Error(err)
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/templates"
+)
+
+type templateInstance struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Preview     bool   `json:"preview"`
+}
+
+func (s *Server) listTemplates(c *gin.Context) {
+
+	list, err := s.Templates.List()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func (s *Server) getTemplate(c *gin.Context) {
+
+	template, err := s.Templates.Get(c.Param("id"))
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+func (s *Server) addTemplate(c *gin.Context) {
+
+	var body templates.Template
+	if err := c.ShouldBindJSON(&body); err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := s.Templates.Get(body.ID); err == nil {
+		fail(c, http.StatusConflict, fmt.Errorf("template '%s' already exists", body.ID))
+		return
+	}
+
+	template, err := s.Templates.Save(body)
+	s.record(c, audit.Entry{Action: "template.add", Target: body.ID}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, template)
+}
+
+func (s *Server) updateTemplate(c *gin.Context) {
+
+	if _, err := s.Templates.Get(c.Param("id")); err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	var body templates.Template
+	if err := c.ShouldBindJSON(&body); err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+	body.ID = c.Param("id")
+
+	template, err := s.Templates.Save(body)
+	s.record(c, audit.Entry{Action: "template.update", Target: body.ID}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+func (s *Server) removeTemplate(c *gin.Context) {
+
+	id := c.Param("id")
+	if _, err := s.Templates.Get(id); err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	err := s.Templates.Remove(id)
+	s.record(c, audit.Entry{Action: "template.remove", Target: id}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// templateFromInstance saves the definition and server files of an
+// instance as a new template.
+func (s *Server) templateFromInstance(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	var body templateInstance
+	if err := c.ShouldBindJSON(&body); err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := s.Templates.Get(body.ID); err == nil {
+		fail(c, http.StatusConflict, fmt.Errorf("template '%s' already exists", body.ID))
+		return
+	}
+
+	template, err := s.Templates.FromInstance(instance, body.ID, body.Name, body.Description)
+	s.record(c, audit.Entry{Action: "template.from-instance", Instance: instance.ID, Target: body.ID}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, template)
+}
+
+// createFromTemplate adds an instance made from a template, or only
+// returns it with the files it would write when preview is set.
+func (s *Server) createFromTemplate(c *gin.Context) {
+
+	template, err := s.Templates.Get(c.Param("id"))
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	var body templateInstance
+	if err := c.ShouldBindJSON(&body); err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if body.Preview {
+		instance, plan, err := s.Templates.Preview(template, body.ID, body.Name)
+		if err != nil {
+			fail(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"instance": instance, "plan": plan})
+		return
+	}
+
+	instance, plan, err := s.Templates.Create(template, body.ID, body.Name, c.GetString(CONTEXT_USER))
+	s.record(c, audit.Entry{Action: "instance.create", Instance: body.ID, Parameters: map[string]string{"template": template.ID}}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"instance": instance, "plan": plan})
+}
+
+// previewTemplate returns what applying a template would change on an
+// instance.
+func (s *Server) previewTemplate(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	template, err := s.Templates.Get(c.Param("template"))
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	plan, err := s.Templates.Plan(template, instance)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+func (s *Server) applyTemplate(c *gin.Context) {
+
+	instance := s.instance(c)
+	if instance == nil {
+		return
+	}
+
+	template, err := s.Templates.Get(c.Param("template"))
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	plan, err := s.Templates.Apply(template, instance, c.GetString(CONTEXT_USER))
+	s.record(c, audit.Entry{Action: "template.apply", Instance: instance.ID, Target: template.ID}, err)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/audit"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/webhooks"
+)
+
+func (s *Server) listWebhooks(c *gin.Context) {
+
+	c.JSON(http.StatusOK, s.Webhooks.List())
+}
+
+func (s *Server) listEventTypes(c *gin.Context) {
+
+	c.JSON(http.StatusOK, events.Types)
+}
+
+func (s *Server) getWebhook(c *gin.Context) {
+
+	target, err := s.Webhooks.Get(c.Param("id"))
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, target)
+}
+
+func (s *Server) addWebhook(c *gin.Context) {
+
+	target := new(webhooks.Target)
+	if !bind(c, target) {
+		return
+	}
+
+	err := s.Webhooks.Add(target)
+	s.record(c, audit.Entry{Action: "webhook.add", Target: target.ID, Parameters: webhookParameters(target)}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	s.respondWebhook(c, http.StatusCreated, target.ID)
+}
+
+func (s *Server) updateWebhook(c *gin.Context) {
+
+	target := new(webhooks.Target)
+	if !bind(c, target) {
+		return
+	}
+
+	err := s.Webhooks.Update(c.Param("id"), target)
+	s.record(c, audit.Entry{Action: "webhook.update", Target: c.Param("id"), Parameters: webhookParameters(target)}, err)
+	if err != nil {
+		fail(c, http.StatusBadRequest, err)
+		return
+	}
+
+	s.respondWebhook(c, http.StatusOK, target.ID)
+}
+
+func (s *Server) removeWebhook(c *gin.Context) {
+
+	err := s.Webhooks.Remove(c.Param("id"))
+	s.record(c, audit.Entry{Action: "webhook.remove", Target: c.Param("id")}, err)
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) testWebhook(c *gin.Context) {
+
+	delivery, err := s.Webhooks.Test(c.Param("id"), c.GetString(CONTEXT_USER))
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+func (s *Server) listDeliveries(c *gin.Context) {
+
+	target := c.Param("id")
+	if target == "" {
+		target = c.Query("webhook")
+	}
+
+	c.JSON(http.StatusOK, s.Webhooks.DeliveryLog(target))
+}
+
+// respondWebhook answers with the stored target so the secret is masked.
+func (s *Server) respondWebhook(c *gin.Context, status int, id string) {
+
+	target, err := s.Webhooks.Get(id)
+	if err != nil {
+		fail(c, http.StatusNotFound, err)
+		return
+	}
+
+	c.JSON(status, target)
+}
+
+func webhookParameters(target *webhooks.Target) map[string]string {
+
+	return map[string]string{
+		"name":      target.Name,
+		"format":    target.Format,
+		"events":    strings.Join(target.Events, ","),
+		"instances": strings.Join(target.Instances, ","),
+	}
+}
@@ -0,0 +1,397 @@
+package ssl
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	ACME_DIR          = "acme"
+	ACME_ACCOUNT_KEY  = "account_key.pem"
+	ACME_ACCOUNT_INFO = "account.json"
+	ACME_TIMEOUT      = 5 * time.Minute
+
+	CHALLENGE_HTTP_01     = "http-01"
+	CHALLENGE_TLS_ALPN_01 = "tls-alpn-01"
+)
+
+type acmeAccount struct {
+	URI          string    `json:"uri"`
+	DirectoryUrl string    `json:"directoryUrl"`
+	Email        string    `json:"email"`
+	Created      time.Time `json:"created"`
+}
+
+// obtain requests a certificate for the configured domains from the ACME
+// directory. When listen is set a temporary TLS listener answers the
+// tls-alpn-01 challenge because the web admin is not serving yet.
+func (s *Ssl) obtain(listen bool) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), ACME_TIMEOUT)
+	defer cancel()
+
+	s.log.Write(fmt.Sprintf("requesting certificate for '%s' from '%s'", strings.Join(s.Acme.Domains, ", "), s.Acme.DirectoryUrl), MODULE, admin_log.LOG_INFO)
+
+	client, err := s.acmeClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch s.Acme.Challenge {
+	case CHALLENGE_HTTP_01:
+		stop, err := s.serveHttpChallenges()
+		if err != nil {
+			return err
+		}
+		defer stop()
+	case CHALLENGE_TLS_ALPN_01:
+		if listen {
+			stop, err := s.serveAlpnChallenges()
+			if err != nil {
+				return err
+			}
+			defer stop()
+		}
+	default:
+		return fmt.Errorf("unsupported ACME challenge '%s'", s.Acme.Challenge)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(s.Acme.Domains...))
+	if err != nil {
+		return fmt.Errorf("failed to create order. ERR: %s", err.Error())
+	}
+
+	for _, url := range order.AuthzURLs {
+		if err := s.authorize(ctx, client, url); err != nil {
+			return err
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("order was not authorized. ERR: %s", err.Error())
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate key. ERR: %s", err.Error())
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: s.Acme.Domains[0]},
+		DNSNames: s.Acme.Domains,
+	}, key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate request. ERR: %s", err.Error())
+	}
+
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("failed to finalize order. ERR: %s", err.Error())
+	}
+
+	certPem := make([]byte, 0)
+	for _, der := range chain {
+		certPem = append(certPem, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal certificate key. ERR: %s", err.Error())
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+
+	if err := s.write(certPem, keyPem, false); err != nil {
+		return err
+	}
+
+	s.log.Write(fmt.Sprintf("certificate for '%s' issued by '%s'", strings.Join(s.Acme.Domains, ", "), s.Acme.DirectoryUrl), MODULE, admin_log.LOG_INFO)
+
+	return nil
+}
+
+// authorize answers the configured challenge of one authorization and waits
+// for the ACME server to validate it.
+func (s *Ssl) authorize(ctx context.Context, client *acme.Client, url string) error {
+
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("failed to get authorization '%s'. ERR: %s", url, err.Error())
+	}
+
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	domain := authz.Identifier.Value
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == s.Acme.Challenge {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("the ACME server does not offer the %s challenge for '%s'", s.Acme.Challenge, domain)
+	}
+
+	switch challenge.Type {
+	case CHALLENGE_HTTP_01:
+		response, err := client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return fmt.Errorf("failed to create http-01 response for '%s'. ERR: %s", domain, err.Error())
+		}
+		s.mutex.Lock()
+		s.tokens[challenge.Token] = response
+		s.mutex.Unlock()
+		defer func() {
+			s.mutex.Lock()
+			delete(s.tokens, challenge.Token)
+			s.mutex.Unlock()
+		}()
+	case CHALLENGE_TLS_ALPN_01:
+		cert, err := client.TLSALPN01ChallengeCert(challenge.Token, domain)
+		if err != nil {
+			return fmt.Errorf("failed to create tls-alpn-01 certificate for '%s'. ERR: %s", domain, err.Error())
+		}
+		s.mutex.Lock()
+		s.challenges[domain] = &cert
+		s.mutex.Unlock()
+		defer func() {
+			s.mutex.Lock()
+			delete(s.challenges, domain)
+			s.mutex.Unlock()
+		}()
+	}
+
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("failed to accept %s challenge for '%s'. ERR: %s", challenge.Type, domain, err.Error())
+	}
+
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("authorization for '%s' failed. ERR: %s", domain, err.Error())
+	}
+
+	s.log.Write(fmt.Sprintf("domain '%s' authorized", domain), MODULE, admin_log.LOG_INFO)
+
+	return nil
+}
+
+// acmeClient returns a client for the configured directory with a
+// registered account, creating the account key on first use.
+func (s *Ssl) acmeClient(ctx context.Context) (*acme.Client, error) {
+
+	dir := filepath.Join(s.ConfigDir, ACME_DIR)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory '%s'. ERR: %s", dir, err.Error())
+	}
+
+	key, err := s.accountKey(filepath.Join(dir, ACME_ACCOUNT_KEY))
+	if err != nil {
+		return nil, err
+	}
+
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: s.Acme.DirectoryUrl,
+		UserAgent:    "sandstorm-web-admin",
+	}
+
+	// test servers like Pebble use their own certificate authority
+	if s.Acme.CaCert != "" {
+		data, err := os.ReadFile(s.Acme.CaCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ACME CA certificate '%s'. ERR: %s", s.Acme.CaCert, err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in '%s'", s.Acme.CaCert)
+		}
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		}
+	}
+
+	info := filepath.Join(dir, ACME_ACCOUNT_INFO)
+	if utils.FileExists(info) {
+		account := acmeAccount{}
+		data, err := os.ReadFile(info)
+		if err == nil {
+			err = json.Unmarshal(data, &account)
+		}
+		if err != nil {
+			s.log.Write(fmt.Sprintf("failed to read ACME account information '%s'. ERR: %s", info, err.Error()), MODULE, admin_log.LOG_WARNING)
+		} else if account.DirectoryUrl == s.Acme.DirectoryUrl && account.URI != "" {
+			client.KID = acme.KeyID(account.URI)
+			return client, nil
+		}
+	}
+
+	account := &acme.Account{}
+	if s.Acme.Email != "" {
+		account.Contact = []string{"mailto:" + s.Acme.Email}
+	}
+
+	registered, err := client.Register(ctx, account, acme.AcceptTOS)
+	if err == acme.ErrAccountAlreadyExists {
+		registered, err = client.GetReg(ctx, "")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to register ACME account. ERR: %s", err.Error())
+	}
+
+	s.log.Write(fmt.Sprintf("ACME account '%s' registered", registered.URI), MODULE, admin_log.LOG_INFO)
+
+	data, err := json.MarshalIndent(acmeAccount{URI: registered.URI, DirectoryUrl: s.Acme.DirectoryUrl, Email: s.Acme.Email, Created: time.Now()}, "", "  ")
+	if err == nil {
+		err = os.WriteFile(info, data, 0600)
+	}
+	if err != nil {
+		s.log.Write(fmt.Sprintf("failed to save ACME account information '%s'. ERR: %s", info, err.Error()), MODULE, admin_log.LOG_WARNING)
+	}
+
+	return client, nil
+}
+
+func (s *Ssl) accountKey(path string) (crypto.Signer, error) {
+
+	if utils.FileExists(path) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ACME account key '%s'. ERR: %s", path, err.Error())
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid ACME account key '%s'", path)
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACME account key '%s'. ERR: %s", path, err.Error())
+		}
+		return key, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ACME account key. ERR: %s", err.Error())
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ACME account key. ERR: %s", err.Error())
+	}
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write ACME account key '%s'. ERR: %s", path, err.Error())
+	}
+
+	s.log.Write(fmt.Sprintf("ACME account key created at '%s'", path), MODULE, admin_log.LOG_INFO)
+
+	return key, nil
+}
+
+// serveHttpChallenges answers http-01 challenges on the configured address
+// until the returned function is called.
+func (s *Ssl) serveHttpChallenges() (func(), error) {
+
+	listener, err := net.Listen("tcp", s.Acme.HttpAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on '%s' for http-01 challenges. ERR: %s", s.Acme.HttpAddress, err.Error())
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(s.httpChallenge), ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+
+	return func() { server.Close() }, nil
+}
+
+func (s *Ssl) httpChallenge(w http.ResponseWriter, r *http.Request) {
+
+	token := strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/")
+
+	s.mutex.RLock()
+	response, ok := s.tokens[token]
+	s.mutex.RUnlock()
+
+	if !ok || token == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(response))
+}
+
+// serveAlpnChallenges answers tls-alpn-01 challenges on the web admin
+// address until the returned function is called.
+func (s *Ssl) serveAlpnChallenges() (func(), error) {
+
+	address := net.JoinHostPort(s.Address, fmt.Sprintf("%d", s.Port))
+	listener, err := tls.Listen("tcp", address, s.TLSConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on '%s' for tls-alpn-01 challenges. ERR: %s", address, err.Error())
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}(conn)
+		}
+	}()
+
+	return func() { listener.Close() }, nil
+}
+
+// alpnChallenge returns the tls-alpn-01 certificate for a handshake made by
+// the ACME server, or nil for regular clients.
+func (s *Ssl) alpnChallenge(hello *tls.ClientHelloInfo) *tls.Certificate {
+
+	for _, proto := range hello.SupportedProtos {
+		if proto == acme.ALPNProto {
+			s.mutex.RLock()
+			defer s.mutex.RUnlock()
+			return s.challenges[hello.ServerName]
+		}
+	}
+
+	return nil
+}
+
+// covers reports whether a certificate includes every configured domain.
+func (s *Ssl) covers(cert *x509.Certificate) bool {
+
+	for _, domain := range s.Acme.Domains {
+		if cert.VerifyHostname(domain) != nil {
+			return false
+		}
+	}
+
+	return true
+}
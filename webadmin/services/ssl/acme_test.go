@@ -0,0 +1,711 @@
+package ssl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"golang.org/x/crypto/acme"
+)
+
+// acmeServer is an in-process ACME server that follows RFC 8555 far enough
+// for golang.org/x/crypto/acme. Like Pebble it validates the challenges on
+// fixed addresses instead of resolving the domains, so no request leaves
+// the host.
+type acmeServer struct {
+	server      *httptest.Server
+	httpAddress string
+	alpnAddress string
+	ca          *x509.Certificate
+	caKey       *ecdsa.PrivateKey
+	nonces      map[string]bool
+	accounts    map[string]*ecdsa.PublicKey
+	orders      map[string]*acmeOrder
+	authzs      map[string]*acmeAuthz
+	challenges  map[string]*acmeChallenge
+	certs       map[string][]byte
+	validated   []string
+	next        int
+	mutex       sync.Mutex
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeOrder struct {
+	Status         string           `json:"status"`
+	Identifiers    []acmeIdentifier `json:"identifiers"`
+	Authorizations []string         `json:"authorizations"`
+	Finalize       string           `json:"finalize"`
+	Certificate    string           `json:"certificate,omitempty"`
+	url            string
+	account        string
+}
+
+type acmeAuthz struct {
+	Status     string           `json:"status"`
+	Identifier acmeIdentifier   `json:"identifier"`
+	Challenges []*acmeChallenge `json:"challenges"`
+	account    string
+}
+
+type acmeChallenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+	authz  *acmeAuthz
+}
+
+func newAcmeServer(t *testing.T) *acmeServer {
+
+	a := &acmeServer{
+		httpAddress: freeAddress(t),
+		alpnAddress: freeAddress(t),
+		nonces:      make(map[string]bool),
+		accounts:    make(map[string]*ecdsa.PublicKey),
+		orders:      make(map[string]*acmeOrder),
+		authzs:      make(map[string]*acmeAuthz),
+		challenges:  make(map[string]*acmeChallenge),
+		certs:       make(map[string][]byte),
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test ACME Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	a.caKey = key
+
+	a.server = httptest.NewTLSServer(http.HandlerFunc(a.handle))
+	t.Cleanup(a.server.Close)
+
+	return a
+}
+
+// freeAddress returns a loopback address nothing listens on.
+func freeAddress(t *testing.T) string {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+func (a *acmeServer) url(kind string) string {
+
+	a.next++
+	return fmt.Sprintf("%s/%s/%d", a.server.URL, kind, a.next)
+}
+
+func (a *acmeServer) nonce() string {
+
+	nonce := random()
+	a.nonces[nonce] = true
+
+	return nonce
+}
+
+func random() string {
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func (a *acmeServer) reply(w http.ResponseWriter, status int, location string, v interface{}) {
+
+	w.Header().Set("Replay-Nonce", a.nonce())
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (a *acmeServer) problem(w http.ResponseWriter, status int, kind string, detail string) {
+
+	w.Header().Set("Replay-Nonce", a.nonce())
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"type": "urn:ietf:params:acme:error:" + kind, "detail": detail})
+}
+
+func (a *acmeServer) handle(w http.ResponseWriter, r *http.Request) {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	kind := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")[0]
+
+	switch kind {
+	case "directory":
+		a.reply(w, http.StatusOK, "", map[string]string{
+			"newNonce":   a.server.URL + "/nonce",
+			"newAccount": a.server.URL + "/new-account",
+			"newOrder":   a.server.URL + "/new-order",
+			"revokeCert": a.server.URL + "/revoke",
+			"keyChange":  a.server.URL + "/key-change",
+		})
+		return
+	case "nonce":
+		w.Header().Set("Replay-Nonce", a.nonce())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		a.problem(w, http.StatusMethodNotAllowed, "malformed", "only POST is supported")
+		return
+	}
+
+	payload, account, key, err := a.verify(r)
+	if err != nil {
+		kind := "malformed"
+		if strings.Contains(err.Error(), "nonce") {
+			kind = "badNonce"
+		}
+		a.problem(w, http.StatusBadRequest, kind, err.Error())
+		return
+	}
+
+	location := a.server.URL + r.URL.Path
+
+	switch kind {
+	case "new-account":
+		if key == nil {
+			a.problem(w, http.StatusBadRequest, "malformed", "new accounts are signed with a jwk")
+			return
+		}
+		uri := a.url("account")
+		a.accounts[uri] = key
+		a.reply(w, http.StatusCreated, uri, map[string]string{"status": "valid"})
+	case "new-order":
+		request := struct {
+			Identifiers []acmeIdentifier `json:"identifiers"`
+		}{}
+		if err := json.Unmarshal(payload, &request); err != nil || len(request.Identifiers) == 0 {
+			a.problem(w, http.StatusBadRequest, "malformed", "invalid order")
+			return
+		}
+		order := &acmeOrder{Identifiers: request.Identifiers, Authorizations: make([]string, 0), url: a.url("order"), account: account}
+		order.Finalize = a.url("finalize")
+		for _, identifier := range request.Identifiers {
+			authz := &acmeAuthz{Status: acme.StatusPending, Identifier: identifier, account: account}
+			for _, kind := range []string{CHALLENGE_HTTP_01, CHALLENGE_TLS_ALPN_01} {
+				challenge := &acmeChallenge{Type: kind, URL: a.url("challenge"), Token: random(), Status: acme.StatusPending, authz: authz}
+				a.challenges[challenge.URL] = challenge
+				authz.Challenges = append(authz.Challenges, challenge)
+			}
+			uri := a.url("authz")
+			a.authzs[uri] = authz
+			order.Authorizations = append(order.Authorizations, uri)
+		}
+		a.orders[order.url] = order
+		a.orders[order.Finalize] = order
+		a.reply(w, http.StatusCreated, order.url, a.status(order))
+	case "order":
+		order, ok := a.orders[location]
+		if !ok || order.account != account {
+			a.problem(w, http.StatusNotFound, "malformed", "no such order")
+			return
+		}
+		a.reply(w, http.StatusOK, order.url, a.status(order))
+	case "authz":
+		authz, ok := a.authzs[location]
+		if !ok || authz.account != account {
+			a.problem(w, http.StatusNotFound, "malformed", "no such authorization")
+			return
+		}
+		a.reply(w, http.StatusOK, "", authz)
+	case "challenge":
+		challenge, ok := a.challenges[location]
+		if !ok || challenge.authz.account != account {
+			a.problem(w, http.StatusNotFound, "malformed", "no such challenge")
+			return
+		}
+		// validated before answering, the client waits for the
+		// authorization anyway
+		if challenge.Status == acme.StatusPending {
+			challenge.Status = acme.StatusValid
+			if err := a.validate(challenge, a.accounts[account]); err != nil {
+				challenge.Status = acme.StatusInvalid
+			} else {
+				a.validated = append(a.validated, challenge.Type+" "+challenge.authz.Identifier.Value)
+			}
+			challenge.authz.Status = challenge.Status
+		}
+		a.reply(w, http.StatusOK, "", challenge)
+	case "finalize":
+		order, ok := a.orders[location]
+		if !ok || order.account != account {
+			a.problem(w, http.StatusNotFound, "malformed", "no such order")
+			return
+		}
+		if a.status(order).Status != acme.StatusReady {
+			a.problem(w, http.StatusForbidden, "orderNotReady", "the order is not ready")
+			return
+		}
+		if err := a.issue(order, payload); err != nil {
+			a.problem(w, http.StatusBadRequest, "badCSR", err.Error())
+			return
+		}
+		a.reply(w, http.StatusOK, order.url, a.status(order))
+	case "cert":
+		chain, ok := a.certs[location]
+		if !ok {
+			a.problem(w, http.StatusNotFound, "malformed", "no such certificate")
+			return
+		}
+		w.Header().Set("Replay-Nonce", a.nonce())
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(chain)
+	default:
+		a.problem(w, http.StatusNotFound, "malformed", "unknown resource")
+	}
+}
+
+// verify checks the JWS of a request: the ES256 signature, a nonce issued
+// by the server and the url. It returns the payload and either the account
+// of the key id or the key sent with a new account.
+func (a *acmeServer) verify(r *http.Request) ([]byte, string, *ecdsa.PublicKey, error) {
+
+	body := struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, "", nil, err
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(body.Protected)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	header := struct {
+		Alg   string          `json:"alg"`
+		JWK   json.RawMessage `json:"jwk"`
+		KID   string          `json:"kid"`
+		Nonce string          `json:"nonce"`
+		URL   string          `json:"url"`
+	}{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, "", nil, err
+	}
+	if !a.nonces[header.Nonce] {
+		return nil, "", nil, fmt.Errorf("unknown nonce '%s'", header.Nonce)
+	}
+	delete(a.nonces, header.Nonce)
+	if header.URL != a.server.URL+r.URL.Path {
+		return nil, "", nil, fmt.Errorf("the signed url '%s' is not the request url", header.URL)
+	}
+	if header.Alg != "ES256" {
+		return nil, "", nil, fmt.Errorf("unsupported algorithm '%s'", header.Alg)
+	}
+
+	var key *ecdsa.PublicKey
+	var jwk *ecdsa.PublicKey
+	if header.KID != "" {
+		if key = a.accounts[header.KID]; key == nil {
+			return nil, "", nil, fmt.Errorf("unknown account '%s'", header.KID)
+		}
+	} else {
+		if jwk, err = parseJWK(header.JWK); err != nil {
+			return nil, "", nil, err
+		}
+		key = jwk
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(body.Signature)
+	if err != nil || len(signature) != 64 {
+		return nil, "", nil, fmt.Errorf("invalid signature")
+	}
+	hash := sha256.Sum256([]byte(body.Protected + "." + body.Payload))
+	if !ecdsa.Verify(key, hash[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		return nil, "", nil, fmt.Errorf("the signature does not match")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(body.Payload)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return payload, header.KID, jwk, nil
+}
+
+func parseJWK(data []byte) (*ecdsa.PublicKey, error) {
+
+	jwk := struct {
+		Kty string `json:"kty"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}{}
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, err
+	}
+	if jwk.Kty != "EC" || jwk.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported jwk %s %s", jwk.Kty, jwk.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
+
+// keyAuthorization is the token and the RFC 7638 thumbprint of the account
+// key.
+func keyAuthorization(token string, key *ecdsa.PublicKey) string {
+
+	size := (key.Curve.Params().BitSize + 7) / 8
+	x := make([]byte, size)
+	y := make([]byte, size)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	thumbprint := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, base64.RawURLEncoding.EncodeToString(x), base64.RawURLEncoding.EncodeToString(y))))
+
+	return token + "." + base64.RawURLEncoding.EncodeToString(thumbprint[:])
+}
+
+// validate fetches the http-01 response or makes the tls-alpn-01 handshake
+// on the fixed addresses.
+func (a *acmeServer) validate(challenge *acmeChallenge, key *ecdsa.PublicKey) error {
+
+	domain := challenge.authz.Identifier.Value
+	expected := keyAuthorization(challenge.Token, key)
+
+	switch challenge.Type {
+	case CHALLENGE_HTTP_01:
+		request, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", a.httpAddress, challenge.Token), nil)
+		if err != nil {
+			return err
+		}
+		request.Host = domain
+		response, err := (&http.Client{Timeout: 5 * time.Second}).Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		data, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+		if response.StatusCode != http.StatusOK || strings.TrimSpace(string(data)) != expected {
+			return fmt.Errorf("unexpected http-01 response %d '%s'", response.StatusCode, data)
+		}
+	case CHALLENGE_TLS_ALPN_01:
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", a.alpnAddress, &tls.Config{
+			ServerName:         domain,
+			NextProtos:         []string{acme.ALPNProto},
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		state := conn.ConnectionState()
+		if state.NegotiatedProtocol != acme.ALPNProto {
+			return fmt.Errorf("negotiated protocol '%s'", state.NegotiatedProtocol)
+		}
+		cert := state.PeerCertificates[0]
+		if len(cert.DNSNames) != 1 || cert.DNSNames[0] != domain {
+			return fmt.Errorf("the challenge certificate is for %v", cert.DNSNames)
+		}
+		sum := sha256.Sum256([]byte(expected))
+		for _, extension := range cert.Extensions {
+			if !extension.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}) {
+				continue
+			}
+			var digest []byte
+			if _, err := asn1.Unmarshal(extension.Value, &digest); err != nil {
+				return err
+			}
+			if !extension.Critical || string(digest) != string(sum[:]) {
+				return fmt.Errorf("the acmeIdentifier extension does not match")
+			}
+			return nil
+		}
+		return fmt.Errorf("the challenge certificate has no acmeIdentifier extension")
+	default:
+		return fmt.Errorf("unknown challenge '%s'", challenge.Type)
+	}
+
+	return nil
+}
+
+// status updates the status of an order from its authorizations.
+func (a *acmeServer) status(order *acmeOrder) *acmeOrder {
+
+	order.Status = acme.StatusReady
+	if order.Certificate != "" {
+		order.Status = acme.StatusValid
+		return order
+	}
+	for _, uri := range order.Authorizations {
+		switch a.authzs[uri].Status {
+		case acme.StatusInvalid:
+			order.Status = acme.StatusInvalid
+			return order
+		case acme.StatusPending:
+			order.Status = acme.StatusPending
+		}
+	}
+
+	return order
+}
+
+// issue signs the certificate request of a ready order with the test root.
+func (a *acmeServer) issue(order *acmeOrder, payload []byte) error {
+
+	request := struct {
+		CSR string `json:"csr"`
+	}{}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return err
+	}
+	der, err := base64.RawURLEncoding.DecodeString(request.CSR)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return err
+	}
+
+	names := make([]string, 0)
+	for _, identifier := range order.Identifiers {
+		names = append(names, identifier.Value)
+	}
+	requested := append([]string{}, csr.DNSNames...)
+	sort.Strings(names)
+	sort.Strings(requested)
+	if strings.Join(names, ",") != strings.Join(requested, ",") {
+		return fmt.Errorf("the request is for %v, the order for %v", requested, names)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(a.next + 100)),
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, a.ca, csr.PublicKey, a.caKey)
+	if err != nil {
+		return err
+	}
+
+	order.Certificate = a.url("cert")
+	a.certs[order.Certificate] = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.ca.Raw})...)
+
+	return nil
+}
+
+func (a *acmeServer) accountCount() int {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return len(a.accounts)
+}
+
+func (a *acmeServer) validations() []string {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return append([]string{}, a.validated...)
+}
+
+// newAcmeSsl configures the web admin for the test server: its TLS
+// certificate is the ACME CA certificate and the web admin listens on the
+// tls-alpn-01 address.
+func newAcmeSsl(t *testing.T, a *acmeServer, challenge string) *Ssl {
+
+	dir := t.TempDir()
+	caCert := filepath.Join(dir, "acme_ca.pem")
+	if err := os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.server.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	host, port, err := net.SplitHostPort(a.alpnAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := admin_log.New()
+	conf := config.New(log)
+	conf.WebAdmin.SslUse = true
+	conf.WebAdmin.SslVerify = true
+	conf.WebAdmin.SslCert = ""
+	conf.WebAdmin.SslKey = ""
+	conf.WebAdmin.ConfigDir = filepath.Join(dir, "config")
+	conf.WebAdmin.Address = host
+	fmt.Sscan(port, &conf.WebAdmin.Port)
+	conf.Acme.Domains = []string{"admin.example.test", "www.example.test"}
+	conf.Acme.Email = "admin@example.test"
+	conf.Acme.DirectoryUrl = a.server.URL + "/directory"
+	conf.Acme.Challenge = challenge
+	conf.Acme.HttpAddress = a.httpAddress
+	conf.Acme.CaCert = caCert
+
+	return New(conf, log)
+}
+
+// checkIssued verifies that the certificate in use was issued by the test
+// server for every domain and is not taken for a generated one.
+func checkIssued(t *testing.T, s *Ssl, a *acmeServer) *x509.Certificate {
+
+	t.Helper()
+
+	if s.SslCert != filepath.Join(s.ConfigDir, CERT_NAME) || s.SslKey != filepath.Join(s.ConfigDir, KEY_NAME) {
+		t.Fatalf("certificate written to '%s' and '%s'", s.SslCert, s.SslKey)
+	}
+
+	s.mutex.RLock()
+	certificate := s.certificate
+	s.mutex.RUnlock()
+	if certificate == nil || len(certificate.Certificate) != 2 {
+		t.Fatalf("the certificate in use is not the issued chain")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(a.ca)
+	for _, domain := range s.Acme.Domains {
+		if _, err := certificate.Leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: domain}); err != nil {
+			t.Errorf("the certificate is not valid for '%s': %v", domain, err)
+		}
+	}
+	if !s.covers(certificate.Leaf) {
+		t.Error("the certificate does not cover the configured domains")
+	}
+	if s.generated(certificate.Leaf) {
+		t.Error("an issued certificate is taken for a generated one")
+	}
+
+	return certificate.Leaf
+}
+
+func TestAcmeHttp01(t *testing.T) {
+
+	a := newAcmeServer(t)
+	s := newAcmeSsl(t, a, CHALLENGE_HTTP_01)
+
+	if !s.Load() {
+		t.Fatal("Load failed to obtain a certificate")
+	}
+	first := checkIssued(t, s, a)
+
+	want := []string{"http-01 admin.example.test", "http-01 www.example.test"}
+	if got := a.validations(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("validated %v, want %v", got, want)
+	}
+
+	// renewing uses the saved account
+	if err := s.obtain(false); err != nil {
+		t.Fatalf("renewal failed: %v", err)
+	}
+	second := checkIssued(t, s, a)
+	if second.SerialNumber.Cmp(first.SerialNumber) == 0 {
+		t.Error("the renewal did not install a new certificate")
+	}
+	if count := a.accountCount(); count != 1 {
+		t.Errorf("%d accounts registered, want 1", count)
+	}
+}
+
+func TestAcmeTlsAlpn01(t *testing.T) {
+
+	a := newAcmeServer(t)
+	s := newAcmeSsl(t, a, CHALLENGE_TLS_ALPN_01)
+
+	if !s.Load() {
+		t.Fatal("Load failed to obtain a certificate")
+	}
+	checkIssued(t, s, a)
+
+	want := []string{"tls-alpn-01 admin.example.test", "tls-alpn-01 www.example.test"}
+	if got := a.validations(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("validated %v, want %v", got, want)
+	}
+
+	// the temporary listener is closed once the certificate is issued
+	if conn, err := net.DialTimeout("tcp", a.alpnAddress, time.Second); err == nil {
+		conn.Close()
+		t.Error("the tls-alpn-01 listener is still open")
+	}
+}
+
+func TestAcmeFailedChallenge(t *testing.T) {
+
+	a := newAcmeServer(t)
+	s := newAcmeSsl(t, a, CHALLENGE_HTTP_01)
+
+	// the server validates on an address the web admin does not answer
+	a.httpAddress = freeAddress(t)
+
+	err := s.obtain(true)
+	if err == nil || !strings.Contains(err.Error(), "authorization for 'admin.example.test' failed") {
+		t.Fatalf("obtain returned %v, want a failed authorization", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.ConfigDir, CERT_NAME)); !os.IsNotExist(err) {
+		t.Errorf("a certificate was written for a failed order")
+	}
+}
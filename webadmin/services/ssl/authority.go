@@ -0,0 +1,213 @@
+package ssl
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+const (
+	CA_CERT_NAME = "web_admin_ca_cert.pem"
+	CA_KEY_NAME  = "web_admin_ca_key.pem"
+	CA_VALIDITY  = 10 * 365 * 24 * time.Hour
+)
+
+// authority loads the local certificate authority used to sign the web
+// admin certificates, creating it the first time. Teams only have to trust
+// the authority once, leaf certificates can then be renewed freely.
+func (s *Ssl) authority() (*x509.Certificate, crypto.Signer, error) {
+
+	certPath := filepath.Join(s.ConfigDir, CA_CERT_NAME)
+	keyPath := filepath.Join(s.ConfigDir, CA_KEY_NAME)
+
+	if utils.FileExists(certPath) && utils.FileExists(keyPath) {
+		return loadAuthority(certPath, keyPath)
+	}
+
+	priv, err := generateKey(s.Options.KeyType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serialNumber, err := serial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	validFrom := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{ORGANIZATION},
+			CommonName:   "Sandstorm Web Admin Local CA",
+		},
+		NotBefore:             validFrom,
+		NotAfter:              validFrom.Add(CA_VALIDITY),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey(priv), priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create the certificate authority. ERR: %s", err.Error())
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal the certificate authority key. ERR: %s", err.Error())
+	}
+
+	if err := os.MkdirAll(s.ConfigDir, 0750); err != nil {
+		return nil, nil, fmt.Errorf("failed to create directory '%s'. ERR: %s", s.ConfigDir, err.Error())
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}), 0600); err != nil {
+		return nil, nil, fmt.Errorf("failed to write '%s'. ERR: %s", keyPath, err.Error())
+	}
+
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, nil, fmt.Errorf("failed to write '%s'. ERR: %s", certPath, err.Error())
+	}
+
+	s.log.Write(fmt.Sprintf("local certificate authority created at '%s'", certPath), MODULE, admin_log.LOG_INFO)
+
+	return loadAuthority(certPath, keyPath)
+}
+
+func loadAuthority(certPath string, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read '%s'. ERR: %s", certPath, err.Error())
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no certificate found in '%s'", certPath)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate '%s'. ERR: %s", certPath, err.Error())
+	}
+
+	if !cert.IsCA {
+		return nil, nil, fmt.Errorf("'%s' is not a certificate authority", certPath)
+	}
+
+	data, err = os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read '%s'. ERR: %s", keyPath, err.Error())
+	}
+
+	block, _ = pem.Decode(data)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no key found in '%s'", keyPath)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid key '%s'. ERR: %s", keyPath, err.Error())
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("key '%s' can not sign certificates", keyPath)
+	}
+
+	return cert, signer, nil
+}
+
+// Authority returns the PEM encoded local certificate authority so it can
+// be installed in browsers.
+func (s *Ssl) Authority() ([]byte, error) {
+
+	if !s.Options.LocalCa {
+		return nil, fmt.Errorf("the local certificate authority is not enabled")
+	}
+
+	certPath := filepath.Join(s.ConfigDir, CA_CERT_NAME)
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s'. ERR: %s", certPath, err.Error())
+	}
+
+	return data, nil
+}
+
+// generated reports whether a certificate was created by the web admin,
+// self signed or signed by the local certificate authority. Only the
+// fingerprint recorded when it was generated or adopted counts, an
+// uploaded self signed certificate is never replaced.
+func (s *Ssl) generated(cert *x509.Certificate) bool {
+
+	data, err := os.ReadFile(filepath.Join(s.ConfigDir, GENERATED_NAME))
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(string(data)) == Fingerprint(cert)
+}
+
+// adopt records as generated the certificate at the default path that
+// the web admin created before the fingerprints were recorded: self
+// signed, or signed by the local certificate authority, for its own
+// organization. It runs once, while no fingerprint is recorded.
+func (s *Ssl) adopt() {
+
+	markerPath := filepath.Join(s.ConfigDir, GENERATED_NAME)
+	if utils.FileExists(markerPath) {
+		return
+	}
+
+	s.mutex.RLock()
+	certPath := s.SslCert
+	var leaf *x509.Certificate
+	if s.certificate != nil {
+		leaf = s.certificate.Leaf
+	}
+	s.mutex.RUnlock()
+
+	if leaf == nil {
+		return
+	}
+	dir, err := filepath.Abs(s.ConfigDir)
+	if err != nil {
+		return
+	}
+	if certPath, err = filepath.Abs(certPath); err != nil || certPath != filepath.Join(dir, CERT_NAME) {
+		return
+	}
+	if len(leaf.Subject.Organization) != 1 || leaf.Subject.Organization[0] != ORGANIZATION {
+		return
+	}
+
+	if !selfSigned(leaf) {
+		if !s.Options.LocalCa {
+			return
+		}
+		ca, _, err := loadAuthority(filepath.Join(s.ConfigDir, CA_CERT_NAME), filepath.Join(s.ConfigDir, CA_KEY_NAME))
+		if err != nil || leaf.CheckSignatureFrom(ca) != nil {
+			return
+		}
+	}
+
+	if err := os.WriteFile(markerPath, []byte(Fingerprint(leaf)+"\n"), 0644); err != nil {
+		s.log.Write(fmt.Sprintf("failed to write data to '%s'. ERR: %s", markerPath, err.Error()), MODULE, admin_log.LOG_WARNING)
+		return
+	}
+
+	s.log.Write(fmt.Sprintf("certificate '%s' was generated by the web admin, it is renewed when it expires", certPath), MODULE, admin_log.LOG_INFO)
+}
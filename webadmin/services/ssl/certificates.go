@@ -0,0 +1,259 @@
+package ssl
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+	"golang.org/x/crypto/acme"
+)
+
+// Certificate describes the certificate currently served by the web admin.
+type Certificate struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serialNumber"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	ExpiresIn    int       `json:"expiresInDays"`
+	DNSNames     []string  `json:"dnsNames"`
+	IPAddresses  []string  `json:"ipAddresses"`
+	KeyAlgorithm string    `json:"keyAlgorithm"`
+	IsCA         bool      `json:"isCa"`
+	SelfSigned   bool      `json:"selfSigned"`
+	Fingerprint  string    `json:"fingerprint"`
+	CertPath     string    `json:"certPath"`
+	KeyPath      string    `json:"keyPath"`
+}
+
+// TLSConfig returns a TLS configuration that always serves the latest
+// loaded certificate, so renewed or uploaded certificates are used without
+// restarting the listener.
+func (s *Ssl) TLSConfig() *tls.Config {
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1", acme.ALPNProto},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if challenge := s.alpnChallenge(hello); challenge != nil {
+				return challenge, nil
+			}
+
+			s.mutex.RLock()
+			defer s.mutex.RUnlock()
+
+			if s.certificate == nil {
+				return nil, fmt.Errorf("no certificate loaded")
+			}
+			return s.certificate, nil
+		},
+	}
+
+	s.clientAuth(config)
+
+	return config
+}
+
+// Reload loads the certificate files again, for certificates replaced on
+// disk by another tool.
+func (s *Ssl) Reload() error {
+
+	return s.reload()
+}
+
+// reload reads the certificate and key files into memory.
+func (s *Ssl) reload() error {
+
+	pair, err := tls.LoadX509KeyPair(s.SslCert, s.SslKey)
+	if err != nil {
+		return s.log.Write(fmt.Sprintf("failed to load certificate '%s' and key '%s'. ERR: %s", s.SslCert, s.SslKey, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return s.log.Write(fmt.Sprintf("failed to parse certificate '%s'. ERR: %s", s.SslCert, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	pair.Leaf = leaf
+
+	s.mutex.Lock()
+	s.certificate = &pair
+	s.mutex.Unlock()
+
+	s.log.Write(fmt.Sprintf("certificate '%s' loaded, valid until %s", s.SslCert, leaf.NotAfter.Format(time.RFC3339)), MODULE, admin_log.LOG_INFO)
+
+	return nil
+}
+
+// Details returns the information of the certificate in use.
+func (s *Ssl) Details() (*Certificate, error) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.certificate == nil || s.certificate.Leaf == nil {
+		return nil, fmt.Errorf("no certificate loaded")
+	}
+
+	leaf := s.certificate.Leaf
+
+	c := new(Certificate)
+	c.Subject = leaf.Subject.String()
+	c.Issuer = leaf.Issuer.String()
+	c.SerialNumber = leaf.SerialNumber.Text(16)
+	c.NotBefore = leaf.NotBefore
+	c.NotAfter = leaf.NotAfter
+	c.ExpiresIn = int(time.Until(leaf.NotAfter).Hours() / 24)
+	c.DNSNames = append([]string{}, leaf.DNSNames...)
+	c.IPAddresses = make([]string, 0, len(leaf.IPAddresses))
+	for _, ip := range leaf.IPAddresses {
+		c.IPAddresses = append(c.IPAddresses, ip.String())
+	}
+	c.KeyAlgorithm = leaf.PublicKeyAlgorithm.String()
+	c.IsCA = leaf.IsCA
+	c.SelfSigned = selfSigned(leaf)
+	c.Fingerprint = Fingerprint(leaf)
+	c.CertPath = s.SslCert
+	c.KeyPath = s.SslKey
+
+	return c, nil
+}
+
+// Replace installs an uploaded PEM certificate and key after checking that
+// they match and that the certificate is currently valid.
+func (s *Ssl) Replace(certPem []byte, keyPem []byte) error {
+
+	pair, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return fmt.Errorf("invalid certificate or key. ERR: %s", err.Error())
+	}
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("invalid certificate. ERR: %s", err.Error())
+	}
+
+	now := time.Now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate is only valid from %s to %s", leaf.NotBefore.Format(time.RFC3339), leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	return s.write(certPem, keyPem, false)
+}
+
+// Watch checks the certificate in use periodically and renews it before it
+// expires, from the ACME directory when one is configured or by generating
+// a new certificate.
+func (s *Ssl) Watch() {
+
+	if !s.SslUse || s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(RENEW_INTERVAL)
+		defer ticker.Stop()
+
+		s.renew()
+		for {
+			select {
+			case <-ticker.C:
+				s.renew()
+			case <-stop:
+				return
+			}
+		}
+	}(s.stop)
+}
+
+func (s *Ssl) Stop() {
+
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+func (s *Ssl) renew() {
+
+	s.mutex.RLock()
+	var leaf *x509.Certificate
+	if s.certificate != nil {
+		leaf = s.certificate.Leaf
+	}
+	s.mutex.RUnlock()
+
+	if leaf == nil {
+		return
+	}
+
+	expiring := time.Until(leaf.NotAfter) < RENEW_BEFORE
+
+	if s.SslVerify && len(s.Acme.Domains) > 0 {
+		if !expiring && s.covers(leaf) {
+			return
+		}
+		s.log.Write(fmt.Sprintf("certificate expires at %s or does not cover '%s', requesting a new one", leaf.NotAfter.Format(time.RFC3339), strings.Join(s.Acme.Domains, ", ")), MODULE, admin_log.LOG_INFO)
+		if err := s.obtain(false); err != nil {
+			s.log.Write(fmt.Sprintf("failed to renew certificate from '%s'. ERR: %s", s.Acme.DirectoryUrl, err.Error()), MODULE, admin_log.LOG_ERROR)
+			if expiring {
+				s.notify(leaf, err.Error())
+			}
+		}
+		return
+	}
+
+	if !expiring {
+		return
+	}
+
+	if !s.generated(leaf) || s.SslVerify {
+		s.log.Write(fmt.Sprintf("certificate '%s' expires at %s and must be replaced", s.SslCert, leaf.NotAfter.Format(time.RFC3339)), MODULE, admin_log.LOG_WARNING)
+		s.notify(leaf, "the certificate is not generated by the web admin and must be replaced")
+		return
+	}
+
+	s.log.Write(fmt.Sprintf("generated certificate expires at %s, renewing", leaf.NotAfter.Format(time.RFC3339)), MODULE, admin_log.LOG_INFO)
+	if err := s.create(); err != nil {
+		s.log.Write(fmt.Sprintf("failed to renew generated certificate. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+}
+
+// notify publishes that the certificate is about to expire, at most once
+// a day so the hourly renewal checks do not repeat it.
+func (s *Ssl) notify(leaf *x509.Certificate, reason string) {
+
+	s.mutex.Lock()
+	if time.Since(s.notified) < NOTIFY_EVERY {
+		s.mutex.Unlock()
+		return
+	}
+	s.notified = time.Now()
+	s.mutex.Unlock()
+
+	s.Events.Publish(events.Event{
+		Type:    events.CERTIFICATE_EXPIRING,
+		Level:   events.LEVEL_WARNING,
+		Title:   "Web admin certificate expiring",
+		Message: fmt.Sprintf("the certificate expires at %s: %s", leaf.NotAfter.Format(time.RFC3339), reason),
+		Fields: map[string]string{
+			"subject":  leaf.Subject.CommonName,
+			"notAfter": leaf.NotAfter.Format(time.RFC3339),
+			"days":     fmt.Sprint(int(time.Until(leaf.NotAfter).Hours() / 24)),
+		},
+	})
+}
+
+func selfSigned(cert *x509.Certificate) bool {
+
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		return false
+	}
+
+	return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
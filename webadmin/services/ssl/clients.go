@@ -0,0 +1,301 @@
+package ssl
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+// ClientMapping links a client certificate, by fingerprint or by subject,
+// to an admin user.
+type ClientMapping struct {
+	ID          string    `json:"id"`
+	User        string    `json:"user"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Subject     string    `json:"subject,omitempty"`
+	NotAfter    time.Time `json:"notAfter,omitempty"`
+	Created     time.Time `json:"created"`
+}
+
+// ClientCertificate is a certificate issued for an admin user by the local
+// certificate authority.
+type ClientCertificate struct {
+	Mapping     *ClientMapping `json:"mapping"`
+	Certificate string         `json:"certificate"`
+	Key         string         `json:"key"`
+	Authority   string         `json:"authority"`
+}
+
+const (
+	CLIENTS_FILE = "client_certificates.json"
+)
+
+// loadClients reads the client certificate authorities and the user
+// mappings used for mutual TLS.
+func (s *Ssl) loadClients() error {
+
+	s.clientCAs = x509.NewCertPool()
+
+	if s.Mtls.CaBundle != "" {
+		data, err := os.ReadFile(s.Mtls.CaBundle)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA bundle '%s'. ERR: %s", s.Mtls.CaBundle, err.Error())
+		}
+		if !s.clientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in client CA bundle '%s'", s.Mtls.CaBundle)
+		}
+	}
+
+	// the local authority always signs the client certificates issued here
+	ca, _, err := s.authority()
+	if err != nil {
+		return err
+	}
+	s.clientCAs.AddCert(ca)
+
+	s.clients = make([]*ClientMapping, 0)
+	path := filepath.Join(s.ConfigDir, CLIENTS_FILE)
+	if !utils.FileExists(path) {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read client mappings '%s'. ERR: %s", path, err.Error())
+	}
+
+	if err := json.Unmarshal(data, &s.clients); err != nil {
+		return fmt.Errorf("failed to parse client mappings '%s'. ERR: %s", path, err.Error())
+	}
+
+	return nil
+}
+
+func (s *Ssl) saveClients() error {
+
+	path := filepath.Join(s.ConfigDir, CLIENTS_FILE)
+
+	data, err := json.MarshalIndent(s.clients, "", "  ")
+	if err != nil {
+		return s.log.Write(fmt.Sprintf("failed to serialize client mappings. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	if err := os.WriteFile(path, data, 0640); err != nil {
+		return s.log.Write(fmt.Sprintf("failed to write client mappings '%s'. ERR: %s", path, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	return nil
+}
+
+// clientAuth adds the client certificate verification to a server TLS
+// configuration. The ACME validation server never presents a client
+// certificate, so tls-alpn-01 handshakes are answered without it.
+func (s *Ssl) clientAuth(config *tls.Config) {
+
+	if !s.Mtls.Use || s.clientCAs == nil {
+		return
+	}
+
+	challenge := config.Clone()
+
+	config.ClientCAs = s.clientCAs
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if s.Mtls.Require {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if s.alpnChallenge(hello) != nil {
+			return challenge, nil
+		}
+		return nil, nil
+	}
+}
+
+// ClientUser returns the admin user mapped to a verified client
+// certificate, matching the fingerprint first and then the subject.
+func (s *Ssl) ClientUser(cert *x509.Certificate) (string, bool) {
+
+	fingerprint := Fingerprint(cert)
+	subject := cert.Subject.String()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, mapping := range s.clients {
+		if mapping.Fingerprint != "" && mapping.Fingerprint == fingerprint {
+			return mapping.User, true
+		}
+	}
+
+	for _, mapping := range s.clients {
+		if mapping.Fingerprint == "" && mapping.Subject != "" && (mapping.Subject == subject || mapping.Subject == cert.Subject.CommonName) {
+			return mapping.User, true
+		}
+	}
+
+	return "", false
+}
+
+// Clients returns the client certificate mappings.
+func (s *Ssl) Clients() []*ClientMapping {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	list := make([]*ClientMapping, len(s.clients))
+	copy(list, s.clients)
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+
+	return list
+}
+
+// AddClient maps a certificate subject or fingerprint to a user.
+func (s *Ssl) AddClient(mapping *ClientMapping) error {
+
+	if !s.Mtls.Use {
+		return fmt.Errorf("mutual TLS is not enabled")
+	}
+
+	mapping.Fingerprint = strings.ToLower(strings.ReplaceAll(mapping.Fingerprint, ":", ""))
+	if mapping.User == "" {
+		return fmt.Errorf("a user is required")
+	}
+	if mapping.Fingerprint == "" && mapping.Subject == "" {
+		return fmt.Errorf("a fingerprint or a subject is required")
+	}
+
+	mapping.ID = utils.RandomID(8)
+	mapping.Created = time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.clients = append(s.clients, mapping)
+
+	return s.saveClients()
+}
+
+func (s *Ssl) RemoveClient(id string) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for n, mapping := range s.clients {
+		if mapping.ID == id {
+			s.clients = append(s.clients[:n], s.clients[n+1:]...)
+			return s.saveClients()
+		}
+	}
+
+	return fmt.Errorf("client mapping '%s' not found", id)
+}
+
+// IssueClient creates a client certificate for a user signed by the local
+// certificate authority and maps its fingerprint to the user.
+func (s *Ssl) IssueClient(user string, days int) (*ClientCertificate, error) {
+
+	if !s.Mtls.Use {
+		return nil, fmt.Errorf("mutual TLS is not enabled")
+	}
+
+	if days <= 0 {
+		days = s.Mtls.ClientValidity
+	}
+
+	ca, caKey, err := s.authority()
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := generateKey(s.Options.KeyType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key. ERR: %s", err.Error())
+	}
+
+	serialNumber, err := serial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate serial number. ERR: %s", err.Error())
+	}
+
+	validFrom := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization:       []string{"Sandstorm Web Admin"},
+			OrganizationalUnit: []string{"admin"},
+			CommonName:         user,
+		},
+		NotBefore:             validFrom,
+		NotAfter:              validFrom.Add(time.Duration(days) * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	if template.NotAfter.After(ca.NotAfter) {
+		template.NotAfter = ca.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, ca, publicKey(priv), caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client certificate. ERR: %s", err.Error())
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client certificate. ERR: %s", err.Error())
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key. ERR: %s", err.Error())
+	}
+
+	mapping := &ClientMapping{
+		ID:          utils.RandomID(8),
+		User:        user,
+		Fingerprint: Fingerprint(cert),
+		Subject:     cert.Subject.String(),
+		NotAfter:    cert.NotAfter,
+		Created:     validFrom,
+	}
+
+	s.mutex.Lock()
+	s.clients = append(s.clients, mapping)
+	err = s.saveClients()
+	s.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Write(fmt.Sprintf("client certificate '%s' issued for user '%s'", mapping.Fingerprint, user), MODULE, admin_log.LOG_INFO)
+
+	return &ClientCertificate{
+		Mapping:     mapping,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		Key:         string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})),
+		Authority:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})),
+	}, nil
+}
+
+// Fingerprint returns the hexadecimal SHA-256 digest of a certificate.
+func Fingerprint(cert *x509.Certificate) string {
+
+	sum := sha256.Sum256(cert.Raw)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -16,32 +16,56 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
 	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
 	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
 )
 
 type Ssl struct {
-	SslUse    bool   `json:"sslUse"`
-	SslVerify bool   `json:"sslVerify"`
-	SslCert   string `json:"sslCertPath"`
-	SslKey    string `json:"sslKeyPath"`
-	ConfigDir string `json:"configDir"`
-	Host      string `json:"host"`
-	log       *admin_log.Log
+	SslUse      bool               `json:"sslUse"`
+	SslVerify   bool               `json:"sslVerify"`
+	SslCert     string             `json:"sslCertPath"`
+	SslKey      string             `json:"sslKeyPath"`
+	ConfigDir   string             `json:"configDir"`
+	Host        string             `json:"host"`
+	Address     string             `json:"address"`
+	Port        int                `json:"port"`
+	Acme        config.Acme        `json:"acme"`
+	Options     config.Certificate `json:"certificate"`
+	Mtls        config.Mtls        `json:"mtls"`
+	Events      *events.Bus        `json:"-"`
+	notified    time.Time
+	clientCAs   *x509.CertPool
+	clients     []*ClientMapping
+	tokens      map[string]string
+	challenges  map[string]*tls.Certificate
+	log         *admin_log.Log
+	certificate *tls.Certificate
+	stop        chan struct{}
+	mutex       sync.RWMutex
 }
 
 const (
-	MODULE    = "ssl"
-	RSA_BITS  = 2048
-	CERT_NAME = "web_admin_cert.pem"
-	KEY_NAME  = "web_admin_key.pem"
-)
-
-var (
-	ecdsa_curve string = "P521"
+	MODULE         = "ssl"
+	CERT_NAME      = "web_admin_cert.pem"
+	KEY_NAME       = "web_admin_key.pem"
+	GENERATED_NAME = "web_admin_generated.txt"
+	ORGANIZATION   = "Sandstorm Web Admin"
+
+	KEY_RSA_2048   = "rsa-2048"
+	KEY_RSA_4096   = "rsa-4096"
+	KEY_ECDSA_P256 = "ecdsa-p256"
+	KEY_ECDSA_P384 = "ecdsa-p384"
+	KEY_ED25519    = "ed25519"
+
+	RENEW_BEFORE   = 30 * 24 * time.Hour
+	RENEW_INTERVAL = time.Hour
+	NOTIFY_EVERY   = 24 * time.Hour
 )
 
 func New(conf *config.Configuration, log *admin_log.Log) *Ssl {
@@ -52,6 +76,13 @@ func New(conf *config.Configuration, log *admin_log.Log) *Ssl {
 	s.SslCert = conf.WebAdmin.SslCert
 	s.SslKey = conf.WebAdmin.SslKey
 	s.ConfigDir = conf.WebAdmin.ConfigDir
+	s.Address = conf.WebAdmin.Address
+	s.Port = conf.WebAdmin.Port
+	s.Acme = conf.Acme
+	s.Options = conf.Certificate
+	s.Mtls = conf.Mtls
+	s.tokens = make(map[string]string)
+	s.challenges = make(map[string]*tls.Certificate)
 	s.log = log
 
 	return s
@@ -60,6 +91,10 @@ func New(conf *config.Configuration, log *admin_log.Log) *Ssl {
 func (s *Ssl) Load() bool {
 
 	if !s.SslUse {
+		if s.Mtls.Use {
+			s.log.Write("mutual TLS requires ADMIN_SSL_USE to be enabled", MODULE, admin_log.LOG_CRITICAL)
+			return false
+		}
 		return true
 	}
 
@@ -95,10 +130,19 @@ func (s *Ssl) Load() bool {
 
 	if noCert || noKey {
 		if s.SslVerify {
-			s.log.Write(fmt.Sprintf("this server can not generate valid SSL certificates and no certificates where found at '%s' ", s.ConfigDir), MODULE, admin_log.LOG_CRITICAL)
-		}
+			if len(s.Acme.Domains) == 0 {
+				s.log.Write(fmt.Sprintf("no certificates where found at '%s' and no ACME domains are configured to request them", s.ConfigDir), MODULE, admin_log.LOG_CRITICAL)
+				return false
+			}
 
-		s.create()
+			if err := s.obtain(true); err != nil {
+				s.log.Write(fmt.Sprintf("failed to obtain certificates from '%s'. ERR: %s", s.Acme.DirectoryUrl, err.Error()), MODULE, admin_log.LOG_CRITICAL)
+				return false
+			}
+		} else if err := s.create(); err != nil {
+			s.log.Write(fmt.Sprintf("failed to generate self signed certificates. ERR: %s", err.Error()), MODULE, admin_log.LOG_CRITICAL)
+			return false
+		}
 	} else {
 		if err := s.validCertificates(); err != nil {
 			s.log.Write(fmt.Sprintf("the certificates found at '%s' are invalid. ERR: %s", s.ConfigDir, err.Error()), MODULE, admin_log.LOG_CRITICAL)
@@ -106,113 +150,201 @@ func (s *Ssl) Load() bool {
 		} else {
 			s.log.Write(fmt.Sprintf("the certificates found at '%s' are ok", s.ConfigDir), MODULE, admin_log.LOG_INFO)
 		}
+
+		if err := s.reload(); err != nil {
+			s.log.Write(err.Error(), MODULE, admin_log.LOG_CRITICAL)
+			return false
+		}
+		s.adopt()
+	}
+
+	if s.Mtls.Use {
+		if err := s.loadClients(); err != nil {
+			s.log.Write(fmt.Sprintf("failed to load mutual TLS configuration. ERR: %s", err.Error()), MODULE, admin_log.LOG_CRITICAL)
+			return false
+		}
+		mode := "optional"
+		if s.Mtls.Require {
+			mode = "required"
+		}
+		s.log.Write(fmt.Sprintf("mutual TLS enabled, client certificates are %s", mode), MODULE, admin_log.LOG_INFO)
 	}
 
 	return true
 }
 
-func (s *Ssl) create() bool {
+// Generate creates a new certificate with the configured key type and
+// names, signed by the local certificate authority when it is enabled.
+func (s *Ssl) Generate() error {
 
-	var priv any
-	var err error
+	return s.create()
+}
 
-	s.log.Write("generating self signed certificates", MODULE, admin_log.LOG_INFO)
+func (s *Ssl) create() error {
 
-	switch ecdsa_curve {
-	case "P224":
-		priv, err = ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
-	case "P256":
-		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	case "P384":
-		priv, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
-	case "P521":
-		priv, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
-	default:
-		s.log.Write(fmt.Sprintf("unknown ecdsa curve '%s'", ecdsa_curve), MODULE, admin_log.LOG_CRITICAL)
+	s.log.Write(fmt.Sprintf("generating %s certificate", s.Options.KeyType), MODULE, admin_log.LOG_INFO)
+
+	priv, err := generateKey(s.Options.KeyType)
+	if err != nil {
+		return s.log.Write(fmt.Sprintf("failed to generate private key. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
 	}
 
+	serialNumber, err := serial()
 	if err != nil {
-		s.log.Write(fmt.Sprintf("failed to generate private key. ERR: %s", err.Error()), MODULE, admin_log.LOG_CRITICAL)
+		return s.log.Write(fmt.Sprintf("failed to generate certificate serial number. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
 	}
 
-	keyUsage := x509.KeyUsageDigitalSignature
-	keyUsage |= x509.KeyUsageKeyEncipherment
+	dnsNames, ips := s.names()
+	if len(dnsNames) == 0 && len(ips) == 0 {
+		return s.log.Write("no dns names or ip addresses found for the certificate", MODULE, admin_log.LOG_ERROR)
+	}
 
-	validFrom := time.Now()
-	validUntil := validFrom.Add(365 * 24 * time.Hour)
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
-	if err != nil {
-		s.log.Write(fmt.Sprintf("failed to generate certificate serial number. ERR: %s", err.Error()), MODULE, admin_log.LOG_CRITICAL)
+	commonName := "Sandstorm Web Admin"
+	if len(dnsNames) > 0 {
+		commonName = dnsNames[0]
 	}
 
+	// only RSA keys are used for key encipherment
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := priv.(*rsa.PrivateKey); ok {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	validFrom := time.Now()
 	template := x509.Certificate{
 		SerialNumber: serialNumber,
 		Subject: pkix.Name{
-			Organization: []string{"Sandstorm Web Admin"},
+			Organization: []string{ORGANIZATION},
+			CommonName:   commonName,
 		},
 		NotBefore: validFrom,
-		NotAfter:  validUntil,
+		NotAfter:  validFrom.Add(time.Duration(s.Options.Validity) * 24 * time.Hour),
 
 		KeyUsage:              keyUsage,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
+		IsCA:                  false,
+		DNSNames:              dnsNames,
+		IPAddresses:           ips,
 	}
 
-	ips, err := s.ips()
-	if ips == nil || err != nil || len(*ips) == 0 {
-		if err == nil {
-			err = fmt.Errorf("no ip addresses found")
+	parent := &template
+	var signer any = priv
+	var chain []byte
+
+	if s.Options.LocalCa {
+		caCert, caKey, err := s.authority()
+		if err != nil {
+			return s.log.Write(fmt.Sprintf("failed to load the local certificate authority. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
 		}
-		s.log.Write(fmt.Sprintf("failed to get host ip addresses from network interfaces. ERR: %s", err), MODULE, admin_log.LOG_CRITICAL)
+		if template.NotAfter.After(caCert.NotAfter) {
+			template.NotAfter = caCert.NotAfter
+		}
+		parent = caCert
+		signer = caKey
+		chain = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
 	}
 
-	template.IPAddresses = append(template.IPAddresses, *ips...)
-
-	template.IsCA = true
-	template.KeyUsage |= x509.KeyUsageCertSign
-
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, s.publicKey(priv), priv)
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, parent, publicKey(priv), signer)
 	if err != nil {
-		s.log.Write(fmt.Sprintf("failed to generate SSL certificates. ERR: %s", err.Error()), MODULE, admin_log.LOG_CRITICAL)
+		return s.log.Write(fmt.Sprintf("failed to generate SSL certificates. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
 	}
 
-	// Write cert.pem
-	s.SslCert = path.Join(s.ConfigDir, CERT_NAME)
-	certOut, err := os.Create(s.SslCert)
+	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
 	if err != nil {
-		s.log.Write(fmt.Sprintf("failed to generate SSL certificates. ERR: %s", err.Error()), MODULE, admin_log.LOG_CRITICAL)
+		return s.log.Write(fmt.Sprintf("failed to marshal private key. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	certPem = append(certPem, chain...)
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes})
+
+	if err := s.write(certPem, keyPem, true); err != nil {
+		return err
 	}
-	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
-		s.log.Write(fmt.Sprintf("failed to write data to '%s'. ERR: %s", s.SslCert, err.Error()), MODULE, admin_log.LOG_CRITICAL)
+
+	s.log.Write(fmt.Sprintf("certificates '%s' and '%s' generated successfully", s.SslCert, s.SslKey), MODULE, admin_log.LOG_INFO)
+
+	return nil
+}
+
+// write saves a certificate and key pair at the configured paths, the
+// configuration directory when none is configured, and makes it the one in
+// use. The fingerprint of a generated certificate is recorded so only
+// those are replaced when they expire.
+func (s *Ssl) write(certPem []byte, keyPem []byte, generated bool) error {
+
+	s.mutex.RLock()
+	certPath := s.SslCert
+	keyPath := s.SslKey
+	s.mutex.RUnlock()
+
+	if certPath == "" {
+		certPath = path.Join(s.ConfigDir, CERT_NAME)
 	}
-	if err := certOut.Close(); err != nil {
-		s.log.Write(fmt.Sprintf("error closing '%s'. ERR: %s", s.SslCert, err.Error()), MODULE, admin_log.LOG_CRITICAL)
+	if keyPath == "" {
+		keyPath = path.Join(s.ConfigDir, KEY_NAME)
 	}
 
-	// Write key.pem
-	s.SslKey = path.Join(s.ConfigDir, KEY_NAME)
-	keyOut, err := os.OpenFile(s.SslKey, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		s.log.Write(fmt.Sprintf("failed to write data to '%s'. ERR: %s", s.SslKey, err.Error()), MODULE, admin_log.LOG_CRITICAL)
+	for _, dir := range []string{s.ConfigDir, filepath.Dir(certPath), filepath.Dir(keyPath)} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return s.log.Write(fmt.Sprintf("failed to create directory '%s'. ERR: %s", dir, err.Error()), MODULE, admin_log.LOG_ERROR)
+		}
 	}
-	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
-	if err != nil {
-		s.log.Write(fmt.Sprintf("failed to marshal private key '%s'. ERR: %s", s.SslKey, err.Error()), MODULE, admin_log.LOG_CRITICAL)
+
+	if err := os.WriteFile(certPath, certPem, 0644); err != nil {
+		return s.log.Write(fmt.Sprintf("failed to write data to '%s'. ERR: %s", certPath, err.Error()), MODULE, admin_log.LOG_ERROR)
 	}
-	if err := pem.Encode(keyOut, &pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}); err != nil {
-		s.log.Write(fmt.Sprintf("failed to write data to '%s'. ERR: %s", s.SslKey, err.Error()), MODULE, admin_log.LOG_CRITICAL)
+
+	if err := os.WriteFile(keyPath, keyPem, 0600); err != nil {
+		return s.log.Write(fmt.Sprintf("failed to write data to '%s'. ERR: %s", keyPath, err.Error()), MODULE, admin_log.LOG_ERROR)
 	}
-	if err := keyOut.Close(); err != nil {
-		s.log.Write(fmt.Sprintf("error closing '%s'. ERR: %s", s.SslKey, err.Error()), MODULE, admin_log.LOG_CRITICAL)
+
+	markerPath := path.Join(s.ConfigDir, GENERATED_NAME)
+	if generated {
+		block, _ := pem.Decode(certPem)
+		if block == nil {
+			return s.log.Write(fmt.Sprintf("failed to decode the certificate written to '%s'", certPath), MODULE, admin_log.LOG_ERROR)
+		}
+		leaf, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return s.log.Write(fmt.Sprintf("failed to parse the certificate written to '%s'. ERR: %s", certPath, err.Error()), MODULE, admin_log.LOG_ERROR)
+		}
+		if err := os.WriteFile(markerPath, []byte(Fingerprint(leaf)+"\n"), 0644); err != nil {
+			return s.log.Write(fmt.Sprintf("failed to write data to '%s'. ERR: %s", markerPath, err.Error()), MODULE, admin_log.LOG_ERROR)
+		}
+	} else if err := os.Remove(markerPath); err != nil && !os.IsNotExist(err) {
+		return s.log.Write(fmt.Sprintf("failed to remove '%s'. ERR: %s", markerPath, err.Error()), MODULE, admin_log.LOG_ERROR)
 	}
 
-	s.log.Write(fmt.Sprintf("certificates '%s' and '%s' generated successfully", s.SslCert, s.SslKey), MODULE, admin_log.LOG_INFO)
+	s.mutex.Lock()
+	s.SslCert = certPath
+	s.SslKey = keyPath
+	s.mutex.Unlock()
+
+	return s.reload()
+}
 
-	return false
+func generateKey(keyType string) (any, error) {
+
+	switch keyType {
+	case KEY_RSA_2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KEY_RSA_4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KEY_ECDSA_P256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KEY_ECDSA_P384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KEY_ED25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	}
+
+	return nil, fmt.Errorf("unknown key type '%s'", keyType)
 }
 
-func (s *Ssl) publicKey(priv any) any {
+func publicKey(priv any) any {
 	switch k := priv.(type) {
 	case *rsa.PrivateKey:
 		return &k.PublicKey
@@ -225,7 +357,54 @@ func (s *Ssl) publicKey(priv any) any {
 	}
 }
 
-func (s *Ssl) ips() (*[]net.IP, error) {
+func serial() (*big.Int, error) {
+
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// names returns the configured subject alternative names, detecting the
+// host name and addresses the web admin is reachable at when none are set.
+func (s *Ssl) names() ([]string, []net.IP) {
+
+	dnsNames := append([]string{}, s.Options.DnsNames...)
+	ips := make([]net.IP, 0)
+	for _, address := range s.Options.IpAddresses {
+		if ip := net.ParseIP(address); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	listen := net.ParseIP(s.Address)
+	loopback := listen != nil && listen.IsLoopback()
+
+	if len(dnsNames) == 0 {
+		if hostname, err := s.hostname(); err == nil && !loopback {
+			dnsNames = append(dnsNames, hostname)
+		}
+		if loopback || listen == nil || listen.IsUnspecified() {
+			dnsNames = append(dnsNames, "localhost")
+		}
+	}
+
+	if len(s.Options.IpAddresses) == 0 {
+		if listen != nil && !listen.IsUnspecified() {
+			ips = append(ips, listen)
+		} else {
+			detected, err := s.ips()
+			if err != nil {
+				s.log.Write(fmt.Sprintf("failed to get host ip addresses from network interfaces. ERR: %s", err.Error()), MODULE, admin_log.LOG_WARNING)
+			}
+			ips = append(ips, detected...)
+		}
+	}
+
+	return dnsNames, ips
+}
+
+// ips returns the addresses of the network interfaces, leaving out the
+// loopback and link local addresses that are never used to reach the web
+// admin from another host.
+func (s *Ssl) ips() ([]net.IP, error) {
 
 	ifaces, err := net.Interfaces()
 	if err != nil {
@@ -238,7 +417,6 @@ func (s *Ssl) ips() (*[]net.IP, error) {
 		if err != nil {
 			return nil, err
 		}
-		// handle err
 		for _, addr := range addrs {
 			var ip net.IP
 			switch v := addr.(type) {
@@ -247,11 +425,37 @@ func (s *Ssl) ips() (*[]net.IP, error) {
 			case *net.IPAddr:
 				ip = v.IP
 			}
+			if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+				continue
+			}
 			ips = append(ips, ip)
 		}
 	}
 
-	return &ips, nil
+	return ips, nil
+}
+
+func (s *Ssl) hostname() (string, error) {
+
+	if s.Host != "" {
+		return s.Host, nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+
+	// prefer the fully qualified name when the resolver knows it
+	if cname, err := net.LookupCNAME(hostname); err == nil {
+		if cname = strings.TrimSuffix(cname, "."); cname != "" {
+			hostname = cname
+		}
+	}
+
+	s.Host = hostname
+
+	return s.Host, nil
 }
 
 func (s *Ssl) validCertificates() error {
@@ -259,14 +463,3 @@ func (s *Ssl) validCertificates() error {
 	_, err := tls.LoadX509KeyPair(s.SslCert, s.SslKey)
 	return err
 }
-
-// func (s *Ssl) hostname() error {
-
-// 	var err error
-// 	s.Host, err = os.Hostname()
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	return nil
-// }
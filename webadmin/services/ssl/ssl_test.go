@@ -0,0 +1,105 @@
+package ssl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+)
+
+func TestNamesLeaveOutLoopback(t *testing.T) {
+
+	log := admin_log.New()
+	conf := config.New(log)
+	conf.WebAdmin.Address = "0.0.0.0"
+	s := New(conf, log)
+
+	_, ips := s.names()
+	for _, ip := range ips {
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			t.Errorf("detected address %s is in the certificate", ip)
+		}
+	}
+
+	// a loopback listen address is the one clients use
+	s.Address = "127.0.0.1"
+	if _, ips := s.names(); len(ips) != 1 || !ips[0].IsLoopback() {
+		t.Errorf("addresses %v, want the listen address", ips)
+	}
+}
+
+// selfSignedPem writes a self signed certificate and its key at the default
+// paths of the configuration directory.
+func selfSignedPem(t *testing.T, dir string, organization string) {
+
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{organization}, CommonName: "localhost"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, CERT_NAME), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, KEY_NAME), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadAdoptsGeneratedCertificates(t *testing.T) {
+
+	for _, c := range []struct {
+		organization string
+		adopted      bool
+	}{
+		{ORGANIZATION, true},
+		{"Someone Else", false},
+	} {
+		log := admin_log.New()
+		conf := config.New(log)
+		conf.WebAdmin.ConfigDir = t.TempDir()
+		conf.WebAdmin.SslUse = true
+		conf.WebAdmin.SslCert = ""
+		conf.WebAdmin.SslKey = ""
+		selfSignedPem(t, conf.WebAdmin.ConfigDir, c.organization)
+
+		s := New(conf, log)
+		if !s.Load() {
+			t.Fatalf("%s: failed to load the certificate", c.organization)
+		}
+
+		if generated := s.generated(s.certificate.Leaf); generated != c.adopted {
+			t.Errorf("%s: generated is %t, want %t", c.organization, generated, c.adopted)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
 	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
 	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
 )
 
@@ -21,15 +22,18 @@ type Steam struct {
 	Downloading       bool      `json:"downloading"`
 	Updating          bool      `json:"updating"`
 	log               *admin_log.Log
+	db                *store.Store
 	installerFilePath string
 	DownloadUrls      map[string]string
 }
 
 const (
 	MODULE = "steam"
+
+	KEY_LAST_UPDATED = "lastUpdated"
 )
 
-func New(conf *config.Configuration, log *admin_log.Log) *Steam {
+func New(conf *config.Configuration, log *admin_log.Log, db *store.Store) *Steam {
 
 	s := new(Steam)
 
@@ -38,6 +42,12 @@ func New(conf *config.Configuration, log *admin_log.Log) *Steam {
 	s.AutomaticUpdates = conf.Steam.AutomaticUpdates
 	s.DownloadUrls = conf.Steam.DownloadUrls
 	s.log = log
+	s.db = db
+
+	db.View(func(tx *store.Tx) error {
+		_, err := tx.Get(store.BUCKET_STEAM, KEY_LAST_UPDATED, &s.LastUpdated)
+		return err
+	})
 
 	return s
 }
@@ -105,7 +115,7 @@ func (s *Steam) IsInstalled() bool {
 		return false
 	}
 
-	path := fmt.Sprintf("%s/steamcmd.sh", s.Dir)
+	path := s.Executable()
 	isInstalled := utils.FileExists(path)
 	if isInstalled {
 		s.log.Write(fmt.Sprintf("steamcmd is installed at '%s'", path), MODULE, admin_log.LOG_INFO)
@@ -151,5 +161,28 @@ func (s *Steam) Install() error {
 	}
 
 	s.Updating = false
+	return s.Updated()
+}
+
+// Updated records that steamcmd installed or updated something now.
+func (s *Steam) Updated() error {
+
+	s.LastUpdated = time.Now()
+	err := s.db.Update(func(tx *store.Tx) error {
+		return tx.Put(store.BUCKET_STEAM, KEY_LAST_UPDATED, s.LastUpdated)
+	})
+	if err != nil {
+		return s.log.Write(fmt.Sprintf("failed to save the last update time. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
 	return nil
 }
+
+func (s *Steam) Executable() string {
+
+	if runtime.GOOS == "windows" {
+		return filepath.Join(s.Dir, "steamcmd.exe")
+	}
+
+	return filepath.Join(s.Dir, "steamcmd.sh")
+}
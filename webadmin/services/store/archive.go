@@ -0,0 +1,108 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+)
+
+// Archive is the portable copy of the whole store used to move the web
+// admin to another host.
+type Archive struct {
+	Version  int                                   `json:"version"`
+	Exported time.Time                             `json:"exported"`
+	Buckets  map[string]map[string]json.RawMessage `json:"buckets"`
+}
+
+// Export writes every bucket of the store as a single JSON document.
+func (s *Store) Export(w io.Writer) error {
+
+	archive := Archive{Exported: time.Now().UTC(), Buckets: make(map[string]map[string]json.RawMessage)}
+
+	err := s.View(func(tx *Tx) error {
+		if _, err := tx.Get(BUCKET_META, KEY_VERSION, &archive.Version); err != nil {
+			return err
+		}
+		for name, bucket := range s.buckets {
+			if len(bucket) == 0 {
+				continue
+			}
+			values := make(map[string]json.RawMessage, len(bucket))
+			for key, value := range bucket {
+				values[key] = value
+			}
+			archive.Buckets[name] = values
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(archive)
+}
+
+// Import replaces the whole content of the store with an archive made by
+// Export, then migrates it if it comes from an older version.
+func (s *Store) Import(r io.Reader) error {
+
+	var archive Archive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return fmt.Errorf("failed to parse store archive. ERR: %s", err.Error())
+	}
+	if archive.Buckets == nil {
+		return fmt.Errorf("the store archive has no buckets")
+	}
+	if archive.Version > Latest() {
+		return fmt.Errorf("the store archive has version %d, newer than the %d this web admin knows", archive.Version, Latest())
+	}
+
+	version := make(map[string]json.RawMessage)
+	for key, value := range archive.Buckets[BUCKET_META] {
+		version[key] = value
+	}
+	data, _ := json.Marshal(archive.Version)
+	version[KEY_VERSION] = data
+	archive.Buckets[BUCKET_META] = version
+
+	for name, bucket := range archive.Buckets {
+		for key, value := range bucket {
+			if !json.Valid(value) {
+				return fmt.Errorf("invalid value of '%s' in bucket '%s'", key, name)
+			}
+		}
+	}
+
+	s.mutex.Lock()
+	err := func() error {
+		if s.closed {
+			return fmt.Errorf("store '%s' is closed", s.path)
+		}
+		unlock, err := s.lock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if err := s.write(s.path, archive.Buckets); err != nil {
+			return err
+		}
+		s.file = nil
+
+		return s.refresh()
+	}()
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.log.Write(fmt.Sprintf("store archive of version %d exported at %s imported into '%s'", archive.Version, archive.Exported.Format(time.RFC3339), s.path), MODULE, admin_log.LOG_INFO)
+
+	return s.migrate()
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd
+
+package store
+
+import (
+	"os"
+)
+
+// lock is a no-op where flock is not available, a single process then
+// has to use the store at a time.
+func lock(file *os.File) error {
+
+	return nil
+}
+
+func unlock(file *os.File) error {
+
+	return nil
+}
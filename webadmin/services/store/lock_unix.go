@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package store
+
+import (
+	"os"
+	"syscall"
+)
+
+func lock(file *os.File) error {
+
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+func unlock(file *os.File) error {
+
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,220 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+// Migration changes the data of the store from the previous version to
+// Version. Migrations run in order, each in its own transaction.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(s *Store, tx *Tx) error
+}
+
+const (
+	BUCKET_META          = "meta"
+	BUCKET_INSTANCES     = "instances"
+	BUCKET_USERS         = "users"
+	BUCKET_SCHEDULES     = "schedules"
+	BUCKET_RUNS          = "schedule_runs"
+	BUCKET_WEBHOOKS      = "webhooks"
+	BUCKET_DELIVERIES    = "webhook_deliveries"
+	BUCKET_AUDIT         = "audit"
+	BUCKET_BACKUPS       = "backups"
+	BUCKET_STEAM         = "steam"
+	BUCKET_PLAYERS       = "players"
+	BUCKET_SESSIONS      = "player_sessions"
+	BUCKET_CHAT          = "chat_messages"
+	BUCKET_CHAT_FILTER   = "chat_filter"
+	BUCKET_ANNOUNCEMENTS = "announcements"
+	BUCKET_TEMPLATES     = "templates"
+
+	KEY_VERSION = "version"
+
+	// IMPORTED_SUFFIX is added to the files imported into the store
+	IMPORTED_SUFFIX = ".imported"
+)
+
+var (
+	migrations = []Migration{
+		{Version: 1, Name: "import the files of earlier versions", Up: importFiles},
+	}
+)
+
+// Version returns the schema version of the data in the store.
+func (s *Store) Version() int {
+
+	version := 0
+	s.View(func(tx *Tx) error {
+		_, err := tx.Get(BUCKET_META, KEY_VERSION, &version)
+		return err
+	})
+
+	return version
+}
+
+// Latest is the version the migrations bring the store to.
+func Latest() int {
+
+	return migrations[len(migrations)-1].Version
+}
+
+func (s *Store) migrate() error {
+
+	current := s.Version()
+	if current > Latest() {
+		return fmt.Errorf("store '%s' has version %d, newer than the %d this web admin knows", s.path, current, Latest())
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+		err := s.Update(func(tx *Tx) error {
+			if err := migration.Up(s, tx); err != nil {
+				return err
+			}
+			return tx.Put(BUCKET_META, KEY_VERSION, migration.Version)
+		})
+		if err != nil {
+			return fmt.Errorf("store migration %d (%s) failed. ERR: %s", migration.Version, migration.Name, err.Error())
+		}
+		s.log.Write(fmt.Sprintf("store migrated to version %d: %s", migration.Version, migration.Name), MODULE, admin_log.LOG_INFO)
+	}
+
+	return nil
+}
+
+// RunKey orders the keys of a history bucket by time.
+func RunKey(t time.Time, id string) string {
+
+	return fmt.Sprintf("%s_%s", t.UTC().Format("20060102T150405.000000000"), id)
+}
+
+// SequenceKey orders the keys of an append only bucket.
+func SequenceKey(n uint64) string {
+
+	return fmt.Sprintf("%020d", n)
+}
+
+// importFiles moves the JSON files the services used before the store into
+// their buckets and renames them so they are not read again.
+func importFiles(s *Store, tx *Tx) error {
+
+	dir := filepath.Dir(s.path)
+	imported := make([]string, 0)
+
+	maps := map[string]string{
+		"instances.json": BUCKET_INSTANCES,
+		"users.json":     BUCKET_USERS,
+		"schedules.json": BUCKET_SCHEDULES,
+		"webhooks.json":  BUCKET_WEBHOOKS,
+	}
+	for name, bucket := range maps {
+		path := filepath.Join(dir, name)
+		if !utils.FileExists(path) {
+			continue
+		}
+		values := make(map[string]json.RawMessage)
+		if err := readJSON(path, &values); err != nil {
+			return err
+		}
+		for key, value := range values {
+			if err := tx.Put(bucket, key, value); err != nil {
+				return err
+			}
+		}
+		imported = append(imported, path)
+	}
+
+	histories := map[string]string{
+		"schedules_history.json":   BUCKET_RUNS,
+		"webhooks_deliveries.json": BUCKET_DELIVERIES,
+	}
+	for name, bucket := range histories {
+		path := filepath.Join(dir, name)
+		if !utils.FileExists(path) {
+			continue
+		}
+		values := make([]json.RawMessage, 0)
+		if err := readJSON(path, &values); err != nil {
+			return err
+		}
+		for _, value := range values {
+			var entry struct {
+				ID      string    `json:"id"`
+				Started time.Time `json:"started"`
+				Time    time.Time `json:"time"`
+			}
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return fmt.Errorf("failed to parse '%s'. ERR: %s", path, err.Error())
+			}
+			if entry.Started.IsZero() {
+				entry.Started = entry.Time
+			}
+			if err := tx.Put(bucket, RunKey(entry.Started, entry.ID), value); err != nil {
+				return err
+			}
+		}
+		imported = append(imported, path)
+	}
+
+	path := filepath.Join(dir, "audit.log")
+	if utils.FileExists(path) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read '%s'. ERR: %s", path, err.Error())
+		}
+		position := uint64(0)
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if !json.Valid([]byte(line)) {
+				return fmt.Errorf("invalid entry at position %d of '%s'", position+1, path)
+			}
+			position++
+			if err := tx.Put(BUCKET_AUDIT, SequenceKey(position), json.RawMessage(line)); err != nil {
+				return err
+			}
+		}
+		imported = append(imported, path)
+	}
+
+	// the files are renamed only when the transaction is written, a failed
+	// migration leaves them in place for the next attempt
+	tx.after = append(tx.after, func() {
+		for _, path := range imported {
+			if err := os.Rename(path, path+IMPORTED_SUFFIX); err != nil {
+				s.log.Write(fmt.Sprintf("failed to rename imported file '%s'. ERR: %s", path, err.Error()), MODULE, admin_log.LOG_WARNING)
+				continue
+			}
+			s.log.Write(fmt.Sprintf("'%s' imported into the store", path), MODULE, admin_log.LOG_INFO)
+		}
+	})
+
+	return nil
+}
+
+func readJSON(path string, v any) error {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read '%s'. ERR: %s", path, err.Error())
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse '%s'. ERR: %s", path, err.Error())
+	}
+
+	return nil
+}
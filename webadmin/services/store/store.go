@@ -0,0 +1,393 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+)
+
+// Store is the embedded database of the web admin. Values are JSON
+// documents kept by key in named buckets, all held in memory and persisted
+// to a single append-only file where every line is one committed
+// transaction. A line cut short by a crash is ignored, superseded values
+// are dropped when the file is compacted.
+//
+// Several processes may share the file, the command line and the running
+// web admin for example: writers take an exclusive lock on a companion lock
+// file and every transaction first replays what the others appended.
+type Store struct {
+	path    string
+	buckets map[string]map[string]json.RawMessage
+	file    os.FileInfo
+	offset  int64
+	live    int
+	garbage int
+	closed  bool
+	log     *admin_log.Log
+	mutex   sync.Mutex
+}
+
+// op is one change of a transaction, a nil value deletes the key.
+type op struct {
+	Bucket string          `json:"b"`
+	Key    string          `json:"k"`
+	Value  json.RawMessage `json:"v,omitempty"`
+}
+
+const (
+	MODULE = "store"
+
+	STORE_FILE = "web_admin.db"
+	LOCK_FILE  = "web_admin.db.lock"
+
+	// the file is compacted when it holds more superseded values than
+	// live ones, and at least COMPACT_MIN of them
+	COMPACT_MIN = 1000
+
+	// MAX_TRANSACTION is the largest transaction read back from the file
+	MAX_TRANSACTION = 64 << 20
+)
+
+func New(conf *config.Configuration, log *admin_log.Log) *Store {
+
+	s := new(Store)
+	s.path = filepath.Join(conf.WebAdmin.ConfigDir, STORE_FILE)
+	s.buckets = make(map[string]map[string]json.RawMessage)
+	s.log = log
+
+	return s
+}
+
+// Open loads the store file, creating it when needed, and runs the
+// migrations not applied yet.
+func (s *Store) Open() error {
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0750); err != nil {
+		return s.log.Write(fmt.Sprintf("failed to create directory '%s'. ERR: %s", filepath.Dir(s.path), err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	s.mutex.Lock()
+	err := s.refresh()
+	s.mutex.Unlock()
+	if err != nil {
+		return s.log.Write(err.Error(), MODULE, admin_log.LOG_ERROR)
+	}
+
+	if err := s.migrate(); err != nil {
+		return s.log.Write(err.Error(), MODULE, admin_log.LOG_ERROR)
+	}
+
+	return nil
+}
+
+// Close waits for the running transaction and refuses the next ones, the
+// services still writing after a shutdown get an error instead of racing
+// with the process that takes the store over.
+func (s *Store) Close() {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+}
+
+// View runs fn with a read only transaction.
+func (s *Store) View(fn func(tx *Tx) error) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("store '%s' is closed", s.path)
+	}
+	if err := s.refresh(); err != nil {
+		return err
+	}
+
+	return fn(&Tx{store: s})
+}
+
+// Update runs fn with a transaction whose changes are written when fn
+// returns no error and discarded otherwise.
+func (s *Store) Update(fn func(tx *Tx) error) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("store '%s' is closed", s.path)
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.refresh(); err != nil {
+		return err
+	}
+
+	tx := &Tx{store: s, writable: true, pending: make(map[string]map[string]json.RawMessage)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := s.commit(tx.ops); err != nil {
+		return err
+	}
+	for _, fn := range tx.after {
+		fn()
+	}
+
+	if s.garbage >= COMPACT_MIN && s.garbage > s.live {
+		if err := s.compact(); err != nil {
+			s.log.Write(err.Error(), MODULE, admin_log.LOG_WARNING)
+		}
+	}
+
+	return nil
+}
+
+// lock takes the exclusive lock shared with the other processes.
+func (s *Store) lock() (func(), error) {
+
+	path := filepath.Join(filepath.Dir(s.path), LOCK_FILE)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store lock '%s'. ERR: %s", path, err.Error())
+	}
+
+	if err := lock(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock store '%s'. ERR: %s", path, err.Error())
+	}
+
+	return func() {
+		unlock(f)
+		f.Close()
+	}, nil
+}
+
+// refresh replays the transactions appended since the last call, or the
+// whole file when it was replaced by a compaction or an import.
+func (s *Store) refresh() error {
+
+	fi, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.reset()
+		s.file = nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read store '%s'. ERR: %s", s.path, err.Error())
+	}
+
+	if s.file == nil || !os.SameFile(s.file, fi) || fi.Size() < s.offset {
+		s.reset()
+	} else if fi.Size() == s.offset {
+		return nil
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open store '%s'. ERR: %s", s.path, err.Error())
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(s.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read store '%s'. ERR: %s", s.path, err.Error())
+	}
+
+	reader := bufio.NewReaderSize(f, 64<<10)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// an incomplete transaction is still being written or was cut
+			// short by a crash, it is not part of the store
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read store '%s'. ERR: %s", s.path, err.Error())
+		}
+		if len(line) > MAX_TRANSACTION {
+			return fmt.Errorf("transaction at offset %d of store '%s' is too large", s.offset, s.path)
+		}
+
+		var ops []op
+		if err := json.Unmarshal(bytes.TrimSpace(line), &ops); err != nil {
+			return fmt.Errorf("failed to parse transaction at offset %d of store '%s'. ERR: %s", s.offset, s.path, err.Error())
+		}
+		s.apply(ops)
+		s.offset += int64(len(line))
+	}
+
+	s.file = fi
+
+	return nil
+}
+
+func (s *Store) reset() {
+
+	s.buckets = make(map[string]map[string]json.RawMessage)
+	s.offset = 0
+	s.live = 0
+	s.garbage = 0
+}
+
+func (s *Store) apply(ops []op) {
+
+	for _, o := range ops {
+		bucket, ok := s.buckets[o.Bucket]
+		if !ok {
+			bucket = make(map[string]json.RawMessage)
+			s.buckets[o.Bucket] = bucket
+		}
+		if _, ok := bucket[o.Key]; ok {
+			s.garbage++
+			s.live--
+		}
+		if o.Value == nil {
+			delete(bucket, o.Key)
+			s.garbage++
+			continue
+		}
+		bucket[o.Key] = o.Value
+		s.live++
+	}
+}
+
+// commit appends the transaction to the file and applies it.
+func (s *Store) commit(ops []op) error {
+
+	if len(ops) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return fmt.Errorf("failed to serialize transaction. ERR: %s", err.Error())
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open store '%s'. ERR: %s", s.path, err.Error())
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write store '%s'. ERR: %s", s.path, err.Error())
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync store '%s'. ERR: %s", s.path, err.Error())
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read store '%s'. ERR: %s", s.path, err.Error())
+	}
+
+	s.apply(ops)
+	s.offset = fi.Size()
+	s.file = fi
+
+	return nil
+}
+
+// compact rewrites the file with the live values only, one transaction per
+// bucket, and replaces the old file atomically.
+func (s *Store) compact() error {
+
+	if err := s.write(s.path, s.buckets); err != nil {
+		return err
+	}
+
+	live, garbage := s.live, s.garbage
+	s.file = nil
+	if err := s.refresh(); err != nil {
+		return err
+	}
+
+	s.log.Write(fmt.Sprintf("store '%s' compacted, %d values kept and %d dropped", s.path, live, garbage), MODULE, admin_log.LOG_INFO)
+
+	return nil
+}
+
+// write replaces the file at path with the buckets, through a temporary
+// file renamed over it.
+func (s *Store) write(path string, buckets map[string]map[string]json.RawMessage) error {
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to create '%s'. ERR: %s", tmp, err.Error())
+	}
+
+	writer := bufio.NewWriter(f)
+	for _, name := range bucketNames(buckets) {
+		bucket := buckets[name]
+		ops := make([]op, 0, len(bucket))
+		for _, key := range bucketKeys(bucket) {
+			ops = append(ops, op{Bucket: name, Key: key, Value: bucket[key]})
+		}
+		if len(ops) == 0 {
+			continue
+		}
+		data, err := json.Marshal(ops)
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("failed to serialize bucket '%s'. ERR: %s", name, err.Error())
+		}
+		writer.Write(append(data, '\n'))
+	}
+
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write '%s'. ERR: %s", tmp, err.Error())
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to sync '%s'. ERR: %s", tmp, err.Error())
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace '%s'. ERR: %s", path, err.Error())
+	}
+
+	return nil
+}
+
+func bucketNames(buckets map[string]map[string]json.RawMessage) []string {
+
+	names := make([]string, 0, len(buckets))
+	for name := range buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func bucketKeys(bucket map[string]json.RawMessage) []string {
+
+	keys := make([]string, 0, len(bucket))
+	for key := range bucket {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
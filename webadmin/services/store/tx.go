@@ -0,0 +1,152 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Tx reads the store and, for the transactions of Update, collects the
+// changes that are committed together once the transaction function
+// returns. Reads see the changes made earlier in the same transaction.
+type Tx struct {
+	store    *Store
+	writable bool
+	ops      []op
+	pending  map[string]map[string]json.RawMessage
+	after    []func()
+}
+
+// raw returns the current value of a key, with the pending changes.
+func (tx *Tx) raw(bucket string, key string) (json.RawMessage, bool) {
+
+	if changes, ok := tx.pending[bucket]; ok {
+		if value, ok := changes[key]; ok {
+			return value, value != nil
+		}
+	}
+
+	value, ok := tx.store.buckets[bucket][key]
+
+	return value, ok
+}
+
+// Get decodes the value of key into v and tells if it was found.
+func (tx *Tx) Get(bucket string, key string, v any) (bool, error) {
+
+	value, ok := tx.raw(bucket, key)
+	if !ok {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(value, v); err != nil {
+		return true, fmt.Errorf("failed to parse '%s' in bucket '%s'. ERR: %s", key, bucket, err.Error())
+	}
+
+	return true, nil
+}
+
+func (tx *Tx) Put(bucket string, key string, v any) error {
+
+	if !tx.writable {
+		return fmt.Errorf("read only transaction")
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to serialize '%s' in bucket '%s'. ERR: %s", key, bucket, err.Error())
+	}
+
+	tx.set(bucket, key, value)
+
+	return nil
+}
+
+func (tx *Tx) Delete(bucket string, key string) error {
+
+	if !tx.writable {
+		return fmt.Errorf("read only transaction")
+	}
+
+	if _, ok := tx.raw(bucket, key); ok {
+		tx.set(bucket, key, nil)
+	}
+
+	return nil
+}
+
+// Clear deletes every key of the bucket.
+func (tx *Tx) Clear(bucket string) error {
+
+	for _, key := range tx.Keys(bucket) {
+		if err := tx.Delete(bucket, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (tx *Tx) set(bucket string, key string, value json.RawMessage) {
+
+	changes, ok := tx.pending[bucket]
+	if !ok {
+		changes = make(map[string]json.RawMessage)
+		tx.pending[bucket] = changes
+	}
+	changes[key] = value
+	tx.ops = append(tx.ops, op{Bucket: bucket, Key: key, Value: value})
+}
+
+// Keys returns the keys of the bucket in ascending order.
+func (tx *Tx) Keys(bucket string) []string {
+
+	keys := make([]string, 0, len(tx.store.buckets[bucket]))
+	for key := range tx.store.buckets[bucket] {
+		if value, ok := tx.pending[bucket][key]; ok && value == nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	for key, value := range tx.pending[bucket] {
+		if _, ok := tx.store.buckets[bucket][key]; !ok && value != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (tx *Tx) Count(bucket string) int {
+
+	return len(tx.Keys(bucket))
+}
+
+// ForEach calls fn with the raw value of every key of the bucket in
+// ascending key order, stopping at the first error.
+func (tx *Tx) ForEach(bucket string, fn func(key string, value []byte) error) error {
+
+	for _, key := range tx.Keys(bucket) {
+		value, _ := tx.raw(bucket, key)
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Last returns the greatest key of the bucket and decodes its value into v.
+func (tx *Tx) Last(bucket string, v any) (string, error) {
+
+	keys := tx.Keys(bucket)
+	if len(keys) == 0 {
+		return "", nil
+	}
+
+	key := keys[len(keys)-1]
+	_, err := tx.Get(bucket, key, v)
+
+	return key, err
+}
@@ -0,0 +1,128 @@
+package templates
+
+import (
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+)
+
+const (
+	PRESET_CHECKPOINT = "checkpoint-coop"
+	PRESET_PVP        = "push-firefight-pvp"
+	PRESET_HARDCORE   = "hardcore-coop"
+	PRESET_EVENT      = "event"
+
+	SECTION_GAME_MODE   = "/Script/Insurgency.INSGameMode"
+	SECTION_MULTIPLAYER = "/Script/Insurgency.INSMultiplayerMode"
+	SECTION_COOP        = "/Script/Insurgency.INSCoopMode"
+	SECTION_CHECKPOINT  = "/Script/Insurgency.INSCheckpointGameMode"
+)
+
+// Presets are the templates that come with the web admin, a new list on
+// every call so they can not be changed.
+func Presets() []Template {
+
+	return []Template{
+		{
+			ID:          PRESET_CHECKPOINT,
+			Name:        "Checkpoint coop",
+			Description: "Cooperative checkpoint against bots on every map, from both sides.",
+			Builtin:     true,
+			Map:         "Oilfield",
+			Scenario:    "Scenario_Refinery_Checkpoint_Security",
+			MaxPlayers:  8,
+			Ini: map[string]insurgency.Ini{
+				insurgency.GAME_INI: {
+					SECTION_MULTIPLAYER: {"bMapVoting": "True"},
+					SECTION_COOP:        {"AIDifficulty": "0.5"},
+					SECTION_CHECKPOINT:  {"MinimumEnemies": "8", "MaximumEnemies": "24"},
+				},
+			},
+			MapCycle: []string{
+				"Scenario_Refinery_Checkpoint_Security",
+				"Scenario_Farmhouse_Checkpoint_Security",
+				"Scenario_Hideout_Checkpoint_Insurgents",
+				"Scenario_Precinct_Checkpoint_Security",
+				"Scenario_Summit_Checkpoint_Insurgents",
+				"Scenario_Crossing_Checkpoint_Security",
+				"Scenario_Tideway_Checkpoint_Insurgents",
+				"Scenario_Outskirts_Checkpoint_Security",
+			},
+		},
+		{
+			ID:          PRESET_PVP,
+			Name:        "Push and Firefight PvP",
+			Description: "Player versus player rotating Push and Firefight.",
+			Builtin:     true,
+			Map:         "Canyon",
+			Scenario:    "Scenario_Crossing_Push_Security",
+			MaxPlayers:  28,
+			Ini: map[string]insurgency.Ini{
+				insurgency.GAME_INI: {
+					SECTION_MULTIPLAYER: {"bMapVoting": "True"},
+					SECTION_GAME_MODE:   {"bKillFeed": "True", "bAutoBalanceTeams": "True"},
+				},
+			},
+			MapCycle: []string{
+				"Scenario_Crossing_Push_Security",
+				"Scenario_Farmhouse_Firefight_East",
+				"Scenario_Hideout_Push_Insurgents",
+				"Scenario_Summit_Firefight_West",
+				"Scenario_Precinct_Push_Security",
+				"Scenario_Refinery_Firefight_West",
+				"Scenario_Tideway_Push_Insurgents",
+				"Scenario_Outskirts_Firefight_East",
+			},
+		},
+		{
+			ID:          PRESET_HARDCORE,
+			Name:        "Hardcore coop",
+			Description: "Checkpoint in the hardcore mode, harder bots and no kill feed.",
+			Builtin:     true,
+			Map:         "Oilfield",
+			Scenario:    "Scenario_Refinery_Checkpoint_Security",
+			Mode:        "CheckpointHardcore",
+			MaxPlayers:  8,
+			Ini: map[string]insurgency.Ini{
+				insurgency.GAME_INI: {
+					SECTION_GAME_MODE:  {"bKillFeed": "False", "bDeadSay": "False", "bVoiceAllowDeadChat": "False"},
+					SECTION_COOP:       {"AIDifficulty": "1.0"},
+					SECTION_CHECKPOINT: {"MinimumEnemies": "12", "MaximumEnemies": "32"},
+				},
+			},
+			MapCycle: []string{
+				"Scenario_Refinery_Checkpoint_Security",
+				"Scenario_Farmhouse_Checkpoint_Insurgents",
+				"Scenario_Hideout_Checkpoint_Security",
+				"Scenario_Precinct_Checkpoint_Insurgents",
+			},
+		},
+		{
+			ID:          PRESET_EVENT,
+			Name:        "Event",
+			Description: "One fixed scenario without map voting, for organized matches.",
+			Builtin:     true,
+			Map:         "Farmhouse",
+			Scenario:    "Scenario_Farmhouse_Push_Security",
+			MaxPlayers:  20,
+			Ini: map[string]insurgency.Ini{
+				insurgency.GAME_INI: {
+					SECTION_MULTIPLAYER: {"bMapVoting": "False"},
+					SECTION_GAME_MODE:   {"bAutoBalanceTeams": "False"},
+				},
+			},
+			MapCycle: []string{
+				"Scenario_Farmhouse_Push_Security",
+			},
+		},
+	}
+}
+
+func isPreset(id string) bool {
+
+	for _, preset := range Presets() {
+		if preset.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
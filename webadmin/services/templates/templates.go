@@ -0,0 +1,476 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/insurgency"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+// Template is what instances made from it share: the launch arguments,
+// overrides of the INI files by file, section and key, the map cycle, the
+// mods and the admins. Empty values leave the instance as it is.
+type Template struct {
+	ID          string                    `json:"id"`
+	Name        string                    `json:"name"`
+	Description string                    `json:"description"`
+	Builtin     bool                      `json:"builtin"`
+	Map         string                    `json:"map"`
+	Scenario    string                    `json:"scenario"`
+	Mode        string                    `json:"mode"`
+	MaxPlayers  int                       `json:"maxPlayers"`
+	Arguments   []string                  `json:"arguments"`
+	Ini         map[string]insurgency.Ini `json:"ini"`
+	MapCycle    []string                  `json:"mapCycle"`
+	Mods        []string                  `json:"mods"`
+	Admins      []string                  `json:"admins"`
+	Created     time.Time                 `json:"created"`
+	Updated     time.Time                 `json:"updated"`
+}
+
+// Change is a value of the instance definition a template changes.
+type Change struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// File is a server file a template changes with its unified diff.
+type File struct {
+	Name    string `json:"name"`
+	Diff    string `json:"diff"`
+	content string
+}
+
+// Plan is what applying a template does to an instance.
+type Plan struct {
+	Template string   `json:"template"`
+	Instance string   `json:"instance"`
+	Changes  []Change `json:"changes"`
+	Files    []File   `json:"files"`
+}
+
+type Templates struct {
+	instances *insurgency.Instances
+	db        *store.Store
+	log       *admin_log.Log
+	mutex     sync.Mutex
+}
+
+const (
+	MODULE = "templates"
+)
+
+var (
+	validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+func New(conf *config.Configuration, log *admin_log.Log, instances *insurgency.Instances, db *store.Store) *Templates {
+
+	t := new(Templates)
+	t.instances = instances
+	t.db = db
+	t.log = log
+
+	return t
+}
+
+// List returns the presets followed by the saved templates.
+func (t *Templates) List() ([]Template, error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	list := Presets()
+	saved := make([]Template, 0)
+	err := t.db.View(func(tx *store.Tx) error {
+		return tx.ForEach(store.BUCKET_TEMPLATES, func(id string, value []byte) error {
+			var template Template
+			if err := json.Unmarshal(value, &template); err != nil {
+				return fmt.Errorf("failed to parse template '%s'. ERR: %s", id, err.Error())
+			}
+			saved = append(saved, template)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, t.log.Write(fmt.Sprintf("failed to load templates. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	sort.Slice(saved, func(a, b int) bool {
+		return saved[a].ID < saved[b].ID
+	})
+
+	return append(list, saved...), nil
+}
+
+func (t *Templates) Get(id string) (*Template, error) {
+
+	for _, preset := range Presets() {
+		if preset.ID == id {
+			return &preset, nil
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	template := new(Template)
+	var found bool
+	err := t.db.View(func(tx *store.Tx) error {
+		var err error
+		found, err = tx.Get(store.BUCKET_TEMPLATES, id, template)
+		return err
+	})
+	if err != nil {
+		return nil, t.log.Write(fmt.Sprintf("failed to read template '%s'. ERR: %s", id, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	if !found {
+		return nil, fmt.Errorf("template '%s' not found", id)
+	}
+
+	return template, nil
+}
+
+// Save validates and adds or replaces a template, presets can not be
+// replaced.
+func (t *Templates) Save(template Template) (*Template, error) {
+
+	if err := template.validate(); err != nil {
+		return nil, err
+	}
+	if isPreset(template.ID) {
+		return nil, fmt.Errorf("template '%s' is a preset and can not be changed", template.ID)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	template.Builtin = false
+	template.Updated = time.Now()
+	err := t.db.Update(func(tx *store.Tx) error {
+		var existing Template
+		if found, err := tx.Get(store.BUCKET_TEMPLATES, template.ID, &existing); err != nil {
+			return err
+		} else if found {
+			template.Created = existing.Created
+		} else {
+			template.Created = template.Updated
+		}
+		return tx.Put(store.BUCKET_TEMPLATES, template.ID, template)
+	})
+	if err != nil {
+		return nil, t.log.Write(fmt.Sprintf("failed to save template '%s'. ERR: %s", template.ID, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	return &template, nil
+}
+
+func (t *Templates) Remove(id string) error {
+
+	if isPreset(id) {
+		return fmt.Errorf("template '%s' is a preset and can not be removed", id)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	found := false
+	err := t.db.Update(func(tx *store.Tx) error {
+		var template Template
+		var err error
+		if found, err = tx.Get(store.BUCKET_TEMPLATES, id, &template); err != nil || !found {
+			return err
+		}
+		return tx.Delete(store.BUCKET_TEMPLATES, id)
+	})
+	if err != nil {
+		return t.log.Write(fmt.Sprintf("failed to remove template '%s'. ERR: %s", id, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+	if !found {
+		return fmt.Errorf("template '%s' not found", id)
+	}
+
+	return nil
+}
+
+func (template *Template) validate() error {
+
+	if !validID.MatchString(template.ID) {
+		return fmt.Errorf("invalid template id '%s'", template.ID)
+	}
+	if strings.TrimSpace(template.Name) == "" {
+		template.Name = template.ID
+	}
+	if template.MaxPlayers < 0 {
+		return fmt.Errorf("invalid max players %d", template.MaxPlayers)
+	}
+
+	for name := range template.Ini {
+		if !contains(insurgency.IniFiles, name) {
+			return fmt.Errorf("unknown INI file '%s', the files are %s", name, strings.Join(insurgency.IniFiles, ", "))
+		}
+	}
+
+	// the scenario may come from a mod, only the game mode is checked
+	if template.Mode != "" {
+		cycle := insurgency.ParseMapCycle(strings.Join(template.MapCycle, "\n"))
+		travel := insurgency.Travel{Scenario: template.Scenario, Mode: template.Mode, Map: template.Map}
+		if travel.Scenario == "" {
+			return fmt.Errorf("game mode '%s' needs a scenario", template.Mode)
+		}
+		if _, err := insurgency.NewCatalogue(cycle).TravelCommand(travel); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FromInstance makes a template of the definition and the server files of
+// an instance, with every value of its INI files.
+func (t *Templates) FromInstance(instance *insurgency.Instance, id string, name string, description string) (*Template, error) {
+
+	template := Template{
+		ID:          id,
+		Name:        name,
+		Description: description,
+		Map:         instance.Map,
+		Scenario:    instance.Scenario,
+		Mode:        instance.Mode,
+		MaxPlayers:  instance.MaxPlayers,
+		Arguments:   append([]string{}, instance.Arguments...),
+		Ini:         make(map[string]insurgency.Ini),
+	}
+
+	files := instance.ConfigurationFiles()
+	for _, name := range insurgency.IniFiles {
+		content, err := read(files[name])
+		if err != nil {
+			return nil, err
+		}
+		if ini := insurgency.ParseIni(content); len(ini) > 0 {
+			template.Ini[name] = ini
+		}
+	}
+
+	lists := map[string]*[]string{insurgency.MAPCYCLE_TXT: &template.MapCycle, insurgency.MODS_TXT: &template.Mods, insurgency.ADMINS_TXT: &template.Admins}
+	for name, list := range lists {
+		content, err := read(files[name])
+		if err != nil {
+			return nil, err
+		}
+		*list = lines(content)
+	}
+
+	return t.Save(template)
+}
+
+// Plan returns what applying a template changes on an instance, which may
+// not be added yet.
+func (t *Templates) Plan(template *Template, instance *insurgency.Instance) (*Plan, error) {
+
+	plan := &Plan{Template: template.ID, Instance: instance.ID, Changes: make([]Change, 0), Files: make([]File, 0)}
+
+	change := func(field string, from string, to string) {
+		if to != "" && from != to {
+			plan.Changes = append(plan.Changes, Change{Field: field, From: from, To: to})
+		}
+	}
+	change("map", instance.Map, template.Map)
+	change("scenario", instance.Scenario, template.Scenario)
+	change("mode", instance.Mode, template.Mode)
+	if template.MaxPlayers > 0 {
+		change("maxPlayers", fmt.Sprint(instance.MaxPlayers), fmt.Sprint(template.MaxPlayers))
+	}
+	if len(template.Arguments) > 0 {
+		change("arguments", strings.Join(instance.Arguments, " "), strings.Join(template.Arguments, " "))
+	}
+
+	files := instance.ConfigurationFiles()
+	contents := make(map[string]string)
+	for name, ini := range template.Ini {
+		current, err := read(files[name])
+		if err != nil {
+			return nil, err
+		}
+		contents[name] = insurgency.SetIni(current, ini)
+	}
+	lists := map[string][]string{insurgency.MAPCYCLE_TXT: template.MapCycle, insurgency.MODS_TXT: template.Mods, insurgency.ADMINS_TXT: template.Admins}
+	for name, list := range lists {
+		if len(list) > 0 {
+			contents[name] = strings.Join(list, "\n") + "\n"
+		}
+	}
+
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		current, err := read(files[name])
+		if err != nil {
+			return nil, err
+		}
+		if current == contents[name] {
+			continue
+		}
+		diff := utils.UnifiedDiff(current, contents[name], fmt.Sprintf("%s (current)", name), fmt.Sprintf("%s (template %s)", name, template.ID))
+		plan.Files = append(plan.Files, File{Name: name, Diff: diff, content: contents[name]})
+	}
+
+	return plan, nil
+}
+
+// Apply changes an instance as planned by the template. The server files
+// are written as new versions, the definition is used on the next start.
+func (t *Templates) Apply(template *Template, instance *insurgency.Instance, author string) (*Plan, error) {
+
+	plan, err := t.Plan(template, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	definition := t.instances.New(instance.ID)
+	definition.Apply(instance)
+	apply(template, definition)
+	instance.Apply(definition)
+	if err := t.instances.Save(); err != nil {
+		return nil, err
+	}
+
+	if err := t.write(plan, instance, author); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// Create adds an instance made from a template on ports no other instance
+// uses, with a random RCON password.
+func (t *Templates) Create(template *Template, id string, name string, author string) (*insurgency.Instance, *Plan, error) {
+
+	instance := t.instances.New(id)
+	if name != "" {
+		instance.Name = name
+	}
+	instance.RconPassword = utils.RandomID(12)
+	apply(template, instance)
+	t.instances.FreePorts(instance)
+
+	plan, err := t.Plan(template, instance)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := t.instances.Add(instance); err != nil {
+		return nil, nil, err
+	}
+
+	if err := t.write(plan, instance, author); err != nil {
+		return nil, nil, err
+	}
+
+	return instance, plan, nil
+}
+
+// Preview returns the instance a template would create and what it would
+// write, without creating it.
+func (t *Templates) Preview(template *Template, id string, name string) (*insurgency.Instance, *Plan, error) {
+
+	instance := t.instances.New(id)
+	if name != "" {
+		instance.Name = name
+	}
+	apply(template, instance)
+	t.instances.FreePorts(instance)
+
+	plan, err := t.Plan(template, instance)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return instance, plan, nil
+}
+
+func (t *Templates) write(plan *Plan, instance *insurgency.Instance, author string) error {
+
+	configurations := instance.Configurations()
+	for _, file := range plan.Files {
+		if _, err := configurations.Write(file.Name, file.content, author, fmt.Sprintf("template %s", plan.Template)); err != nil {
+			return t.log.Write(fmt.Sprintf("failed to apply template '%s' to instance '%s'. ERR: %s", plan.Template, instance.ID, err.Error()), MODULE, admin_log.LOG_ERROR)
+		}
+	}
+
+	return nil
+}
+
+func apply(template *Template, instance *insurgency.Instance) {
+
+	if template.Map != "" {
+		instance.Map = template.Map
+	}
+	if template.Scenario != "" {
+		instance.Scenario = template.Scenario
+	}
+	if template.Mode != "" {
+		instance.Mode = template.Mode
+	}
+	if template.MaxPlayers > 0 {
+		instance.MaxPlayers = template.MaxPlayers
+	}
+	if len(template.Arguments) > 0 {
+		instance.Arguments = append([]string{}, template.Arguments...)
+	}
+}
+
+func read(path string) (string, error) {
+
+	if !utils.FileExists(path) {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read '%s'. ERR: %s", path, err.Error())
+	}
+
+	return string(data), nil
+}
+
+// lines returns the lines of a list file that are not blank or comments.
+func lines(content string) []string {
+
+	list := make([]string, 0)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list = append(list, line)
+	}
+
+	return list
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package users
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type User struct {
+	Name     string    `json:"name"`
+	Password string    `json:"password"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+type Users struct {
+	db    *store.Store
+	dummy []byte
+	log   *admin_log.Log
+}
+
+const (
+	MODULE = "users"
+
+	DEFAULT_ADMIN = "admin"
+)
+
+var (
+	validName = regexp.MustCompile(`^[A-Za-z0-9_.@-]{1,64}$`)
+)
+
+func New(conf *config.Configuration, log *admin_log.Log, db *store.Store) *Users {
+
+	u := new(Users)
+	u.db = db
+	u.dummy, _ = bcrypt.GenerateFromPassword([]byte(DEFAULT_ADMIN), bcrypt.DefaultCost)
+	u.log = log
+
+	return u
+}
+
+// Load creates an 'admin' user with the web admin password when there are
+// no users yet and a password is configured, so the API can be used right
+// after installation. The users are read from the store on every use so
+// the ones added from the command line are known at once.
+func (u *Users) Load(password string) error {
+
+	if len(u.List()) > 0 || password == "" {
+		return nil
+	}
+
+	u.log.Write(fmt.Sprintf("no users found, creating user '%s' with the web admin password", DEFAULT_ADMIN), MODULE, admin_log.LOG_WARNING)
+
+	return u.Add(DEFAULT_ADMIN, password)
+}
+
+func (u *Users) get(name string) (*User, bool) {
+
+	user := new(User)
+	found := false
+	err := u.db.View(func(tx *store.Tx) error {
+		var err error
+		found, err = tx.Get(store.BUCKET_USERS, name, user)
+		return err
+	})
+	if err != nil {
+		u.log.Write(fmt.Sprintf("failed to read user '%s'. ERR: %s", name, err.Error()), MODULE, admin_log.LOG_ERROR)
+		return nil, false
+	}
+
+	return user, found
+}
+
+func (u *Users) Authenticate(name string, password string) bool {
+
+	user, ok := u.get(name)
+	if !ok {
+		// keep the response time similar for unknown users
+		bcrypt.CompareHashAndPassword(u.dummy, []byte(password))
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+}
+
+func (u *Users) Add(name string, password string) error {
+
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid user name '%s'", name)
+	}
+
+	hash, err := hash(password)
+	if err != nil {
+		return err
+	}
+
+	return u.db.Update(func(tx *store.Tx) error {
+		found, err := tx.Get(store.BUCKET_USERS, name, new(User))
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("user '%s' already exists", name)
+		}
+		now := time.Now()
+		return tx.Put(store.BUCKET_USERS, name, &User{Name: name, Password: hash, Created: now, Updated: now})
+	})
+}
+
+func (u *Users) SetPassword(name string, password string) error {
+
+	hash, err := hash(password)
+	if err != nil {
+		return err
+	}
+
+	return u.db.Update(func(tx *store.Tx) error {
+		user := new(User)
+		found, err := tx.Get(store.BUCKET_USERS, name, user)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("user '%s' not found", name)
+		}
+		user.Password = hash
+		user.Updated = time.Now()
+		return tx.Put(store.BUCKET_USERS, name, user)
+	})
+}
+
+func (u *Users) Exists(name string) bool {
+
+	_, ok := u.get(name)
+
+	return ok
+}
+
+func (u *Users) List() []string {
+
+	names := make([]string, 0)
+	u.db.View(func(tx *store.Tx) error {
+		names = tx.Keys(store.BUCKET_USERS)
+		return nil
+	})
+
+	return names
+}
+
+func hash(password string) (string, error) {
+
+	if len(password) < 8 {
+		return "", fmt.Errorf("passwords must have at least 8 characters")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password. ERR: %s", err.Error())
+	}
+
+	return string(hash), nil
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	DIFF_CONTEXT = 3
+)
+
+type diffLine struct {
+	kind byte
+	text string
+	a    int
+	b    int
+}
+
+// UnifiedDiff returns the differences between two texts in the unified
+// diff format, or an empty string if they are equal.
+func UnifiedDiff(from string, to string, fromName string, toName string) string {
+
+	a := splitLines(from)
+	b := splitLines(to)
+	lines := diffLines(a, b)
+
+	changed := false
+	for _, l := range lines {
+		if l.kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for start := 0; start < len(lines); {
+
+		// find the next change
+		first := -1
+		for n := start; n < len(lines); n++ {
+			if lines[n].kind != ' ' {
+				first = n
+				break
+			}
+		}
+		if first < 0 {
+			break
+		}
+
+		// grow the hunk while changes are closer than two contexts
+		begin := first - DIFF_CONTEXT
+		if begin < start {
+			begin = start
+		}
+		end := first
+		for n := first; n < len(lines); n++ {
+			if lines[n].kind != ' ' {
+				end = n
+			} else if n-end > 2*DIFF_CONTEXT {
+				break
+			}
+		}
+		end += DIFF_CONTEXT
+		if end >= len(lines) {
+			end = len(lines) - 1
+		}
+
+		aStart, bStart, aCount, bCount := 0, 0, 0, 0
+		for n := begin; n <= end; n++ {
+			if lines[n].kind != '+' {
+				if aCount == 0 {
+					aStart = lines[n].a
+				}
+				aCount++
+			}
+			if lines[n].kind != '-' {
+				if bCount == 0 {
+					bStart = lines[n].b
+				}
+				bCount++
+			}
+		}
+		if aCount == 0 {
+			aStart = hunkAnchor(lines, begin, true)
+		}
+		if bCount == 0 {
+			bStart = hunkAnchor(lines, begin, false)
+		}
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for n := begin; n <= end; n++ {
+			fmt.Fprintf(&out, "%c%s\n", lines[n].kind, lines[n].text)
+		}
+
+		start = end + 1
+	}
+
+	return out.String()
+}
+
+// diffLines computes a line diff from the longest common subsequence.
+// Configuration files are small so the quadratic table is fine.
+func diffLines(a []string, b []string) []diffLine {
+
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := make([]diffLine, 0, n+m)
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i], i + 1, j + 1})
+			i++
+			j++
+		case j < m && (i >= n || lcs[i][j+1] > lcs[i+1][j]):
+			lines = append(lines, diffLine{'+', b[j], i, j + 1})
+			j++
+		default:
+			lines = append(lines, diffLine{'-', a[i], i + 1, j})
+			i++
+		}
+	}
+
+	return lines
+}
+
+func hunkAnchor(lines []diffLine, begin int, old bool) int {
+
+	if old {
+		return lines[begin].a
+	}
+
+	return lines[begin].b
+}
+
+func hunkRange(start int, count int) string {
+
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(text string) []string {
+
+	if text == "" {
+		return []string{}
+	}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -1,11 +1,18 @@
 package utils
 
 import (
+	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
-const MODULE = "Utils"
+const (
+	MODULE = "Utils"
+
+	TAIL_MAX_BYTES = 1024 * 1024
+)
 
 func FileExists(path string) bool {
 
@@ -46,3 +53,104 @@ func isFile(fi fs.FileInfo) bool {
 
 	return !fi.IsDir()
 }
+
+// TailLines returns up to the last n lines of a text file reading at most
+// the last 1MB of it.
+func TailLines(path string, n int) ([]string, error) {
+
+	if n <= 0 {
+		return []string{}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var offset int64 = 0
+	if fi.Size() > TAIL_MAX_BYTES {
+		offset = fi.Size() - TAIL_MAX_BYTES
+	}
+
+	data := make([]byte, fi.Size()-offset)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\r\n"), "\n")
+	if offset > 0 && len(lines) > 1 {
+		// the first line is most likely cut
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for l := range lines {
+		lines[l] = strings.TrimRight(lines[l], "\r")
+	}
+
+	return lines, nil
+}
+
+func CopyFile(source string, dest string) error {
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0750); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// CopyDir copies the regular files and directories under source into dest.
+func CopyDir(source string, dest string) error {
+
+	return filepath.Walk(source, func(path string, fi fs.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0750)
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		return CopyFile(path, target)
+	})
+}
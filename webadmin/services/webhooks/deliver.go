@@ -0,0 +1,266 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+// Delivery is one event sent to one target, kept in the delivery log with
+// the outcome of its last attempt.
+type Delivery struct {
+	ID         string     `json:"id"`
+	Target     string     `json:"target"`
+	Event      string     `json:"event"`
+	Instance   string     `json:"instance,omitempty"`
+	Time       time.Time  `json:"time"`
+	Status     string     `json:"status"`
+	Attempts   int        `json:"attempts"`
+	StatusCode int        `json:"statusCode,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Finished   *time.Time `json:"finished,omitempty"`
+}
+
+const (
+	DELIVERY_PENDING   = "pending"
+	DELIVERY_DELIVERED = "delivered"
+	DELIVERY_FAILED    = "failed"
+
+	HEADER_EVENT     = "X-Webadmin-Event"
+	HEADER_DELIVERY  = "X-Webadmin-Delivery"
+	HEADER_SIGNATURE = "X-Webadmin-Signature"
+
+	USER_AGENT = "sandstorm-web-admin"
+)
+
+// Notify starts a delivery of the event to every target that accepts it.
+// It does not wait for the deliveries, nor for storing them, so it can be
+// subscribed to the bus.
+func (w *Webhooks) Notify(event events.Event) {
+
+	w.mutex.Lock()
+	targets := make([]Target, 0)
+	for _, target := range w.Targets {
+		if target.Accepts(event) {
+			targets = append(targets, *target)
+		}
+	}
+	w.mutex.Unlock()
+
+	for _, target := range targets {
+		w.start(target, event)
+	}
+}
+
+// Test sends a test event to a target, even when it is disabled or filters
+// the test event out.
+func (w *Webhooks) Test(id string, user string) (*Delivery, error) {
+
+	w.mutex.Lock()
+	current, ok := w.Targets[id]
+	if !ok {
+		w.mutex.Unlock()
+		return nil, fmt.Errorf("webhook '%s' not found", id)
+	}
+	target := *current
+	w.mutex.Unlock()
+
+	event := events.Event{
+		Type:    events.TEST,
+		Time:    time.Now(),
+		Level:   events.LEVEL_INFO,
+		Title:   "Test notification",
+		Message: fmt.Sprintf("test notification sent by '%s' to webhook '%s'", user, target.Name),
+	}
+
+	return w.start(target, event), nil
+}
+
+func (w *Webhooks) start(target Target, event events.Event) *Delivery {
+
+	delivery := &Delivery{
+		ID:       utils.RandomID(8),
+		Target:   target.ID,
+		Event:    event.Type,
+		Instance: event.Instance,
+		Time:     time.Now(),
+		Status:   DELIVERY_PENDING,
+	}
+
+	w.mutex.Lock()
+	w.Deliveries = append(w.Deliveries, delivery)
+	dropped := make([]Delivery, 0)
+	if len(w.Deliveries) > MAX_DELIVERIES {
+		for _, old := range w.Deliveries[:len(w.Deliveries)-MAX_DELIVERIES] {
+			dropped = append(dropped, *old)
+		}
+		w.Deliveries = w.Deliveries[len(w.Deliveries)-MAX_DELIVERIES:]
+	}
+	snapshot := *delivery
+	w.mutex.Unlock()
+
+	go w.deliver(target, event, delivery, snapshot, dropped)
+
+	return &snapshot
+}
+
+// deliver stores the pending delivery and posts the event until the target
+// accepts it, retrying network errors, rate limits and server errors with
+// an exponential backoff.
+func (w *Webhooks) deliver(target Target, event events.Event, delivery *Delivery, pending Delivery, dropped []Delivery) {
+
+	w.saveDelivery(pending, dropped)
+
+	body, err := format(target.Format, delivery.ID, event)
+	if err != nil {
+		w.finish(delivery, 0, err, false)
+		return
+	}
+
+	backoff := w.Backoff
+	for attempt := 1; ; attempt++ {
+
+		status, retryAfter, err := w.post(target, event, delivery.ID, body)
+		retry := err != nil && (status == 0 || status == http.StatusTooManyRequests || status >= 500)
+
+		w.mutex.Lock()
+		delivery.Attempts = attempt
+		delivery.StatusCode = status
+		w.mutex.Unlock()
+
+		if err == nil || !retry || attempt >= w.Attempts {
+			w.finish(delivery, status, err, retry)
+			return
+		}
+
+		wait := backoff
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > w.MaxBackoff {
+			wait = w.MaxBackoff
+		}
+		backoff *= 2
+
+		w.log.Write(fmt.Sprintf("delivery %s of '%s' to webhook '%s' failed, retrying in %s. ERR: %s", delivery.ID, event.Type, target.Name, wait, err.Error()), MODULE, admin_log.LOG_DEBUG)
+
+		select {
+		case <-w.done:
+			w.finish(delivery, status, fmt.Errorf("%s, the web admin stopped before retrying", err.Error()), false)
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// post sends one attempt and returns the http status, how long the target
+// asked to wait before the next one and an error when it was not accepted.
+func (w *Webhooks) post(target Target, event events.Event, id string, body []byte) (int, time.Duration, error) {
+
+	request, err := http.NewRequest(http.MethodPost, target.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", USER_AGENT)
+	request.Header.Set(HEADER_EVENT, event.Type)
+	request.Header.Set(HEADER_DELIVERY, id)
+	if target.Secret != "" {
+		request.Header.Set(HEADER_SIGNATURE, Sign(target.Secret, body))
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response.StatusCode, 0, nil
+	}
+
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+
+	return response.StatusCode, retryAfter, fmt.Errorf("webhook answered with status %d", response.StatusCode)
+}
+
+func (w *Webhooks) finish(delivery *Delivery, status int, err error, retried bool) {
+
+	w.mutex.Lock()
+	now := time.Now()
+	delivery.StatusCode = status
+	delivery.Finished = &now
+	if err != nil {
+		delivery.Status = DELIVERY_FAILED
+		delivery.Error = err.Error()
+		if retried {
+			delivery.Error = fmt.Sprintf("%s, giving up after %d attempts", err.Error(), delivery.Attempts)
+		}
+		w.log.Write(fmt.Sprintf("delivery %s of '%s' to webhook '%s' failed. ERR: %s", delivery.ID, delivery.Event, delivery.Target, delivery.Error), MODULE, admin_log.LOG_WARNING)
+	} else {
+		delivery.Status = DELIVERY_DELIVERED
+	}
+	finished := *delivery
+	w.mutex.Unlock()
+
+	w.saveDelivery(finished, nil)
+}
+
+// Sign returns the signature header value of a body, the hex encoded
+// HMAC-SHA256 of the body keyed with the secret of the target.
+func Sign(secret string, body []byte) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliveryLog returns the most recent deliveries first, optionally only
+// those of one target.
+func (w *Webhooks) DeliveryLog(target string) []Delivery {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	deliveries := make([]Delivery, 0, len(w.Deliveries))
+	for n := len(w.Deliveries) - 1; n >= 0; n-- {
+		if target == "" || w.Deliveries[n].Target == target {
+			deliveries = append(deliveries, *w.Deliveries[n])
+		}
+	}
+
+	return deliveries
+}
+
+// saveDelivery stores a copy of a delivery and forgets the ones dropped
+// from the delivery log, without holding the mutex.
+func (w *Webhooks) saveDelivery(delivery Delivery, dropped []Delivery) error {
+
+	err := w.db.Update(func(tx *store.Tx) error {
+		for _, old := range dropped {
+			tx.Delete(store.BUCKET_DELIVERIES, store.RunKey(old.Time, old.ID))
+		}
+		return tx.Put(store.BUCKET_DELIVERIES, store.RunKey(delivery.Time, delivery.ID), delivery)
+	})
+	if err != nil {
+		return w.log.Write(fmt.Sprintf("failed to save webhook delivery %s. ERR: %s", delivery.ID, err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	return nil
+}
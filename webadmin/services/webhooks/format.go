@@ -0,0 +1,128 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+)
+
+type payload struct {
+	ID    string       `json:"id"`
+	Event events.Event `json:"event"`
+}
+
+type discordMessage struct {
+	Username string         `json:"username"`
+	Embeds   []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Color       int            `json:"color"`
+	Timestamp   string         `json:"timestamp"`
+	Fields      []discordField `json:"fields,omitempty"`
+	Footer      discordFooter  `json:"footer"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+const (
+	DISCORD_USERNAME = "Sandstorm Web Admin"
+
+	// limits of the discord embeds
+	DISCORD_MAX_FIELDS      = 25
+	DISCORD_MAX_TITLE       = 256
+	DISCORD_MAX_DESCRIPTION = 4096
+	DISCORD_MAX_VALUE       = 1024
+
+	COLOR_INFO    = 0x3498db
+	COLOR_SUCCESS = 0x2ecc71
+	COLOR_WARNING = 0xf1c40f
+	COLOR_ERROR   = 0xe74c3c
+)
+
+func format(name string, id string, event events.Event) ([]byte, error) {
+
+	switch name {
+	case FORMAT_DISCORD:
+		return json.Marshal(discord(event))
+	case FORMAT_JSON:
+		return json.Marshal(payload{ID: id, Event: event})
+	}
+
+	return nil, fmt.Errorf("unknown webhook format '%s'", name)
+}
+
+// discord turns the event into a message with a single embed coloured by
+// the level of the event.
+func discord(event events.Event) discordMessage {
+
+	embed := discordEmbed{
+		Title:       truncate(event.Title, DISCORD_MAX_TITLE),
+		Description: truncate(event.Message, DISCORD_MAX_DESCRIPTION),
+		Color:       color(event),
+		Timestamp:   event.Time.UTC().Format(time.RFC3339),
+		Fields:      make([]discordField, 0, len(event.Fields)+1),
+		Footer:      discordFooter{Text: event.Type},
+	}
+
+	if event.Instance != "" {
+		embed.Fields = append(embed.Fields, discordField{Name: "instance", Value: event.Instance, Inline: true})
+	}
+
+	names := make([]string, 0, len(event.Fields))
+	for name := range event.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if len(embed.Fields) == DISCORD_MAX_FIELDS {
+			break
+		}
+		value := event.Fields[name]
+		if value == "" {
+			// discord refuses empty field values
+			value = "-"
+		}
+		embed.Fields = append(embed.Fields, discordField{Name: name, Value: truncate(value, DISCORD_MAX_VALUE), Inline: true})
+	}
+
+	return discordMessage{Username: DISCORD_USERNAME, Embeds: []discordEmbed{embed}}
+}
+
+func color(event events.Event) int {
+
+	switch {
+	case event.Level == events.LEVEL_ERROR:
+		return COLOR_ERROR
+	case event.Level == events.LEVEL_WARNING:
+		return COLOR_WARNING
+	case event.Type == events.INSTANCE_STARTED || event.Type == events.UPDATE_APPLIED:
+		return COLOR_SUCCESS
+	}
+
+	return COLOR_INFO
+}
+
+func truncate(value string, length int) string {
+
+	runes := []rune(value)
+	if len(runes) <= length {
+		return value
+	}
+
+	return string(runes[:length-1]) + "…"
+}
@@ -0,0 +1,361 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/utils"
+)
+
+// Target is an url the events are posted to. Events and Instances filter
+// what is sent, an empty filter lets everything through and an event type
+// may end with '*' to match a whole group like 'instance.*'.
+type Target struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Url       string    `json:"url"`
+	Format    string    `json:"format"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Instances []string  `json:"instances"`
+	Enabled   bool      `json:"enabled"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+type Webhooks struct {
+	Targets    map[string]*Target `json:"targets"`
+	Deliveries []*Delivery        `json:"deliveries"`
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	client     *http.Client
+	db         *store.Store
+	done       chan struct{}
+	log        *admin_log.Log
+	mutex      sync.Mutex
+}
+
+const (
+	MODULE = "webhooks"
+
+	FORMAT_DISCORD = "discord"
+	FORMAT_JSON    = "json"
+
+	MAX_DELIVERIES = 200
+
+	SECRET_MASK = "********"
+
+	DEFAULT_ATTEMPTS    = 5
+	DEFAULT_BACKOFF     = 2 * time.Second
+	DEFAULT_MAX_BACKOFF = time.Minute
+	REQUEST_TIMEOUT     = 10 * time.Second
+)
+
+var (
+	Formats = []string{FORMAT_DISCORD, FORMAT_JSON}
+)
+
+func New(conf *config.Configuration, log *admin_log.Log, db *store.Store) *Webhooks {
+
+	w := new(Webhooks)
+	w.Targets = make(map[string]*Target)
+	w.Deliveries = make([]*Delivery, 0)
+	w.Attempts = DEFAULT_ATTEMPTS
+	w.Backoff = DEFAULT_BACKOFF
+	w.MaxBackoff = DEFAULT_MAX_BACKOFF
+	w.client = &http.Client{Timeout: REQUEST_TIMEOUT}
+	w.db = db
+	w.done = make(chan struct{})
+	w.log = log
+
+	return w
+}
+
+func (w *Webhooks) Load() error {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err := w.db.View(func(tx *store.Tx) error {
+		err := tx.ForEach(store.BUCKET_WEBHOOKS, func(id string, value []byte) error {
+			target := new(Target)
+			if err := json.Unmarshal(value, target); err != nil {
+				return fmt.Errorf("failed to parse webhook '%s'. ERR: %s", id, err.Error())
+			}
+			w.Targets[id] = target
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.ForEach(store.BUCKET_DELIVERIES, func(key string, value []byte) error {
+			delivery := new(Delivery)
+			if err := json.Unmarshal(value, delivery); err != nil {
+				return fmt.Errorf("failed to parse webhook delivery '%s'. ERR: %s", key, err.Error())
+			}
+			w.Deliveries = append(w.Deliveries, delivery)
+			return nil
+		})
+	})
+	if err != nil {
+		return w.log.Write(fmt.Sprintf("failed to load webhooks. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	// deliveries still pending when the web admin stopped are lost
+	for _, delivery := range w.Deliveries {
+		if delivery.Status == DELIVERY_PENDING {
+			delivery.Status = DELIVERY_FAILED
+			delivery.Error = "the web admin stopped before the delivery finished"
+			w.saveDelivery(*delivery, nil)
+		}
+	}
+
+	w.log.Write(fmt.Sprintf("%d webhooks loaded", len(w.Targets)), MODULE, admin_log.LOG_INFO)
+
+	return nil
+}
+
+func (w *Webhooks) save() error {
+
+	err := w.db.Update(func(tx *store.Tx) error {
+		for _, id := range tx.Keys(store.BUCKET_WEBHOOKS) {
+			if _, ok := w.Targets[id]; !ok {
+				tx.Delete(store.BUCKET_WEBHOOKS, id)
+			}
+		}
+		for id, target := range w.Targets {
+			if err := tx.Put(store.BUCKET_WEBHOOKS, id, target); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return w.log.Write(fmt.Sprintf("failed to save webhooks. ERR: %s", err.Error()), MODULE, admin_log.LOG_ERROR)
+	}
+
+	return nil
+}
+
+// Subscribe delivers the events published on the bus to the targets.
+func (w *Webhooks) Subscribe(bus *events.Bus) {
+
+	bus.Subscribe(w.Notify)
+}
+
+// Stop abandons the retries of the deliveries in progress.
+func (w *Webhooks) Stop() {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	select {
+	case <-w.done:
+	default:
+		close(w.done)
+	}
+}
+
+func (w *Webhooks) validate(target *Target) error {
+
+	target.Name = strings.TrimSpace(target.Name)
+	if target.Name == "" {
+		return fmt.Errorf("the webhook needs a name")
+	}
+
+	u, err := url.Parse(target.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url '%s'", target.Url)
+	}
+
+	if target.Format == "" {
+		target.Format = FORMAT_JSON
+	}
+	if !contains(Formats, target.Format) {
+		return fmt.Errorf("webhook format '%s' is not one of %v", target.Format, Formats)
+	}
+
+	for _, pattern := range target.Events {
+		known := false
+		for _, t := range events.Types {
+			if match(pattern, t) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown event '%s'", pattern)
+		}
+	}
+
+	if target.Events == nil {
+		target.Events = make([]string, 0)
+	}
+	if target.Instances == nil {
+		target.Instances = make([]string, 0)
+	}
+
+	return nil
+}
+
+func (w *Webhooks) Add(target *Target) error {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err := w.validate(target); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	target.ID = utils.RandomID(8)
+	target.Created = now
+	target.Updated = now
+	w.Targets[target.ID] = target
+
+	return w.save()
+}
+
+// Update replaces a target. A masked or empty secret keeps the current one,
+// use Secret "-" to remove it.
+func (w *Webhooks) Update(id string, target *Target) error {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	current, ok := w.Targets[id]
+	if !ok {
+		return fmt.Errorf("webhook '%s' not found", id)
+	}
+
+	if err := w.validate(target); err != nil {
+		return err
+	}
+
+	switch target.Secret {
+	case "", SECRET_MASK:
+		target.Secret = current.Secret
+	case "-":
+		target.Secret = ""
+	}
+
+	target.ID = id
+	target.Created = current.Created
+	target.Updated = time.Now()
+	w.Targets[id] = target
+
+	return w.save()
+}
+
+func (w *Webhooks) Remove(id string) error {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if _, ok := w.Targets[id]; !ok {
+		return fmt.Errorf("webhook '%s' not found", id)
+	}
+
+	delete(w.Targets, id)
+
+	return w.save()
+}
+
+// Get returns a copy of the target with its secret masked.
+func (w *Webhooks) Get(id string) (Target, error) {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	target, ok := w.Targets[id]
+	if !ok {
+		return Target{}, fmt.Errorf("webhook '%s' not found", id)
+	}
+
+	return target.masked(), nil
+}
+
+// List returns copies of the targets, with their secrets masked, sorted by
+// name.
+func (w *Webhooks) List() []Target {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	targets := make([]Target, 0, len(w.Targets))
+	for _, target := range w.Targets {
+		targets = append(targets, target.masked())
+	}
+	sort.Slice(targets, func(a, b int) bool { return targets[a].Name < targets[b].Name })
+
+	return targets
+}
+
+func (t *Target) masked() Target {
+
+	target := *t
+	if target.Secret != "" {
+		target.Secret = SECRET_MASK
+	}
+
+	return target
+}
+
+// Accepts tells if the event passes the filters of the target.
+func (t *Target) Accepts(event events.Event) bool {
+
+	if !t.Enabled {
+		return false
+	}
+
+	if len(t.Events) > 0 {
+		found := false
+		for _, pattern := range t.Events {
+			if match(pattern, event.Type) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	// events that are not about an instance go to every target
+	if len(t.Instances) > 0 && event.Instance != "" {
+		return contains(t.Instances, event.Instance)
+	}
+
+	return true
+}
+
+func match(pattern string, eventType string) bool {
+
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(eventType, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return pattern == eventType
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,454 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/admin_log"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/config"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/events"
+	"github.com/joaoribeirodasilva/sandstorm_web_admin/webadmin/services/store"
+)
+
+// request is what the receiver got in one attempt.
+type request struct {
+	header http.Header
+	body   []byte
+	time   time.Time
+}
+
+// receiver is a webhook endpoint that answers with the scripted statuses,
+// then with 204.
+type receiver struct {
+	server   *httptest.Server
+	statuses []int
+	requests chan request
+	mutex    sync.Mutex
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+
+	r := &receiver{statuses: statuses, requests: make(chan request, 16)}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.requests <- request{header: req.Header.Clone(), body: body, time: time.Now()}
+
+		r.mutex.Lock()
+		status := http.StatusNoContent
+		if len(r.statuses) > 0 {
+			status = r.statuses[0]
+			r.statuses = r.statuses[1:]
+		}
+		r.mutex.Unlock()
+
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "30")
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.server.Close)
+
+	return r
+}
+
+func (r *receiver) receive(t *testing.T) request {
+
+	t.Helper()
+
+	select {
+	case req := <-r.requests:
+		return req
+	case <-time.After(2 * time.Second):
+		t.Fatal("the receiver got no request")
+	}
+
+	return request{}
+}
+
+func (r *receiver) nothing(t *testing.T) {
+
+	t.Helper()
+
+	select {
+	case req := <-r.requests:
+		t.Fatalf("unexpected request for '%s'", req.header.Get(HEADER_EVENT))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func newWebhooks(t *testing.T) (*Webhooks, *store.Store, *config.Configuration) {
+
+	log := admin_log.New()
+	conf := config.New(log)
+	conf.WebAdmin.ConfigDir = t.TempDir()
+
+	db := store.New(conf, log)
+	if err := db.Open(); err != nil {
+		t.Fatalf("failed to open the store: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	w := New(conf, log, db)
+	w.Backoff = 5 * time.Millisecond
+	w.MaxBackoff = 20 * time.Millisecond
+
+	// the deliveries write to the store in the temporary directory
+	t.Cleanup(func() {
+		for _, delivery := range w.DeliveryLog("") {
+			w.finished(t, delivery.ID)
+		}
+	})
+	t.Cleanup(w.Stop)
+
+	return w, db, conf
+}
+
+func (w *Webhooks) addTarget(t *testing.T, target *Target) *Target {
+
+	t.Helper()
+
+	target.Enabled = true
+	if err := w.Add(target); err != nil {
+		t.Fatalf("failed to add webhook '%s': %v", target.Name, err)
+	}
+
+	return target
+}
+
+// finished waits for the delivery to leave the pending status, in the
+// delivery log and in the store.
+func (w *Webhooks) finished(t *testing.T, id string) Delivery {
+
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, delivery := range w.DeliveryLog("") {
+			if delivery.ID != id || delivery.Status == DELIVERY_PENDING {
+				continue
+			}
+			stored := Delivery{}
+			w.db.View(func(tx *store.Tx) error {
+				_, err := tx.Get(store.BUCKET_DELIVERIES, store.RunKey(delivery.Time, delivery.ID), &stored)
+				return err
+			})
+			if stored.Status == delivery.Status {
+				return delivery
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("delivery %s did not finish", id)
+
+	return Delivery{}
+}
+
+func crashed() events.Event {
+
+	return events.Event{
+		Type:     events.INSTANCE_CRASHED,
+		Time:     time.Date(2024, 1, 1, 4, 0, 0, 0, time.UTC),
+		Level:    events.LEVEL_ERROR,
+		Instance: "one",
+		Title:    "Instance crashed",
+		Message:  "instance 'one' exited with code 139",
+		Fields:   map[string]string{"exitCode": "139", "reason": "exit", "log": ""},
+	}
+}
+
+func TestSignature(t *testing.T) {
+
+	w, _, _ := newWebhooks(t)
+	r := newReceiver(t)
+	w.addTarget(t, &Target{Name: "signed", Url: r.server.URL, Secret: "s3cret"})
+	w.addTarget(t, &Target{Name: "unsigned", Url: r.server.URL})
+
+	w.Notify(crashed())
+
+	signed := 0
+	for n := 0; n < 2; n++ {
+		req := r.receive(t)
+		header := req.header.Get(HEADER_SIGNATURE)
+		if header == "" {
+			continue
+		}
+		signed++
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(req.body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); header != want {
+			t.Errorf("signature '%s', want '%s'", header, want)
+		}
+		if Sign("other", req.body) == header {
+			t.Error("the signature does not depend on the secret")
+		}
+	}
+	if signed != 1 {
+		t.Errorf("%d requests signed, want only the one of the target with a secret", signed)
+	}
+}
+
+func TestJsonPayload(t *testing.T) {
+
+	w, _, _ := newWebhooks(t)
+	r := newReceiver(t)
+	w.addTarget(t, &Target{Name: "json", Url: r.server.URL, Format: FORMAT_JSON})
+
+	event := crashed()
+	w.Notify(event)
+	req := r.receive(t)
+
+	if req.header.Get("Content-Type") != "application/json" || req.header.Get(HEADER_EVENT) != events.INSTANCE_CRASHED || req.header.Get("User-Agent") != USER_AGENT {
+		t.Errorf("unexpected headers %v", req.header)
+	}
+
+	got := payload{}
+	if err := json.Unmarshal(req.body, &got); err != nil {
+		t.Fatalf("invalid payload '%s': %v", req.body, err)
+	}
+	if got.ID == "" || got.ID != req.header.Get(HEADER_DELIVERY) {
+		t.Errorf("payload id '%s', delivery header '%s'", got.ID, req.header.Get(HEADER_DELIVERY))
+	}
+	if got.Event.Type != event.Type || got.Event.Instance != "one" || !got.Event.Time.Equal(event.Time) || got.Event.Message != event.Message || got.Event.Fields["exitCode"] != "139" {
+		t.Errorf("payload event %+v, want %+v", got.Event, event)
+	}
+}
+
+func TestDiscordPayload(t *testing.T) {
+
+	w, _, _ := newWebhooks(t)
+	r := newReceiver(t)
+	w.addTarget(t, &Target{Name: "discord", Url: r.server.URL, Format: FORMAT_DISCORD})
+
+	w.Notify(crashed())
+	req := r.receive(t)
+
+	got := discordMessage{}
+	if err := json.Unmarshal(req.body, &got); err != nil {
+		t.Fatalf("invalid discord message '%s': %v", req.body, err)
+	}
+	if got.Username != DISCORD_USERNAME || len(got.Embeds) != 1 {
+		t.Fatalf("unexpected discord message %+v", got)
+	}
+
+	embed := got.Embeds[0]
+	if embed.Title != "Instance crashed" || embed.Description != "instance 'one' exited with code 139" || embed.Color != COLOR_ERROR || embed.Timestamp != "2024-01-01T04:00:00Z" || embed.Footer.Text != events.INSTANCE_CRASHED {
+		t.Errorf("unexpected embed %+v", embed)
+	}
+
+	// the instance first, then the fields by name with empty values replaced
+	want := []discordField{{"instance", "one", true}, {"exitCode", "139", true}, {"log", "-", true}, {"reason", "exit", true}}
+	if len(embed.Fields) != len(want) {
+		t.Fatalf("fields %+v, want %+v", embed.Fields, want)
+	}
+	for n := range want {
+		if embed.Fields[n] != want[n] {
+			t.Errorf("field %d is %+v, want %+v", n, embed.Fields[n], want[n])
+		}
+	}
+
+	long := crashed()
+	long.Title = strings.Repeat("t", DISCORD_MAX_TITLE+10)
+	if title := []rune(discord(long).Embeds[0].Title); len(title) != DISCORD_MAX_TITLE || title[len(title)-1] != '…' {
+		t.Errorf("a long title is not truncated to %d characters", DISCORD_MAX_TITLE)
+	}
+}
+
+func TestFilters(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		target   Target
+		event    events.Event
+		accepted bool
+	}{
+		{"no filters", Target{Enabled: true}, events.Event{Type: events.DISK_LOW}, true},
+		{"disabled", Target{}, events.Event{Type: events.DISK_LOW}, false},
+		{"event type", Target{Enabled: true, Events: []string{events.INSTANCE_CRASHED}}, events.Event{Type: events.INSTANCE_CRASHED}, true},
+		{"other event type", Target{Enabled: true, Events: []string{events.INSTANCE_CRASHED}}, events.Event{Type: events.INSTANCE_STARTED}, false},
+		{"event group", Target{Enabled: true, Events: []string{"instance.*"}}, events.Event{Type: events.INSTANCE_STARTED}, true},
+		{"other event group", Target{Enabled: true, Events: []string{"instance.*"}}, events.Event{Type: events.UPDATE_APPLIED}, false},
+		{"instance", Target{Enabled: true, Instances: []string{"one"}}, events.Event{Type: events.INSTANCE_STARTED, Instance: "one"}, true},
+		{"other instance", Target{Enabled: true, Instances: []string{"one"}}, events.Event{Type: events.INSTANCE_STARTED, Instance: "two"}, false},
+		{"event of no instance", Target{Enabled: true, Instances: []string{"one"}}, events.Event{Type: events.DISK_LOW}, true},
+	}
+
+	for _, test := range tests {
+		if accepted := test.target.Accepts(test.event); accepted != test.accepted {
+			t.Errorf("%s: Accepts = %v, want %v", test.name, accepted, test.accepted)
+		}
+	}
+
+	w, _, _ := newWebhooks(t)
+	crashes := newReceiver(t)
+	two := newReceiver(t)
+	w.addTarget(t, &Target{Name: "crashes", Url: crashes.server.URL, Events: []string{events.INSTANCE_CRASHED}})
+	w.addTarget(t, &Target{Name: "two", Url: two.server.URL, Instances: []string{"two"}})
+
+	w.Notify(crashed())
+	if req := crashes.receive(t); req.header.Get(HEADER_EVENT) != events.INSTANCE_CRASHED {
+		t.Errorf("the crash webhook got '%s'", req.header.Get(HEADER_EVENT))
+	}
+	two.nothing(t)
+
+	w.Notify(events.Event{Type: events.INSTANCE_STARTED, Instance: "two"})
+	two.receive(t)
+	crashes.nothing(t)
+}
+
+func TestNotifyDoesNotWaitForTheStore(t *testing.T) {
+
+	w, db, _ := newWebhooks(t)
+	r := newReceiver(t)
+	w.addTarget(t, &Target{Name: "busy", Url: r.server.URL})
+
+	release := make(chan struct{})
+	busy := make(chan struct{})
+	go db.Update(func(tx *store.Tx) error {
+		close(busy)
+		<-release
+		return nil
+	})
+	<-busy
+
+	notified := make(chan struct{})
+	go func() {
+		w.Notify(crashed())
+		close(notified)
+	}()
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Error("Notify waited for the store")
+	}
+	close(release)
+
+	log := w.DeliveryLog("")
+	if len(log) != 1 {
+		t.Fatalf("delivery log %+v, want one delivery", log)
+	}
+	w.finished(t, log[0].ID)
+}
+
+func TestRetry(t *testing.T) {
+
+	w, _, _ := newWebhooks(t)
+	r := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	target := w.addTarget(t, &Target{Name: "flaky", Url: r.server.URL})
+
+	delivery, err := w.Test(target.ID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := r.receive(t)
+	second := r.receive(t)
+	third := r.receive(t)
+	for _, req := range []request{second, third} {
+		if req.header.Get(HEADER_DELIVERY) != delivery.ID || string(req.body) != string(first.body) {
+			t.Error("a retry is not the same delivery")
+		}
+	}
+	// Retry-After is capped by MaxBackoff
+	if wait := third.time.Sub(second.time); wait < w.MaxBackoff || wait > time.Second {
+		t.Errorf("waited %s after the rate limit, want the maximum backoff %s", wait, w.MaxBackoff)
+	}
+
+	finished := w.finished(t, delivery.ID)
+	if finished.Status != DELIVERY_DELIVERED || finished.Attempts != 3 || finished.StatusCode != http.StatusOK || finished.Error != "" {
+		t.Errorf("unexpected delivery %+v", finished)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+
+	w, _, _ := newWebhooks(t)
+	w.Attempts = 3
+	r := newReceiver(t, 500, 502, 503, 504)
+	target := w.addTarget(t, &Target{Name: "down", Url: r.server.URL})
+
+	delivery, _ := w.Test(target.ID, "alice")
+	finished := w.finished(t, delivery.ID)
+	if finished.Status != DELIVERY_FAILED || finished.Attempts != 3 || finished.StatusCode != 503 || !strings.Contains(finished.Error, "giving up after 3 attempts") {
+		t.Errorf("unexpected delivery %+v", finished)
+	}
+	for n := 0; n < 3; n++ {
+		r.receive(t)
+	}
+	r.nothing(t)
+}
+
+func TestClientErrorsAreNotRetried(t *testing.T) {
+
+	w, _, _ := newWebhooks(t)
+	r := newReceiver(t, http.StatusNotFound)
+	target := w.addTarget(t, &Target{Name: "gone", Url: r.server.URL})
+
+	delivery, _ := w.Test(target.ID, "alice")
+	finished := w.finished(t, delivery.ID)
+	if finished.Status != DELIVERY_FAILED || finished.Attempts != 1 || finished.StatusCode != http.StatusNotFound || strings.Contains(finished.Error, "giving up") {
+		t.Errorf("unexpected delivery %+v", finished)
+	}
+	r.receive(t)
+	r.nothing(t)
+}
+
+func TestDeliveryLogIsPersisted(t *testing.T) {
+
+	w, db, conf := newWebhooks(t)
+	r := newReceiver(t, http.StatusBadRequest)
+	target := w.addTarget(t, &Target{Name: "log", Url: r.server.URL, Secret: "s3cret"})
+
+	failed, _ := w.Test(target.ID, "alice")
+	w.finished(t, failed.ID)
+	delivered, _ := w.Test(target.ID, "alice")
+	w.finished(t, delivered.ID)
+
+	loaded := New(conf, admin_log.New(), db)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := loaded.Get(target.ID); err != nil || got.Url != r.server.URL || got.Secret != SECRET_MASK {
+		t.Errorf("loaded webhook %+v, %v", got, err)
+	}
+	if loaded.Targets[target.ID].Secret != "s3cret" {
+		t.Error("the secret of the webhook was not saved")
+	}
+
+	log := loaded.DeliveryLog(target.ID)
+	if len(log) != 2 || log[0].ID != delivered.ID || log[1].ID != failed.ID {
+		t.Fatalf("loaded delivery log %+v, want the two deliveries, the latest first", log)
+	}
+	if log[0].Status != DELIVERY_DELIVERED || log[1].Status != DELIVERY_FAILED || log[1].StatusCode != http.StatusBadRequest || log[1].Finished == nil {
+		t.Errorf("unexpected deliveries %+v", log)
+	}
+	if len(loaded.DeliveryLog("other")) != 0 {
+		t.Error("the delivery log of another webhook is not empty")
+	}
+}
+
+func TestPendingDeliveriesFailOnLoad(t *testing.T) {
+
+	w, db, conf := newWebhooks(t)
+	pending := &Delivery{ID: "pending", Target: "gone", Event: events.TEST, Time: time.Now(), Status: DELIVERY_PENDING}
+	w.saveDelivery(*pending, nil)
+
+	loaded := New(conf, admin_log.New(), db)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	log := loaded.DeliveryLog("")
+	if len(log) != 1 || log[0].Status != DELIVERY_FAILED || log[0].Error == "" {
+		t.Errorf("delivery log %+v, want the pending delivery failed", log)
+	}
+}